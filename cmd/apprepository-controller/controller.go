@@ -19,6 +19,8 @@ package main
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
 	apprepov1alpha1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
@@ -26,6 +28,7 @@ import (
 	appreposcheme "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/clientset/versioned/scheme"
 	informers "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/informers/externalversions"
 	listers "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/listers/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/cron"
 	"github.com/kubeapps/kubeapps/pkg/kube"
 	log "github.com/sirupsen/logrus"
 	batchv1 "k8s.io/api/batch/v1"
@@ -48,6 +51,16 @@ import (
 
 const controllerAgentName = "apprepository-controller"
 
+const (
+	// keyringVolume is the volume of the sync job with the trusted keys of
+	// an AppRepository.
+	keyringVolume = "keyring"
+	// keyringDir is the directory the trusted keys are mounted in.
+	keyringDir = "/var/run/kubeapps/keyring"
+	// keyringFile is the file of the trusted keys within keyringDir.
+	keyringFile = "keyring"
+)
+
 const (
 	// SuccessSynced is used as part of the Event 'reason' when an AppRepository
 	// is synced
@@ -68,6 +81,12 @@ const (
 	// MessageResourceSynced is the message used for an Event fired when an
 	// AppRepsitory is synced successfully
 	MessageResourceSynced = "AppRepository synced successfully"
+	// ErrInvalidSyncSchedule is used as part of the Event 'reason' when an
+	// AppRepository fails to sync due to an invalid sync schedule.
+	ErrInvalidSyncSchedule = "ErrInvalidSyncSchedule"
+	// MessageInvalidSyncSchedule is the message used for Events when the sync
+	// schedule of an AppRepository is not valid
+	MessageInvalidSyncSchedule = "Invalid sync schedule %q: %v"
 )
 
 // Controller is the controller implementation for AppRepository resources
@@ -79,6 +98,8 @@ type Controller struct {
 
 	cronjobsLister batchlisters.CronJobLister
 	cronjobsSynced cache.InformerSynced
+	jobsSynced     cache.InformerSynced
+	podsSynced     cache.InformerSynced
 	appreposLister listers.AppRepositoryLister
 	appreposSynced cache.InformerSynced
 
@@ -93,6 +114,10 @@ type Controller struct {
 	recorder record.EventRecorder
 
 	kubeappsNamespace string
+
+	// syncer syncs the AppRepositories in process when set, instead of
+	// CronJobs and Jobs.
+	syncer *inProcessSyncer
 }
 
 // NewController returns a new sample controller
@@ -103,9 +128,11 @@ func NewController(
 	apprepoInformerFactory informers.SharedInformerFactory,
 	kubeappsNamespace string) *Controller {
 
-	// obtain references to shared index informers for the CronJob and
-	// AppRepository types.
+	// obtain references to shared index informers for the CronJob, Job, Pod
+	// and AppRepository types.
 	cronjobInformer := kubeInformerFactory.Batch().V1beta1().CronJobs()
+	jobInformer := kubeInformerFactory.Batch().V1().Jobs()
+	podInformer := kubeInformerFactory.Core().V1().Pods()
 	apprepoInformer := apprepoInformerFactory.Kubeapps().V1alpha1().AppRepositories()
 
 	// Create event broadcaster
@@ -123,6 +150,8 @@ func NewController(
 		apprepoclientset:  apprepoclientset,
 		cronjobsLister:    cronjobInformer.Lister(),
 		cronjobsSynced:    cronjobInformer.Informer().HasSynced,
+		jobsSynced:        jobInformer.Informer().HasSynced,
+		podsSynced:        podInformer.Informer().HasSynced,
 		appreposLister:    apprepoInformer.Lister(),
 		appreposSynced:    apprepoInformer.Informer().HasSynced,
 		workqueue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "AppRepositories"),
@@ -137,7 +166,8 @@ func NewController(
 		UpdateFunc: func(oldObj, newObj interface{}) {
 			oldApp := oldObj.(*apprepov1alpha1.AppRepository)
 			newApp := newObj.(*apprepov1alpha1.AppRepository)
-			if oldApp.Spec.URL != newApp.Spec.URL || oldApp.Spec.ResyncRequests != newApp.Spec.ResyncRequests {
+			// Any change of the spec may change the CronJob, e.g. its args.
+			if !reflect.DeepEqual(oldApp.Spec, newApp.Spec) {
 				controller.enqueueAppRepo(newApp)
 			}
 		},
@@ -160,6 +190,18 @@ func NewController(
 		DeleteFunc: controller.handleObject,
 	})
 
+	// Set up an event handler for when sync Jobs finish, to report their
+	// outcome in the status of the AppRepository.
+	jobInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: controller.handleJob,
+	})
+
+	// Set up an event handler for when the containers of sync Jobs fail, since
+	// they are restarted and their Job may never finish.
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: controller.handleSyncPod,
+	})
+
 	return controller
 }
 
@@ -176,7 +218,7 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	log.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.cronjobsSynced, c.appreposSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.cronjobsSynced, c.jobsSynced, c.podsSynced, c.appreposSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -186,6 +228,11 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
 
+	if c.syncer != nil {
+		log.Infof("Starting %d in-process sync workers", c.syncer.workers)
+		go c.syncer.run(stopCh)
+	}
+
 	log.Info("Started workers")
 	<-stopCh
 	log.Info("Shutting down workers")
@@ -271,6 +318,10 @@ func (c *Controller) syncHandler(key string) error {
 		// The AppRepository resource may no longer exist, in which case we stop
 		// processing.
 		if errors.IsNotFound(err) {
+			if c.syncer != nil {
+				c.syncer.syncNow(key)
+				return nil
+			}
 			log.Infof("AppRepository '%s' no longer exists so performing cleanup of charts from the DB", key)
 			// Trigger a Job to perfrom the cleanup of the charts in the DB corresponding to deleted AppRepository
 			_, err = c.kubeclientset.BatchV1().Jobs(namespace).Create(context.TODO(), newCleanupJob(name, namespace, c.kubeappsNamespace), metav1.CreateOptions{})
@@ -279,6 +330,21 @@ func (c *Controller) syncHandler(key string) error {
 		return fmt.Errorf("Error fetching object with key %s from store: %v", key, err)
 	}
 
+	// An invalid schedule can only be fixed by updating the AppRepository,
+	// which enqueues it again, so it is not retried.
+	if apprepo.Spec.SyncSchedule != "" {
+		if err := cron.Validate(apprepo.Spec.SyncSchedule); err != nil {
+			msg := fmt.Sprintf(MessageInvalidSyncSchedule, apprepo.Spec.SyncSchedule, err)
+			c.recorder.Event(apprepo, corev1.EventTypeWarning, ErrInvalidSyncSchedule, msg)
+			runtime.HandleError(fmt.Errorf("AppRepository '%s': %s", key, msg))
+			return nil
+		}
+	}
+
+	if c.syncer != nil {
+		return c.syncInProcess(key, apprepo)
+	}
+
 	// Get the cronjob with the same name as AppRepository
 	cronjobName := cronJobName(apprepo)
 	cronjob, err := c.cronjobsLister.CronJobs(c.kubeappsNamespace).Get(cronjobName)
@@ -291,7 +357,9 @@ func (c *Controller) syncHandler(key string) error {
 		}
 
 		// Trigger a manual Job for the initial sync
-		_, err = c.kubeclientset.BatchV1().Jobs(c.kubeappsNamespace).Create(context.TODO(), newSyncJob(apprepo, c.kubeappsNamespace), metav1.CreateOptions{})
+		if !apprepo.Spec.Suspend {
+			_, err = c.kubeclientset.BatchV1().Jobs(c.kubeappsNamespace).Create(context.TODO(), newSyncJob(apprepo, c.kubeappsNamespace), metav1.CreateOptions{})
+		}
 	} else if err == nil {
 		// If the resource already exists, we'll update it
 		log.Infof("Updating CronJob %q in namespace %q for AppRepository %q in namespace %q", cronjobName, c.kubeappsNamespace, apprepo.GetName(), apprepo.GetNamespace())
@@ -301,7 +369,9 @@ func (c *Controller) syncHandler(key string) error {
 		}
 
 		// The AppRepository has changed, launch a manual Job
-		_, err = c.kubeclientset.BatchV1().Jobs(c.kubeappsNamespace).Create(context.TODO(), newSyncJob(apprepo, c.kubeappsNamespace), metav1.CreateOptions{})
+		if !apprepo.Spec.Suspend {
+			_, err = c.kubeclientset.BatchV1().Jobs(c.kubeappsNamespace).Create(context.TODO(), newSyncJob(apprepo, c.kubeappsNamespace), metav1.CreateOptions{})
+		}
 	}
 
 	// If an error occurs during Get/Create, we'll requeue the item so we can
@@ -326,6 +396,28 @@ func (c *Controller) syncHandler(key string) error {
 	return nil
 }
 
+// syncInProcess schedules an immediate sync of an AppRepository in the
+// controller, removing the CronJob left by the Job sync mode if any.
+func (c *Controller) syncInProcess(key string, apprepo *apprepov1alpha1.AppRepository) error {
+	cronjobName := cronJobName(apprepo)
+	cronjob, err := c.cronjobsLister.CronJobs(c.kubeappsNamespace).Get(cronjobName)
+	if err == nil && (metav1.IsControlledBy(cronjob, apprepo) || objectBelongsTo(cronjob, apprepo)) {
+		log.Infof("Deleting CronJob %q in namespace %q as AppRepositories are synced in process", cronjobName, c.kubeappsNamespace)
+		err = c.kubeclientset.BatchV1beta1().CronJobs(c.kubeappsNamespace).Delete(context.TODO(), cronjobName, metav1.DeleteOptions{})
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	if !apprepo.Spec.Suspend {
+		c.syncer.syncNow(key)
+	}
+	if apprepo.GetNamespace() == c.kubeappsNamespace {
+		c.recorder.Event(apprepo, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+	}
+	return nil
+}
+
 // belongsTo is similar to IsControlledBy, but enables us to establish a relationship
 // between cronjobs and app repositories in different namespaces.
 func objectBelongsTo(object, parent metav1.Object) bool {
@@ -417,7 +509,8 @@ func newCronJob(apprepo *apprepov1alpha1.AppRepository, kubeappsNamespace string
 			Labels:          jobLabels(apprepo),
 		},
 		Spec: batchv1beta1.CronJobSpec{
-			Schedule: crontab,
+			Schedule: syncSchedule(apprepo),
+			Suspend:  suspend(apprepo),
 			// Set to replace as short-circuit in k8s <1.12
 			// TODO re-evaluate ConcurrentPolicy when 1.12+ is mainstream (i.e 1.14)
 			// https://github.com/kubernetes/kubernetes/issues/54870
@@ -429,6 +522,25 @@ func newCronJob(apprepo *apprepov1alpha1.AppRepository, kubeappsNamespace string
 	}
 }
 
+// syncSchedule returns the schedule of the sync CronJob of an AppRepository,
+// defaulting to the schedule of the controller.
+func syncSchedule(apprepo *apprepov1alpha1.AppRepository) string {
+	if apprepo.Spec.SyncSchedule != "" {
+		return apprepo.Spec.SyncSchedule
+	}
+	return crontab
+}
+
+// suspend returns whether the sync CronJob of an AppRepository is suspended,
+// leaving it unset otherwise.
+func suspend(apprepo *apprepov1alpha1.AppRepository) *bool {
+	if !apprepo.Spec.Suspend {
+		return nil
+	}
+	suspend := true
+	return &suspend
+}
+
 // newSyncJob triggers a job for the AppRepository resource. It also sets the
 // appropriate OwnerReferences on the resource
 func newSyncJob(apprepo *apprepov1alpha1.AppRepository, kubeappsNamespace string) *batchv1.Job {
@@ -463,6 +575,24 @@ func syncJobSpec(apprepo *apprepov1alpha1.AppRepository, kubeappsNamespace strin
 			MountPath: "/usr/local/share/ca-certificates",
 		})
 	}
+	if apprepo.Spec.Verification != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: keyringVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: keyringSecretRefForRepo(apprepo, kubeappsNamespace).Name,
+					Items: []corev1.KeyToPath{
+						{Key: apprepo.Spec.Verification.KeyringSecretRef.Key, Path: keyringFile},
+					},
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      keyringVolume,
+			ReadOnly:  true,
+			MountPath: keyringDir,
+		})
+	}
 	// Get the predefined pod spec for the apprepo definition if exists
 	podTemplateSpec := apprepo.Spec.SyncJobPodTemplate
 	// Add labels
@@ -565,6 +695,18 @@ func apprepoSyncJobArgs(apprepo *apprepov1alpha1.AppRepository) []string {
 		args = append(args, "--user-agent-comment="+userAgentComment)
 	}
 
+	if metricsPushgatewayURL != "" {
+		args = append(args, "--metrics-pushgateway-url="+metricsPushgatewayURL)
+	}
+
+	if apprepo.Spec.Type == "oci" {
+		args = append(args, "--repo-type=oci", "--oci-repositories="+strings.Join(apprepo.Spec.OCIRepositories, ","))
+	}
+
+	if apprepo.Spec.Verification != nil {
+		args = append(args, "--keyring="+keyringDir+"/"+keyringFile)
+	}
+
 	return append(args, "--namespace="+apprepo.GetNamespace(), apprepo.GetName(), apprepo.Spec.URL)
 }
 
@@ -603,6 +745,17 @@ func secretKeyRefForRepo(keyRef corev1.SecretKeySelector, apprepo *apprepov1alph
 	return &keyRef
 }
 
+// keyringSecretRefForRepo returns the trusted keys of an AppRepository. For
+// AppRepositories outside the kubeapps namespace, they are read from the copy
+// of the keyring secret in the kubeapps namespace.
+func keyringSecretRefForRepo(apprepo *apprepov1alpha1.AppRepository, kubeappsNamespace string) *corev1.SecretKeySelector {
+	keyRef := apprepo.Spec.Verification.KeyringSecretRef
+	if apprepo.ObjectMeta.Namespace != kubeappsNamespace {
+		keyRef.LocalObjectReference.Name = kube.KubeappsKeyringSecretNameForRepo(apprepo.ObjectMeta.Name, apprepo.ObjectMeta.Namespace)
+	}
+	return &keyRef
+}
+
 // apprepoCleanupJobArgs returns a list of args for the repo cleanup container
 func apprepoCleanupJobArgs(repoName, repoNamespace string) []string {
 	return append([]string{
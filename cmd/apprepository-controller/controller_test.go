@@ -5,6 +5,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	apprepov1alpha1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/kube"
 	batchv1 "k8s.io/api/batch/v1"
 	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
@@ -18,6 +19,7 @@ func Test_newCronJob(t *testing.T) {
 	dbUser = "admin"
 	dbSecretName = "mongodb"
 	const kubeappsNamespace = "kubeapps"
+	suspended := true
 	tests := []struct {
 		name             string
 		apprepo          *apprepov1alpha1.AppRepository
@@ -297,6 +299,96 @@ func Test_newCronJob(t *testing.T) {
 			"kubeapps/v2.3",
 			"*/20 * * * *",
 		},
+		{
+			"my-charts with its own sync schedule, suspended",
+			&apprepov1alpha1.AppRepository{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "AppRepository",
+					APIVersion: "kubeapps.com/v1alpha1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "my-charts",
+					Namespace: "kubeapps",
+					Labels: map[string]string{
+						"name":       "my-charts",
+						"created-by": "kubeapps",
+					},
+				},
+				Spec: apprepov1alpha1.AppRepositorySpec{
+					Type:         "helm",
+					URL:          "https://charts.acme.com/my-charts",
+					SyncSchedule: "*/2 * * * *",
+					Suspend:      true,
+				},
+			},
+			batchv1beta1.CronJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "apprepo-kubeapps-sync-my-charts",
+					OwnerReferences: []metav1.OwnerReference{
+						*metav1.NewControllerRef(
+							&apprepov1alpha1.AppRepository{ObjectMeta: metav1.ObjectMeta{Name: "my-charts"}},
+							schema.GroupVersionKind{
+								Group:   apprepov1alpha1.SchemeGroupVersion.Group,
+								Version: apprepov1alpha1.SchemeGroupVersion.Version,
+								Kind:    "AppRepository",
+							}),
+					},
+					Labels: map[string]string{
+						LabelRepoName:      "my-charts",
+						LabelRepoNamespace: "kubeapps",
+					},
+				},
+				Spec: batchv1beta1.CronJobSpec{
+					Schedule:          "*/2 * * * *",
+					Suspend:           &suspended,
+					ConcurrencyPolicy: "Replace",
+					JobTemplate: batchv1beta1.JobTemplateSpec{
+						Spec: batchv1.JobSpec{
+							Template: corev1.PodTemplateSpec{
+								ObjectMeta: metav1.ObjectMeta{
+									Labels: map[string]string{
+										LabelRepoName:      "my-charts",
+										LabelRepoNamespace: "kubeapps",
+									},
+								},
+								Spec: corev1.PodSpec{
+									RestartPolicy: "OnFailure",
+									Containers: []corev1.Container{
+										{
+											Name:            "sync",
+											Image:           repoSyncImage,
+											ImagePullPolicy: "IfNotPresent",
+											Command:         []string{"/chart-repo"},
+											Args: []string{
+												"sync",
+												"--database-type=mongodb",
+												"--database-url=mongodb.kubeapps",
+												"--database-user=admin",
+												"--database-name=assets",
+												"--namespace=kubeapps",
+												"my-charts",
+												"https://charts.acme.com/my-charts",
+											},
+											Env: []corev1.EnvVar{
+												{
+													Name: "DB_PASSWORD",
+													ValueFrom: &corev1.EnvVarSource{
+														SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "mongodb"}, Key: "mongodb-root-password"}},
+												},
+											},
+											VolumeMounts: nil,
+										},
+									},
+									Volumes: nil,
+								},
+							},
+						},
+					},
+				},
+			},
+			"",
+			"*/20 * * * *",
+		},
 	}
 
 	for _, tt := range tests {
@@ -876,6 +968,100 @@ func Test_newSyncJob(t *testing.T) {
 	}
 }
 
+func Test_apprepoSyncJobArgs(t *testing.T) {
+	dbURL = "mongodb.kubeapps"
+	dbName = "assets"
+	dbUser = "admin"
+	apprepo := &apprepov1alpha1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-charts",
+			Namespace: "kubeapps",
+		},
+		Spec: apprepov1alpha1.AppRepositorySpec{
+			Type:            "oci",
+			URL:             "oci://harbor.acme.com",
+			OCIRepositories: []string{"library/nginx", "library/wordpress"},
+		},
+	}
+	expected := []string{
+		"sync",
+		"--database-type=mongodb",
+		"--database-url=mongodb.kubeapps",
+		"--database-user=admin",
+		"--database-name=assets",
+		"--repo-type=oci",
+		"--oci-repositories=library/nginx,library/wordpress",
+		"--namespace=kubeapps",
+		"my-charts",
+		"oci://harbor.acme.com",
+	}
+
+	if got, want := apprepoSyncJobArgs(apprepo), expected; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+
+	metricsPushgatewayURL = "http://pushgateway.monitoring:9091"
+	defer func() { metricsPushgatewayURL = "" }()
+	expected = append(expected[:5:5], append([]string{"--metrics-pushgateway-url=http://pushgateway.monitoring:9091"}, expected[5:]...)...)
+	if got, want := apprepoSyncJobArgs(apprepo), expected; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func Test_syncJobSpecVerification(t *testing.T) {
+	const kubeappsNamespace = "kubeapps"
+	newAppRepo := func(namespace string) *apprepov1alpha1.AppRepository {
+		return &apprepov1alpha1.AppRepository{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-charts",
+				Namespace: namespace,
+			},
+			Spec: apprepov1alpha1.AppRepositorySpec{
+				Type: "helm",
+				URL:  "https://charts.acme.com/my-charts",
+				Verification: &apprepov1alpha1.AppRepositoryVerification{
+					KeyringSecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "my-keyring"}, Key: "pubring.gpg"},
+					Required:         true,
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name       string
+		apprepo    *apprepov1alpha1.AppRepository
+		secretName string
+	}{
+		{"repo in the kubeapps namespace", newAppRepo(kubeappsNamespace), "my-keyring"},
+		// The secret is read from its copy in the kubeapps namespace.
+		{"repo in another namespace", newAppRepo("my-namespace"), kube.KubeappsKeyringSecretNameForRepo("my-charts", "my-namespace")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := syncJobSpec(tt.apprepo, kubeappsNamespace).Template.Spec
+			expectedVolumes := []corev1.Volume{{
+				Name: "keyring",
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName: tt.secretName,
+						Items:      []corev1.KeyToPath{{Key: "pubring.gpg", Path: "keyring"}},
+					},
+				},
+			}}
+			if got, want := spec.Volumes, expectedVolumes; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+			expectedMounts := []corev1.VolumeMount{{Name: "keyring", ReadOnly: true, MountPath: "/var/run/kubeapps/keyring"}}
+			if got, want := spec.Containers[0].VolumeMounts, expectedMounts; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+			args := spec.Containers[0].Args
+			if got, want := args[len(args)-4], "--keyring=/var/run/kubeapps/keyring/keyring"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
 func Test_newCleanupJob(t *testing.T) {
 	dbURL = "mongodb.kubeapps"
 	dbName = "assets"
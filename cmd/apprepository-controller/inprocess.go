@@ -0,0 +1,296 @@
+/*
+Copyright 2020 Bitnami.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	apprepov1alpha1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	clientset "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/clientset/versioned"
+	listers "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/listers/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/assetsyncer"
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	"github.com/kubeapps/kubeapps/pkg/cron"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// syncModeJob runs the asset-syncer in CronJobs and Jobs.
+	syncModeJob = "job"
+	// syncModeInProcess runs the asset-syncer logic in the controller.
+	syncModeInProcess = "inprocess"
+)
+
+// inProcessSyncer syncs AppRepositories and cleans up the charts of deleted
+// ones in the controller process, instead of running the asset-syncer in
+// CronJobs and Jobs. Each AppRepository is synced by one of a fixed number of
+// workers when its schedule is due, and retried with an exponential backoff
+// when its sync fails.
+type inProcessSyncer struct {
+	kubeclientset     kubernetes.Interface
+	apprepoclientset  clientset.Interface
+	appreposLister    listers.AppRepositoryLister
+	kubeappsNamespace string
+	workers           int
+
+	// queue holds the keys of the AppRepositories to sync or clean up, added
+	// with a delay until their next scheduled sync or retry. A key is never
+	// processed by two workers at the same time.
+	queue workqueue.DelayingInterface
+	// backoff returns the delay before retrying a failed sync.
+	backoff workqueue.RateLimiter
+
+	mu sync.Mutex
+	// due is the time of the next sync of each AppRepository. The wake-ups
+	// of the queue for an earlier schedule are ignored.
+	due map[string]time.Time
+
+	// sync and delete are the asset-syncer operations.
+	sync   func(netClient assetsyncer.HTTPClient, repo assetsyncer.Repository) (*models.RepoSyncResult, error)
+	delete func(repo models.Repo) error
+	now    func() time.Time
+}
+
+// newInProcessSyncer returns an inProcessSyncer storing the charts with the
+// given manager.
+func newInProcessSyncer(kubeclientset kubernetes.Interface, apprepoclientset clientset.Interface, appreposLister listers.AppRepositoryLister, kubeappsNamespace string, workers int, manager assetsyncer.AssetManager) *inProcessSyncer {
+	return &inProcessSyncer{
+		kubeclientset:     kubeclientset,
+		apprepoclientset:  apprepoclientset,
+		appreposLister:    appreposLister,
+		kubeappsNamespace: kubeappsNamespace,
+		workers:           workers,
+		queue:             workqueue.NewNamedDelayingQueue("AppRepositorySyncs"),
+		backoff:           workqueue.NewItemExponentialFailureRateLimiter(5*time.Second, 10*time.Minute),
+		due:               map[string]time.Time{},
+		sync: func(netClient assetsyncer.HTTPClient, repo assetsyncer.Repository) (*models.RepoSyncResult, error) {
+			return assetsyncer.Sync(manager, netClient, repo)
+		},
+		delete: manager.Delete,
+		now:    time.Now,
+	}
+}
+
+// run starts the workers and blocks until stopCh is closed.
+func (s *inProcessSyncer) run(stopCh <-chan struct{}) {
+	defer s.queue.ShutDown()
+	for i := 0; i < s.workers; i++ {
+		go wait.Until(s.runWorker, time.Second, stopCh)
+	}
+	<-stopCh
+}
+
+// syncNow schedules an immediate sync of an AppRepository, following its
+// changes, or the cleanup of its charts if it no longer exists.
+func (s *inProcessSyncer) syncNow(key string) {
+	s.mu.Lock()
+	s.due[key] = s.now()
+	s.mu.Unlock()
+	s.queue.Add(key)
+}
+
+func (s *inProcessSyncer) runWorker() {
+	for s.processNextWorkItem() {
+	}
+}
+
+func (s *inProcessSyncer) processNextWorkItem() bool {
+	obj, shutdown := s.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer s.queue.Done(obj)
+	key, ok := obj.(string)
+	if !ok {
+		runtime.HandleError(fmt.Errorf("expected string in workqueue but got %#v", obj))
+		return true
+	}
+	if err := s.process(key); err != nil {
+		runtime.HandleError(fmt.Errorf("error syncing '%s' in process: %s", key, err.Error()))
+	}
+	return true
+}
+
+// process syncs or cleans up the AppRepository with the given key if it is
+// due, and schedules its next sync.
+func (s *inProcessSyncer) process(key string) error {
+	now := s.now()
+	s.mu.Lock()
+	due, scheduled := s.due[key]
+	s.mu.Unlock()
+	if scheduled && now.Before(due) {
+		// Wake-up of a schedule replaced since.
+		return nil
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		s.forget(key)
+		return err
+	}
+	apprepo, err := s.appreposLister.AppRepositories(namespace).Get(name)
+	if errors.IsNotFound(err) {
+		log.Infof("AppRepository '%s' no longer exists so performing cleanup of charts from the DB", key)
+		if err := s.delete(models.Repo{Namespace: namespace, Name: name}); err != nil {
+			s.retry(key, now, nil)
+			return err
+		}
+		s.forget(key)
+		return nil
+	}
+	if err != nil {
+		s.retry(key, now, nil)
+		return err
+	}
+	if apprepo.Spec.Suspend {
+		s.forget(key)
+		return nil
+	}
+	schedule, err := cron.Parse(syncSchedule(apprepo))
+	if err != nil {
+		// The schedule is reported by the controller and fixed with an update.
+		s.forget(key)
+		return err
+	}
+
+	log.Infof("Syncing AppRepository %q in namespace %q", name, namespace)
+	result, err := s.syncRepo(apprepo)
+	if err != nil {
+		result = assetsyncer.ResultForError(err)
+	}
+	if serr := s.updateStatus(apprepo, result, s.now()); serr != nil {
+		runtime.HandleError(fmt.Errorf("unable to update the status of AppRepository '%s': %v", key, serr))
+	}
+	if err != nil {
+		s.retry(key, now, schedule)
+		return err
+	}
+	s.backoff.Forget(key)
+	s.scheduleAt(key, now, schedule.Next(now))
+	return nil
+}
+
+// syncRepo syncs an AppRepository with the credentials and the trusted keys
+// of its secrets.
+func (s *inProcessSyncer) syncRepo(apprepo *apprepov1alpha1.AppRepository) (*models.RepoSyncResult, error) {
+	var authorizationHeader string
+	if apprepo.Spec.Auth.Header != nil {
+		header, err := s.secretValue(secretKeyRefForRepo(apprepo.Spec.Auth.Header.SecretKeyRef, apprepo, s.kubeappsNamespace))
+		if err != nil {
+			return nil, err
+		}
+		authorizationHeader = string(header)
+	}
+	var customCA []byte
+	if apprepo.Spec.Auth.CustomCA != nil {
+		var err error
+		customCA, err = s.secretValue(secretKeyRefForRepo(apprepo.Spec.Auth.CustomCA.SecretKeyRef, apprepo, s.kubeappsNamespace))
+		if err != nil {
+			return nil, err
+		}
+	}
+	var keyring []byte
+	if apprepo.Spec.Verification != nil {
+		var err error
+		keyring, err = s.secretValue(keyringSecretRefForRepo(apprepo, s.kubeappsNamespace))
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Each repository gets its own client so that a custom CA is only trusted
+	// for the repository it is configured for.
+	netClient, err := assetsyncer.NewNetClient(customCA)
+	if err != nil {
+		return nil, err
+	}
+	return s.sync(netClient, assetsyncer.Repository{
+		Namespace:           apprepo.GetNamespace(),
+		Name:                apprepo.GetName(),
+		URL:                 apprepo.Spec.URL,
+		Type:                apprepo.Spec.Type,
+		OCIRepositories:     apprepo.Spec.OCIRepositories,
+		AuthorizationHeader: authorizationHeader,
+		Keyring:             keyring,
+	})
+}
+
+// secretValue returns the value of a key of a secret of an AppRepository,
+// referenced by its copy in the kubeapps namespace as for the sync Jobs.
+func (s *inProcessSyncer) secretValue(ref *corev1.SecretKeySelector) ([]byte, error) {
+	secret, err := s.kubeclientset.CoreV1().Secrets(s.kubeappsNamespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %q not found in secret %q", ref.Key, ref.Name)
+	}
+	return value, nil
+}
+
+// updateStatus reports the result of a sync in the status of an
+// AppRepository.
+func (s *inProcessSyncer) updateStatus(apprepo *apprepov1alpha1.AppRepository, result *models.RepoSyncResult, syncTime time.Time) error {
+	apprepo = apprepo.DeepCopy()
+	apprepo.Status = repoStatus(apprepo.Status, metav1.NewTime(syncTime), result)
+	_, err := s.apprepoclientset.KubeappsV1alpha1().AppRepositories(apprepo.GetNamespace()).UpdateStatus(context.TODO(), apprepo, metav1.UpdateOptions{})
+	return err
+}
+
+// retry schedules a failed operation after the backoff of the key, or at the
+// next scheduled sync if it comes first.
+func (s *inProcessSyncer) retry(key string, now time.Time, schedule cron.Schedule) {
+	at := now.Add(s.backoff.When(key))
+	if schedule != nil {
+		if next := schedule.Next(now); !next.IsZero() && next.Before(at) {
+			at = next
+		}
+	}
+	s.scheduleAt(key, now, at)
+}
+
+// scheduleAt queues the key at the given time, replacing its previous
+// schedule. The key is not queued again if the time is zero.
+func (s *inProcessSyncer) scheduleAt(key string, now, at time.Time) {
+	if at.IsZero() {
+		s.forget(key)
+		return
+	}
+	s.mu.Lock()
+	s.due[key] = at
+	s.mu.Unlock()
+	s.queue.AddAfter(key, at.Sub(now))
+}
+
+// forget removes the schedule and backoff of the key.
+func (s *inProcessSyncer) forget(key string) {
+	s.mu.Lock()
+	delete(s.due, key)
+	s.mu.Unlock()
+	s.backoff.Forget(key)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	apprepov1alpha1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	fakeapprepo "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/clientset/versioned/fake"
+	listers "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/listers/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/assetsyncer"
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+type fakeSyncer struct {
+	synced  []assetsyncer.Repository
+	deleted []models.Repo
+	err     error
+}
+
+func (f *fakeSyncer) sync(netClient assetsyncer.HTTPClient, repo assetsyncer.Repository) (*models.RepoSyncResult, error) {
+	f.synced = append(f.synced, repo)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &models.RepoSyncResult{Checksum: "abc", ChartCount: 3}, nil
+}
+
+func (f *fakeSyncer) delete(repo models.Repo) error {
+	f.deleted = append(f.deleted, repo)
+	return f.err
+}
+
+func newInProcessSyncerFixture(now time.Time, f *fakeSyncer, apprepos ...*apprepov1alpha1.AppRepository) (*inProcessSyncer, *fakeapprepo.Clientset) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	objects := []runtime.Object{}
+	for _, apprepo := range apprepos {
+		indexer.Add(apprepo)
+		objects = append(objects, apprepo)
+	}
+	apprepoClient := fakeapprepo.NewSimpleClientset(objects...)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "apprepo-my-charts-secrets", Namespace: "kubeapps"},
+		Data:       map[string][]byte{"AuthorizationHeader": []byte("Bearer abc")},
+	}
+	return &inProcessSyncer{
+		kubeclientset:     fake.NewSimpleClientset(secret),
+		apprepoclientset:  apprepoClient,
+		appreposLister:    listers.NewAppRepositoryLister(indexer),
+		kubeappsNamespace: "kubeapps",
+		workers:           1,
+		queue:             workqueue.NewDelayingQueue(),
+		backoff:           workqueue.NewItemExponentialFailureRateLimiter(5*time.Second, 10*time.Minute),
+		due:               map[string]time.Time{},
+		sync:              f.sync,
+		delete:            f.delete,
+		now:               func() time.Time { return now },
+	}, apprepoClient
+}
+
+func Test_inProcessSyncer_process(t *testing.T) {
+	now := time.Date(2020, 6, 1, 10, 7, 0, 0, time.UTC)
+	apprepo := &apprepov1alpha1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-charts", Namespace: "kubeapps"},
+		Spec: apprepov1alpha1.AppRepositorySpec{
+			URL:          "https://charts.acme.com/my-charts",
+			SyncSchedule: "*/30 * * * *",
+			Auth: apprepov1alpha1.AppRepositoryAuth{
+				Header: &apprepov1alpha1.AppRepositoryAuthHeader{
+					SecretKeyRef: corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "apprepo-my-charts-secrets"},
+						Key:                  "AuthorizationHeader",
+					},
+				},
+			},
+		},
+	}
+
+	t.Run("syncs the repository and schedules the next sync", func(t *testing.T) {
+		f := &fakeSyncer{}
+		s, apprepoClient := newInProcessSyncerFixture(now, f, apprepo)
+		s.syncNow("kubeapps/my-charts")
+
+		if err := s.process("kubeapps/my-charts"); err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		expected := []assetsyncer.Repository{{
+			Namespace:           "kubeapps",
+			Name:                "my-charts",
+			URL:                 "https://charts.acme.com/my-charts",
+			AuthorizationHeader: "Bearer abc",
+		}}
+		if !cmp.Equal(expected, f.synced) {
+			t.Errorf("Unexpected syncs: %s", cmp.Diff(expected, f.synced))
+		}
+		if got, want := s.due["kubeapps/my-charts"], time.Date(2020, 6, 1, 10, 30, 0, 0, time.UTC); !got.Equal(want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		updated, err := apprepoClient.KubeappsV1alpha1().AppRepositories("kubeapps").Get(context.TODO(), "my-charts", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if got, want := updated.Status.ChartCount, 3; got != want {
+			t.Errorf("got: %d, want: %d", got, want)
+		}
+	})
+
+	t.Run("retries a failed sync with a backoff", func(t *testing.T) {
+		f := &fakeSyncer{err: errors.New("boom")}
+		s, apprepoClient := newInProcessSyncerFixture(now, f, apprepo)
+		s.syncNow("kubeapps/my-charts")
+
+		if err := s.process("kubeapps/my-charts"); err == nil {
+			t.Fatalf("got: nil, want: error")
+		}
+
+		if got, want := s.due["kubeapps/my-charts"], now.Add(5*time.Second); !got.Equal(want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+		updated, err := apprepoClient.KubeappsV1alpha1().AppRepositories("kubeapps").Get(context.TODO(), "my-charts", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if got, want := updated.Status.LastError, "boom"; got != want {
+			t.Errorf("got: %q, want: %q", got, want)
+		}
+	})
+
+	t.Run("ignores the wake-ups of a previous schedule", func(t *testing.T) {
+		f := &fakeSyncer{}
+		s, _ := newInProcessSyncerFixture(now, f, apprepo)
+		s.due["kubeapps/my-charts"] = now.Add(time.Minute)
+
+		if err := s.process("kubeapps/my-charts"); err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		if len(f.synced) != 0 {
+			t.Errorf("got: %v, want: no sync", f.synced)
+		}
+	})
+
+	t.Run("does not sync a suspended repository", func(t *testing.T) {
+		suspended := apprepo.DeepCopy()
+		suspended.Spec.Suspend = true
+		f := &fakeSyncer{}
+		s, _ := newInProcessSyncerFixture(now, f, suspended)
+		s.syncNow("kubeapps/my-charts")
+
+		if err := s.process("kubeapps/my-charts"); err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		if len(f.synced) != 0 {
+			t.Errorf("got: %v, want: no sync", f.synced)
+		}
+		if _, ok := s.due["kubeapps/my-charts"]; ok {
+			t.Errorf("expected the repository not to be scheduled")
+		}
+	})
+
+	t.Run("cleans up a deleted repository", func(t *testing.T) {
+		f := &fakeSyncer{}
+		s, _ := newInProcessSyncerFixture(now, f)
+		s.syncNow("kubeapps/my-charts")
+
+		if err := s.process("kubeapps/my-charts"); err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		expected := []models.Repo{{Namespace: "kubeapps", Name: "my-charts"}}
+		if !cmp.Equal(expected, f.deleted) {
+			t.Errorf("Unexpected deletions: %s", cmp.Diff(expected, f.deleted))
+		}
+	})
+}
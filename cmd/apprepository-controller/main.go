@@ -18,10 +18,13 @@ package main
 
 import (
 	"flag"
+	"os"
 
+	"github.com/kubeapps/common/datastore"
 	clientset "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/clientset/versioned"
 	informers "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/informers/externalversions"
 	"github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/signals"
+	"github.com/kubeapps/kubeapps/pkg/assetsyncer"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd" // Uncomment the following line to load the gcp plugin (only required to authenticate against GKE clusters).
@@ -45,6 +48,10 @@ var (
 	userAgentComment  string
 	crontab           string
 	reposPerNamespace bool
+	syncMode          string
+	syncWorkers       int
+	// metricsPushgatewayURL is where the sync Jobs push their metrics
+	metricsPushgatewayURL string
 )
 
 func main() {
@@ -74,6 +81,25 @@ func main() {
 
 	controller := NewController(kubeClient, apprepoClient, kubeInformerFactory, apprepoInformerFactory, namespace)
 
+	switch syncMode {
+	case syncModeJob:
+	case syncModeInProcess:
+		// The database password is read from the same secret as the sync Jobs.
+		dbConfig := datastore.Config{URL: dbURL, Database: dbName, Username: dbUser, Password: os.Getenv("DB_PASSWORD")}
+		manager, err := assetsyncer.NewManager(dbType, dbConfig, namespace)
+		if err != nil {
+			log.Fatalf("Error creating the assets database manager: %s", err.Error())
+		}
+		if err = manager.Init(); err != nil {
+			log.Fatalf("Error connecting to the assets database: %s", err.Error())
+		}
+		defer manager.Close()
+		assetsyncer.UserAgentComment = userAgentComment
+		controller.syncer = newInProcessSyncer(kubeClient, apprepoClient, controller.appreposLister, namespace, syncWorkers, manager)
+	default:
+		log.Fatalf("Unknown sync mode %q, expected %q or %q", syncMode, syncModeJob, syncModeInProcess)
+	}
+
 	go kubeInformerFactory.Start(stopCh)
 	go apprepoInformerFactory.Start(stopCh)
 
@@ -97,4 +123,7 @@ func init() {
 	flag.StringVar(&dbSecretKey, "database-secret-key", "mongodb-root-password", "Kubernetes secret key used for database credentials")
 	flag.StringVar(&userAgentComment, "user-agent-comment", "", "UserAgent comment used during outbound requests")
 	flag.StringVar(&crontab, "crontab", "*/10 * * * *", "CronTab to specify schedule")
+	flag.StringVar(&syncMode, "sync-mode", syncModeJob, "How AppRepositories are synced. Allowed values: job (CronJobs and Jobs running repo-sync-image), inprocess (workers of the controller)")
+	flag.StringVar(&metricsPushgatewayURL, "metrics-pushgateway-url", "", "URL of a Pushgateway the sync Jobs push their metrics to")
+	flag.IntVar(&syncWorkers, "sync-workers", 2, "Maximum number of AppRepositories synced at the same time with the inprocess sync mode")
 }
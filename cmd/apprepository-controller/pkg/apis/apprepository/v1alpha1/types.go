@@ -22,7 +22,6 @@ import (
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // AppRepository is a specification for an AppRepository resource
@@ -36,6 +35,8 @@ type AppRepository struct {
 
 // AppRepositorySpec is the spec for an AppRepository resource
 type AppRepositorySpec struct {
+	// Type is the type of repository: "helm" (default) for a chart
+	// repository serving an index.yaml or "oci" for an OCI registry.
 	Type               string                 `json:"type"`
 	URL                string                 `json:"url"`
 	Auth               AppRepositoryAuth      `json:"auth,omitempty"`
@@ -45,6 +46,26 @@ type AppRepositorySpec struct {
 	// in the same namespace as the AppRepository and should be included
 	// automatically for matching images.
 	DockerRegistrySecrets []string `json:"dockerRegistrySecrets,omitempty"`
+	// OCIRepositories is the list of repositories within an OCI registry
+	// containing charts (e.g. "project/mychart"). OCI registries are not
+	// required to support listing their catalog so the repositories to be
+	// synced must be specified explicitly. Only used for the "oci" type.
+	OCIRepositories []string `json:"ociRepositories,omitempty"`
+	// SyncSchedule is the schedule, in cron format, on which the repository
+	// is synced. The schedule of the controller is used when empty.
+	SyncSchedule string `json:"syncSchedule,omitempty"`
+	// Suspend stops the syncs of the repository, both the scheduled ones and
+	// the ones triggered by changes of the AppRepository.
+	Suspend bool `json:"suspend,omitempty"`
+	// Verification configures the verification of the provenance files of
+	// the charts. Charts are not verified when nil.
+	Verification *AppRepositoryVerification `json:"verification,omitempty"`
+	// ReleaseMetadata are the labels and annotations injected in the objects
+	// of the releases of the charts of the repository.
+	ReleaseMetadata *AppRepositoryReleaseMetadata `json:"releaseMetadata,omitempty"`
+	// ImageRewrite rewrites the images of the releases of the charts of the
+	// repository, to pull them from mirrors of their registries.
+	ImageRewrite *AppRepositoryImageRewrite `json:"imageRewrite,omitempty"`
 }
 
 // AppRepositoryAuth is the auth for an AppRepository resource
@@ -63,9 +84,80 @@ type AppRepositoryCustomCA struct {
 	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
 }
 
+// AppRepositoryVerification is the verification of the provenance files of
+// the charts of an AppRepository
+type AppRepositoryVerification struct {
+	// Selects a key of a secret in the pod's namespace containing the trusted
+	// PGP public keys, either ASCII armored or binary
+	KeyringSecretRef corev1.SecretKeySelector `json:"keyringSecretRef"`
+	// Required refuses to install the chart versions which are not signed by
+	// one of the trusted keys.
+	Required bool `json:"required,omitempty"`
+}
+
+// AppRepositoryReleaseMetadata are the labels and annotations injected in
+// the objects of releases. "$(USER)" in their values is replaced by the user
+// installing or upgrading the release.
+type AppRepositoryReleaseMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// AppRepositoryImageRewrite rewrites the images of the containers of
+// releases
+type AppRepositoryImageRewrite struct {
+	// Rules are tried in order, the first one matching an image rewriting it
+	Rules []AppRepositoryImageRewriteRule `json:"rules"`
+	// PinDigests replaces the tags of the rewritten images with the digests
+	// of their manifests in the mirror
+	PinDigests bool `json:"pinDigests,omitempty"`
+}
+
+// AppRepositoryImageRewriteRule rewrites the repository of the images
+// matching From, e.g. "docker.io/*" to "mirror.internal/dockerhub/*". A
+// trailing "/*" matches every repository under a registry or path.
+type AppRepositoryImageRewriteRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
 // AppRepositoryStatus is the status for an AppRepository resource
 type AppRepositoryStatus struct {
-	Status string `json:"status"`
+	// Status is unused and kept for backwards compatibility.
+	Status string `json:"status,omitempty"`
+	// Conditions are the latest observations of the repository state.
+	Conditions []AppRepositoryCondition `json:"conditions,omitempty"`
+	// LastSyncTime is the time at which the last successful sync completed.
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// LastSyncChecksum is the checksum of the repository content at the time
+	// of the last successful sync.
+	LastSyncChecksum string `json:"lastSyncChecksum,omitempty"`
+	// ChartCount is the number of charts found by the last successful sync.
+	ChartCount int `json:"chartCount"`
+	// LastError is the error of the last sync, if it failed.
+	LastError string `json:"lastError,omitempty"`
+}
+
+// AppRepositoryConditionType is a valid value for AppRepositoryCondition.Type
+type AppRepositoryConditionType string
+
+const (
+	// AppRepositorySynced means the last sync of the repository succeeded.
+	AppRepositorySynced AppRepositoryConditionType = "Synced"
+	// AppRepositoryReady means the charts of the repository are available.
+	AppRepositoryReady AppRepositoryConditionType = "Ready"
+	// AppRepositoryAuthFailed means the last sync was rejected by the
+	// repository because of missing or invalid credentials.
+	AppRepositoryAuthFailed AppRepositoryConditionType = "AuthFailed"
+)
+
+// AppRepositoryCondition describes the state of an AppRepository at a certain point.
+type AppRepositoryCondition struct {
+	Type               AppRepositoryConditionType `json:"type"`
+	Status             corev1.ConditionStatus     `json:"status"`
+	LastTransitionTime metav1.Time                `json:"lastTransitionTime,omitempty"`
+	Reason             string                     `json:"reason,omitempty"`
+	Message            string                     `json:"message,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -30,7 +30,7 @@ func (in *AppRepository) DeepCopyInto(out *AppRepository) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -95,6 +95,23 @@ func (in *AppRepositoryAuthHeader) DeepCopy() *AppRepositoryAuthHeader {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryCondition) DeepCopyInto(out *AppRepositoryCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryCondition.
+func (in *AppRepositoryCondition) DeepCopy() *AppRepositoryCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppRepositoryCustomCA) DeepCopyInto(out *AppRepositoryCustomCA) {
 	*out = *in
@@ -112,6 +129,43 @@ func (in *AppRepositoryCustomCA) DeepCopy() *AppRepositoryCustomCA {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryImageRewrite) DeepCopyInto(out *AppRepositoryImageRewrite) {
+	*out = *in
+	if in.Rules != nil {
+		in, out := &in.Rules, &out.Rules
+		*out = make([]AppRepositoryImageRewriteRule, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryImageRewrite.
+func (in *AppRepositoryImageRewrite) DeepCopy() *AppRepositoryImageRewrite {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryImageRewrite)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryImageRewriteRule) DeepCopyInto(out *AppRepositoryImageRewriteRule) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryImageRewriteRule.
+func (in *AppRepositoryImageRewriteRule) DeepCopy() *AppRepositoryImageRewriteRule {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryImageRewriteRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppRepositoryList) DeepCopyInto(out *AppRepositoryList) {
 	*out = *in
@@ -145,6 +199,36 @@ func (in *AppRepositoryList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryReleaseMetadata) DeepCopyInto(out *AppRepositoryReleaseMetadata) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryReleaseMetadata.
+func (in *AppRepositoryReleaseMetadata) DeepCopy() *AppRepositoryReleaseMetadata {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryReleaseMetadata)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppRepositorySpec) DeepCopyInto(out *AppRepositorySpec) {
 	*out = *in
@@ -155,6 +239,26 @@ func (in *AppRepositorySpec) DeepCopyInto(out *AppRepositorySpec) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.OCIRepositories != nil {
+		in, out := &in.OCIRepositories, &out.OCIRepositories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Verification != nil {
+		in, out := &in.Verification, &out.Verification
+		*out = new(AppRepositoryVerification)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ReleaseMetadata != nil {
+		in, out := &in.ReleaseMetadata, &out.ReleaseMetadata
+		*out = new(AppRepositoryReleaseMetadata)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ImageRewrite != nil {
+		in, out := &in.ImageRewrite, &out.ImageRewrite
+		*out = new(AppRepositoryImageRewrite)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -171,6 +275,17 @@ func (in *AppRepositorySpec) DeepCopy() *AppRepositorySpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AppRepositoryStatus) DeepCopyInto(out *AppRepositoryStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]AppRepositoryCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
 	return
 }
 
@@ -183,3 +298,20 @@ func (in *AppRepositoryStatus) DeepCopy() *AppRepositoryStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AppRepositoryVerification) DeepCopyInto(out *AppRepositoryVerification) {
+	*out = *in
+	in.KeyringSecretRef.DeepCopyInto(&out.KeyringSecretRef)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppRepositoryVerification.
+func (in *AppRepositoryVerification) DeepCopy() *AppRepositoryVerification {
+	if in == nil {
+		return nil
+	}
+	out := new(AppRepositoryVerification)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -40,6 +40,7 @@ type AppRepositoriesGetter interface {
 type AppRepositoryInterface interface {
 	Create(ctx context.Context, appRepository *v1alpha1.AppRepository, opts v1.CreateOptions) (*v1alpha1.AppRepository, error)
 	Update(ctx context.Context, appRepository *v1alpha1.AppRepository, opts v1.UpdateOptions) (*v1alpha1.AppRepository, error)
+	UpdateStatus(ctx context.Context, appRepository *v1alpha1.AppRepository, opts v1.UpdateOptions) (*v1alpha1.AppRepository, error)
 	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
 	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
 	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1alpha1.AppRepository, error)
@@ -135,6 +136,22 @@ func (c *appRepositories) Update(ctx context.Context, appRepository *v1alpha1.Ap
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *appRepositories) UpdateStatus(ctx context.Context, appRepository *v1alpha1.AppRepository, opts v1.UpdateOptions) (result *v1alpha1.AppRepository, err error) {
+	result = &v1alpha1.AppRepository{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("apprepositories").
+		Name(appRepository.Name).
+		SubResource("status").
+		VersionedParams(&opts, scheme.ParameterCodec).
+		Body(appRepository).
+		Do(ctx).
+		Into(result)
+	return
+}
+
 // Delete takes name of the appRepository and deletes it. Returns an error if one occurs.
 func (c *appRepositories) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	return c.client.Delete().
@@ -102,6 +102,18 @@ func (c *FakeAppRepositories) Update(ctx context.Context, appRepository *v1alpha
 	return obj.(*v1alpha1.AppRepository), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeAppRepositories) UpdateStatus(ctx context.Context, appRepository *v1alpha1.AppRepository, opts v1.UpdateOptions) (*v1alpha1.AppRepository, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(apprepositoriesResource, "status", c.ns, appRepository), &v1alpha1.AppRepository{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*v1alpha1.AppRepository), err
+}
+
 // Delete takes name of the appRepository and deletes it. Returns an error if one occurs.
 func (c *FakeAppRepositories) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	_, err := c.Fake.
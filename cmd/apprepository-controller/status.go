@@ -0,0 +1,313 @@
+/*
+Copyright 2020 Bitnami.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	apprepov1alpha1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	log "github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+)
+
+const (
+	// Reasons of the AppRepository conditions.
+	reasonSyncSucceeded   = "SyncSucceeded"
+	reasonSyncFailed      = "SyncFailed"
+	reasonChartsAvailable = "ChartsAvailable"
+	reasonNeverSynced     = "NeverSynced"
+	reasonUnauthorized    = "Unauthorized"
+	reasonAuthorized      = "Authorized"
+
+	// syncContainerName is the name of the container of the sync jobs.
+	syncContainerName = "sync"
+)
+
+// handleJob updates the status of the AppRepository of a sync Job once the
+// Job finishes.
+func (c *Controller) handleJob(oldObj, newObj interface{}) {
+	oldJob, ok := oldObj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	job, ok := newObj.(*batchv1.Job)
+	if !ok {
+		return
+	}
+	if jobFinished(oldJob) || !jobFinished(job) {
+		return
+	}
+	if err := c.updateRepoStatus(job); err != nil {
+		runtime.HandleError(fmt.Errorf("unable to update the status of the AppRepository of job %q: %v", job.GetName(), err))
+	}
+}
+
+// handleSyncPod updates the status of the AppRepository of a sync pod when
+// its sync container fails. Failed containers are restarted until the next
+// scheduled Job replaces theirs, so their Job may never finish: failures are
+// reported as soon as they happen.
+func (c *Controller) handleSyncPod(oldObj, newObj interface{}) {
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	pod, ok := newObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	terminated := syncFailure(oldPod, pod)
+	if terminated == nil {
+		return
+	}
+	name, namespace := pod.Labels[LabelRepoName], pod.Labels[LabelRepoNamespace]
+	if name == "" || namespace == "" {
+		return
+	}
+	result, _ := terminationResult(terminated)
+	if result.Error == "" {
+		result.Error = fmt.Sprintf("sync container failed with exit code %d", terminated.ExitCode)
+	}
+	log.Infof("Updating the status of AppRepository %q in namespace %q after pod %q failed", name, namespace, pod.GetName())
+	if err := c.setRepoStatus(name, namespace, terminated.FinishedAt, result); err != nil {
+		runtime.HandleError(fmt.Errorf("unable to update the status of the AppRepository of pod %q: %v", pod.GetName(), err))
+	}
+}
+
+// syncFailure returns the termination state of the sync container of a pod
+// if it failed since the previous state of the pod.
+func syncFailure(oldPod, pod *corev1.Pod) *corev1.ContainerStateTerminated {
+	oldStatus, status := syncContainerStatus(oldPod), syncContainerStatus(pod)
+	if status == nil {
+		return nil
+	}
+	var terminated *corev1.ContainerStateTerminated
+	switch {
+	case oldStatus != nil && oldStatus.State.Terminated != nil:
+		// The termination was already reported.
+	case status.State.Terminated != nil:
+		terminated = status.State.Terminated
+	case oldStatus != nil && status.RestartCount > oldStatus.RestartCount:
+		// The container was restarted before its termination was observed.
+		terminated = status.LastTerminationState.Terminated
+	}
+	if terminated == nil || terminated.ExitCode == 0 {
+		return nil
+	}
+	return terminated
+}
+
+// syncContainerStatus returns the status of the sync container of a pod.
+func syncContainerStatus(pod *corev1.Pod) *corev1.ContainerStatus {
+	for i, status := range pod.Status.ContainerStatuses {
+		if status.Name == syncContainerName {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
+
+// updateRepoStatus updates the status of the AppRepository synced by a
+// finished Job with the result reported by the Job.
+func (c *Controller) updateRepoStatus(job *batchv1.Job) error {
+	// Cleanup jobs have no repository labels and are ignored.
+	repoLabels := job.Spec.Template.GetLabels()
+	name, namespace := repoLabels[LabelRepoName], repoLabels[LabelRepoNamespace]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	result, err := c.syncJobResult(job)
+	if err != nil {
+		return err
+	}
+	log.Infof("Updating the status of AppRepository %q in namespace %q after job %q", name, namespace, job.GetName())
+	return c.setRepoStatus(name, namespace, jobCompletionTime(job), result)
+}
+
+// setRepoStatus updates the status of an AppRepository with the result of a
+// sync finished at the given time.
+func (c *Controller) setRepoStatus(name, namespace string, syncTime metav1.Time, result *models.RepoSyncResult) error {
+	apprepo, err := c.appreposLister.AppRepositories(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	apprepo = apprepo.DeepCopy()
+	apprepo.Status = repoStatus(apprepo.Status, syncTime, result)
+	_, err = c.apprepoclientset.KubeappsV1alpha1().AppRepositories(namespace).UpdateStatus(context.TODO(), apprepo, metav1.UpdateOptions{})
+	return err
+}
+
+// syncJobResult returns the result of a sync Job, written by the sync
+// container as its termination message.
+func (c *Controller) syncJobResult(job *batchv1.Job) (*models.RepoSyncResult, error) {
+	pods, err := c.kubeclientset.CoreV1().Pods(job.GetNamespace()).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.Set{"job-name": job.GetName()}.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var terminated *corev1.ContainerStateTerminated
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != syncContainerName {
+				continue
+			}
+			// Failed containers are restarted, in which case the result of
+			// the last run is in the last termination state.
+			t := status.State.Terminated
+			if t == nil {
+				t = status.LastTerminationState.Terminated
+			}
+			if t != nil && (terminated == nil || terminated.FinishedAt.Before(&t.FinishedAt)) {
+				terminated = t
+			}
+		}
+	}
+	return parseSyncResult(job, terminated), nil
+}
+
+// parseSyncResult returns the result of a sync from the termination state of
+// the sync container. Jobs which did not report a result, for example because
+// their pods were already removed, only report whether they failed.
+func parseSyncResult(job *batchv1.Job, terminated *corev1.ContainerStateTerminated) *models.RepoSyncResult {
+	result, reported := terminationResult(terminated)
+	if reported {
+		return result
+	}
+	if failed, message := jobFailed(job); failed && result.Error == "" {
+		result.Error = message
+		if result.Error == "" {
+			result.Error = "sync job failed"
+		}
+	}
+	return result
+}
+
+// terminationResult returns the result of a sync written by the sync
+// container as its termination message, and whether it was reported. The
+// message is the error itself if the container failed before reporting a
+// result.
+func terminationResult(terminated *corev1.ContainerStateTerminated) (*models.RepoSyncResult, bool) {
+	result := &models.RepoSyncResult{}
+	if terminated == nil || terminated.Message == "" {
+		return result, false
+	}
+	if err := json.Unmarshal([]byte(terminated.Message), result); err != nil {
+		result.Error = strings.TrimSpace(terminated.Message)
+		return result, false
+	}
+	return result, true
+}
+
+// repoStatus returns the status of an AppRepository after a sync finished at
+// the given time with the given result.
+func repoStatus(status apprepov1alpha1.AppRepositoryStatus, syncTime metav1.Time, result *models.RepoSyncResult) apprepov1alpha1.AppRepositoryStatus {
+	now := metav1.Now()
+	if result.Error != "" {
+		status.LastError = result.Error
+		setCondition(&status, apprepov1alpha1.AppRepositorySynced, corev1.ConditionFalse, reasonSyncFailed, result.Error, now)
+	} else {
+		status.LastError = ""
+		status.LastSyncTime = &syncTime
+		status.LastSyncChecksum = result.Checksum
+		// The charts are not counted when the repository did not change.
+		if !result.Unchanged {
+			status.ChartCount = result.ChartCount
+		}
+		setCondition(&status, apprepov1alpha1.AppRepositorySynced, corev1.ConditionTrue, reasonSyncSucceeded, "", now)
+	}
+
+	if result.AuthFailed {
+		setCondition(&status, apprepov1alpha1.AppRepositoryAuthFailed, corev1.ConditionTrue, reasonUnauthorized, result.Error, now)
+	} else {
+		setCondition(&status, apprepov1alpha1.AppRepositoryAuthFailed, corev1.ConditionFalse, reasonAuthorized, "", now)
+	}
+
+	// The charts of the last successful sync are still served after a failure.
+	if status.LastSyncTime != nil {
+		setCondition(&status, apprepov1alpha1.AppRepositoryReady, corev1.ConditionTrue, reasonChartsAvailable, "", now)
+	} else {
+		setCondition(&status, apprepov1alpha1.AppRepositoryReady, corev1.ConditionFalse, reasonNeverSynced, "The repository has not been synced successfully yet", now)
+	}
+	return status
+}
+
+// setCondition adds or updates a condition of the status, only changing its
+// transition time when the condition status changes.
+func setCondition(status *apprepov1alpha1.AppRepositoryStatus, conditionType apprepov1alpha1.AppRepositoryConditionType, conditionStatus corev1.ConditionStatus, reason, message string, now metav1.Time) {
+	condition := apprepov1alpha1.AppRepositoryCondition{
+		Type:               conditionType,
+		Status:             conditionStatus,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	}
+	conditions := make([]apprepov1alpha1.AppRepositoryCondition, len(status.Conditions))
+	copy(conditions, status.Conditions)
+	for i, c := range conditions {
+		if c.Type != conditionType {
+			continue
+		}
+		if c.Status == conditionStatus {
+			condition.LastTransitionTime = c.LastTransitionTime
+		}
+		conditions[i] = condition
+		status.Conditions = conditions
+		return
+	}
+	status.Conditions = append(conditions, condition)
+}
+
+// jobCompletionTime returns the completion time of a Job, or the current time
+// if it is not reported.
+func jobCompletionTime(job *batchv1.Job) metav1.Time {
+	if job.Status.CompletionTime != nil {
+		return *job.Status.CompletionTime
+	}
+	return metav1.Now()
+}
+
+// jobFinished returns whether a Job completed or failed.
+func jobFinished(job *batchv1.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// jobFailed returns whether a Job failed and the message of its failure.
+func jobFailed(job *batchv1.Job) (bool, string) {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return true, c.Message
+		}
+	}
+	return false, ""
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	apprepov1alpha1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	fakeapprepo "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/clientset/versioned/fake"
+	informers "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/informers/externalversions"
+	listers "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/listers/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+var ignoreTransitionTime = cmpopts.IgnoreFields(apprepov1alpha1.AppRepositoryCondition{}, "LastTransitionTime")
+
+func finishedJob(conditionType batchv1.JobConditionType, message string) *batchv1.Job {
+	completionTime := metav1.NewTime(time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC))
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "apprepo-kubeapps-sync-my-charts-abcde", Namespace: "kubeapps"},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						LabelRepoName:      "my-charts",
+						LabelRepoNamespace: "kubeapps",
+					},
+				},
+			},
+		},
+		Status: batchv1.JobStatus{
+			CompletionTime: &completionTime,
+			Conditions: []batchv1.JobCondition{
+				{Type: conditionType, Status: corev1.ConditionTrue, Message: message},
+			},
+		},
+	}
+}
+
+func Test_repoStatus(t *testing.T) {
+	lastSync := metav1.NewTime(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC))
+	completionTime := metav1.NewTime(time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC))
+	tests := []struct {
+		name     string
+		status   apprepov1alpha1.AppRepositoryStatus
+		result   *models.RepoSyncResult
+		expected apprepov1alpha1.AppRepositoryStatus
+	}{
+		{
+			"successful sync",
+			apprepov1alpha1.AppRepositoryStatus{},
+			&models.RepoSyncResult{Checksum: "abc", ChartCount: 12},
+			apprepov1alpha1.AppRepositoryStatus{
+				LastSyncTime:     &completionTime,
+				LastSyncChecksum: "abc",
+				ChartCount:       12,
+				Conditions: []apprepov1alpha1.AppRepositoryCondition{
+					{Type: apprepov1alpha1.AppRepositorySynced, Status: corev1.ConditionTrue, Reason: reasonSyncSucceeded},
+					{Type: apprepov1alpha1.AppRepositoryAuthFailed, Status: corev1.ConditionFalse, Reason: reasonAuthorized},
+					{Type: apprepov1alpha1.AppRepositoryReady, Status: corev1.ConditionTrue, Reason: reasonChartsAvailable},
+				},
+			},
+		},
+		{
+			"unchanged repository keeps the chart count",
+			apprepov1alpha1.AppRepositoryStatus{ChartCount: 12, LastSyncTime: &lastSync, LastError: "boom"},
+			&models.RepoSyncResult{Checksum: "abc", Unchanged: true},
+			apprepov1alpha1.AppRepositoryStatus{
+				LastSyncTime:     &completionTime,
+				LastSyncChecksum: "abc",
+				ChartCount:       12,
+				Conditions: []apprepov1alpha1.AppRepositoryCondition{
+					{Type: apprepov1alpha1.AppRepositorySynced, Status: corev1.ConditionTrue, Reason: reasonSyncSucceeded},
+					{Type: apprepov1alpha1.AppRepositoryAuthFailed, Status: corev1.ConditionFalse, Reason: reasonAuthorized},
+					{Type: apprepov1alpha1.AppRepositoryReady, Status: corev1.ConditionTrue, Reason: reasonChartsAvailable},
+				},
+			},
+		},
+		{
+			"failed sync of a repository never synced",
+			apprepov1alpha1.AppRepositoryStatus{},
+			&models.RepoSyncResult{Error: "repo index request failed: unauthorized", AuthFailed: true},
+			apprepov1alpha1.AppRepositoryStatus{
+				LastError: "repo index request failed: unauthorized",
+				Conditions: []apprepov1alpha1.AppRepositoryCondition{
+					{Type: apprepov1alpha1.AppRepositorySynced, Status: corev1.ConditionFalse, Reason: reasonSyncFailed, Message: "repo index request failed: unauthorized"},
+					{Type: apprepov1alpha1.AppRepositoryAuthFailed, Status: corev1.ConditionTrue, Reason: reasonUnauthorized, Message: "repo index request failed: unauthorized"},
+					{Type: apprepov1alpha1.AppRepositoryReady, Status: corev1.ConditionFalse, Reason: reasonNeverSynced, Message: "The repository has not been synced successfully yet"},
+				},
+			},
+		},
+		{
+			"failed sync keeps the previous sync",
+			apprepov1alpha1.AppRepositoryStatus{ChartCount: 12, LastSyncTime: &lastSync, LastSyncChecksum: "abc"},
+			&models.RepoSyncResult{Error: "no charts in repository index"},
+			apprepov1alpha1.AppRepositoryStatus{
+				LastSyncTime:     &lastSync,
+				LastSyncChecksum: "abc",
+				ChartCount:       12,
+				LastError:        "no charts in repository index",
+				Conditions: []apprepov1alpha1.AppRepositoryCondition{
+					{Type: apprepov1alpha1.AppRepositorySynced, Status: corev1.ConditionFalse, Reason: reasonSyncFailed, Message: "no charts in repository index"},
+					{Type: apprepov1alpha1.AppRepositoryAuthFailed, Status: corev1.ConditionFalse, Reason: reasonAuthorized},
+					{Type: apprepov1alpha1.AppRepositoryReady, Status: corev1.ConditionTrue, Reason: reasonChartsAvailable},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := repoStatus(tt.status, completionTime, tt.result)
+			if !cmp.Equal(tt.expected, status, ignoreTransitionTime) {
+				t.Errorf("Unexpected status: %s", cmp.Diff(tt.expected, status, ignoreTransitionTime))
+			}
+		})
+	}
+}
+
+func Test_setCondition(t *testing.T) {
+	previous := metav1.NewTime(time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC))
+	now := metav1.NewTime(time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC))
+	status := apprepov1alpha1.AppRepositoryStatus{
+		Conditions: []apprepov1alpha1.AppRepositoryCondition{
+			{Type: apprepov1alpha1.AppRepositorySynced, Status: corev1.ConditionTrue, LastTransitionTime: previous},
+			{Type: apprepov1alpha1.AppRepositoryReady, Status: corev1.ConditionTrue, LastTransitionTime: previous},
+		},
+	}
+
+	setCondition(&status, apprepov1alpha1.AppRepositorySynced, corev1.ConditionFalse, reasonSyncFailed, "boom", now)
+	setCondition(&status, apprepov1alpha1.AppRepositoryReady, corev1.ConditionTrue, reasonChartsAvailable, "", now)
+
+	if got, want := status.Conditions[0].LastTransitionTime, now; !got.Equal(&want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if got, want := status.Conditions[1].LastTransitionTime, previous; !got.Equal(&want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
+
+func Test_parseSyncResult(t *testing.T) {
+	tests := []struct {
+		name       string
+		job        *batchv1.Job
+		terminated *corev1.ContainerStateTerminated
+		expected   *models.RepoSyncResult
+	}{
+		{
+			"result of the termination message",
+			finishedJob(batchv1.JobComplete, ""),
+			&corev1.ContainerStateTerminated{Message: `{"checksum":"abc","chartCount":3}`},
+			&models.RepoSyncResult{Checksum: "abc", ChartCount: 3},
+		},
+		{
+			"termination message which is not a result",
+			finishedJob(batchv1.JobFailed, "BackoffLimitExceeded"),
+			&corev1.ContainerStateTerminated{Message: "panic: boom\n"},
+			&models.RepoSyncResult{Error: "panic: boom"},
+		},
+		{
+			"failed job without termination message",
+			finishedJob(batchv1.JobFailed, "Job has reached the specified backoff limit"),
+			nil,
+			&models.RepoSyncResult{Error: "Job has reached the specified backoff limit"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parseSyncResult(tt.job, tt.terminated)
+			if !cmp.Equal(tt.expected, result) {
+				t.Errorf("Unexpected result: %s", cmp.Diff(tt.expected, result))
+			}
+		})
+	}
+}
+
+func Test_updateRepoStatus(t *testing.T) {
+	apprepo := &apprepov1alpha1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-charts", Namespace: "kubeapps"},
+		Spec:       apprepov1alpha1.AppRepositorySpec{URL: "https://charts.acme.com/my-charts"},
+	}
+	job := finishedJob(batchv1.JobComplete, "")
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      job.Name + "-xyz",
+			Namespace: "kubeapps",
+			Labels:    map[string]string{"job-name": job.Name},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: syncContainerName,
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{Message: `{"checksum":"abc","chartCount":3}`},
+					},
+				},
+			},
+		},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	indexer.Add(apprepo)
+	apprepoClient := fakeapprepo.NewSimpleClientset(apprepo)
+	c := &Controller{
+		kubeclientset:    fake.NewSimpleClientset(pod),
+		apprepoclientset: apprepoClient,
+		appreposLister:   listers.NewAppRepositoryLister(indexer),
+	}
+
+	c.handleJob(&batchv1.Job{}, job)
+
+	updated, err := apprepoClient.KubeappsV1alpha1().AppRepositories("kubeapps").Get(context.TODO(), "my-charts", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := updated.Status.ChartCount, 3; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	if got, want := updated.Status.LastSyncChecksum, "abc"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func Test_handleSyncPod(t *testing.T) {
+	apprepo := &apprepov1alpha1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-charts", Namespace: "kubeapps"},
+		Spec:       apprepov1alpha1.AppRepositorySpec{URL: "https://charts.acme.com/my-charts"},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "apprepo-kubeapps-sync-my-charts-abcde-xyz",
+			Namespace: "kubeapps",
+			Labels: map[string]string{
+				LabelRepoName:      "my-charts",
+				LabelRepoNamespace: "kubeapps",
+			},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name:  syncContainerName,
+					State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				},
+			},
+		},
+	}
+	kubeClient := fake.NewSimpleClientset(pod)
+	apprepoClient := fakeapprepo.NewSimpleClientset(apprepo)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0, kubeinformers.WithNamespace("kubeapps"))
+	apprepoInformerFactory := informers.NewSharedInformerFactory(apprepoClient, 0)
+	c := NewController(kubeClient, apprepoClient, kubeInformerFactory, apprepoInformerFactory, "kubeapps")
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	kubeInformerFactory.Start(stopCh)
+	apprepoInformerFactory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, c.podsSynced, c.appreposSynced) {
+		t.Fatalf("failed to wait for caches to sync")
+	}
+
+	// The container fails and is restarted, so its Job does not finish.
+	failed := pod.DeepCopy()
+	failed.Status.ContainerStatuses[0] = corev1.ContainerStatus{
+		Name:         syncContainerName,
+		RestartCount: 1,
+		State:        corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+		LastTerminationState: corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: `{"chartCount":0,"error":"401 Unauthorized","authFailed":true}`},
+		},
+	}
+	_, err := kubeClient.CoreV1().Pods("kubeapps").UpdateStatus(context.TODO(), failed, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var updated *apprepov1alpha1.AppRepository
+	err = wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		updated, err = apprepoClient.KubeappsV1alpha1().AppRepositories("kubeapps").Get(context.TODO(), "my-charts", metav1.GetOptions{})
+		return err == nil && len(updated.Status.Conditions) > 0, err
+	})
+	if err != nil {
+		t.Fatalf("the status of the AppRepository was not updated: %+v", err)
+	}
+	if got, want := updated.Status.LastError, "401 Unauthorized"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	expected := []apprepov1alpha1.AppRepositoryCondition{
+		{Type: apprepov1alpha1.AppRepositorySynced, Status: corev1.ConditionFalse, Reason: reasonSyncFailed, Message: "401 Unauthorized"},
+		{Type: apprepov1alpha1.AppRepositoryAuthFailed, Status: corev1.ConditionTrue, Reason: reasonUnauthorized, Message: "401 Unauthorized"},
+		{Type: apprepov1alpha1.AppRepositoryReady, Status: corev1.ConditionFalse, Reason: reasonNeverSynced, Message: "The repository has not been synced successfully yet"},
+	}
+	if got, want := updated.Status.Conditions, expected; !cmp.Equal(want, got, ignoreTransitionTime) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got, ignoreTransitionTime))
+	}
+}
+
+func Test_syncFailure(t *testing.T) {
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	failed := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: "boom"}}
+	succeeded := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}
+	podWith := func(status corev1.ContainerStatus) *corev1.Pod {
+		status.Name = syncContainerName
+		return &corev1.Pod{Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}}}
+	}
+	tests := []struct {
+		name     string
+		oldPod   *corev1.Pod
+		pod      *corev1.Pod
+		expected bool
+	}{
+		{"container fails", podWith(corev1.ContainerStatus{State: running}), podWith(corev1.ContainerStatus{State: failed}), true},
+		{"container succeeds", podWith(corev1.ContainerStatus{State: running}), podWith(corev1.ContainerStatus{State: succeeded}), false},
+		{"failure already reported", podWith(corev1.ContainerStatus{State: failed}), podWith(corev1.ContainerStatus{State: failed}), false},
+		{"failed container restarted", podWith(corev1.ContainerStatus{State: running}), podWith(corev1.ContainerStatus{State: running, RestartCount: 1, LastTerminationState: failed}), true},
+		{"restart after a reported failure", podWith(corev1.ContainerStatus{State: failed}), podWith(corev1.ContainerStatus{State: running, RestartCount: 1, LastTerminationState: failed}), false},
+		{"pod without sync container", &corev1.Pod{}, &corev1.Pod{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, want := syncFailure(tt.oldPod, tt.pod) != nil, tt.expected; got != want {
+				t.Errorf("got: %t, want: %t", got, want)
+			}
+		})
+	}
+}
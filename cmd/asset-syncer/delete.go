@@ -20,6 +20,7 @@ import (
 	"os"
 
 	"github.com/kubeapps/common/datastore"
+	"github.com/kubeapps/kubeapps/pkg/assetsyncer"
 	"github.com/kubeapps/kubeapps/pkg/chart/models"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -41,7 +42,7 @@ var deleteCmd = &cobra.Command{
 
 		dbConfig := datastore.Config{URL: databaseURL, Database: databaseName, Username: databaseUser, Password: databasePassword}
 		kubeappsNamespace := os.Getenv("POD_NAMESPACE")
-		manager, err := newManager(databaseType, dbConfig, kubeappsNamespace)
+		manager, err := assetsyncer.NewManager(databaseType, dbConfig, kubeappsNamespace)
 		if err != nil {
 			logrus.Fatal(err)
 		}
@@ -19,9 +19,12 @@ package main
 import (
 	"os"
 
+	"github.com/kubeapps/kubeapps/pkg/assetsyncer"
 	"github.com/spf13/cobra"
 )
 
+const additionalCAFile = "/usr/local/share/ca-certificates/ca.crt"
+
 var (
 	databaseType     string
 	databaseURL      string
@@ -30,6 +33,14 @@ var (
 	databasePassword string
 	debug            bool
 	namespace        string
+	repoType         string
+	ociRepositories  []string
+	// terminationMessagePath is where the result of a sync is written
+	terminationMessagePath string
+	metricsPushgatewayURL  string
+	metricsTextfile        string
+	// keyringFile holds the trusted keys the charts are verified with
+	keyringFile string
 )
 
 var rootCmd = &cobra.Command{
@@ -53,10 +64,17 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&databaseName, "database-name", "charts", "Name of the database to use")
 	rootCmd.PersistentFlags().StringVar(&databaseUser, "database-user", "", "Database user")
 	rootCmd.PersistentFlags().StringVar(&namespace, "namespace", "", "Namespace of the repository being synced")
-	// User agent configuration can be found in version.go. Check that file for more details
-	rootCmd.PersistentFlags().StringVar(&userAgentComment, "user-agent-comment", "", "UserAgent comment used during outbound requests")
+	// User agent configuration can be found in pkg/assetsyncer/version.go. Check that file for more details
+	rootCmd.PersistentFlags().StringVar(&assetsyncer.UserAgentComment, "user-agent-comment", "", "UserAgent comment used during outbound requests")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "verbose logging")
 
+	syncCmd.Flags().StringVar(&repoType, "repo-type", assetsyncer.HelmRepoType, "Type of the repository being synced. Choice: helm, oci")
+	syncCmd.Flags().StringSliceVar(&ociRepositories, "oci-repositories", []string{}, "Repositories of the OCI registry to sync, required for the oci type")
+	syncCmd.Flags().StringVar(&metricsPushgatewayURL, "metrics-pushgateway-url", "", "URL of a Pushgateway to push the metrics of the sync to")
+	syncCmd.Flags().StringVar(&metricsTextfile, "metrics-textfile", "", "File to write the metrics of the sync to in the Prometheus text format")
+	syncCmd.Flags().StringVar(&keyringFile, "keyring", "", "File with the trusted PGP public keys to verify the provenance files of the charts with")
+	syncCmd.Flags().StringVar(&terminationMessagePath, "termination-message-path", "/dev/termination-log", "File to write the result of the sync to, empty to disable it")
+
 	databasePassword = os.Getenv("DB_PASSWORD")
 
 	cmds := []*cobra.Command{syncCmd, deleteCmd, invalidateCacheCmd}
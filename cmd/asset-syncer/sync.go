@@ -17,10 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"os"
-	"time"
 
 	"github.com/kubeapps/common/datastore"
+	"github.com/kubeapps/kubeapps/pkg/assetsyncer"
 	"github.com/kubeapps/kubeapps/pkg/chart/models"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -42,7 +44,7 @@ var syncCmd = &cobra.Command{
 
 		dbConfig := datastore.Config{URL: databaseURL, Database: databaseName, Username: databaseUser, Password: databasePassword}
 		kubeappsNamespace := os.Getenv("POD_NAMESPACE")
-		manager, err := newManager(databaseType, dbConfig, kubeappsNamespace)
+		manager, err := assetsyncer.NewManager(databaseType, dbConfig, kubeappsNamespace)
 		if err != nil {
 			logrus.Fatal(err)
 		}
@@ -53,41 +55,64 @@ var syncCmd = &cobra.Command{
 		defer manager.Close()
 
 		authorizationHeader := os.Getenv("AUTHORIZATION_HEADER")
-		repo, repoContent, err := getRepo(namespace, args[0], args[1], authorizationHeader)
+		netClient, err := assetsyncer.InitNetClient(additionalCAFile)
 		if err != nil {
 			logrus.Fatal(err)
 		}
-
-		// Check if the repo has been already processed
-		if manager.RepoAlreadyProcessed(models.Repo{Namespace: repo.Namespace, Name: repo.Name}, repo.Checksum) {
-			logrus.WithFields(logrus.Fields{"url": repo.URL}).Info("Skipping repository since there are no updates")
-			return
+		var keyring []byte
+		if keyringFile != "" {
+			keyring, err = ioutil.ReadFile(keyringFile)
+			if err != nil {
+				logrus.Fatal(err)
+			}
 		}
-
-		index, err := parseRepoIndex(repoContent)
+		result, err := assetsyncer.Sync(manager, netClient, assetsyncer.Repository{
+			Namespace:           namespace,
+			Name:                args[0],
+			URL:                 args[1],
+			Type:                repoType,
+			OCIRepositories:     ociRepositories,
+			AuthorizationHeader: authorizationHeader,
+			Keyring:             keyring,
+		})
 		if err != nil {
-			logrus.Fatal(err)
+			result = assetsyncer.ResultForError(err)
 		}
-
-		charts := chartsFromIndex(index, &models.Repo{Namespace: repo.Namespace, Name: repo.Name, URL: repo.URL})
-		if len(charts) == 0 {
-			logrus.Fatal("no charts in repository index")
+		// The result is read from the termination message of the sync job by
+		// the apprepository-controller to update the status of the AppRepository.
+		if werr := writeSyncResult(terminationMessagePath, result); werr != nil {
+			logrus.Errorf("Unable to write the sync result: %v", werr)
 		}
-
-		if err = manager.Sync(models.Repo{Name: repo.Name, Namespace: repo.Namespace}, charts); err != nil {
-			logrus.Fatalf("Can't add chart repository to database: %v", err)
+		reportMetrics(namespace, args[0])
+		if err != nil {
+			logrus.Fatal(err)
 		}
+	},
+}
 
-		// Fetch and store chart icons
-		fImporter := fileImporter{manager}
-		fImporter.fetchFiles(charts, repo)
-
-		// Update cache in the database
-		if err = manager.UpdateLastCheck(repo.Namespace, repo.Name, repo.Checksum, time.Now()); err != nil {
-			logrus.Fatal(err)
+// reportMetrics pushes the metrics of the sync to the Pushgateway and writes
+// them to the textfile, if configured, as the Job ends before being scraped.
+func reportMetrics(namespace, name string) {
+	if metricsPushgatewayURL != "" {
+		if err := assetsyncer.PushMetrics(metricsPushgatewayURL, namespace, name); err != nil {
+			logrus.Errorf("Unable to push the metrics to %s: %v", metricsPushgatewayURL, err)
 		}
-		logrus.WithFields(logrus.Fields{"url": repo.URL}).Info("Stored repository update in cache")
+	}
+	if metricsTextfile != "" {
+		if err := assetsyncer.WriteMetricsTextfile(metricsTextfile); err != nil {
+			logrus.Errorf("Unable to write the metrics to %s: %v", metricsTextfile, err)
+		}
+	}
+}
 
-		logrus.Infof("Successfully added the chart repository %s to database", args[0])
-	},
+// writeSyncResult writes the outcome of a sync as JSON to the given path.
+func writeSyncResult(path string, result *models.RepoSyncResult) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
 }
@@ -19,32 +19,14 @@ package main
 import (
 	"fmt"
 
+	"github.com/kubeapps/kubeapps/pkg/assetsyncer"
 	"github.com/spf13/cobra"
 )
 
-var (
-	version          = "devel"
-	userAgentComment string
-)
-
-// Returns the user agent to be used during calls to the chart repositories
-// Examples:
-// asset-syncer/devel
-// asset-syncer/1.0
-// asset-syncer/1.0 (monocular v1.0-beta4)
-// More info here https://github.com/kubeapps/kubeapps/issues/767#issuecomment-436835938
-func userAgent() string {
-	ua := "asset-syncer/" + version
-	if userAgentComment != "" {
-		ua = fmt.Sprintf("%s (%s)", ua, userAgentComment)
-	}
-	return ua
-}
-
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "returns version information",
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println(version)
+		fmt.Println(assetsyncer.Version)
 	},
 }
@@ -18,6 +18,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 
@@ -83,6 +84,20 @@ func getPageNumberAndSize(req *http.Request) (int, int) {
 	return int(pageInt), int(sizeInt)
 }
 
+// pageOffset returns the number of pages needed for the given number of charts
+// and the offset of the first chart of the requested page. If the page number
+// is out of range, the offset of the last page is returned.
+func pageOffset(count, pageNumber, pageSize int) (int, int) {
+	totalPages := int(math.Ceil(float64(count) / float64(pageSize)))
+	if pageNumber > totalPages {
+		pageNumber = totalPages
+	}
+	if pageNumber < 1 {
+		pageNumber = 1
+	}
+	return totalPages, pageSize * (pageNumber - 1)
+}
+
 // showDuplicates returns if a request wants to retrieve charts. Default false
 func showDuplicates(req *http.Request) bool {
 	return len(req.FormValue("showDuplicates")) > 0
@@ -259,6 +274,23 @@ func listChartsWithFilters(w http.ResponseWriter, req *http.Request, params Para
 	response.NewDataResponse(cl).Write(w)
 }
 
+// searchCharts returns the charts matching the query, most relevant first
+func searchCharts(w http.ResponseWriter, req *http.Request, params Params) {
+	charts, err := manager.searchCharts(params["namespace"], params["query"], req.FormValue("repo"))
+	if err != nil {
+		log.WithError(err).Errorf("could not search charts with the query %q", params["query"])
+		response.NewErrorResponse(http.StatusInternalServerError, "could not search charts").Write(w)
+		return
+	}
+
+	chartResponse := charts
+	if !showDuplicates(req) {
+		chartResponse = uniqChartList(charts)
+	}
+	cl := newChartListResponse(chartResponse)
+	response.NewDataResponse(cl).Write(w)
+}
+
 func newChartResponse(c *models.Chart) *apiResponse {
 	latestCV := c.ChartVersions[0]
 	namespace := c.Repo.Namespace
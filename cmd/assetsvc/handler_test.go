@@ -107,6 +107,9 @@ func Test_chartVersionAttributes(t *testing.T) {
 		{"my-chart", models.Chart{
 			ID: "my-repo/my-chart", ChartVersions: []models.ChartVersion{{Version: "0.1.0"}},
 		}},
+		{"my-signed-chart", models.Chart{
+			ID: "my-repo/my-signed-chart", ChartVersions: []models.ChartVersion{{Version: "0.1.0", Verification: "verified", SignedBy: "Me <me@example.com>"}},
+		}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -114,6 +117,8 @@ func Test_chartVersionAttributes(t *testing.T) {
 			assert.Equal(t, cv.Version, tt.chart.ChartVersions[0].Version, "version string should be the same")
 			assert.Equal(t, cv.Readme, pathPrefix+"/ns/"+namespace+"/assets/"+tt.chart.ID+"/versions/"+tt.chart.ChartVersions[0].Version+"/README.md", "README.md resource path should be the same")
 			assert.Equal(t, cv.Values, pathPrefix+"/ns/"+namespace+"/assets/"+tt.chart.ID+"/versions/"+tt.chart.ChartVersions[0].Version+"/values.yaml", "values.yaml resource path should be the same")
+			assert.Equal(t, cv.Verification, tt.chart.ChartVersions[0].Verification, "verification status should be the same")
+			assert.Equal(t, cv.SignedBy, tt.chart.ChartVersions[0].SignedBy, "signer should be the same")
 		})
 	}
 }
@@ -942,3 +947,31 @@ func Test_findLatestChart(t *testing.T) {
 		assert.Equal(t, len(data), 2, "it should return both charts")
 	})
 }
+
+func Test_searchTerms(t *testing.T) {
+	assert.Equal(t, []string{"word", "press", "2"}, searchTerms(" Word-Press (2)"))
+	assert.Empty(t, searchTerms("*:&|"))
+}
+
+func Test_pageOffset(t *testing.T) {
+	tests := []struct {
+		name               string
+		count              int
+		pageNumber         int
+		pageSize           int
+		expectedTotalPages int
+		expectedOffset     int
+	}{
+		{"first page", 25, 1, 10, 3, 0},
+		{"last page", 25, 3, 10, 3, 20},
+		{"page out of range", 25, 4, 10, 3, 20},
+		{"no charts", 0, 1, 10, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			totalPages, offset := pageOffset(tt.count, tt.pageNumber, tt.pageSize)
+			assert.Equal(t, tt.expectedTotalPages, totalPages, "total pages")
+			assert.Equal(t, tt.expectedOffset, offset, "offset")
+		})
+	}
+}
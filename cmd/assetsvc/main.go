@@ -24,6 +24,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/heptiolabs/healthcheck"
 	"github.com/kubeapps/common/datastore"
+	"github.com/kubeapps/kubeapps/pkg/metrics"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/negroni"
 )
@@ -47,6 +48,7 @@ func setupRoutes() http.Handler {
 	apiv1.Methods("GET").Path("/ns/{namespace}/charts").Queries("name", "{chartName}", "version", "{version}", "appversion", "{appversion}", "showDuplicates", "{showDuplicates}").Handler(WithParams(listChartsWithFilters))
 	apiv1.Methods("GET").Path("/ns/{namespace}/charts").Handler(WithParams(listCharts))
 	apiv1.Methods("GET").Path("/ns/{namespace}/charts").Queries("showDuplicates", "{showDuplicates}").Handler(WithParams(listCharts))
+	apiv1.Methods("GET").Path("/ns/{namespace}/charts/search").Queries("q", "{query}").Handler(WithParams(searchCharts))
 	apiv1.Methods("GET").Path("/ns/{namespace}/charts/{repo}").Handler(WithParams(listCharts))
 	apiv1.Methods("GET").Path("/ns/{namespace}/charts/{repo}/{chartName}").Handler(WithParams(getChart))
 	apiv1.Methods("GET").Path("/ns/{namespace}/charts/{repo}/{chartName}/versions").Handler(WithParams(listChartVersions))
@@ -57,6 +59,7 @@ func setupRoutes() http.Handler {
 	apiv1.Methods("GET").Path("/ns/{namespace}/assets/{repo}/{chartName}/versions/{version}/values.schema.json").Handler(WithParams(getChartVersionSchema))
 
 	n := negroni.Classic()
+	n.Use(metrics.NewMiddleware(r))
 	n.UseHandler(r)
 	return n
 }
@@ -66,6 +69,7 @@ func main() {
 	dbName := flag.String("database-name", "charts", "Database database")
 	dbUsername := flag.String("database-user", "", "Database user")
 	dbType := flag.String("database-type", "mongodb", "Database type")
+	metricsAddr := flag.String("metrics-addr", "", "Address to serve the Prometheus metrics on, e.g. \":9090\", disabled if empty")
 	dbPassword := os.Getenv("DB_PASSWORD")
 	flag.Parse()
 
@@ -86,6 +90,11 @@ func main() {
 
 	n := setupRoutes()
 
+	// Metrics are served separately from the API, if enabled.
+	if *metricsAddr != "" {
+		go metrics.Serve(*metricsAddr)
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -521,3 +521,35 @@ func Test_GetChartSchema(t *testing.T) {
 		})
 	}
 }
+
+// tests the GET /{apiVersion}/ns/{namespace}/charts/search endpoint
+func Test_SearchCharts(t *testing.T) {
+	ts := httptest.NewServer(setupRoutes())
+	defer ts.Close()
+
+	charts := []*models.Chart{
+		{Repo: testRepo, ID: "my-repo/wordpress-exporter", Name: "wordpress-exporter", ChartVersions: []models.ChartVersion{{Version: "0.0.1", Digest: "123"}}},
+		{Repo: testRepo, ID: "my-repo/blog", Name: "blog", Keywords: []string{"wordpress"}, ChartVersions: []models.ChartVersion{{Version: "0.0.1", Digest: "456"}}},
+		{Repo: testRepo, ID: "my-repo/wordpress", Name: "wordpress", ChartVersions: []models.ChartVersion{{Version: "1.2.3", Digest: "789"}}},
+	}
+	var m mock.Mock
+	manager = getMockManager(&m)
+	m.On("All", &chartsList).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]*models.Chart) = charts
+	})
+
+	res, err := http.Get(ts.URL + pathPrefix + "/ns/kubeapps/charts/search?q=WordPress")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+
+	m.AssertExpectations(t)
+	assert.Equal(t, res.StatusCode, http.StatusOK, "http status code should match")
+
+	var b bodyAPIListResponse
+	json.NewDecoder(res.Body).Decode(&b)
+	var ids []string
+	for _, c := range *b.Data {
+		ids = append(ids, c.ID)
+	}
+	assert.Equal(t, []string{"my-repo/wordpress", "my-repo/wordpress-exporter", "my-repo/blog"}, ids, "charts should be sorted by relevance")
+}
@@ -17,8 +17,11 @@ limitations under the License.
 package main
 
 import (
-	"math"
+	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/kubeapps/common/datastore"
 	"github.com/kubeapps/kubeapps/pkg/chart/models"
@@ -74,18 +77,15 @@ func (m *mongodbAssetManager) getPaginatedChartList(namespace, repo string, page
 		countPipeline := append(pipeline, bson.M{"$count": "count"})
 		cc := count{}
 		err := c.Pipe(countPipeline).One(&cc)
-		if err != nil {
+		// $count doesn't return any document if there are no charts
+		if err != nil && err != mgo.ErrNotFound {
 			return charts, 0, err
 		}
-		totalPages = int(math.Ceil(float64(cc.Count) / float64(pageSize)))
-
-		// If the page number is out of range, return the last one
-		if pageNumber > totalPages {
-			pageNumber = totalPages
-		}
+		var offset int
+		totalPages, offset = pageOffset(cc.Count, pageNumber, pageSize)
 
 		pipeline = append(pipeline,
-			bson.M{"$skip": pageSize * (pageNumber - 1)},
+			bson.M{"$skip": offset},
 			bson.M{"$limit": pageSize},
 		)
 	}
@@ -144,23 +144,79 @@ func (m *mongodbAssetManager) getChartsWithFilters(namespace, name, version, app
 	return charts, err
 }
 
-func (m *mongodbAssetManager) searchCharts(query, repo string) ([]*models.Chart, error) {
+// searchCharts returns the charts matching all the terms of the query in
+// any of their name, description, keywords, sources or maintainers, ordered
+// by relevance.
+func (m *mongodbAssetManager) searchCharts(namespace, query, repo string) ([]*models.Chart, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []*models.Chart{}, nil
+	}
+
 	db, closer := m.DBSession.DB()
 	defer closer()
 	var charts []*models.Chart
-	conditions := bson.M{
-		"$or": []bson.M{
-			{"name": bson.M{"$regex": query}},
-			{"description": bson.M{"$regex": query}},
-			{"repo.name": bson.M{"$regex": query}},
-			{"keywords": bson.M{"$elemMatch": bson.M{"$regex": query}}},
-			{"sources": bson.M{"$elemMatch": bson.M{"$regex": query}}},
-			{"maintainers": bson.M{"$elemMatch": bson.M{"name": bson.M{"$regex": query}}}},
-		},
+	termConditions := []bson.M{}
+	for _, term := range terms {
+		regex := bson.RegEx{Pattern: regexp.QuoteMeta(term), Options: "i"}
+		termConditions = append(termConditions, bson.M{
+			"$or": []bson.M{
+				{"name": regex},
+				{"description": regex},
+				{"keywords": regex},
+				{"sources": regex},
+				{"maintainers.name": regex},
+			},
+		})
+	}
+	conditions := bson.M{"$and": termConditions}
+	if namespace != dbutils.AllNamespaces {
+		conditions["repo.namespace"] = bson.M{"$in": []string{namespace, m.KubeappsNamespace}}
 	}
 	if repo != "" {
 		conditions["repo.name"] = repo
 	}
 	err := db.C(chartCollection).Find(conditions).All(&charts)
-	return charts, err
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(charts, func(i, j int) bool {
+		ri, rj := searchRank(charts[i], terms), searchRank(charts[j], terms)
+		if ri != rj {
+			return ri > rj
+		}
+		return charts[i].Name < charts[j].Name
+	})
+	return charts, nil
+}
+
+// searchRank scores how relevant a chart is for the given search terms,
+// weighting the name above keywords, description and maintainers.
+func searchRank(c *models.Chart, terms []string) int {
+	rank := 0
+	name := strings.ToLower(c.Name)
+	for _, term := range terms {
+		if name == term {
+			rank += 8
+		} else if strings.Contains(name, term) {
+			rank += 4
+		}
+		for _, k := range c.Keywords {
+			if strings.Contains(strings.ToLower(k), term) {
+				rank += 2
+				break
+			}
+		}
+		if strings.Contains(strings.ToLower(c.Description), term) {
+			rank++
+		}
+		for _, mt := range c.Maintainers {
+			if strings.Contains(strings.ToLower(mt.Name), term) {
+				rank++
+				break
+			}
+		}
+	}
+	return rank
 }
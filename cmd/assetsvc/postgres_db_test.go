@@ -372,6 +372,42 @@ func TestGetPaginatedChartList(t *testing.T) {
 				&models.Chart{ID: repoName + "/chart-1", Name: "chart-1", ChartVersions: chartVersions},
 			},
 		},
+		{
+			name: "it does not consider charts without a digest as duplicates",
+			existingCharts: map[string]map[string][]models.Chart{
+				namespaceName: map[string][]models.Chart{
+					repoName: []models.Chart{
+						models.Chart{ID: repoName + "/chart-1", Name: "chart-1"},
+						models.Chart{ID: repoName + "/chart-2", Name: "chart-2"},
+					},
+				},
+			},
+			repo:      "",
+			namespace: namespaceName,
+			showDups:  false,
+			expectedCharts: []*models.Chart{
+				&models.Chart{ID: repoName + "/chart-1", Name: "chart-1"},
+				&models.Chart{ID: repoName + "/chart-2", Name: "chart-2"},
+			},
+		},
+		{
+			name: "it does not consider charts with an empty digest as duplicates",
+			existingCharts: map[string]map[string][]models.Chart{
+				namespaceName: map[string][]models.Chart{
+					repoName: []models.Chart{
+						models.Chart{ID: repoName + "/chart-1", Name: "chart-1", ChartVersions: []models.ChartVersion{{Version: "1.0.0"}}},
+						models.Chart{ID: repoName + "/chart-2", Name: "chart-2", ChartVersions: []models.ChartVersion{{Version: "1.0.0"}}},
+					},
+				},
+			},
+			repo:      "",
+			namespace: namespaceName,
+			showDups:  false,
+			expectedCharts: []*models.Chart{
+				&models.Chart{ID: repoName + "/chart-1", Name: "chart-1", ChartVersions: []models.ChartVersion{{Version: "1.0.0"}}},
+				&models.Chart{ID: repoName + "/chart-2", Name: "chart-2", ChartVersions: []models.ChartVersion{{Version: "1.0.0"}}},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -396,3 +432,61 @@ func TestGetPaginatedChartList(t *testing.T) {
 		})
 	}
 }
+
+func TestSearchCharts(t *testing.T) {
+	pgtest.SkipIfNoDB(t)
+	const namespaceName = "namespace-name"
+
+	charts := []models.Chart{
+		models.Chart{ID: "repo-name/nginx", Name: "nginx", Description: "NGINX web server"},
+		models.Chart{ID: "repo-name/wordpress", Name: "wordpress", Keywords: []string{"blog"}},
+		models.Chart{ID: "repo-name/apache", Name: "apache", Sources: []string{"https://github.com/bitnami/bitnami-docker-apache"}},
+	}
+
+	testCases := []struct {
+		name          string
+		query         string
+		expectedNames []string
+	}{
+		{
+			name:          "it matches the name",
+			query:         "ngin",
+			expectedNames: []string{"nginx"},
+		},
+		{
+			name:          "it matches the keywords",
+			query:         "blog",
+			expectedNames: []string{"wordpress"},
+		},
+		{
+			name:          "it matches the sources",
+			query:         "bitnami",
+			expectedNames: []string{"apache"},
+		},
+		{
+			name:          "it requires all the terms to match",
+			query:         "apache github",
+			expectedNames: []string{"apache"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pam, cleanup := getInitializedManager(t)
+			defer cleanup()
+			pgtest.EnsureChartsExist(t, pam, charts, models.Repo{Name: "repo-name", Namespace: namespaceName})
+
+			result, err := pam.searchCharts(namespaceName, tc.query, "")
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			names := []string{}
+			for _, c := range result {
+				names = append(names, c.Name)
+			}
+			if got, want := names, tc.expectedNames; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
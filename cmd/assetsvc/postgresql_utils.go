@@ -31,6 +31,11 @@ import (
 // TODO(mnelson): standardise error API for package.
 var ErrChartVersionNotFound = errors.New("chart version not found")
 
+// latestDigest is the digest of the latest version of a chart, or its ID if
+// the version has no digest, so that such charts are never considered
+// duplicates of each other. A missing digest is stored as an empty string.
+const latestDigest = "COALESCE(NULLIF(info -> 'chartVersions' -> 0 ->> 'digest', ''), chart_id)"
+
 type postgresAssetManager struct {
 	dbutils.PostgresAssetManagerIface
 }
@@ -43,16 +48,10 @@ func newPGManager(config datastore.Config, kubeappsNamespace string) (assetManag
 	return &postgresAssetManager{m}, nil
 }
 
-func exists(current []string, str string) bool {
-	for _, s := range current {
-		if s == str {
-			return true
-		}
-	}
-	return false
-}
-
-func (m *postgresAssetManager) getPaginatedChartList(namespace, repo string, pageNumber, pageSize int, showDuplicates bool) ([]*models.Chart, int, error) {
+// visibleChartsClauses returns the conditions, and their params, to query
+// the charts of the given repo visible from the namespace, which includes
+// the global charts of the kubeapps namespace.
+func (m *postgresAssetManager) visibleChartsClauses(namespace, repo string) ([]string, []interface{}) {
 	clauses := []string{}
 	queryParams := []interface{}{}
 	if namespace != dbutils.AllNamespaces {
@@ -63,33 +62,48 @@ func (m *postgresAssetManager) getPaginatedChartList(namespace, repo string, pag
 		queryParams = append(queryParams, repo)
 		clauses = append(clauses, fmt.Sprintf("repo_name = $%d", len(queryParams)))
 	}
+	return clauses, queryParams
+}
+
+func (m *postgresAssetManager) getPaginatedChartList(namespace, repo string, pageNumber, pageSize int, showDuplicates bool) ([]*models.Chart, int, error) {
+	clauses, queryParams := m.visibleChartsClauses(namespace, repo)
 	repoQuery := ""
 	if len(clauses) > 0 {
 		repoQuery = strings.Join(clauses, " AND ")
 		repoQuery = "WHERE " + repoQuery
 	}
-	dbQuery := fmt.Sprintf("SELECT info FROM %s %s ORDER BY info ->> 'name' ASC", dbutils.ChartTable, repoQuery)
-	charts, err := m.QueryAllCharts(dbQuery, queryParams...)
-	if err != nil {
-		return nil, 0, nil
-	}
+	chartsQuery := fmt.Sprintf("SELECT info FROM %s %s", dbutils.ChartTable, repoQuery)
 	if !showDuplicates {
-		// Group by unique digest for the latest version (remove duplicates)
-		uniqueCharts := []*models.Chart{}
-		digests := []string{}
-		for _, c := range charts {
-			if len(c.ChartVersions) == 0 {
-				return nil, 0, fmt.Errorf("chart %q missing chart versions", c.ID)
-			}
-			if !exists(digests, c.ChartVersions[0].Digest) {
-				digests = append(digests, c.ChartVersions[0].Digest)
-				uniqueCharts = append(uniqueCharts, c)
-			}
+		// Group by unique digest for the latest version (remove duplicates) before
+		// paginating so that every page has the requested size
+		chartsQuery = fmt.Sprintf(
+			"SELECT DISTINCT ON (%[1]s) info FROM %[2]s %[3]s ORDER BY %[1]s, info ->> 'name' ASC",
+			latestDigest, dbutils.ChartTable, repoQuery,
+		)
+	}
+
+	totalPages := 1
+	paginationQuery := ""
+	if pageSize != 0 {
+		// If a pageSize is given, returns only the the specified number of charts and
+		// the number of pages
+		var cc count
+		err := m.QueryOne(&cc, fmt.Sprintf("SELECT json_build_object('count', count(*)) FROM (%s) AS charts", chartsQuery), queryParams...)
+		if err != nil {
+			return nil, 0, err
 		}
-		// TODO(andresmgot): Implement pagination but currently Kubeapps don't support it
-		return uniqueCharts, 1, nil
+		var offset int
+		totalPages, offset = pageOffset(cc.Count, pageNumber, pageSize)
+		queryParams = append(queryParams, pageSize, offset)
+		paginationQuery = fmt.Sprintf(" LIMIT $%d OFFSET $%d", len(queryParams)-1, len(queryParams))
 	}
-	return charts, 1, nil
+
+	dbQuery := fmt.Sprintf("SELECT info FROM (%s) AS charts ORDER BY info ->> 'name' ASC%s", chartsQuery, paginationQuery)
+	charts, err := m.QueryAllCharts(dbQuery, queryParams...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return charts, totalPages, nil
 }
 
 func (m *postgresAssetManager) getChart(namespace, chartID string) (models.Chart, error) {
@@ -171,3 +185,25 @@ func (m *postgresAssetManager) getChartsWithFilters(namespace, name, version, ap
 	}
 	return result, nil
 }
+
+// searchCharts returns the charts matching all the terms of the query as
+// prefixes, ordered by relevance using the full-text search index.
+func (m *postgresAssetManager) searchCharts(namespace, query, repo string) ([]*models.Chart, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []*models.Chart{}, nil
+	}
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+
+	clauses, queryParams := m.visibleChartsClauses(namespace, repo)
+	queryParams = append(queryParams, strings.Join(terms, " & "))
+	tsQuery := fmt.Sprintf("to_tsquery('simple', $%d)", len(queryParams))
+	clauses = append(clauses, fmt.Sprintf("%s @@ %s", dbutils.ChartSearchDocument, tsQuery))
+	dbQuery := fmt.Sprintf(
+		"SELECT info FROM %s WHERE %s ORDER BY ts_rank(%s, %s) DESC, info ->> 'name' ASC",
+		dbutils.ChartTable, strings.Join(clauses, " AND "), dbutils.ChartSearchDocument, tsQuery,
+	)
+	return m.QueryAllCharts(dbQuery, queryParams...)
+}
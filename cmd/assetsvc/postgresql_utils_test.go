@@ -195,6 +195,10 @@ func Test_getPaginatedChartList(t *testing.T) {
 		{ID: "bar", ChartVersions: []models.ChartVersion{{Digest: "456"}}},
 		{ID: "copyFoo", ChartVersions: []models.ChartVersion{{Digest: "123"}}},
 	}
+	const (
+		visibleCharts = "FROM charts WHERE (repo_namespace = $1 OR repo_namespace = $2)"
+		uniqueCharts  = "SELECT DISTINCT ON (COALESCE(NULLIF(info -> 'chartVersions' -> 0 ->> 'digest', ''), chart_id)) info " + visibleCharts + " ORDER BY COALESCE(NULLIF(info -> 'chartVersions' -> 0 ->> 'digest', ''), chart_id), info ->> 'name' ASC"
+	)
 	tests := []struct {
 		name               string
 		namespace          string
@@ -202,7 +206,10 @@ func Test_getPaginatedChartList(t *testing.T) {
 		pageNumber         int
 		pageSize           int
 		showDuplicates     bool
-		expectedCharts     []*models.Chart
+		count              int
+		expectedCountQuery string
+		expectedQuery      string
+		expectedParams     []interface{}
 		expectedTotalPages int
 	}{
 		{
@@ -212,20 +219,54 @@ func Test_getPaginatedChartList(t *testing.T) {
 			pageNumber:         1,
 			pageSize:           100,
 			showDuplicates:     true,
-			expectedCharts:     availableCharts,
+			count:              3,
+			expectedCountQuery: "SELECT json_build_object('count', count(*)) FROM (SELECT info " + visibleCharts + " AND repo_name = $3) AS charts",
+			expectedQuery:      "SELECT info FROM (SELECT info " + visibleCharts + " AND repo_name = $3) AS charts ORDER BY info ->> 'name' ASC LIMIT $4 OFFSET $5",
+			expectedParams:     []interface{}{"other-namespace", "kubeapps", "bitnami", 100, 0},
 			expectedTotalPages: 1,
 		},
 		{
-			name:               "one page withuot duplicates",
+			name:               "one page without duplicates",
 			namespace:          "other-namespace",
-			repo:               "",
 			pageNumber:         1,
 			pageSize:           100,
-			showDuplicates:     false,
-			expectedCharts:     []*models.Chart{availableCharts[0], availableCharts[1]},
+			count:              2,
+			expectedCountQuery: "SELECT json_build_object('count', count(*)) FROM (" + uniqueCharts + ") AS charts",
+			expectedQuery:      "SELECT info FROM (" + uniqueCharts + ") AS charts ORDER BY info ->> 'name' ASC LIMIT $3 OFFSET $4",
+			expectedParams:     []interface{}{"other-namespace", "kubeapps", 100, 0},
+			expectedTotalPages: 1,
+		},
+		{
+			name:               "several pages without duplicates",
+			namespace:          "other-namespace",
+			pageNumber:         2,
+			pageSize:           10,
+			count:              25,
+			expectedCountQuery: "SELECT json_build_object('count', count(*)) FROM (" + uniqueCharts + ") AS charts",
+			expectedQuery:      "SELECT info FROM (" + uniqueCharts + ") AS charts ORDER BY info ->> 'name' ASC LIMIT $3 OFFSET $4",
+			expectedParams:     []interface{}{"other-namespace", "kubeapps", 10, 10},
+			expectedTotalPages: 3,
+		},
+		{
+			name:               "a page out of range returns the last page",
+			namespace:          "other-namespace",
+			pageNumber:         5,
+			pageSize:           10,
+			count:              25,
+			expectedCountQuery: "SELECT json_build_object('count', count(*)) FROM (" + uniqueCharts + ") AS charts",
+			expectedQuery:      "SELECT info FROM (" + uniqueCharts + ") AS charts ORDER BY info ->> 'name' ASC LIMIT $3 OFFSET $4",
+			expectedParams:     []interface{}{"other-namespace", "kubeapps", 10, 20},
+			expectedTotalPages: 3,
+		},
+		{
+			name:               "all charts without a page size",
+			namespace:          "other-namespace",
+			pageNumber:         1,
+			pageSize:           0,
+			expectedQuery:      "SELECT info FROM (" + uniqueCharts + ") AS charts ORDER BY info ->> 'name' ASC",
+			expectedParams:     []interface{}{"other-namespace", "kubeapps"},
 			expectedTotalPages: 1,
 		},
-		// TODO(andresmgot): several pages
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -234,24 +275,56 @@ func Test_getPaginatedChartList(t *testing.T) {
 			pg := postgresAssetManager{fpg}
 
 			chartsResponse = availableCharts
-			expectedQuery := "WHERE (repo_namespace = $1 OR repo_namespace = $2)"
-			expectedParams := []interface{}{"other-namespace", "kubeapps"}
-			if tt.repo != "" {
-				expectedQuery = expectedQuery + " AND repo_name = $3"
-				expectedParams = append(expectedParams, "bitnami")
+			if tt.expectedCountQuery != "" {
+				m.On("QueryOne", &count{}, tt.expectedCountQuery, tt.expectedParams[:len(tt.expectedParams)-2]).Run(func(args mock.Arguments) {
+					*args.Get(0).(*count) = count{tt.count}
+				})
 			}
-			expectedQuery = fmt.Sprintf("SELECT info FROM %s %s ORDER BY info ->> 'name' ASC", dbutils.ChartTable, expectedQuery)
-			m.On("QueryAllCharts", expectedQuery, expectedParams)
+			m.On("QueryAllCharts", tt.expectedQuery, tt.expectedParams)
 			charts, totalPages, err := pg.getPaginatedChartList(tt.namespace, tt.repo, tt.pageNumber, tt.pageSize, tt.showDuplicates)
 			if err != nil {
 				t.Errorf("Found error %v", err)
 			}
+			m.AssertExpectations(t)
 			if totalPages != tt.expectedTotalPages {
 				t.Errorf("Unexpected number of pages, got %d expecting %d", totalPages, tt.expectedTotalPages)
 			}
-			if !cmp.Equal(charts, tt.expectedCharts) {
-				t.Errorf("Unexpected result %v", cmp.Diff(charts, tt.expectedCharts))
+			if !cmp.Equal(charts, availableCharts) {
+				t.Errorf("Unexpected result %v", cmp.Diff(charts, availableCharts))
 			}
 		})
 	}
 }
+
+func Test_PGsearchCharts(t *testing.T) {
+	m := &mock.Mock{}
+	fpg := &fakePGManager{m}
+	pg := postgresAssetManager{fpg}
+
+	chartsResponse = []*models.Chart{{ID: "bitnami/wordpress"}}
+	tsQuery := "to_tsquery('simple', $4)"
+	expectedQuery := fmt.Sprintf(
+		"SELECT info FROM charts WHERE (repo_namespace = $1 OR repo_namespace = $2) AND repo_name = $3 AND %s @@ %s ORDER BY ts_rank(%s, %s) DESC, info ->> 'name' ASC",
+		dbutils.ChartSearchDocument, tsQuery, dbutils.ChartSearchDocument, tsQuery,
+	)
+	m.On("QueryAllCharts", expectedQuery, []interface{}{"other-namespace", "kubeapps", "bitnami", "word:* & press:*"})
+
+	charts, err := pg.searchCharts("other-namespace", "Word-Press!", "bitnami")
+	if err != nil {
+		t.Errorf("Found error %v", err)
+	}
+	if !cmp.Equal(charts, chartsResponse) {
+		t.Errorf("Unexpected result %v", cmp.Diff(charts, chartsResponse))
+	}
+	m.AssertExpectations(t)
+
+	// An empty query doesn't hit the database.
+	charts, err = pg.searchCharts("other-namespace", " ", "")
+	if err != nil {
+		t.Errorf("Found error %v", err)
+	}
+	if len(charts) != 0 {
+		t.Errorf("Expected no charts, got %d", len(charts))
+	}
+	m.AssertNumberOfCalls(t, "QueryAllCharts", 1)
+}
@@ -18,6 +18,8 @@ package main
 
 import (
 	"fmt"
+	"strings"
+	"unicode"
 
 	"github.com/kubeapps/common/datastore"
 	"github.com/kubeapps/kubeapps/pkg/chart/models"
@@ -31,6 +33,7 @@ type assetManager interface {
 	getChartVersion(namespace, chartID, version string) (models.Chart, error)
 	getChartFiles(namespace, filesID string) (models.ChartFiles, error)
 	getChartsWithFilters(namespace, name, version, appVersion string) ([]*models.Chart, error)
+	searchCharts(namespace, query, repo string) ([]*models.Chart, error)
 }
 
 func newManager(databaseType string, config datastore.Config, kubeappsNamespace string) (assetManager, error) {
@@ -42,3 +45,11 @@ func newManager(databaseType string, config datastore.Config, kubeappsNamespace
 		return nil, fmt.Errorf("Unsupported database type %s", databaseType)
 	}
 }
+
+// searchTerms splits a search query into lowercase words, ignoring any
+// punctuation so that the terms can be safely used in database queries.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
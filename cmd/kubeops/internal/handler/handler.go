@@ -2,12 +2,16 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/kubeapps/common/response"
 	"github.com/kubeapps/kubeapps/pkg/agent"
+	"github.com/kubeapps/kubeapps/pkg/audit"
 	"github.com/kubeapps/kubeapps/pkg/auth"
 	chartUtils "github.com/kubeapps/kubeapps/pkg/chart"
 	"github.com/kubeapps/kubeapps/pkg/chart/helm3to2"
@@ -16,8 +20,13 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/negroni"
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/postrender"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 )
 
 const (
@@ -43,14 +52,34 @@ type Options struct {
 	UserAgent          string
 	KubeappsNamespace  string
 	AdditionalClusters kube.AdditionalClustersConfig
+	// Audit records the release actions. The audit log is disabled if nil.
+	Audit *audit.Logger
+	// Policy loads the policy releases must comply with. Releases are not
+	// evaluated if nil.
+	Policy *agent.PolicyLoader
+	// ReleaseMetadata is injected in the objects of the releases of each
+	// namespace, along with the metadata of the app repository of the chart.
+	ReleaseMetadata *agent.ReleaseMetadataConfig
+	// Users resolves the user of the requests for the release metadata
+	// referring to it. The user is left empty if nil.
+	Users audit.UserResolver
+	// DigestResolver resolves the digests of the images pinned by the image
+	// rewrite of app repositories.
+	DigestResolver agent.DigestResolver
 }
 
 // Config represents data needed by each handler to be able to create Helm 3 actions.
 // It cannot be created without a bearer token, so a new one must be created upon each HTTP request.
 type Config struct {
-	ActionConfig *action.Configuration
-	Options      Options
-	ChartClient  chartUtils.Resolver
+	ActionConfig  *action.Configuration
+	Options       Options
+	ChartClient   chartUtils.Resolver
+	KubeClient    kubernetes.Interface
+	DynamicClient dynamic.Interface
+	// RESTMapper builds the mapper of the kinds of the release objects. It
+	// is only called by the handlers which need it, since the mapper runs an
+	// API discovery of the cluster.
+	RESTMapper func() meta.RESTMapper
 }
 
 // WithHandlerConfig takes a dependentHandler and creates a regular (WithParams) handler that,
@@ -87,6 +116,12 @@ func WithHandlerConfig(storageForDriver agent.StorageForDriver, options Options)
 				response.NewErrorResponse(http.StatusInternalServerError, authUserError).Write(w)
 				return
 			}
+			userDynamicClient, err := dynamic.NewForConfig(restConfig)
+			if err != nil {
+				log.Errorf("Failed to create dynamic client with user config: %v", err)
+				response.NewErrorResponse(http.StatusInternalServerError, authUserError).Write(w)
+				return
+			}
 			actionConfig, err := agent.NewActionConfig(storageForDriver, restConfig, userKubeClient, namespace)
 			if err != nil {
 				log.Errorf("Failed to create action config with user client: %v", err)
@@ -102,9 +137,14 @@ func WithHandlerConfig(storageForDriver agent.StorageForDriver, options Options)
 			}
 
 			cfg := Config{
-				Options:      options,
-				ActionConfig: actionConfig,
-				ChartClient:  chartUtils.NewChartClient(kubeHandler, options.KubeappsNamespace, options.UserAgent),
+				Options:       options,
+				ActionConfig:  actionConfig,
+				ChartClient:   chartUtils.NewChartClient(kubeHandler, options.KubeappsNamespace, options.UserAgent),
+				KubeClient:    userKubeClient,
+				DynamicClient: userDynamicClient,
+				RESTMapper: func() meta.RESTMapper {
+					return restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(userKubeClient.Discovery()))
+				},
 			}
 			f(cfg, w, req, params)
 		}
@@ -121,6 +161,24 @@ func AddRouteWith(
 	}
 }
 
+// clusterFromParams returns the cluster of a request, which defaults to the
+// cluster of Kubeapps.
+func clusterFromParams(params handlerutil.Params) string {
+	cluster, ok := params[clusterParam]
+	if !ok {
+		cluster = kube.DefaultClusterName
+	}
+	return cluster
+}
+
+// recordAudit records a release action in the audit log.
+func recordAudit(cfg Config, req *http.Request, params handlerutil.Params, event audit.Event, err error) {
+	event.Cluster = clusterFromParams(params)
+	event.Namespace = params[namespaceParam]
+	event.Resource = audit.ResourceRelease
+	cfg.Options.Audit.Record(auth.ExtractToken(req.Header.Get(authHeader)), event, err)
+}
+
 func returnForbiddenActions(forbiddenActions []auth.Action, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 	body, err := json.Marshal(forbiddenActions)
@@ -131,10 +189,40 @@ func returnForbiddenActions(forbiddenActions []auth.Action, w http.ResponseWrite
 	response.NewErrorResponse(http.StatusForbidden, string(body)).Write(w)
 }
 
+// returnValidationErrors returns the field errors of invalid values as the
+// message of the response.
+func returnValidationErrors(validationErr *agent.ValuesValidationError, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	body, err := json.Marshal(validationErr.Errors)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewErrorResponse(http.StatusUnprocessableEntity, string(body)).Write(w)
+}
+
+// returnPolicyViolations returns the violations of the policy as the message
+// of the response.
+func returnPolicyViolations(violationErr *agent.PolicyViolationError, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	body, err := json.Marshal(violationErr.Violations)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewErrorResponse(http.StatusUnprocessableEntity, string(body)).Write(w)
+}
+
 func returnErrMessage(err error, w http.ResponseWriter) {
 	code := handlerutil.ErrorCode(err)
 	errMessage := err.Error()
-	if code == http.StatusForbidden {
+	var validationErr *agent.ValuesValidationError
+	var violationErr *agent.PolicyViolationError
+	if errors.As(err, &validationErr) {
+		returnValidationErrors(validationErr, w)
+	} else if errors.As(err, &violationErr) {
+		returnPolicyViolations(violationErr, w)
+	} else if code == http.StatusForbidden {
 		forbiddenActions := auth.ParseForbiddenActions(errMessage)
 		if len(forbiddenActions) > 0 {
 			returnForbiddenActions(forbiddenActions, w)
@@ -147,6 +235,78 @@ func returnErrMessage(err error, w http.ResponseWriter) {
 	}
 }
 
+// newPostRenderer returns the post-renderer of a release, which rewrites
+// the images as configured in the app repository of its chart, injects the
+// metadata of its namespace and of the app repository and then evaluates the
+// policy.
+func newPostRenderer(cfg Config, req *http.Request, params handlerutil.Params, policy *agent.Policy) (postrender.PostRenderer, error) {
+	options := agent.PostRenderOptions{
+		RegistrySecrets: cfg.ChartClient.RegistrySecretsPerDomain(),
+		Metadata:        cfg.Options.ReleaseMetadata.ForNamespace(params[namespaceParam]),
+		Policy:          policy,
+	}
+	if appRepo := cfg.ChartClient.AppRepository(); appRepo != nil {
+		if appRepo.Spec.ReleaseMetadata != nil {
+			options.Metadata = options.Metadata.Merge(agent.ReleaseMetadata{
+				Labels:      appRepo.Spec.ReleaseMetadata.Labels,
+				Annotations: appRepo.Spec.ReleaseMetadata.Annotations,
+			})
+		}
+		if imageRewrite := appRepo.Spec.ImageRewrite; imageRewrite != nil {
+			for _, rule := range imageRewrite.Rules {
+				options.ImageRewriteRules = append(options.ImageRewriteRules, agent.ImageRewriteRule{From: rule.From, To: rule.To})
+			}
+			if imageRewrite.PinDigests {
+				options.DigestResolver = cfg.Options.DigestResolver
+			}
+		}
+	}
+	if options.Metadata.UsesUser() {
+		options.Metadata = options.Metadata.WithUser(resolveUser(cfg, req, params))
+	}
+	return agent.NewReleasePostRenderer(options)
+}
+
+// resolveUser returns the name of the user of a request. Failures are only
+// logged so that they do not fail the release.
+func resolveUser(cfg Config, req *http.Request, params handlerutil.Params) string {
+	if cfg.Options.Users == nil {
+		return ""
+	}
+	user, err := cfg.Options.Users.Resolve(auth.ExtractToken(req.Header.Get(authHeader)), clusterFromParams(params))
+	if err != nil {
+		log.Errorf("Unable to resolve the user of the release: %v", err)
+	}
+	return user
+}
+
+// provisionPullSecrets copies the image pull secrets of the app repository
+// of the chart into the namespace of a release, for the pods of the release
+// to pull the images of its registries.
+func provisionPullSecrets(cfg Config, namespace, releaseName string) error {
+	appRepo := cfg.ChartClient.AppRepository()
+	if appRepo == nil {
+		return nil
+	}
+	return agent.ProvisionPullSecrets(cfg.KubeClient, appRepo.Namespace, appRepo.Spec.DockerRegistrySecrets, namespace, releaseName)
+}
+
+// releasePullSecrets deletes the image pull secrets copied for a release
+// which are not used by other releases. Failures are only logged since the
+// release itself is already gone.
+func releasePullSecrets(cfg Config, namespace, releaseName string) {
+	if err := agent.ReleasePullSecrets(cfg.KubeClient, namespace, releaseName); err != nil {
+		log.Errorf("Unable to release the image pull secrets of %s/%s: %v", namespace, releaseName, err)
+	}
+}
+
+// returnPolicyLoadError refuses a release whose policy cannot be loaded,
+// rather than letting it skip the policy.
+func returnPolicyLoadError(err error, w http.ResponseWriter) {
+	log.Errorf("Unable to load the release policy: %v", err)
+	response.NewErrorResponse(http.StatusInternalServerError, fmt.Sprintf("Unable to load the release policy: %v", err)).Write(w)
+}
+
 // ListReleases list existing releases.
 func ListReleases(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
 	apps, err := agent.ListReleases(cfg.ActionConfig, params[namespaceParam], cfg.Options.ListLimit, req.URL.Query().Get("statuses"))
@@ -162,7 +322,8 @@ func ListAllReleases(cfg Config, w http.ResponseWriter, req *http.Request, _ han
 	ListReleases(cfg, w, req, make(map[string]string))
 }
 
-// CreateRelease creates a release.
+// CreateRelease creates a release. With the "dryRun" query param, the release
+// is only rendered and nothing is installed.
 func CreateRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
 	chartDetails, chartMulti, err := handlerutil.ParseAndGetChart(req, cfg.ChartClient, isV1SupportRequired)
 	if err != nil {
@@ -174,7 +335,48 @@ func CreateRelease(cfg Config, w http.ResponseWriter, req *http.Request, params
 	releaseName := chartDetails.ReleaseName
 	namespace := params[namespaceParam]
 	valuesString := chartDetails.Values
-	release, err := agent.CreateRelease(cfg.ActionConfig, releaseName, namespace, valuesString, ch, cfg.ChartClient.RegistrySecretsPerDomain())
+	if err := agent.ValidateValues(ch, valuesString, nil); err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	policy, err := cfg.Options.Policy.Load()
+	if err != nil {
+		returnPolicyLoadError(err, w)
+		return
+	}
+	postRenderer, err := newPostRenderer(cfg, req, params, policy)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if handlerutil.QueryParamIsTruthy("dryRun", req) {
+		result, err := agent.DryRunCreateRelease(cfg.ActionConfig, releaseName, namespace, valuesString, ch, postRenderer)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+		response.NewDataResponse(result).Write(w)
+		return
+	}
+	if err := provisionPullSecrets(cfg, namespace, releaseName); err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	release, err := agent.CreateRelease(cfg.ActionConfig, releaseName, namespace, valuesString, ch, postRenderer)
+	if err != nil {
+		// The secrets are only released when the failed install left no
+		// release, rather than when the release already existed.
+		if _, getErr := agent.GetRelease(cfg.ActionConfig, releaseName); getErr != nil {
+			releasePullSecrets(cfg, namespace, releaseName)
+		}
+	}
+	recordAudit(cfg, req, params, audit.Event{
+		Name:       releaseName,
+		Action:     "create",
+		Chart:      chartDetails.ChartName,
+		Version:    ch.Metadata.Version,
+		ValuesHash: audit.ValuesHash(valuesString),
+	}, err)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
@@ -189,7 +391,8 @@ func OperateRelease(cfg Config, w http.ResponseWriter, req *http.Request, params
 		upgradeRelease(cfg, w, req, params)
 	case "rollback":
 		rollbackRelease(cfg, w, req, params)
-	// TODO: Add "test" case here.
+	case "test":
+		testRelease(cfg, w, req, params)
 	default:
 		// By default, for maintaining compatibility, we call upgrade.
 		upgradeRelease(cfg, w, req, params)
@@ -205,7 +408,46 @@ func upgradeRelease(cfg Config, w http.ResponseWriter, req *http.Request, params
 	}
 
 	ch := chartMulti.Helm3Chart
-	rel, err := agent.UpgradeRelease(cfg.ActionConfig, releaseName, chartDetails.Values, ch, cfg.ChartClient.RegistrySecretsPerDomain())
+	current, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if err := agent.ValidateValues(ch, chartDetails.Values, current.Config); err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	policy, err := cfg.Options.Policy.Load()
+	if err != nil {
+		returnPolicyLoadError(err, w)
+		return
+	}
+	postRenderer, err := newPostRenderer(cfg, req, params, policy)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if handlerutil.QueryParamIsTruthy("dryRun", req) {
+		result, err := agent.DryRunUpgradeRelease(cfg.ActionConfig, releaseName, chartDetails.Values, ch, postRenderer)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+		response.NewDataResponse(result).Write(w)
+		return
+	}
+	if err := provisionPullSecrets(cfg, params[namespaceParam], releaseName); err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	rel, err := agent.UpgradeRelease(cfg.ActionConfig, releaseName, chartDetails.Values, ch, postRenderer)
+	recordAudit(cfg, req, params, audit.Event{
+		Name:       releaseName,
+		Action:     "upgrade",
+		Chart:      chartDetails.ChartName,
+		Version:    ch.Metadata.Version,
+		ValuesHash: audit.ValuesHash(chartDetails.Values),
+	}, err)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
@@ -231,6 +473,12 @@ func rollbackRelease(cfg Config, w http.ResponseWriter, req *http.Request, param
 		return
 	}
 	rel, err := agent.RollbackRelease(cfg.ActionConfig, releaseName, int(revisionInt))
+	event := audit.Event{Name: releaseName, Action: "rollback", Revision: int(revisionInt)}
+	if err == nil && rel.Chart != nil && rel.Chart.Metadata != nil {
+		event.Chart = rel.Chart.Metadata.Name
+		event.Version = rel.Chart.Metadata.Version
+	}
+	recordAudit(cfg, req, params, event, err)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
@@ -243,6 +491,17 @@ func rollbackRelease(cfg Config, w http.ResponseWriter, req *http.Request, param
 	response.NewDataResponse(compatRelease).Write(w)
 }
 
+func testRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	releaseName := params[nameParam]
+	timeout := time.Duration(cfg.Options.Timeout) * time.Second
+	result, err := agent.TestRelease(cfg.ActionConfig, agent.PodLogsForClientset(cfg.KubeClient), releaseName, timeout)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(result).Write(w)
+}
+
 // GetRelease returns a release.
 func GetRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
 	// Namespace is already known by the RESTClientGetter.
@@ -260,6 +519,134 @@ func GetRelease(cfg Config, w http.ResponseWriter, req *http.Request, params han
 	response.NewDataResponse(compatRelease).Write(w)
 }
 
+// GetReleaseStatus returns the readiness of the resources of a release.
+func GetReleaseStatus(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	rel, err := agent.GetRelease(cfg.ActionConfig, params[nameParam])
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	status, err := agent.GetReleaseStatus(rel, cfg.DynamicClient, cfg.RESTMapper())
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(status).Write(w)
+}
+
+// WatchRelease streams the changes of the resources of a release as
+// Server-Sent Events until the client disconnects or a watch fails.
+func WatchRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.NewErrorResponse(http.StatusInternalServerError, "Streaming is not supported").Write(w)
+		return
+	}
+	rel, err := agent.GetRelease(cfg.ActionConfig, params[nameParam])
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	events, errs, err := agent.WatchRelease(req.Context(), rel, cfg.DynamicClient, cfg.RESTMapper())
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for event := range events {
+		writeEvent(w, event.Type, event)
+		flusher.Flush()
+	}
+	select {
+	case err := <-errs:
+		log.Infof("Stopped watching release %s: %v", rel.Name, err)
+		writeEvent(w, "ERROR", response.NewErrorResponse(handlerutil.ErrorCode(err), err.Error()))
+		flusher.Flush()
+	default:
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, data interface{}) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		log.Errorf("Unable to encode event: %v", err)
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+}
+
+// GetReleaseHistory returns the revisions of a release.
+func GetReleaseHistory(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	history, err := agent.GetReleaseHistory(cfg.ActionConfig, params[nameParam])
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(history).Write(w)
+}
+
+// GetReleaseDiff returns the manifest and values differences between the
+// revisions given in the "from" and "to" query params.
+func GetReleaseDiff(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	releaseName := params[nameParam]
+	from, err := strconv.Atoi(req.FormValue("from"))
+	if err != nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, "Missing or invalid \"from\" revision in request").Write(w)
+		return
+	}
+	to, err := strconv.Atoi(req.FormValue("to"))
+	if err != nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, "Missing or invalid \"to\" revision in request").Write(w)
+		return
+	}
+	fromRelease, err := agent.GetReleaseRevision(cfg.ActionConfig, releaseName, from)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	toRelease, err := agent.GetReleaseRevision(cfg.ActionConfig, releaseName, to)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	diff, err := agent.DiffReleases(fromRelease, toRelease)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(diff).Write(w)
+}
+
+// PreviewValues returns the values a release would be installed or upgraded
+// with: the chart default values coalesced with the values of the request,
+// or with the values of the existing release if the request has none.
+func PreviewValues(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	chartDetails, chartMulti, err := handlerutil.ParseAndGetChart(req, cfg.ChartClient, isV1SupportRequired)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	var releaseValues map[string]interface{}
+	rel, err := agent.GetRelease(cfg.ActionConfig, params[nameParam])
+	if err == nil {
+		releaseValues = rel.Config
+	} else if handlerutil.ErrorCode(err) != http.StatusNotFound {
+		returnErrMessage(err, w)
+		return
+	}
+	preview, err := agent.PreviewValues(chartMulti.Helm3Chart, releaseValues, chartDetails.Values)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(preview).Write(w)
+}
+
 // DeleteRelease deletes a release.
 func DeleteRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
 	releaseName := params[nameParam]
@@ -268,10 +655,12 @@ func DeleteRelease(cfg Config, w http.ResponseWriter, req *http.Request, params
 	// https://stackoverflow.com/a/59210923/2135002
 	keepHistory := !purge
 	err := agent.DeleteRelease(cfg.ActionConfig, releaseName, keepHistory)
+	recordAudit(cfg, req, params, audit.Event{Name: releaseName, Action: "delete"}, err)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
 	}
+	releasePullSecrets(cfg, params[namespaceParam], releaseName)
 	w.Header().Set("Status-Code", "200")
 	w.Write([]byte("OK"))
 }
@@ -1,6 +1,9 @@
 package handler
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -11,7 +14,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/docker/distribution/reference"
 	"github.com/google/go-cmp/cmp"
+	appRepov1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/agent"
+	"github.com/kubeapps/kubeapps/pkg/audit"
 	chartFake "github.com/kubeapps/kubeapps/pkg/chart/fake"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
@@ -20,6 +27,12 @@ import (
 	"helm.sh/helm/v3/pkg/storage"
 	"helm.sh/helm/v3/pkg/storage/driver"
 	helmTime "helm.sh/helm/v3/pkg/time"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
 
 	"helm.sh/helm/v3/pkg/release"
 )
@@ -47,7 +60,10 @@ func newConfigFixture(t *testing.T, k *kubefake.FailingKubeClient) *Config {
 				t.Logf(format, v...)
 			},
 		},
-		ChartClient: &chartFake.FakeChart{},
+		ChartClient:   &chartFake.FakeChart{},
+		KubeClient:    fake.NewSimpleClientset(),
+		DynamicClient: fakedynamic.NewSimpleDynamicClient(runtime.NewScheme()),
+		RESTMapper:    func() meta.RESTMapper { return meta.NewDefaultRESTMapper(nil) },
 		Options: Options{
 			ListLimit: defaultListLimit,
 		},
@@ -113,6 +129,20 @@ func TestActions(t *testing.T) {
 			},
 			ResponseBody: "",
 		},
+		{
+			// Scenario params
+			Description:      "Render a release without installing it",
+			ExistingReleases: []*release.Release{},
+			// Request params
+			RequestBody: `{"chartName": "foo", "releaseName": "foobar",	"version": "1.0.0"}`,
+			RequestQuery: "?dryRun=true",
+			Action:       "create",
+			Params:       map[string]string{"namespace": "default"},
+			// Expected result
+			StatusCode:        200,
+			RemainingReleases: nil,
+			ResponseBody:      `{"data":{"manifest":"","hooks":"","notes":""}}`,
+		},
 		{
 			// Scenario params
 			Description: "Create a conflicting release",
@@ -482,6 +512,20 @@ func TestUpgradeAction(t *testing.T) {
 			},
 			responseBody: `{"data":{"name":"my-release","info":{"status":{"code":1}},"chart":{"metadata":{"name":"apache"},"values":{"raw":"{}\n"}},"config":{"raw":"{}\n"},"version":2,"namespace":"default"}}`,
 		},
+		{
+			name: "renders an upgrade without applying it",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			queryString: "action=upgrade&dryRun=true",
+			requestBody: `{"chartName": "apache",	"releaseName":"my-release",	"version": "1.0.0"}`,
+			params:     map[string]string{nameParam: releaseName},
+			statusCode: http.StatusOK,
+			expectedReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			responseBody: `{"data":{"manifest":"","hooks":"","notes":""}}`,
+		},
 		{
 			name:             "upgrade a missing release",
 			existingReleases: []*release.Release{},
@@ -524,3 +568,547 @@ func TestUpgradeAction(t *testing.T) {
 		})
 	}
 }
+
+func TestGetReleaseHistory(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		params           map[string]string
+		statusCode       int
+		responseBody     string
+	}{
+		{
+			name: "returns the revisions of a release",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusSuperseded),
+				createRelease("apache", releaseName, "default", 2, release.StatusDeployed),
+			},
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusOK,
+			responseBody: `{"data":[{"revision":1,"status":"superseded","chart":"apache","chartVersion":"","appVersion":"","updated":"0001-01-01T00:00:00Z","description":""},{"revision":2,"status":"deployed","chart":"apache","chartVersion":"","appVersion":"","updated":"0001-01-01T00:00:00Z","description":""}]}`,
+		},
+		{
+			name: "errors if the release does not exist",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			params:       map[string]string{nameParam: "does-not-exist"},
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, tc.existingReleases)
+			req := httptest.NewRequest("GET", "https://example.com/whatever", strings.NewReader(""))
+			response := httptest.NewRecorder()
+
+			GetReleaseHistory(*cfg, response, req, tc.params)
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGetReleaseDiff(t *testing.T) {
+	const releaseName = "my-release"
+	existingReleases := []*release.Release{
+		createRelease("apache", releaseName, "default", 1, release.StatusSuperseded),
+		createRelease("apache", releaseName, "default", 2, release.StatusDeployed),
+	}
+	testCases := []struct {
+		name         string
+		queryString  string
+		params       map[string]string
+		statusCode   int
+		responseBody string
+	}{
+		{
+			name:         "returns the differences between two revisions",
+			queryString:  "from=1&to=2",
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusOK,
+			responseBody: `{"data":{"from":1,"to":2,"resources":[],"values":""}}`,
+		},
+		{
+			name:         "errors if the from revision is not specified",
+			queryString:  "to=2",
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"Missing or invalid \"from\" revision in request"}`,
+		},
+		{
+			name:         "errors if the to revision is invalid",
+			queryString:  "from=1&to=latest",
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"Missing or invalid \"to\" revision in request"}`,
+		},
+		{
+			name:         "errors if a revision does not exist",
+			queryString:  "from=1&to=3",
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, existingReleases)
+			req := httptest.NewRequest("GET", fmt.Sprintf("https://example.com/whatever?%s", tc.queryString), strings.NewReader(""))
+			response := httptest.NewRecorder()
+
+			GetReleaseDiff(*cfg, response, req, tc.params)
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestTestAction(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		params           map[string]string
+		statusCode       int
+		responseBody     string
+	}{
+		{
+			name: "runs the tests of a release",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusOK,
+			responseBody: `{"data":{"passed":true,"tests":[]}}`,
+		},
+		{
+			name: "errors if the release does not exist",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			params:       map[string]string{nameParam: "does-not-exist"},
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, tc.existingReleases)
+			req := httptest.NewRequest("PUT", "https://example.com/whatever?action=test", strings.NewReader(""))
+			response := httptest.NewRecorder()
+
+			OperateRelease(*cfg, response, req, tc.params)
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGetReleaseStatus(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name         string
+		params       map[string]string
+		statusCode   int
+		responseBody string
+	}{
+		{
+			name:         "returns the status of a release",
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusOK,
+			responseBody: `{"data":{"status":"ready","resources":[]}}`,
+		},
+		{
+			name:         "errors if the release does not exist",
+			params:       map[string]string{nameParam: "does-not-exist"},
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			})
+			req := httptest.NewRequest("GET", "https://example.com/whatever", strings.NewReader(""))
+			response := httptest.NewRecorder()
+
+			GetReleaseStatus(*cfg, response, req, tc.params)
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWatchRelease(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name         string
+		params       map[string]string
+		statusCode   int
+		contentType  string
+		responseBody string
+	}{
+		{
+			name:         "streams the changes of a release without resources",
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusOK,
+			contentType:  "text/event-stream",
+			responseBody: "",
+		},
+		{
+			name:         "errors if the release does not exist",
+			params:       map[string]string{nameParam: "does-not-exist"},
+			statusCode:   http.StatusNotFound,
+			contentType:  "application/json; charset=UTF-8",
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			})
+			req := httptest.NewRequest("GET", "https://example.com/whatever", strings.NewReader(""))
+			response := httptest.NewRecorder()
+
+			WatchRelease(*cfg, response, req, tc.params)
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Header().Get("Content-Type"), tc.contentType; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	cfg := newConfigFixture(t, k)
+	cfg.Options.Audit = audit.NewLogger(nil, audit.NewJSONSink(&buf))
+	params := map[string]string{clusterParam: "default", namespaceParam: "default", nameParam: "foobar"}
+
+	req := httptest.NewRequest("POST", "https://example.com/whatever", strings.NewReader(`{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "values": "a: b"}`))
+	CreateRelease(*cfg, httptest.NewRecorder(), req, params)
+	req = httptest.NewRequest("PUT", "https://example.com/whatever?action=rollback&revision=3", strings.NewReader(""))
+	OperateRelease(*cfg, httptest.NewRecorder(), req, params)
+	req = httptest.NewRequest("DELETE", "https://example.com/whatever", strings.NewReader(""))
+	DeleteRelease(*cfg, httptest.NewRecorder(), req, params)
+
+	expected := []audit.Event{
+		{
+			Cluster:    "default",
+			Namespace:  "default",
+			Resource:   audit.ResourceRelease,
+			Name:       "foobar",
+			Action:     "create",
+			Chart:      "foo",
+			ValuesHash: audit.ValuesHash("a: b"),
+			Outcome:    audit.OutcomeSuccess,
+		},
+		{
+			Cluster:   "default",
+			Namespace: "default",
+			Resource:  audit.ResourceRelease,
+			Name:      "foobar",
+			Action:    "rollback",
+			Revision:  3,
+			Outcome:   audit.OutcomeFailure,
+			Error:     "release: not found",
+		},
+		{
+			Cluster:   "default",
+			Namespace: "default",
+			Resource:  audit.ResourceRelease,
+			Name:      "foobar",
+			Action:    "delete",
+			Outcome:   audit.OutcomeSuccess,
+		},
+	}
+	events := []audit.Event{}
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var event audit.Event
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		event.Time = time.Time{}
+		events = append(events, event)
+	}
+	if got, want := events, expected; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestPullSecretsProvisioning(t *testing.T) {
+	k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	cfg := newConfigFixture(t, k)
+	cfg.KubeClient = fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "repo-namespace"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths":{}}`)},
+	})
+	cfg.ChartClient = &chartFake.FakeChart{AppRepo: &appRepov1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "repo", Namespace: "repo-namespace"},
+		Spec:       appRepov1.AppRepositorySpec{DockerRegistrySecrets: []string{"registry"}},
+	}}
+	params := map[string]string{clusterParam: "default", namespaceParam: "default", nameParam: "foobar"}
+
+	req := httptest.NewRequest("POST", "https://example.com/whatever", strings.NewReader(`{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0"}`))
+	CreateRelease(*cfg, httptest.NewRecorder(), req, params)
+	secret, err := cfg.KubeClient.CoreV1().Secrets("default").Get(context.TODO(), "registry", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := secret.Labels[agent.PullSecretOwnerLabelPrefix+"foobar"], "true"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	req = httptest.NewRequest("DELETE", "https://example.com/whatever", strings.NewReader(""))
+	DeleteRelease(*cfg, httptest.NewRecorder(), req, params)
+	if _, err := cfg.KubeClient.CoreV1().Secrets("default").Get(context.TODO(), "registry", metav1.GetOptions{}); err == nil {
+		t.Errorf("got: the secret of the deleted release, want: deleted")
+	}
+}
+
+// fakeUserResolver resolves every token to the same user.
+type fakeUserResolver struct {
+	user string
+}
+
+func (r *fakeUserResolver) Resolve(token, cluster string) (string, error) {
+	return r.user, nil
+}
+
+func TestNewPostRenderer(t *testing.T) {
+	k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	cfg := newConfigFixture(t, k)
+	cfg.Options.ReleaseMetadata = &agent.ReleaseMetadataConfig{Namespaces: map[string]agent.ReleaseMetadata{
+		"*":       {Labels: map[string]string{"managed-by": "kubeapps", "team": "none"}},
+		"default": {Annotations: map[string]string{"installed-by": agent.UserPlaceholder}},
+	}}
+	cfg.Options.Users = &fakeUserResolver{user: "me@example.com"}
+	cfg.ChartClient = &chartFake.FakeChart{AppRepo: &appRepov1.AppRepository{
+		Spec: appRepov1.AppRepositorySpec{
+			ReleaseMetadata: &appRepov1.AppRepositoryReleaseMetadata{Labels: map[string]string{"team": "a"}},
+		},
+	}}
+	req := httptest.NewRequest("POST", "https://example.com/whatever", strings.NewReader(""))
+
+	postRenderer, err := newPostRenderer(*cfg, req, map[string]string{namespaceParam: "default"}, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	output, err := postRenderer.Run(bytes.NewBufferString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: foo\n"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expected := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  annotations:
+    installed-by: me@example.com
+  labels:
+    managed-by: kubeapps
+    team: a
+  name: foo
+`
+	if got, want := output.String(), expected; got != want {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+// fakeDigestResolver resolves every image to the same digest.
+type fakeDigestResolver struct {
+	digest string
+}
+
+func (r *fakeDigestResolver) ResolveDigest(image reference.NamedTagged) (string, error) {
+	return r.digest, nil
+}
+
+func TestNewPostRendererImageRewrite(t *testing.T) {
+	const digest = "sha256:0123456789012345678901234567890123456789012345678901234567890123"
+	k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	cfg := newConfigFixture(t, k)
+	cfg.Options.DigestResolver = &fakeDigestResolver{digest: digest}
+	input := "apiVersion: v1\nkind: Pod\nmetadata:\n  name: foo\nspec:\n  containers:\n  - image: bitnami/nginx:1.19\n    name: nginx\n"
+
+	testCases := []struct {
+		name          string
+		imageRewrite  *appRepov1.AppRepositoryImageRewrite
+		expectedImage string
+	}{
+		{
+			name:          "leaves the images without image rewrite",
+			expectedImage: "bitnami/nginx:1.19",
+		},
+		{
+			name: "rewrites the images",
+			imageRewrite: &appRepov1.AppRepositoryImageRewrite{
+				Rules: []appRepov1.AppRepositoryImageRewriteRule{{From: "docker.io/*", To: "mirror.internal/dockerhub/*"}},
+			},
+			expectedImage: "mirror.internal/dockerhub/bitnami/nginx:1.19",
+		},
+		{
+			name: "rewrites and pins the images",
+			imageRewrite: &appRepov1.AppRepositoryImageRewrite{
+				Rules:      []appRepov1.AppRepositoryImageRewriteRule{{From: "docker.io/*", To: "mirror.internal/dockerhub/*"}},
+				PinDigests: true,
+			},
+			expectedImage: "mirror.internal/dockerhub/bitnami/nginx:1.19@" + digest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg.ChartClient = &chartFake.FakeChart{AppRepo: &appRepov1.AppRepository{
+				Spec: appRepov1.AppRepositorySpec{ImageRewrite: tc.imageRewrite},
+			}}
+			req := httptest.NewRequest("POST", "https://example.com/whatever", strings.NewReader(""))
+
+			postRenderer, err := newPostRenderer(*cfg, req, map[string]string{namespaceParam: "default"}, nil)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			output, err := postRenderer.Run(bytes.NewBufferString(input))
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			expected := strings.Replace(input, "bitnami/nginx:1.19", tc.expectedImage, 1)
+			if got, want := output.String(), expected; got != want {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestReturnValidationErrors(t *testing.T) {
+	response := httptest.NewRecorder()
+	err := fmt.Errorf("Unable to install: %w", &agent.ValuesValidationError{
+		Errors: []agent.FieldError{{Field: "image.tag", Message: "Invalid type. Expected: string, given: integer"}},
+	})
+
+	returnErrMessage(err, response)
+
+	if got, want := response.Code, http.StatusUnprocessableEntity; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	expectedBody := `{"code":422,"message":"[{\"field\":\"image.tag\",\"message\":\"Invalid type. Expected: string, given: integer\"}]"}`
+	if got, want := response.Body.String(), expectedBody; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestReturnPolicyViolations(t *testing.T) {
+	response := httptest.NewRecorder()
+	err := fmt.Errorf("Unable to install: %w", &agent.PolicyViolationError{
+		Violations: []agent.PolicyViolation{{Rule: "privileged", Kind: "Deployment", Name: "foo", Container: "bar", Message: "the container is privileged"}},
+	})
+
+	returnErrMessage(err, response)
+
+	if got, want := response.Code, http.StatusUnprocessableEntity; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	expectedBody := `{"code":422,"message":"[{\"rule\":\"privileged\",\"kind\":\"Deployment\",\"name\":\"foo\",\"container\":\"bar\",\"message\":\"the container is privileged\"}]"}`
+	if got, want := response.Body.String(), expectedBody; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func releaseWithConfig() *release.Release {
+	rel := createRelease("foo", "foobar", "default", 1, release.StatusDeployed)
+	rel.Config = map[string]interface{}{"service": map[string]interface{}{"type": "NodePort"}}
+	return rel
+}
+
+func TestPreviewValues(t *testing.T) {
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		values           string
+		responseBody     string
+	}{
+		{
+			name:         "previews the values of a new release",
+			values:       "replicaCount: 2",
+			responseBody: `{"data":{"values":"replicaCount: 2\n","overrides":[],"unknown":[]}}`,
+		},
+		{
+			name:             "previews the values of an upgrade",
+			existingReleases: []*release.Release{releaseWithConfig()},
+			values:           "replicaCount: 2",
+			responseBody:     `{"data":{"values":"replicaCount: 2\n","overrides":[],"unknown":[]}}`,
+		},
+		{
+			name:             "previews the values of an upgrade without values",
+			existingReleases: []*release.Release{releaseWithConfig()},
+			values:           "",
+			responseBody:     `{"data":{"values":"service:\n  type: NodePort\n","overrides":[],"unknown":[]}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, tc.existingReleases)
+			req := httptest.NewRequest("POST", "https://example.com/whatever", strings.NewReader(fmt.Sprintf(`{"chartName": "foo", "releaseName": "foobar", "version": "1.0.0", "values": %q}`, tc.values)))
+			response := httptest.NewRecorder()
+
+			PreviewValues(*cfg, response, req, map[string]string{namespaceParam: "default", nameParam: "foobar"})
+
+			if got, want := response.Code, http.StatusOK; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
@@ -18,22 +18,40 @@ import (
 	"github.com/heptiolabs/healthcheck"
 	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/handler"
 	"github.com/kubeapps/kubeapps/pkg/agent"
+	"github.com/kubeapps/kubeapps/pkg/audit"
 	"github.com/kubeapps/kubeapps/pkg/auth"
+	chartUtils "github.com/kubeapps/kubeapps/pkg/chart"
 	backendHandlers "github.com/kubeapps/kubeapps/pkg/http-handler"
 	"github.com/kubeapps/kubeapps/pkg/kube"
+	"github.com/kubeapps/kubeapps/pkg/metrics"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"github.com/urfave/negroni"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/helm/pkg/helm/environment"
 )
 
 const additionalClustersCAFilesPrefix = "/etc/additional-clusters-cafiles"
 
+// digestResolutionTimeout is the timeout of the requests resolving the
+// digests of the images pinned by the image rewrite of app repositories.
+const digestResolutionTimeout = 30 * time.Second
+
 var (
 	additionalClustersConfigPath string
 	assetsvcURL                  string
+	auditLogPath                 string
+	auditWebhookURL              string
+	chartCacheDir                string
+	chartCacheSize               int64
 	helmDriverArg                string
+	indexCacheSize               int64
 	listLimit                    int
+	metricsAddr                  string
+	podSpecPathsConfigPath       string
+	policyConfigMap              string
+	releaseMetadataConfigPath    string
 	settings                     environment.EnvSettings
 	timeout                      int64
 	userAgentComment             string
@@ -46,8 +64,17 @@ func init() {
 	pflag.IntVar(&listLimit, "list-max", 256, "maximum number of releases to fetch")
 	pflag.StringVar(&userAgentComment, "user-agent-comment", "", "UserAgent comment used during outbound requests")
 	// Default timeout from https://github.com/helm/helm/blob/b0b0accdfc84e154b3d48ec334cd5b4f9b345667/cmd/helm/install.go#L216
-	pflag.Int64Var(&timeout, "timeout", 300, "Timeout to perform release operations (install, upgrade, rollback, delete)")
+	pflag.Int64Var(&timeout, "timeout", 300, "Timeout to perform release operations (install, upgrade, rollback, delete, test)")
 	pflag.StringVar(&additionalClustersConfigPath, "additional-clusters-config-path", "", "Configuration for additional clusters")
+	pflag.StringVar(&auditLogPath, "audit-log-path", "", "File to append the audit log of release and app repository changes to, or \"-\" for stdout")
+	pflag.StringVar(&auditWebhookURL, "audit-webhook-url", "", "URL to post the audit log of release and app repository changes to")
+	pflag.StringVar(&chartCacheDir, "chart-cache-dir", "", "Directory to persist the cache of chart tarballs to, kept in memory only if empty")
+	pflag.Int64Var(&chartCacheSize, "chart-cache-size", chartUtils.DefaultCacheOptions.TarballCacheSize, "Maximum size in bytes of the cache of chart tarballs")
+	pflag.Int64Var(&indexCacheSize, "index-cache-size", chartUtils.DefaultCacheOptions.IndexCacheSize, "Maximum size in bytes of the cache of parsed repository indexes")
+	pflag.StringVar(&podSpecPathsConfigPath, "pod-spec-paths-config-path", "", "Configuration of the paths of the pod spec of custom resources embedding pods")
+	pflag.StringVar(&policyConfigMap, "policy-configmap", "", "Name of the ConfigMap of the Kubeapps namespace with the policy releases must comply with")
+	pflag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve the Prometheus metrics on, e.g. \":9090\", disabled if empty")
+	pflag.StringVar(&releaseMetadataConfigPath, "release-metadata-config-path", "", "Configuration of the labels and annotations injected in the objects of the releases of each namespace")
 }
 
 func main() {
@@ -70,11 +97,56 @@ func main() {
 		defer cleanupCAFiles()
 	}
 
+	err := chartUtils.ConfigureCache(chartUtils.CacheOptions{
+		IndexCacheSize:   indexCacheSize,
+		TarballCacheSize: chartCacheSize,
+		Dir:              chartCacheDir,
+	})
+	if err != nil {
+		log.Fatalf("Unable to setup the chart cache: %+v", err)
+	}
+
+	auditLogger, err := newAuditLogger(additionalClusters)
+	if err != nil {
+		log.Fatalf("Unable to setup the audit log: %+v", err)
+	}
+
+	policyLoader, err := newPolicyLoader(kubeappsNamespace)
+	if err != nil {
+		log.Fatalf("Unable to setup the release policy: %+v", err)
+	}
+
+	var releaseMetadata *agent.ReleaseMetadataConfig
+	if releaseMetadataConfigPath != "" {
+		releaseMetadata, err = parseReleaseMetadataConfig(releaseMetadataConfigPath)
+		if err != nil {
+			log.Fatalf("Unable to parse the release metadata config: %+v", err)
+		}
+	}
+
+	if podSpecPathsConfigPath != "" {
+		podSpecPaths, err := parsePodSpecPaths(podSpecPathsConfigPath)
+		if err != nil {
+			log.Fatalf("Unable to parse the pod spec paths config: %+v", err)
+		}
+		agent.SetPodSpecPaths(podSpecPaths)
+	}
+
+	users, err := audit.NewTokenReviewResolver(additionalClusters)
+	if err != nil {
+		log.Fatalf("Unable to setup the resolution of users: %+v", err)
+	}
+
 	options := handler.Options{
 		ListLimit:          listLimit,
 		Timeout:            timeout,
 		KubeappsNamespace:  kubeappsNamespace,
 		AdditionalClusters: additionalClusters,
+		Audit:              auditLogger,
+		Policy:             policyLoader,
+		ReleaseMetadata:    releaseMetadata,
+		Users:              users,
+		DigestResolver:     agent.NewRegistryDigestResolver(&http.Client{Timeout: digestResolutionTimeout}),
 	}
 
 	storageForDriver := agent.StorageForSecrets
@@ -103,16 +175,26 @@ func main() {
 	addRoute("POST", "/namespaces/{namespace}/releases", handler.CreateRelease)
 	addRoute("GET", "/namespaces/{namespace}/releases/{releaseName}", handler.GetRelease)
 	addRoute("PUT", "/namespaces/{namespace}/releases/{releaseName}", handler.OperateRelease)
+	addRoute("GET", "/namespaces/{namespace}/releases/{releaseName}/status", handler.GetReleaseStatus)
+	addRoute("GET", "/namespaces/{namespace}/releases/{releaseName}/watch", handler.WatchRelease)
+	addRoute("GET", "/namespaces/{namespace}/releases/{releaseName}/history", handler.GetReleaseHistory)
+	addRoute("GET", "/namespaces/{namespace}/releases/{releaseName}/diff", handler.GetReleaseDiff)
+	addRoute("POST", "/namespaces/{namespace}/releases/{releaseName}/values/preview", handler.PreviewValues)
 	addRoute("DELETE", "/namespaces/{namespace}/releases/{releaseName}", handler.DeleteRelease)
 	addRoute("GET", "/clusters/{cluster}/releases", handler.ListAllReleases)
 	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases", handler.ListReleases)
 	addRoute("POST", "/clusters/{cluster}/namespaces/{namespace}/releases", handler.CreateRelease)
 	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}", handler.GetRelease)
 	addRoute("PUT", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}", handler.OperateRelease)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/status", handler.GetReleaseStatus)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/watch", handler.WatchRelease)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/history", handler.GetReleaseHistory)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/diff", handler.GetReleaseDiff)
+	addRoute("POST", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/values/preview", handler.PreviewValues)
 	addRoute("DELETE", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}", handler.DeleteRelease)
 
 	// Backend routes unrelated to kubeops functionality.
-	err := backendHandlers.SetupDefaultRoutes(r.PathPrefix("/backend/v1").Subrouter(), additionalClusters)
+	err = backendHandlers.SetupDefaultRoutes(r.PathPrefix("/backend/v1").Subrouter(), additionalClusters, auditLogger)
 	if err != nil {
 		log.Fatalf("Unable to setup backend routes: %+v", err)
 	}
@@ -138,7 +220,12 @@ func main() {
 		negroni.Wrap(http.StripPrefix(assetsvcPrefix, assetsvcProxy)),
 	))
 
+	clusters := []string{}
+	for name := range additionalClusters {
+		clusters = append(clusters, name)
+	}
 	n := negroni.Classic()
+	n.Use(metrics.NewMiddleware(r, clusters...))
 	n.UseHandler(r)
 
 	port := os.Getenv("PORT")
@@ -147,6 +234,11 @@ func main() {
 	}
 	addr := ":" + port
 
+	// Metrics are served separately from the API, if enabled.
+	if metricsAddr != "" {
+		go metrics.Serve(metricsAddr)
+	}
+
 	srv := &http.Server{
 		Addr:    addr,
 		Handler: n,
@@ -178,6 +270,63 @@ func main() {
 	os.Exit(0)
 }
 
+// newAuditLogger returns a logger for the configured audit log sinks, or nil
+// if the audit log is disabled.
+func newAuditLogger(additionalClusters kube.AdditionalClustersConfig) (*audit.Logger, error) {
+	var sinks []audit.Sink
+	if auditLogPath != "" {
+		sink, err := audit.NewFileSink(auditLogPath)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if auditWebhookURL != "" {
+		sinks = append(sinks, audit.NewWebhookSink(auditWebhookURL))
+	}
+	if len(sinks) == 0 {
+		return nil, nil
+	}
+	resolver, err := audit.NewTokenReviewResolver(additionalClusters)
+	if err != nil {
+		return nil, err
+	}
+	return audit.NewLogger(resolver, sinks...), nil
+}
+
+// newPolicyLoader returns a loader of the configured release policy, or nil
+// if releases are not evaluated against a policy.
+func newPolicyLoader(kubeappsNamespace string) (*agent.PolicyLoader, error) {
+	if policyConfigMap == "" {
+		return nil, nil
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return agent.NewPolicyLoader(clientset, kubeappsNamespace, policyConfigMap), nil
+}
+
+func parseReleaseMetadataConfig(configPath string) (*agent.ReleaseMetadataConfig, error) {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return agent.ParseReleaseMetadataConfig(content)
+}
+
+func parsePodSpecPaths(configPath string) (agent.PodSpecPaths, error) {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return agent.ParsePodSpecPaths(content)
+}
+
 func parseAdditionalClusterConfig(configPath, caFilesPrefix string) (kube.AdditionalClustersConfig, func(), error) {
 	caFilesDir, err := ioutil.TempDir(caFilesPrefix, "")
 	if err != nil {
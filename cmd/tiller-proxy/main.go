@@ -35,6 +35,7 @@ import (
 	"github.com/kubeapps/kubeapps/pkg/handlerutil"
 	backendHandlers "github.com/kubeapps/kubeapps/pkg/http-handler"
 	"github.com/kubeapps/kubeapps/pkg/kube"
+	"github.com/kubeapps/kubeapps/pkg/metrics"
 	tillerProxy "github.com/kubeapps/kubeapps/pkg/proxy"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
@@ -64,6 +65,7 @@ var (
 	tlsKeyDefault    = fmt.Sprintf("%s/tls.key", os.Getenv("HELM_HOME"))
 
 	assetsvcURL string
+	metricsAddr string
 )
 
 func init() {
@@ -75,6 +77,7 @@ func init() {
 	pflag.BoolVar(&tlsVerify, "tls-verify", false, "enable TLS for request and verify remote")
 	pflag.BoolVar(&tlsEnable, "tls", false, "enable TLS for request")
 	pflag.IntVar(&listLimit, "list-max", 256, "maximum number of releases to fetch")
+	pflag.StringVar(&metricsAddr, "metrics-addr", "", "Address to serve the Prometheus metrics on, e.g. \":9090\", disabled if empty")
 	pflag.StringVar(&userAgentComment, "user-agent-comment", "", "UserAgent comment used during outbound requests")
 	// Default timeout from https://github.com/helm/helm/blob/b0b0accdfc84e154b3d48ec334cd5b4f9b345667/cmd/helm/install.go#L216
 	pflag.Int64Var(&timeout, "timeout", 300, "Timeout to perform release operations (install, upgrade, rollback, delete)")
@@ -172,7 +175,7 @@ func main() {
 	apiv1.Methods("DELETE").Path("/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}").Handler(handlerutil.WithParams(h.DeleteRelease))
 
 	// Backend routes unrelated to tiller-proxy functionality.
-	err = backendHandlers.SetupDefaultRoutes(r.PathPrefix("/backend/v1").Subrouter(), kube.AdditionalClustersConfig{})
+	err = backendHandlers.SetupDefaultRoutes(r.PathPrefix("/backend/v1").Subrouter(), kube.AdditionalClustersConfig{}, nil)
 	if err != nil {
 		log.Fatalf("Unable to setup backend routes: %+v", err)
 	}
@@ -197,6 +200,7 @@ func main() {
 	))
 
 	n := negroni.Classic()
+	n.Use(metrics.NewMiddleware(r))
 	n.UseHandler(r)
 
 	port := os.Getenv("PORT")
@@ -205,6 +209,11 @@ func main() {
 	}
 	addr := ":" + port
 
+	// Metrics are served separately from the API, if enabled.
+	if metricsAddr != "" {
+		go metrics.Serve(metricsAddr)
+	}
+
 	srv := &http.Server{
 		Addr:    addr,
 		Handler: n,
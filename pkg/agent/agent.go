@@ -1,9 +1,11 @@
 package agent
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/kubeapps/kubeapps/pkg/chart/helm3to2"
 	"github.com/kubeapps/kubeapps/pkg/proxy"
@@ -12,9 +14,12 @@ import (
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/postrender"
 	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
 	"helm.sh/helm/v3/pkg/storage"
 	"helm.sh/helm/v3/pkg/storage/driver"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -69,7 +74,13 @@ func ListReleases(actionConfig *action.Configuration, namespace string, listLimi
 }
 
 // CreateRelease creates a release.
-func CreateRelease(actionConfig *action.Configuration, name, namespace, valueString string, ch *chart.Chart, registrySecrets map[string]string) (*release.Release, error) {
+func CreateRelease(actionConfig *action.Configuration, name, namespace, valueString string, ch *chart.Chart, postRenderer postrender.PostRenderer) (*release.Release, error) {
+	rel, err := createRelease(actionConfig, name, namespace, valueString, ch, postRenderer)
+	recordAction(actionInstall, err)
+	return rel, err
+}
+
+func createRelease(actionConfig *action.Configuration, name, namespace, valueString string, ch *chart.Chart, postRenderer postrender.PostRenderer) (*release.Release, error) {
 	// Check if the release already exists
 	_, err := GetRelease(actionConfig, name)
 	if err == nil {
@@ -78,18 +89,19 @@ func CreateRelease(actionConfig *action.Configuration, name, namespace, valueStr
 	cmd := action.NewInstall(actionConfig)
 	cmd.ReleaseName = name
 	cmd.Namespace = namespace
-	cmd.PostRenderer, err = NewDockerSecretsPostRenderer(registrySecrets)
-	if err != nil {
-		return nil, err
-	}
+	cmd.PostRenderer = postRenderer
 	values, err := getValues([]byte(valueString))
 	if err != nil {
 		return nil, err
 	}
 	release, err := cmd.Run(ch, values)
+	if violationErr := policyViolationError(err); violationErr != nil {
+		// Nothing is installed when the manifests violate the policy.
+		return nil, violationErr
+	}
 	if err != nil {
 		// Simulate the Atomic flag and delete the release if failed
-		errDelete := DeleteRelease(actionConfig, name, false)
+		errDelete := deleteRelease(actionConfig, name, false)
 		if errDelete != nil && !strings.Contains(errDelete.Error(), "release: not found") {
 			return nil, fmt.Errorf("Release %q failed: %v. Unable to delete failed release: %v", name, err, errDelete)
 		}
@@ -99,7 +111,13 @@ func CreateRelease(actionConfig *action.Configuration, name, namespace, valueStr
 }
 
 // UpgradeRelease upgrades a release.
-func UpgradeRelease(actionConfig *action.Configuration, name, valuesYaml string, ch *chart.Chart, registrySecrets map[string]string) (*release.Release, error) {
+func UpgradeRelease(actionConfig *action.Configuration, name, valuesYaml string, ch *chart.Chart, postRenderer postrender.PostRenderer) (*release.Release, error) {
+	rel, err := upgradeRelease(actionConfig, name, valuesYaml, ch, postRenderer)
+	recordAction(actionUpgrade, err)
+	return rel, err
+}
+
+func upgradeRelease(actionConfig *action.Configuration, name, valuesYaml string, ch *chart.Chart, postRenderer postrender.PostRenderer) (*release.Release, error) {
 	// Check if the release already exists:
 	_, err := GetRelease(actionConfig, name)
 	if err != nil {
@@ -108,27 +126,102 @@ func UpgradeRelease(actionConfig *action.Configuration, name, valuesYaml string,
 	log.Printf("Upgrading release %s", name)
 	cmd := action.NewUpgrade(actionConfig)
 
-	cmd.PostRenderer, err = NewDockerSecretsPostRenderer(registrySecrets)
-	if err != nil {
-		return nil, err
-	}
+	cmd.PostRenderer = postRenderer
 	values, err := chartutil.ReadValues([]byte(valuesYaml))
 	if err != nil {
 		return nil, fmt.Errorf("Unable to upgrade the release because values could not be parsed: %v", err)
 	}
 	res, err := cmd.Run(name, ch, values)
+	if violationErr := policyViolationError(err); violationErr != nil {
+		return nil, violationErr
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Unable to upgrade the release: %v", err)
 	}
 	return res, nil
 }
 
+// DryRunResult is the outcome of installing or upgrading a release without
+// applying any change to the cluster.
+type DryRunResult struct {
+	Manifest string `json:"manifest"`
+	Hooks    string `json:"hooks"`
+	Notes    string `json:"notes"`
+	// Resources contains, for upgrades, the objects which differ from the
+	// manifest of the current release.
+	Resources []ResourceDiff `json:"resources,omitempty"`
+}
+
+// DryRunCreateRelease renders a release as CreateRelease would install it,
+// without contacting the cluster other than to check that the release does
+// not exist yet.
+func DryRunCreateRelease(actionConfig *action.Configuration, name, namespace, valueString string, ch *chart.Chart, postRenderer postrender.PostRenderer) (*DryRunResult, error) {
+	_, err := GetRelease(actionConfig, name)
+	if err == nil {
+		return nil, fmt.Errorf("release %s already exists", name)
+	}
+	if !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, err
+	}
+	// A client-only install replaces the clients of its configuration with
+	// fakes so work with a copy to leave the given one untouched.
+	dryRunConfig := *actionConfig
+	cmd := action.NewInstall(&dryRunConfig)
+	cmd.ReleaseName = name
+	cmd.Namespace = namespace
+	cmd.DryRun = true
+	cmd.ClientOnly = true
+	cmd.PostRenderer = postRenderer
+	values, err := getValues([]byte(valueString))
+	if err != nil {
+		return nil, err
+	}
+	rel, err := cmd.Run(ch, values)
+	if violationErr := policyViolationError(err); violationErr != nil {
+		return nil, violationErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to render the release: %v", err)
+	}
+	return dryRunResultFromRelease(rel), nil
+}
+
+// DryRunUpgradeRelease renders a release as UpgradeRelease would upgrade it
+// and returns the objects which would change, without updating the cluster.
+func DryRunUpgradeRelease(actionConfig *action.Configuration, name, valuesYaml string, ch *chart.Chart, postRenderer postrender.PostRenderer) (*DryRunResult, error) {
+	current, err := GetRelease(actionConfig, name)
+	if err != nil {
+		return nil, err
+	}
+	cmd := action.NewUpgrade(actionConfig)
+	cmd.DryRun = true
+	cmd.PostRenderer = postRenderer
+	values, err := chartutil.ReadValues([]byte(valuesYaml))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to upgrade the release because values could not be parsed: %v", err)
+	}
+	rel, err := cmd.Run(name, ch, values)
+	if violationErr := policyViolationError(err); violationErr != nil {
+		return nil, violationErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Unable to render the release: %v", err)
+	}
+	result := dryRunResultFromRelease(rel)
+	result.Resources, err = DiffManifests(current.Manifest, rel.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // RollbackRelease rolls back a release to the specified revision.
 func RollbackRelease(actionConfig *action.Configuration, releaseName string, revision int) (*release.Release, error) {
 	log.Printf("Rolling back %s to revision %d.", releaseName, revision)
 	rollback := action.NewRollback(actionConfig)
 	rollback.Version = revision
 	err := rollback.Run(releaseName)
+	recordAction(actionRollback, err)
 	if err != nil {
 		return nil, err
 	}
@@ -138,6 +231,84 @@ func RollbackRelease(actionConfig *action.Configuration, releaseName string, rev
 	return GetRelease(actionConfig, releaseName)
 }
 
+// TestResult is the outcome of running the tests of a release.
+type TestResult struct {
+	Passed bool             `json:"passed"`
+	Tests  []HookTestResult `json:"tests"`
+}
+
+// HookTestResult is the outcome of a single test hook.
+type HookTestResult struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Phase  string `json:"phase"`
+	Passed bool   `json:"passed"`
+	Logs   string `json:"logs,omitempty"`
+}
+
+// PodLogs returns the logs of a pod.
+type PodLogs func(namespace, name string) ([]byte, error)
+
+// PodLogsForClientset returns the PodLogs reading the logs with the given
+// clientset.
+func PodLogsForClientset(clientset kubernetes.Interface) PodLogs {
+	return func(namespace, name string) ([]byte, error) {
+		return clientset.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{}).Do(context.TODO()).Raw()
+	}
+}
+
+// TestRelease runs the test hooks of a release and returns their status
+// along with the logs of the test pods.
+func TestRelease(actionConfig *action.Configuration, podLogs PodLogs, name string, timeout time.Duration) (*TestResult, error) {
+	log.Printf("Running tests for release %s", name)
+	cmd := action.NewReleaseTesting(actionConfig)
+	cmd.Timeout = timeout
+	rel, runErr := cmd.Run(name)
+	if rel == nil {
+		return nil, runErr
+	}
+
+	result := &TestResult{Passed: true, Tests: []HookTestResult{}}
+	for _, h := range rel.Hooks {
+		if !isTestHook(h) {
+			continue
+		}
+		test := HookTestResult{
+			Name:   h.Name,
+			Kind:   h.Kind,
+			Phase:  h.LastRun.Phase.String(),
+			Passed: h.LastRun.Phase == release.HookPhaseSucceeded,
+		}
+		if !test.Passed {
+			result.Passed = false
+		}
+		if h.Kind == "Pod" && !h.LastRun.StartedAt.IsZero() {
+			logs, err := podLogs(rel.Namespace, h.Name)
+			if err != nil {
+				// The pod may have been deleted by its hook deletion policy.
+				log.Infof("Unable to get the logs of test pod %s: %v", h.Name, err)
+			} else {
+				test.Logs = string(logs)
+			}
+		}
+		result.Tests = append(result.Tests, test)
+	}
+	// A failing test is reported in the result, any other error is returned.
+	if runErr != nil && result.Passed {
+		return nil, runErr
+	}
+	return result, nil
+}
+
+func isTestHook(h *release.Hook) bool {
+	for _, e := range h.Events {
+		if e == release.HookTest {
+			return true
+		}
+	}
+	return false
+}
+
 // GetRelease returns the info of a release.
 func GetRelease(actionConfig *action.Configuration, name string) (*release.Release, error) {
 	// Namespace is already known by the RESTClientGetter.
@@ -149,8 +320,48 @@ func GetRelease(actionConfig *action.Configuration, name string) (*release.Relea
 	return release, nil
 }
 
+// ReleaseRevision summarizes a single revision of a release.
+type ReleaseRevision struct {
+	Revision     int       `json:"revision"`
+	Status       string    `json:"status"`
+	Chart        string    `json:"chart"`
+	ChartVersion string    `json:"chartVersion"`
+	AppVersion   string    `json:"appVersion"`
+	Updated      time.Time `json:"updated"`
+	Description  string    `json:"description"`
+}
+
+// GetReleaseHistory returns the revisions of a release, oldest first.
+func GetReleaseHistory(actionConfig *action.Configuration, name string) ([]ReleaseRevision, error) {
+	// Namespace is already known by the RESTClientGetter.
+	cmd := action.NewHistory(actionConfig)
+	releases, err := cmd.Run(name)
+	if err != nil {
+		return nil, err
+	}
+	releaseutil.SortByRevision(releases)
+	history := make([]ReleaseRevision, 0, len(releases))
+	for _, r := range releases {
+		history = append(history, revisionFromRelease(r))
+	}
+	return history, nil
+}
+
+// GetReleaseRevision returns a specific revision of a release.
+func GetReleaseRevision(actionConfig *action.Configuration, name string, revision int) (*release.Release, error) {
+	return actionConfig.Releases.Get(name, revision)
+}
+
 // DeleteRelease deletes a release.
 func DeleteRelease(actionConfig *action.Configuration, name string, keepHistory bool) error {
+	err := deleteRelease(actionConfig, name, keepHistory)
+	recordAction(actionDelete, err)
+	return err
+}
+
+// deleteRelease deletes a release without recording it in the metrics, as
+// when cleaning up a failed install.
+func deleteRelease(actionConfig *action.Configuration, name string, keepHistory bool) error {
 	// Namespace is already known by the RESTClientGetter.
 	cmd := action.NewUninstall(actionConfig)
 	cmd.KeepHistory = keepHistory
@@ -229,3 +440,33 @@ func appOverviewFromRelease(r *release.Release) proxy.AppOverview {
 		ChartMetadata: *r2Metadata,
 	}
 }
+
+func dryRunResultFromRelease(r *release.Release) *DryRunResult {
+	var hooks strings.Builder
+	for _, h := range r.Hooks {
+		fmt.Fprintf(&hooks, "---\n# Source: %s\n%s\n", h.Path, h.Manifest)
+	}
+	result := &DryRunResult{
+		Manifest: r.Manifest,
+		Hooks:    hooks.String(),
+	}
+	if r.Info != nil {
+		result.Notes = r.Info.Notes
+	}
+	return result
+}
+
+func revisionFromRelease(r *release.Release) ReleaseRevision {
+	revision := ReleaseRevision{Revision: r.Version}
+	if r.Info != nil {
+		revision.Status = r.Info.Status.String()
+		revision.Updated = r.Info.LastDeployed.Time
+		revision.Description = r.Info.Description
+	}
+	if r.Chart != nil && r.Chart.Metadata != nil {
+		revision.Chart = r.Chart.Metadata.Name
+		revision.ChartVersion = r.Chart.Metadata.Version
+		revision.AppVersion = r.Chart.Metadata.AppVersion
+	}
+	return revision
+}
@@ -1,9 +1,12 @@
 package agent
 
 import (
+	"errors"
 	"io/ioutil"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	kubechart "github.com/kubeapps/kubeapps/pkg/chart"
 	chartFake "github.com/kubeapps/kubeapps/pkg/chart/fake"
@@ -708,3 +711,268 @@ func TestUpgradeRelease(t *testing.T) {
 		})
 	}
 }
+
+func TestGetReleaseHistory(t *testing.T) {
+	testCases := []struct {
+		name     string
+		releases []releaseStub
+		release  string
+		expected []ReleaseRevision
+		err      error
+	}{
+		{
+			name: "returns the revisions of a release, oldest first",
+			releases: []releaseStub{
+				releaseStub{"airwatch", "default", 2, "1.1.0", release.StatusDeployed},
+				releaseStub{"otherrelease", "default", 1, "1.0.0", release.StatusDeployed},
+				releaseStub{"airwatch", "default", 1, "1.0.0", release.StatusSuperseded},
+			},
+			release: "airwatch",
+			expected: []ReleaseRevision{
+				{Revision: 1, Status: "superseded", ChartVersion: "1.0.0"},
+				{Revision: 2, Status: "deployed", ChartVersion: "1.1.0"},
+			},
+		},
+		{
+			name: "errors if the release does not exist",
+			releases: []releaseStub{
+				releaseStub{"otherrelease", "default", 1, "1.0.0", release.StatusDeployed},
+			},
+			release: "airwatch",
+			err:     driver.ErrReleaseNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newActionConfigFixture(t)
+			makeReleases(t, cfg, tc.releases)
+
+			history, err := GetReleaseHistory(cfg, tc.release)
+			if got, want := err, tc.err; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+			if got, want := history, tc.expected; !cmp.Equal(got, want) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+// chartWithTemplates returns a chart rendering a ConfigMap with the given
+// number of replicas and some notes.
+func chartWithTemplates(replicas string) *chart.Chart {
+	return &chart.Chart{
+		Metadata: &chart.Metadata{Name: "mychart", Version: "1.0.0"},
+		Templates: []*chart.File{
+			{
+				Name: "templates/configmap.yaml",
+				Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}-config\ndata:\n  replicas: \"" + replicas + "\"\n"),
+			},
+			{
+				Name: "templates/NOTES.txt",
+				Data: []byte("Thanks for installing {{ .Release.Name }}"),
+			},
+		},
+	}
+}
+
+// failingQueryDriver is a memory driver whose queries fail, as when the
+// storage cannot be read.
+type failingQueryDriver struct {
+	*driver.Memory
+}
+
+func (d failingQueryDriver) Query(labels map[string]string) ([]*release.Release, error) {
+	return nil, errors.New(`secrets is forbidden: User "foo" cannot list resource "secrets"`)
+}
+
+func TestDryRunCreateRelease(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		existingReleases []releaseStub
+		failingStorage   bool
+		shouldFail       bool
+	}{
+		{
+			desc: "renders a new release",
+			existingReleases: []releaseStub{
+				releaseStub{"otherchart", "default", 1, "1.0.0", release.StatusDeployed},
+			},
+		},
+		{
+			desc: "fails with an existing name",
+			existingReleases: []releaseStub{
+				releaseStub{"myrls", "default", 1, "1.0.0", release.StatusDeployed},
+			},
+			shouldFail: true,
+		},
+		{
+			desc:           "fails if the existing releases cannot be read",
+			failingStorage: true,
+			shouldFail:     true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			actionConfig := newActionConfigFixture(t)
+			makeReleases(t, actionConfig, tc.existingReleases)
+			if tc.failingStorage {
+				actionConfig.Releases = storage.Init(failingQueryDriver{driver.NewMemory()})
+			}
+
+			result, err := DryRunCreateRelease(actionConfig, "myrls", "default", "", chartWithTemplates("1"), nil)
+			if got, want := err != nil, tc.shouldFail; got != want {
+				t.Fatalf("got: %v, want: %v (%v)", got, want, err)
+			}
+			if !tc.shouldFail {
+				if !strings.Contains(result.Manifest, "name: myrls-config") {
+					t.Errorf("expected the rendered manifest, got: %q", result.Manifest)
+				}
+				if got, want := result.Notes, "Thanks for installing myrls"; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			}
+
+			// Nothing is installed.
+			rlss, err := actionConfig.Releases.ListReleases()
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := len(rlss), len(tc.existingReleases); got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
+
+func TestDryRunUpgradeRelease(t *testing.T) {
+	actionConfig := newActionConfigFixture(t)
+	current, err := DryRunCreateRelease(actionConfig, "myrls", "default", "", chartWithTemplates("1"), nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	err = actionConfig.Releases.Create(&release.Release{
+		Name:      "myrls",
+		Namespace: "default",
+		Version:   1,
+		Info:      &release.Info{Status: release.StatusDeployed},
+		Chart:     chartWithTemplates("1"),
+		Manifest:  current.Manifest,
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	result, err := DryRunUpgradeRelease(actionConfig, "myrls", "", chartWithTemplates("2"), nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := len(result.Resources), 1; got != want {
+		t.Fatalf("got: %d, want: %d", got, want)
+	}
+	if got, want := result.Resources[0].Name, "myrls-config"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := result.Resources[0].Change, ResourceModified; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if !strings.Contains(result.Resources[0].Diff, "+  replicas: \"2\"") {
+		t.Errorf("expected the diff to contain the new replicas, got: %q", result.Resources[0].Diff)
+	}
+
+	// No new revision is created.
+	_, err = actionConfig.Releases.Get("myrls", 2)
+	if got, want := err, driver.ErrReleaseNotFound; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestTestRelease(t *testing.T) {
+	testHook := release.Hook{
+		Name:     "myrls-test",
+		Kind:     "Pod",
+		Path:     "mychart/templates/tests/test.yaml",
+		Manifest: "apiVersion: v1\nkind: Pod\nmetadata:\n  name: myrls-test\n",
+		Events:   []release.HookEvent{release.HookTest},
+	}
+	installHook := release.Hook{
+		Name:     "myrls-job",
+		Kind:     "Job",
+		Path:     "mychart/templates/job.yaml",
+		Manifest: "apiVersion: batch/v1\nkind: Job\nmetadata:\n  name: myrls-job\n",
+		Events:   []release.HookEvent{release.HookPostInstall},
+	}
+	testCases := []struct {
+		desc       string
+		release    string
+		watchError error
+		expected   *TestResult
+		shouldFail bool
+	}{
+		{
+			desc:    "reports passing tests with their logs",
+			release: "myrls",
+			expected: &TestResult{
+				Passed: true,
+				Tests: []HookTestResult{
+					{Name: "myrls-test", Kind: "Pod", Phase: "Succeeded", Passed: true, Logs: "fake logs"},
+				},
+			},
+		},
+		{
+			desc:       "reports failing tests",
+			release:    "myrls",
+			watchError: errors.New("pod myrls-test failed"),
+			expected: &TestResult{
+				Passed: false,
+				Tests: []HookTestResult{
+					{Name: "myrls-test", Kind: "Pod", Phase: "Failed", Passed: false, Logs: "fake logs"},
+				},
+			},
+		},
+		{
+			desc:       "errors if the release does not exist",
+			release:    "otherrls",
+			shouldFail: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			actionConfig := newActionConfigFixture(t)
+			actionConfig.KubeClient = &kubefake.FailingKubeClient{
+				PrintingKubeClient:   kubefake.PrintingKubeClient{Out: ioutil.Discard},
+				WatchUntilReadyError: tc.watchError,
+			}
+			// Copy the hooks since running the tests updates them.
+			th, ih := testHook, installHook
+			err := actionConfig.Releases.Create(&release.Release{
+				Name:      "myrls",
+				Namespace: "default",
+				Version:   1,
+				Info:      &release.Info{Status: release.StatusDeployed},
+				Chart:     chartWithTemplates("1"),
+				Hooks:     []*release.Hook{&th, &ih},
+			})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			podLogs := func(namespace, name string) ([]byte, error) {
+				if namespace != "default" || name != "myrls-test" {
+					return nil, errors.New("pod not found")
+				}
+				return []byte("fake logs"), nil
+			}
+			result, err := TestRelease(actionConfig, podLogs, tc.release, time.Minute)
+			if got, want := err != nil, tc.shouldFail; got != want {
+				t.Fatalf("got: %v, want: %v (%v)", got, want, err)
+			}
+			if got, want := result, tc.expected; !cmp.Equal(got, want) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"sigs.k8s.io/yaml"
+)
+
+// Kinds of change reported for a resource in a manifest diff.
+const (
+	ResourceAdded    = "added"
+	ResourceRemoved  = "removed"
+	ResourceModified = "modified"
+)
+
+// Number of unchanged lines shown around each change.
+const diffContextLength = 3
+
+// ResourceDiff is the difference of a single Kubernetes object between two manifests.
+type ResourceDiff struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Change    string `json:"change"`
+	Diff      string `json:"diff"`
+}
+
+// ReleaseDiff is the difference between two revisions of a release.
+type ReleaseDiff struct {
+	From      int            `json:"from"`
+	To        int            `json:"to"`
+	Resources []ResourceDiff `json:"resources"`
+	Values    string         `json:"values"`
+}
+
+type manifestResource struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+}
+
+// DiffReleases returns the manifest and values differences between two revisions of a release.
+func DiffReleases(from, to *release.Release) (*ReleaseDiff, error) {
+	resources, err := DiffManifests(from.Manifest, to.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	fromValues, err := yaml.Marshal(from.Config)
+	if err != nil {
+		return nil, err
+	}
+	toValues, err := yaml.Marshal(to.Config)
+	if err != nil {
+		return nil, err
+	}
+	values, err := unifiedDiff(
+		fmt.Sprintf("values (revision %d)", from.Version), string(fromValues),
+		fmt.Sprintf("values (revision %d)", to.Version), string(toValues),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &ReleaseDiff{
+		From:      from.Version,
+		To:        to.Version,
+		Resources: resources,
+		Values:    values,
+	}, nil
+}
+
+// DiffManifests returns, for every object which differs between the two
+// given multi-document manifests, a unified diff of its YAML definition.
+// Objects are matched by kind, namespace and name.
+func DiffManifests(fromManifest, toManifest string) ([]ResourceDiff, error) {
+	fromResources, err := splitManifest(fromManifest)
+	if err != nil {
+		return nil, err
+	}
+	toResources, err := splitManifest(toManifest)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for key := range fromResources {
+		keys = append(keys, key)
+	}
+	for key := range toResources {
+		if _, ok := fromResources[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	diffs := []ResourceDiff{}
+	for _, key := range keys {
+		fromResource, inFrom := fromResources[key]
+		toResource, inTo := toResources[key]
+		var change string
+		switch {
+		case !inFrom:
+			change = ResourceAdded
+		case !inTo:
+			change = ResourceRemoved
+		case fromResource.content != toResource.content:
+			change = ResourceModified
+		default:
+			continue
+		}
+		r := toResource
+		if !inTo {
+			r = fromResource
+		}
+		resourceDiff := ResourceDiff{Kind: r.kind, Namespace: r.namespace, Name: r.name, Change: change}
+		resourceDiff.Diff, err = unifiedDiff(key, fromResource.content, key, toResource.content)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, resourceDiff)
+	}
+	return diffs, nil
+}
+
+type splitResource struct {
+	kind      string
+	namespace string
+	name      string
+	content   string
+}
+
+// splitManifest indexes the objects of a multi-document manifest by
+// "kind/namespace/name".
+func splitManifest(manifest string) (map[string]splitResource, error) {
+	resources := map[string]splitResource{}
+	for _, content := range releaseutil.SplitManifests(manifest) {
+		var r manifestResource
+		if err := yaml.Unmarshal([]byte(content), &r); err != nil {
+			return nil, fmt.Errorf("unable to parse manifest: %v", err)
+		}
+		if r.Kind == "" {
+			// Empty document, e.g. a template which renders only comments.
+			continue
+		}
+		key := strings.Join([]string{r.Kind, r.Metadata.Namespace, r.Metadata.Name}, "/")
+		resources[key] = splitResource{
+			kind:      r.Kind,
+			namespace: r.Metadata.Namespace,
+			name:      r.Metadata.Name,
+			content:   strings.TrimSpace(content) + "\n",
+		}
+	}
+	return resources, nil
+}
+
+func unifiedDiff(fromName, from, toName, to string) (string, error) {
+	if from == to {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(from),
+		B:        splitLines(to),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  diffContextLength,
+	})
+}
+
+// splitLines splits a string into lines keeping their line endings. Unlike
+// difflib.SplitLines, it does not add an empty line at the end of the text.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,178 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+const (
+	configMapV1 = `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo-config
+data:
+  replicas: "1"
+`
+	configMapV2 = `---
+# Source: foo/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo-config
+data:
+  replicas: "2"
+`
+	service = `---
+# Source: foo/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: default
+`
+	secret = `---
+# Source: foo/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: foo
+`
+)
+
+func TestDiffManifests(t *testing.T) {
+	testCases := []struct {
+		name     string
+		from     string
+		to       string
+		expected []ResourceDiff
+	}{
+		{
+			name:     "returns no differences for identical manifests",
+			from:     configMapV1 + service,
+			to:       configMapV1 + service,
+			expected: []ResourceDiff{},
+		},
+		{
+			name: "returns a modified resource",
+			from: configMapV1 + service,
+			to:   service + configMapV2,
+			expected: []ResourceDiff{
+				{
+					Kind:   "ConfigMap",
+					Name:   "foo-config",
+					Change: ResourceModified,
+					Diff: `--- ConfigMap//foo-config
++++ ConfigMap//foo-config
+@@ -4,4 +4,4 @@
+ metadata:
+   name: foo-config
+ data:
+-  replicas: "1"
++  replicas: "2"
+`,
+				},
+			},
+		},
+		{
+			name: "returns added and removed resources",
+			from: configMapV1 + secret,
+			to:   configMapV1 + service,
+			expected: []ResourceDiff{
+				{
+					Kind:   "Secret",
+					Name:   "foo",
+					Change: ResourceRemoved,
+					Diff: `--- Secret//foo
++++ Secret//foo
+@@ -1,5 +0,0 @@
+-# Source: foo/templates/secret.yaml
+-apiVersion: v1
+-kind: Secret
+-metadata:
+-  name: foo
+`,
+				},
+				{
+					Kind:      "Service",
+					Namespace: "default",
+					Name:      "foo",
+					Change:    ResourceAdded,
+					Diff: `--- Service/default/foo
++++ Service/default/foo
+@@ -0,0 +1,6 @@
++# Source: foo/templates/service.yaml
++apiVersion: v1
++kind: Service
++metadata:
++  name: foo
++  namespace: default
+`,
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			diffs, err := DiffManifests(tc.from, tc.to)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := diffs, tc.expected; !cmp.Equal(got, want) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestDiffReleases(t *testing.T) {
+	from := &release.Release{
+		Version:  1,
+		Manifest: configMapV1,
+		Config:   map[string]interface{}{"replicas": 1},
+	}
+	to := &release.Release{
+		Version:  3,
+		Manifest: configMapV1,
+		Config:   map[string]interface{}{"replicas": 2},
+	}
+
+	diff, err := DiffReleases(from, to)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := &ReleaseDiff{
+		From:      1,
+		To:        3,
+		Resources: []ResourceDiff{},
+		Values: `--- values (revision 1)
++++ values (revision 3)
+@@ -1 +1 @@
+-replicas: 1
++replicas: 2
+`,
+	}
+	if got, want := diff, expected; !cmp.Equal(got, want) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
@@ -2,13 +2,11 @@ package agent
 
 import (
 	"bytes"
-	"io"
 	"net/url"
 	"strings"
 
 	"github.com/docker/distribution/reference"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -93,18 +91,9 @@ func (r *DockerSecretsPostRenderer) Run(renderedManifests *bytes.Buffer) (modifi
 		return renderedManifests, nil
 	}
 
-	decoder := yaml.NewDecoder(renderedManifests)
-	var resourceList []interface{}
-	for {
-		var resource interface{}
-		err := decoder.Decode(&resource)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		resourceList = append(resourceList, resource)
+	resourceList, err := decodeManifests(renderedManifests)
+	if err != nil {
+		return nil, err
 	}
 
 	// TODO(mnelson): If re-rendering the entire manifest creates issues, we
@@ -113,18 +102,7 @@ func (r *DockerSecretsPostRenderer) Run(renderedManifests *bytes.Buffer) (modifi
 	// more complex.
 	r.processResourceList(resourceList)
 
-	modifiedManifests = bytes.NewBuffer([]byte{})
-	encoder := yaml.NewEncoder(modifiedManifests)
-	defer encoder.Close()
-
-	for _, resource := range resourceList {
-		err = encoder.Encode(resource)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return modifiedManifests, nil
+	return encodeManifests(resourceList)
 }
 
 // updatePodSpecWithPullSecrets updates the podSpec inline with the relevant pull secrets.
@@ -201,7 +179,8 @@ func (r *DockerSecretsPostRenderer) updatePodSpecWithPullSecrets(podSpec map[int
 	}
 }
 
-// getResourcePodSpec checks the kind of the resource and extracts the pod spec accordingly.
+// getResourcePodSpec checks the kind and API version of the resource and extracts the pod
+// spec accordingly, see podSpecPaths.
 // We do not parse the yaml into actual Kubernetes objects since we want to be
 // independent of api versions. This requires special care and limitations, so
 // we limit our assumptions of the untyped handling to the following, with any
@@ -209,22 +188,29 @@ func (r *DockerSecretsPostRenderer) updatePodSpecWithPullSecrets(podSpec map[int
 // - A resource doc is a map with a "kind" key with a string value
 // - A pod resource doc has a "spec" key containing a map
 func getResourcePodSpec(kind string, resource map[interface{}]interface{}) map[interface{}]interface{} {
-	switch kind {
-	case "Pod":
-		return getMapForKeys([]string{"spec"}, resource)
-	case "DaemonSet", "Deployment", "Job", "ReplicaSet", "ReplicationController", "StatefulSet":
-		// These resources all include a spec.template.spec PodSpec.
-		// https://kubernetes.io/docs/reference/generated/kubernetes-api/v1.18/#podtemplatespec-v1-core
-		return getMapForKeys([]string{"spec", "template", "spec"}, resource)
-	case "PodTemplate":
-		return getMapForKeys([]string{"template", "spec"}, resource)
-	case "CronJob":
-		// A CronJob spec contains a jobTemplate:
-		// https://kubernetes.io/docs/reference/generated/kubernetes-api/v1.18/#cronjobspec-v1beta1-batch
-		return getMapForKeys([]string{"spec", "jobTemplate", "spec", "template", "spec"}, resource)
+	keys := podSpecPaths.lookup(resourceAPIVersion(resource), kind)
+	if keys == nil {
+		return nil
 	}
+	return getMapForKeys(keys, resource)
+}
+
+// getResourcePodTemplate returns the pod template of a resource, which holds
+// the metadata and spec of the pods it creates, or nil for pods themselves
+// and resources without pods.
+func getResourcePodTemplate(kind string, resource map[interface{}]interface{}) map[interface{}]interface{} {
+	keys := podSpecPaths.lookup(resourceAPIVersion(resource), kind)
+	if len(keys) < 2 {
+		return nil
+	}
+	return getMapForKeys(keys[:len(keys)-1], resource)
+}
 
-	return nil
+// resourceAPIVersion returns the API version of a resource, or an empty
+// string if it has none.
+func resourceAPIVersion(resource map[interface{}]interface{}) string {
+	apiVersion, _ := resource["apiVersion"].(string)
+	return apiVersion
 }
 
 func getMapForKeys(keys []string, m map[interface{}]interface{}) map[interface{}]interface{} {
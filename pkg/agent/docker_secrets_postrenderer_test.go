@@ -570,6 +570,52 @@ func TestGetResourcePodSpec(t *testing.T) {
 				"some": "spec",
 			},
 		},
+		{
+			name: "it returns the pod spec from a Knative service",
+			kind: "Service",
+			resource: map[interface{}]interface{}{
+				"apiVersion": "serving.knative.dev/v1",
+				"kind":       "Service",
+				"spec": map[interface{}]interface{}{
+					"template": map[interface{}]interface{}{
+						"spec": map[interface{}]interface{}{"some": "spec"},
+					},
+				},
+			},
+			result: map[interface{}]interface{}{
+				"some": "spec",
+			},
+		},
+		{
+			name: "it ignores a core service",
+			kind: "Service",
+			resource: map[interface{}]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"spec": map[interface{}]interface{}{
+					"template": map[interface{}]interface{}{
+						"spec": map[interface{}]interface{}{"some": "spec"},
+					},
+				},
+			},
+			result: nil,
+		},
+		{
+			name: "it returns the pod spec from an Argo rollout",
+			kind: "Rollout",
+			resource: map[interface{}]interface{}{
+				"apiVersion": "argoproj.io/v1alpha1",
+				"kind":       "Rollout",
+				"spec": map[interface{}]interface{}{
+					"template": map[interface{}]interface{}{
+						"spec": map[interface{}]interface{}{"some": "spec"},
+					},
+				},
+			},
+			result: map[interface{}]interface{}{
+				"some": "spec",
+			},
+		},
 	}
 
 	for _, tc := range testCases {
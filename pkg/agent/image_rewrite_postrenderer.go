@@ -0,0 +1,284 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/kubeapps/kubeapps/pkg/oci"
+)
+
+// registryDockerIO is the host serving the registry API of docker.io.
+const registryDockerIO = "registry-1.docker.io"
+
+// ImageRewriteRule rewrites the repository of the images matching From to
+// To. Both are repository names, e.g. "docker.io/bitnami/nginx", and a
+// trailing "/*" matches every repository under a registry or path, e.g.
+// "docker.io/*" to "mirror.internal/dockerhub/*".
+type ImageRewriteRule struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// imageRewriteRule is a validated ImageRewriteRule. The names are
+// normalized, without the wildcard.
+type imageRewriteRule struct {
+	from     string
+	to       string
+	wildcard bool
+}
+
+// rewrite returns the repository name a rule rewrites a name to.
+func (r imageRewriteRule) rewrite(name string) (string, bool) {
+	if !r.wildcard {
+		return r.to, name == r.from
+	}
+	if !strings.HasPrefix(name, r.from+"/") {
+		return "", false
+	}
+	return r.to + strings.TrimPrefix(name, r.from), true
+}
+
+// DigestResolver returns the digest of the manifest of a tagged image.
+type DigestResolver interface {
+	ResolveDigest(image reference.NamedTagged) (string, error)
+}
+
+// RegistryDigestResolver resolves digests with the registry API of the
+// registry of the images, anonymously.
+type RegistryDigestResolver struct {
+	client oci.HTTPClient
+}
+
+// NewRegistryDigestResolver returns a DigestResolver sending requests with
+// the given client.
+func NewRegistryDigestResolver(client oci.HTTPClient) *RegistryDigestResolver {
+	return &RegistryDigestResolver{client: client}
+}
+
+// ResolveDigest returns the digest of the manifest of a tagged image.
+func (r *RegistryDigestResolver) ResolveDigest(image reference.NamedTagged) (string, error) {
+	host := reference.Domain(image)
+	if host == DockerIO {
+		host = registryDockerIO
+	}
+	registry, err := oci.NewRegistry("https://"+host, r.client, nil)
+	if err != nil {
+		return "", err
+	}
+	return registry.ResolveDigest(reference.Path(image), image.Tag())
+}
+
+// ImageRewritePostRenderer is a helm post-renderer which rewrites the images
+// of the containers and init containers of the workloads of a release, so
+// that they are pulled from mirrors of their registries. The rewritten
+// images can be pinned to their digest in the mirror.
+type ImageRewritePostRenderer struct {
+	rules []imageRewriteRule
+	// resolver resolves the digests of the rewritten images. They are not
+	// pinned if nil.
+	resolver DigestResolver
+	// digests caches the digests resolved during a run.
+	digests map[string]string
+}
+
+// NewImageRewritePostRenderer returns a post renderer rewriting images with
+// the given rules, the first rule matching an image rewriting it.
+func NewImageRewritePostRenderer(rules []ImageRewriteRule, resolver DigestResolver) (*ImageRewritePostRenderer, error) {
+	r := &ImageRewritePostRenderer{resolver: resolver}
+	for _, rule := range rules {
+		parsed, err := parseImageRewriteRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, parsed)
+	}
+	return r, nil
+}
+
+func parseImageRewriteRule(rule ImageRewriteRule) (imageRewriteRule, error) {
+	from, fromWildcard := trimWildcard(rule.From)
+	to, toWildcard := trimWildcard(rule.To)
+	if fromWildcard != toWildcard {
+		return imageRewriteRule{}, fmt.Errorf("invalid image rewrite rule %q -> %q: both or none of the repositories must end with /*", rule.From, rule.To)
+	}
+	parsed := imageRewriteRule{wildcard: fromWildcard}
+	var err error
+	if parsed.from, err = normalizeRepositoryName(from, fromWildcard); err != nil {
+		return imageRewriteRule{}, fmt.Errorf("invalid image rewrite rule %q -> %q: %v", rule.From, rule.To, err)
+	}
+	if parsed.to, err = normalizeRepositoryName(to, toWildcard); err != nil {
+		return imageRewriteRule{}, fmt.Errorf("invalid image rewrite rule %q -> %q: %v", rule.From, rule.To, err)
+	}
+	return parsed, nil
+}
+
+func trimWildcard(name string) (string, bool) {
+	if strings.HasSuffix(name, "/*") {
+		return strings.TrimSuffix(name, "/*"), true
+	}
+	return name, false
+}
+
+// normalizeRepositoryName returns the fully qualified name of a repository,
+// or of a registry or path when followed by a wildcard.
+func normalizeRepositoryName(name string, wildcard bool) (string, error) {
+	if !wildcard {
+		ref, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			return "", err
+		}
+		if !reference.IsNameOnly(ref) {
+			return "", fmt.Errorf("%s is not a repository name", name)
+		}
+		return ref.Name(), nil
+	}
+	// A registry or path is not a valid repository on its own, so it is
+	// validated as the parent of a placeholder repository.
+	ref, err := reference.ParseNormalizedNamed(name + "/x")
+	if err != nil {
+		return "", err
+	}
+	if isRegistryDomain(name) {
+		domain := reference.Domain(ref)
+		if domain == "" {
+			return "", fmt.Errorf("%s is not a valid registry", name)
+		}
+		return domain, nil
+	}
+	return strings.TrimSuffix(ref.Name(), "/x"), nil
+}
+
+// isRegistryDomain returns whether a name without path is the domain of a
+// registry rather than the first component of a docker.io repository, as
+// the docker reference grammar tells them apart.
+func isRegistryDomain(name string) bool {
+	return !strings.Contains(name, "/") && (strings.ContainsAny(name, ".:") || name == "localhost")
+}
+
+// Run returns the rendered yaml with the images rewritten. An error is
+// returned if the manifests cannot be parsed or re-rendered, or if the
+// digest of a rewritten image cannot be resolved.
+func (r *ImageRewritePostRenderer) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	if len(r.rules) == 0 {
+		return renderedManifests, nil
+	}
+
+	resourceList, err := decodeManifests(renderedManifests)
+	if err != nil {
+		return nil, err
+	}
+	r.digests = map[string]string{}
+	if err := r.processResourceList(resourceList); err != nil {
+		return nil, err
+	}
+	return encodeManifests(resourceList)
+}
+
+func (r *ImageRewritePostRenderer) processResourceList(resourceList []interface{}) error {
+	for _, resourceItem := range resourceList {
+		resource, ok := resourceItem.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		if items, ok := resource["items"].([]interface{}); ok {
+			if err := r.processResourceList(items); err != nil {
+				return err
+			}
+			continue
+		}
+		kind, ok := resource["kind"].(string)
+		if !ok {
+			// Invalid resources are left for the API server to reject.
+			continue
+		}
+		podSpec := getResourcePodSpec(kind, resource)
+		if podSpec == nil {
+			continue
+		}
+		for _, container := range podContainers(podSpec) {
+			image, ok := container["image"].(string)
+			if !ok {
+				continue
+			}
+			rewritten, err := r.rewriteImage(image)
+			if err != nil {
+				return err
+			}
+			container["image"] = rewritten
+		}
+	}
+	return nil
+}
+
+// rewriteImage returns the image rewritten by the first matching rule,
+// keeping its tag or digest, or the image itself if no rule matches.
+func (r *ImageRewritePostRenderer) rewriteImage(image string) (string, error) {
+	ref, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		// Invalid images are left for the API server to reject.
+		return image, nil
+	}
+	for _, rule := range r.rules {
+		name, ok := rule.rewrite(ref.Name())
+		if !ok {
+			continue
+		}
+		rewritten, err := reference.ParseNormalizedNamed(name)
+		if err != nil {
+			return "", fmt.Errorf("unable to rewrite the image %s: %v", image, err)
+		}
+		return r.withTagOrDigest(image, rewritten, ref)
+	}
+	return image, nil
+}
+
+// withTagOrDigest returns the rewritten image with the tag and digest of the
+// original one. The tag is pinned to its digest in the rewritten repository
+// if required.
+func (r *ImageRewritePostRenderer) withTagOrDigest(image string, rewritten reference.Named, ref reference.Named) (string, error) {
+	if digested, ok := ref.(reference.Digested); ok {
+		result := rewritten.String()
+		if tagged, ok := ref.(reference.Tagged); ok {
+			result += ":" + tagged.Tag()
+		}
+		return result + "@" + digested.Digest().String(), nil
+	}
+	tag := "latest"
+	if tagged, ok := ref.(reference.Tagged); ok {
+		tag = tagged.Tag()
+	}
+	rewrittenTagged, err := reference.WithTag(rewritten, tag)
+	if err != nil {
+		return "", fmt.Errorf("unable to rewrite the image %s: %v", image, err)
+	}
+	if r.resolver == nil {
+		return rewrittenTagged.String(), nil
+	}
+	digest, ok := r.digests[rewrittenTagged.String()]
+	if !ok {
+		digest, err = r.resolver.ResolveDigest(rewrittenTagged)
+		if err != nil {
+			return "", fmt.Errorf("unable to pin the image %s to its digest: %v", rewrittenTagged, err)
+		}
+		r.digests[rewrittenTagged.String()] = digest
+	}
+	return rewrittenTagged.String() + "@" + digest, nil
+}
@@ -0,0 +1,270 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution/reference"
+)
+
+// fakeDigestResolver resolves the digests of known images.
+type fakeDigestResolver struct {
+	digests  map[string]string
+	resolved []string
+}
+
+func (r *fakeDigestResolver) ResolveDigest(image reference.NamedTagged) (string, error) {
+	r.resolved = append(r.resolved, image.String())
+	digest, ok := r.digests[image.String()]
+	if !ok {
+		return "", errors.New("manifest unknown")
+	}
+	return digest, nil
+}
+
+func TestImageRewritePostRenderer(t *testing.T) {
+	const digest = "sha256:0123456789012345678901234567890123456789012345678901234567890123"
+	rules := []ImageRewriteRule{
+		{From: "docker.io/bitnami/nginx", To: "mirror.internal/nginx"},
+		{From: "docker.io/*", To: "mirror.internal/dockerhub/*"},
+		{From: "quay.io/coreos/*", To: "mirror.internal/quay/*"},
+	}
+	testCases := []struct {
+		name      string
+		input     string
+		rules     []ImageRewriteRule
+		digests   map[string]string
+		output    string
+		resolved  []string
+		expectErr bool
+	}{
+		{
+			name:   "it returns the input without parsing without rules",
+			input:  `anything at : all`,
+			output: `anything at : all`,
+		},
+		{
+			name:      "it returns an error if the input cannot be parsed as yaml",
+			input:     "v: [A,",
+			rules:     rules,
+			expectErr: true,
+		},
+		{
+			name: "it rewrites the images of containers and init containers with the first matching rule",
+			input: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    spec:
+      initContainers:
+      - image: busybox
+        name: init
+      containers:
+      - image: bitnami/nginx:1.19
+        name: nginx
+      - image: quay.io/coreos/etcd@` + digest + `
+        name: etcd
+      - image: gcr.io/google-containers/pause:3.2
+        name: pause
+`,
+			rules: rules,
+			output: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    spec:
+      containers:
+      - image: mirror.internal/nginx:1.19
+        name: nginx
+      - image: mirror.internal/quay/etcd@` + digest + `
+        name: etcd
+      - image: gcr.io/google-containers/pause:3.2
+        name: pause
+      initContainers:
+      - image: mirror.internal/dockerhub/library/busybox:latest
+        name: init
+`,
+		},
+		{
+			name: "it rewrites the images of the items of lists and of cronjobs",
+			input: `apiVersion: v1
+kind: List
+items:
+- apiVersion: batch/v1beta1
+  kind: CronJob
+  metadata:
+    name: foo
+  spec:
+    jobTemplate:
+      spec:
+        template:
+          spec:
+            containers:
+            - image: docker.io/library/busybox:1.32
+              name: busybox
+`,
+			rules: rules,
+			output: `apiVersion: v1
+items:
+- apiVersion: batch/v1beta1
+  kind: CronJob
+  metadata:
+    name: foo
+  spec:
+    jobTemplate:
+      spec:
+        template:
+          spec:
+            containers:
+            - image: mirror.internal/dockerhub/library/busybox:1.32
+              name: busybox
+kind: List
+`,
+		},
+		{
+			name: "it pins the rewritten images to their digest in the mirror once",
+			input: `apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+spec:
+  initContainers:
+  - image: busybox:1.32
+    name: init
+  containers:
+  - image: busybox:1.32
+    name: busybox
+  - image: gcr.io/google-containers/pause:3.2
+    name: pause
+`,
+			rules:    rules,
+			digests:  map[string]string{"mirror.internal/dockerhub/library/busybox:1.32": digest},
+			resolved: []string{"mirror.internal/dockerhub/library/busybox:1.32"},
+			output: `apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+spec:
+  containers:
+  - image: mirror.internal/dockerhub/library/busybox:1.32@` + digest + `
+    name: busybox
+  - image: gcr.io/google-containers/pause:3.2
+    name: pause
+  initContainers:
+  - image: mirror.internal/dockerhub/library/busybox:1.32@` + digest + `
+    name: init
+`,
+		},
+		{
+			name: "it returns an error if a rewritten image cannot be pinned",
+			input: `apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+spec:
+  containers:
+  - image: busybox:1.32
+    name: busybox
+`,
+			rules:     rules,
+			digests:   map[string]string{},
+			resolved:  []string{"mirror.internal/dockerhub/library/busybox:1.32"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var resolver *fakeDigestResolver
+			var r *ImageRewritePostRenderer
+			var err error
+			if tc.digests != nil {
+				resolver = &fakeDigestResolver{digests: tc.digests}
+				r, err = NewImageRewritePostRenderer(tc.rules, resolver)
+			} else {
+				r, err = NewImageRewritePostRenderer(tc.rules, nil)
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			output, err := r.Run(bytes.NewBufferString(tc.input))
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if err == nil && output.String() != tc.output {
+				t.Errorf("got: %q, want: %q", output.String(), tc.output)
+			}
+			if resolver != nil && strings.Join(resolver.resolved, ",") != strings.Join(tc.resolved, ",") {
+				t.Errorf("got: %v resolved, want: %v", resolver.resolved, tc.resolved)
+			}
+		})
+	}
+}
+
+func TestNewImageRewritePostRendererInvalidRules(t *testing.T) {
+	testCases := []struct {
+		name string
+		rule ImageRewriteRule
+	}{
+		{"a wildcard rewritten to a repository", ImageRewriteRule{From: "docker.io/*", To: "mirror.internal/nginx"}},
+		{"a repository rewritten to a wildcard", ImageRewriteRule{From: "nginx", To: "mirror.internal/*"}},
+		{"an empty repository", ImageRewriteRule{From: "", To: "mirror.internal/nginx"}},
+		{"a tagged repository", ImageRewriteRule{From: "nginx:1.19", To: "mirror.internal/nginx"}},
+		{"an invalid repository", ImageRewriteRule{From: "docker.io/*", To: "mirror_internal.example/*"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewImageRewritePostRenderer([]ImageRewriteRule{tc.rule}, nil); err == nil {
+				t.Errorf("got: nil, want: error")
+			}
+		})
+	}
+}
+
+func TestRegistryDigestResolver(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2/dockerhub/library/busybox/manifests/1.32" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", "sha256:123")
+	}))
+	defer server.Close()
+
+	image, err := reference.ParseNormalizedNamed(strings.TrimPrefix(server.URL, "https://") + "/dockerhub/library/busybox:1.32")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	digest, err := NewRegistryDigestResolver(server.Client()).ResolveDigest(image.(reference.NamedTagged))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := digest, "sha256:123"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
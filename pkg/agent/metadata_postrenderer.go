@@ -0,0 +1,213 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// UserPlaceholder is replaced in the values of the release metadata by the
+// name of the user installing or upgrading the release.
+const UserPlaceholder = "$(USER)"
+
+// maxLabelValueLength is the maximum length of the value of a label.
+const maxLabelValueLength = 63
+
+var invalidLabelValueChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ReleaseMetadata are the labels and annotations injected in the objects of
+// releases and in the pods they create.
+type ReleaseMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// IsEmpty returns whether there is no metadata to inject.
+func (m ReleaseMetadata) IsEmpty() bool {
+	return len(m.Labels) == 0 && len(m.Annotations) == 0
+}
+
+// Merge returns the metadata overridden by the labels and annotations of
+// another metadata.
+func (m ReleaseMetadata) Merge(other ReleaseMetadata) ReleaseMetadata {
+	return ReleaseMetadata{
+		Labels:      mergeStringMaps(m.Labels, other.Labels),
+		Annotations: mergeStringMaps(m.Annotations, other.Annotations),
+	}
+}
+
+// UsesUser returns whether any value refers to the user of the release.
+func (m ReleaseMetadata) UsesUser() bool {
+	for _, values := range []map[string]string{m.Labels, m.Annotations} {
+		for _, v := range values {
+			if strings.Contains(v, UserPlaceholder) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithUser returns the metadata with the placeholders replaced by the name
+// of a user. The label values are reduced to the characters valid in labels.
+func (m ReleaseMetadata) WithUser(user string) ReleaseMetadata {
+	result := ReleaseMetadata{}
+	if m.Labels != nil {
+		labelUser := invalidLabelValueChars.ReplaceAllString(user, "_")
+		result.Labels = map[string]string{}
+		for k, v := range m.Labels {
+			result.Labels[k] = labelValue(strings.ReplaceAll(v, UserPlaceholder, labelUser))
+		}
+	}
+	if m.Annotations != nil {
+		result.Annotations = map[string]string{}
+		for k, v := range m.Annotations {
+			result.Annotations[k] = strings.ReplaceAll(v, UserPlaceholder, user)
+		}
+	}
+	return result
+}
+
+// labelValue truncates a value to the length of labels, which must begin
+// and end with an alphanumeric character.
+func labelValue(value string) string {
+	if len(value) > maxLabelValueLength {
+		value = value[:maxLabelValueLength]
+	}
+	return strings.Trim(value, "._-")
+}
+
+func mergeStringMaps(m, other map[string]string) map[string]string {
+	if len(m) == 0 && len(other) == 0 {
+		return nil
+	}
+	result := map[string]string{}
+	for k, v := range m {
+		result[k] = v
+	}
+	for k, v := range other {
+		result[k] = v
+	}
+	return result
+}
+
+// ReleaseMetadataConfig is the release metadata of each namespace, with the
+// metadata of the "*" namespace injected in the releases of every namespace.
+type ReleaseMetadataConfig struct {
+	Namespaces map[string]ReleaseMetadata `json:"namespaces"`
+}
+
+// allNamespaces is the key of the metadata of every namespace.
+const allNamespaces = "*"
+
+// ParseReleaseMetadataConfig parses the YAML release metadata of namespaces.
+func ParseReleaseMetadataConfig(data []byte) (*ReleaseMetadataConfig, error) {
+	config := &ReleaseMetadataConfig{}
+	if err := sigsyaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("unable to parse the release metadata config: %v", err)
+	}
+	return config, nil
+}
+
+// ForNamespace returns the metadata of the releases of a namespace.
+func (c *ReleaseMetadataConfig) ForNamespace(namespace string) ReleaseMetadata {
+	if c == nil {
+		return ReleaseMetadata{}
+	}
+	return c.Namespaces[allNamespaces].Merge(c.Namespaces[namespace])
+}
+
+// MetadataPostRenderer is a helm post-renderer which injects labels and
+// annotations in every object of a release and in the template of the pods
+// of its workloads. The injected values override those of the chart.
+type MetadataPostRenderer struct {
+	metadata ReleaseMetadata
+}
+
+// NewMetadataPostRenderer returns a post renderer injecting the given
+// metadata.
+func NewMetadataPostRenderer(metadata ReleaseMetadata) *MetadataPostRenderer {
+	return &MetadataPostRenderer{metadata: metadata}
+}
+
+// Run returns the rendered yaml with the metadata injected. An error is only
+// returned if the manifests cannot be parsed or re-rendered.
+func (r *MetadataPostRenderer) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	if r.metadata.IsEmpty() {
+		return renderedManifests, nil
+	}
+
+	resourceList, err := decodeManifests(renderedManifests)
+	if err != nil {
+		return nil, err
+	}
+	r.processResourceList(resourceList)
+	return encodeManifests(resourceList)
+}
+
+func (r *MetadataPostRenderer) processResourceList(resourceList []interface{}) {
+	for _, resourceItem := range resourceList {
+		resource, ok := resourceItem.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		if items, ok := resource["items"].([]interface{}); ok {
+			r.processResourceList(items)
+			continue
+		}
+		kind, ok := resource["kind"].(string)
+		if !ok {
+			// Invalid resources are left for the API server to reject.
+			continue
+		}
+		r.injectMetadata(resource)
+		if podTemplate := getResourcePodTemplate(kind, resource); podTemplate != nil {
+			r.injectMetadata(podTemplate)
+		}
+	}
+}
+
+// injectMetadata sets the labels and annotations in the metadata of an
+// object or a pod template.
+func (r *MetadataPostRenderer) injectMetadata(object map[interface{}]interface{}) {
+	metadata, ok := object["metadata"].(map[interface{}]interface{})
+	if !ok {
+		metadata = map[interface{}]interface{}{}
+		object["metadata"] = metadata
+	}
+	setStringMapValues(metadata, "labels", r.metadata.Labels)
+	setStringMapValues(metadata, "annotations", r.metadata.Annotations)
+}
+
+func setStringMapValues(metadata map[interface{}]interface{}, key string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+	m, ok := metadata[key].(map[interface{}]interface{})
+	if !ok {
+		m = map[interface{}]interface{}{}
+		metadata[key] = m
+	}
+	for k, v := range values {
+		m[k] = v
+	}
+}
@@ -0,0 +1,244 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMetadataPostRenderer(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		metadata  ReleaseMetadata
+		output    string
+		expectErr bool
+	}{
+		{
+			name:   "it returns the input without parsing without metadata",
+			input:  `anything at : all`,
+			output: `anything at : all`,
+		},
+		{
+			name:      "it returns an error if the input cannot be parsed as yaml",
+			input:     "v: [A,",
+			metadata:  ReleaseMetadata{Labels: map[string]string{"team": "a"}},
+			expectErr: true,
+		},
+		{
+			name: "it injects the metadata in the objects and the templates of their pods",
+			input: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  labels:
+    app: foo
+    team: chart
+spec:
+  template:
+    metadata:
+      labels:
+        app: foo
+    spec:
+      containers:
+      - image: nginx
+        name: nginx
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`,
+			metadata: ReleaseMetadata{
+				Labels:      map[string]string{"team": "a"},
+				Annotations: map[string]string{"example.com/installed-by": "me@example.com"},
+			},
+			output: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  annotations:
+    example.com/installed-by: me@example.com
+  labels:
+    app: foo
+    team: a
+  name: foo
+spec:
+  template:
+    metadata:
+      annotations:
+        example.com/installed-by: me@example.com
+      labels:
+        app: foo
+        team: a
+    spec:
+      containers:
+      - image: nginx
+        name: nginx
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  annotations:
+    example.com/installed-by: me@example.com
+  labels:
+    team: a
+  name: foo
+`,
+		},
+		{
+			name: "it injects the metadata in the items of lists and the pods of cronjobs",
+			input: `apiVersion: v1
+kind: List
+items:
+- apiVersion: batch/v1beta1
+  kind: CronJob
+  metadata:
+    name: foo
+  spec:
+    jobTemplate:
+      spec:
+        template:
+          spec:
+            containers:
+            - image: busybox
+              name: busybox
+`,
+			metadata: ReleaseMetadata{Labels: map[string]string{"team": "a"}},
+			output: `apiVersion: v1
+items:
+- apiVersion: batch/v1beta1
+  kind: CronJob
+  metadata:
+    labels:
+      team: a
+    name: foo
+  spec:
+    jobTemplate:
+      spec:
+        template:
+          metadata:
+            labels:
+              team: a
+          spec:
+            containers:
+            - image: busybox
+              name: busybox
+kind: List
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := NewMetadataPostRenderer(tc.metadata).Run(bytes.NewBufferString(tc.input))
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if err != nil {
+				return
+			}
+			if got, want := output.String(), tc.output; got != want {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestReleaseMetadataWithUser(t *testing.T) {
+	metadata := ReleaseMetadata{
+		Labels:      map[string]string{"team": "a", "installed-by": "user-" + UserPlaceholder},
+		Annotations: map[string]string{"example.com/installed-by": UserPlaceholder},
+	}
+	if !metadata.UsesUser() {
+		t.Errorf("got: false, want: true")
+	}
+
+	expected := ReleaseMetadata{
+		Labels:      map[string]string{"team": "a", "installed-by": "user-system_serviceaccount_kubeapps_me"},
+		Annotations: map[string]string{"example.com/installed-by": "system:serviceaccount:kubeapps:me"},
+	}
+	if got, want := metadata.WithUser("system:serviceaccount:kubeapps:me"), expected; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+
+	// Label values are limited to 63 characters ending with an alphanumeric.
+	long := ReleaseMetadata{Labels: map[string]string{"installed-by": UserPlaceholder}}
+	user := "a-very-long-user-name-which-does-not-fit-in-a-label-value-@example.com"
+	if got, want := long.WithUser(user).Labels["installed-by"], "a-very-long-user-name-which-does-not-fit-in-a-label-value-_exam"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestReleaseMetadataConfig(t *testing.T) {
+	config, err := ParseReleaseMetadataConfig([]byte(`namespaces:
+  "*":
+    labels:
+      managed-by: kubeapps
+      team: none
+  team-a:
+    labels:
+      team: a
+    annotations:
+      cost-center: "1234"
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	testCases := []struct {
+		name      string
+		config    *ReleaseMetadataConfig
+		namespace string
+		expected  ReleaseMetadata
+	}{
+		{
+			name:      "it merges the metadata of the namespace with that of every namespace",
+			config:    config,
+			namespace: "team-a",
+			expected: ReleaseMetadata{
+				Labels:      map[string]string{"managed-by": "kubeapps", "team": "a"},
+				Annotations: map[string]string{"cost-center": "1234"},
+			},
+		},
+		{
+			name:      "it returns the metadata of every namespace for other namespaces",
+			config:    config,
+			namespace: "default",
+			expected: ReleaseMetadata{
+				Labels: map[string]string{"managed-by": "kubeapps", "team": "none"},
+			},
+		},
+		{
+			name:      "it returns no metadata without config",
+			namespace: "default",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := tc.config.ForNamespace(tc.namespace), tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+
+	if _, err := ParseReleaseMetadataConfig([]byte("namespaces: []")); err == nil {
+		t.Errorf("got: nil, want: an error for an invalid config")
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import "github.com/prometheus/client_golang/prometheus"
+
+const (
+	actionInstall  = "install"
+	actionUpgrade  = "upgrade"
+	actionRollback = "rollback"
+	actionDelete   = "delete"
+)
+
+var helmActions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "kubeapps",
+	Name:      "helm_actions_total",
+	Help:      "Number of Helm actions run on releases, by action and result.",
+}, []string{"action", "result"})
+
+func init() {
+	prometheus.MustRegister(helmActions)
+}
+
+// recordAction counts a Helm action as a success or a failure.
+func recordAction(action string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	helmActions.WithLabelValues(action, result).Inc()
+}
@@ -0,0 +1,46 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func TestDeleteReleaseMetrics(t *testing.T) {
+	success := helmActions.WithLabelValues(actionDelete, "success")
+	failure := helmActions.WithLabelValues(actionDelete, "failure")
+	successes, failures := testutil.ToFloat64(success), testutil.ToFloat64(failure)
+	cfg := newActionConfigFixture(t)
+	makeReleases(t, cfg, []releaseStub{{"apache", "default", 1, "1.0.0", release.StatusDeployed}})
+
+	if err := DeleteRelease(cfg, "apache", true); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := DeleteRelease(cfg, "foo", true); err == nil {
+		t.Fatalf("got: nil, want: error")
+	}
+
+	if got, want := testutil.ToFloat64(success), successes+1; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(failure), failures+1; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// PodSpecPath is the path of the PodSpec embedded in a kind of resource, e.g.
+// ["spec", "template", "spec"] for a Deployment.
+type PodSpecPath struct {
+	// APIVersion restricts the path to the resources of an API version, e.g.
+	// "serving.knative.dev/v1", or of any version of an API group with
+	// "serving.knative.dev/*". The path applies to every API version if
+	// empty.
+	APIVersion string   `json:"apiVersion,omitempty"`
+	Kind       string   `json:"kind"`
+	Path       []string `json:"path"`
+}
+
+// specificity returns how specifically a path matches a resource, or -1 if
+// it does not match it.
+func (p PodSpecPath) specificity(apiVersion, kind string) int {
+	switch {
+	case p.Kind != kind:
+		return -1
+	case p.APIVersion == "":
+		return 0
+	case strings.HasSuffix(p.APIVersion, "/*"):
+		if strings.HasPrefix(apiVersion, strings.TrimSuffix(p.APIVersion, "*")) {
+			return 1
+		}
+		return -1
+	case p.APIVersion == apiVersion:
+		return 2
+	default:
+		return -1
+	}
+}
+
+// PodSpecPaths are the paths of the PodSpec of the kinds of resources whose
+// pods are mutated by the post-renderers.
+type PodSpecPaths []PodSpecPath
+
+// lookup returns the path of the PodSpec of a resource, or nil if it has
+// none. The most specific path applies, the first one among equally
+// specific paths.
+func (p PodSpecPaths) lookup(apiVersion, kind string) []string {
+	var path []string
+	best := -1
+	for _, podSpecPath := range p {
+		if specificity := podSpecPath.specificity(apiVersion, kind); specificity > best {
+			path, best = podSpecPath.Path, specificity
+		}
+	}
+	return path
+}
+
+// defaultPodSpecPaths are the paths of the workloads of Kubernetes, of any
+// API version, and of common custom resources embedding pods.
+var defaultPodSpecPaths = PodSpecPaths{
+	{Kind: "Pod", Path: []string{"spec"}},
+	// These resources all include a spec.template.spec PodSpec.
+	// https://kubernetes.io/docs/reference/generated/kubernetes-api/v1.18/#podtemplatespec-v1-core
+	{Kind: "DaemonSet", Path: []string{"spec", "template", "spec"}},
+	{Kind: "Deployment", Path: []string{"spec", "template", "spec"}},
+	{Kind: "Job", Path: []string{"spec", "template", "spec"}},
+	{Kind: "ReplicaSet", Path: []string{"spec", "template", "spec"}},
+	{Kind: "ReplicationController", Path: []string{"spec", "template", "spec"}},
+	{Kind: "StatefulSet", Path: []string{"spec", "template", "spec"}},
+	{Kind: "PodTemplate", Path: []string{"template", "spec"}},
+	// A CronJob spec contains a jobTemplate:
+	// https://kubernetes.io/docs/reference/generated/kubernetes-api/v1.18/#cronjobspec-v1beta1-batch
+	{Kind: "CronJob", Path: []string{"spec", "jobTemplate", "spec", "template", "spec"}},
+	// https://argoproj.github.io/argo-rollouts/features/specification/
+	{APIVersion: "argoproj.io/*", Kind: "Rollout", Path: []string{"spec", "template", "spec"}},
+	// https://knative.dev/docs/serving/spec/knative-api-specification-1.0/
+	{APIVersion: "serving.knative.dev/*", Kind: "Service", Path: []string{"spec", "template", "spec"}},
+	{APIVersion: "serving.knative.dev/*", Kind: "Configuration", Path: []string{"spec", "template", "spec"}},
+	{APIVersion: "serving.knative.dev/*", Kind: "Revision", Path: []string{"spec"}},
+	// https://openkruise.io/en-us/docs/cloneset.html
+	{APIVersion: "apps.kruise.io/*", Kind: "CloneSet", Path: []string{"spec", "template", "spec"}},
+}
+
+// podSpecPaths are the paths used by the post-renderers, the configured
+// ones followed by the default ones.
+var podSpecPaths = defaultPodSpecPaths
+
+// SetPodSpecPaths configures the paths of the PodSpec of additional kinds of
+// resources, which override the default paths matching the same resources
+// as specifically. It is meant to be called once on startup, before any
+// release is rendered.
+func SetPodSpecPaths(paths PodSpecPaths) {
+	podSpecPaths = append(append(PodSpecPaths{}, paths...), defaultPodSpecPaths...)
+}
+
+// ParsePodSpecPaths parses and validates a YAML list of PodSpec paths.
+func ParsePodSpecPaths(data []byte) (PodSpecPaths, error) {
+	var paths PodSpecPaths
+	if err := sigsyaml.UnmarshalStrict(data, &paths); err != nil {
+		return nil, fmt.Errorf("unable to parse the pod spec paths: %v", err)
+	}
+	for i, p := range paths {
+		if p.Kind == "" {
+			return nil, fmt.Errorf("the pod spec path %d has no kind", i)
+		}
+		if len(p.Path) == 0 {
+			return nil, fmt.Errorf("the pod spec path of %s has no keys", p.Kind)
+		}
+		if strings.Contains(strings.TrimSuffix(p.APIVersion, "/*"), "*") {
+			return nil, fmt.Errorf("the pod spec path of %s has an invalid apiVersion %q", p.Kind, p.APIVersion)
+		}
+	}
+	return paths, nil
+}
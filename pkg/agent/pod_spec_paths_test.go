@@ -0,0 +1,127 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPodSpecPathsLookup(t *testing.T) {
+	paths := PodSpecPaths{
+		{Kind: "Workload", Path: []string{"any"}},
+		{APIVersion: "example.com/*", Kind: "Workload", Path: []string{"group"}},
+		{APIVersion: "example.com/v2", Kind: "Workload", Path: []string{"version"}},
+		{APIVersion: "example.com/v2", Kind: "Workload", Path: []string{"shadowed"}},
+	}
+	testCases := []struct {
+		name       string
+		apiVersion string
+		kind       string
+		expected   []string
+	}{
+		{"an exact API version takes precedence", "example.com/v2", "Workload", []string{"version"}},
+		{"an API group takes precedence over any API version", "example.com/v1", "Workload", []string{"group"}},
+		{"a path without API version matches any API version", "other.example.com/v1", "Workload", []string{"any"}},
+		{"a path without API version matches resources without API version", "", "Workload", []string{"any"}},
+		{"an API group does not match other groups with the same prefix", "example.com.evil/v1", "Workload", []string{"any"}},
+		{"no path for another kind", "example.com/v2", "Other", nil},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := paths.lookup(tc.apiVersion, tc.kind), tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestSetPodSpecPaths(t *testing.T) {
+	defer SetPodSpecPaths(nil)
+
+	SetPodSpecPaths(PodSpecPaths{
+		{APIVersion: "example.com/v1", Kind: "Workload", Path: []string{"spec", "pod"}},
+		{Kind: "Deployment", Path: []string{"spec", "custom"}},
+	})
+
+	if got, want := podSpecPaths.lookup("example.com/v1", "Workload"), []string{"spec", "pod"}; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	// The configured paths override the default ones.
+	if got, want := podSpecPaths.lookup("apps/v1", "Deployment"), []string{"spec", "custom"}; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if got, want := podSpecPaths.lookup("batch/v1beta1", "CronJob"), []string{"spec", "jobTemplate", "spec", "template", "spec"}; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestParsePodSpecPaths(t *testing.T) {
+	testCases := []struct {
+		name      string
+		data      string
+		expected  PodSpecPaths
+		expectErr bool
+	}{
+		{
+			name: "it parses the paths",
+			data: `
+- apiVersion: example.com/*
+  kind: Workload
+  path: [spec, template, spec]
+- kind: Task
+  path: [spec, pod]
+`,
+			expected: PodSpecPaths{
+				{APIVersion: "example.com/*", Kind: "Workload", Path: []string{"spec", "template", "spec"}},
+				{Kind: "Task", Path: []string{"spec", "pod"}},
+			},
+		},
+		{
+			name:      "it returns an error for a path without kind",
+			data:      "- path: [spec]",
+			expectErr: true,
+		},
+		{
+			name:      "it returns an error for a path without keys",
+			data:      "- kind: Workload",
+			expectErr: true,
+		},
+		{
+			name:      "it returns an error for an invalid wildcard",
+			data:      "- apiVersion: example.*/v1\n  kind: Workload\n  path: [spec]",
+			expectErr: true,
+		},
+		{
+			name:      "it returns an error for an unknown field",
+			data:      "- kind: Workload\n  paths: [spec]",
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			paths, err := ParsePodSpecPaths([]byte(tc.data))
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if got, want := paths, tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,321 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// Types of the rules of a policy.
+const (
+	// RulePrivileged forbids privileged containers.
+	RulePrivileged = "privileged"
+	// RuleResourceLimits requires every container to set limits for the
+	// resources of the rule, cpu and memory by default.
+	RuleResourceLimits = "resourceLimits"
+	// RuleAllowedRegistries requires every container image to come from one
+	// of the registries of the rule, optionally followed by a repository path.
+	RuleAllowedRegistries = "allowedRegistries"
+)
+
+// PolicyConfigMapKey is the key of the rules in the policy ConfigMap.
+const PolicyConfigMapKey = "rules.yaml"
+
+var defaultLimitedResources = []string{"cpu", "memory"}
+
+// PolicyRule is a rule the pods of a release must comply with.
+type PolicyRule struct {
+	// Name identifies the rule in the violations, defaults to its type.
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+	// Kinds restricts the rule to the objects of these kinds, all the kinds
+	// defining pods if empty.
+	Kinds      []string `json:"kinds,omitempty"`
+	Resources  []string `json:"resources,omitempty"`
+	Registries []string `json:"registries,omitempty"`
+}
+
+// Policy is the set of rules the objects of releases are evaluated against
+// before Helm applies them.
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// PolicyViolation is a breach of a rule by an object of a release.
+type PolicyViolation struct {
+	Rule      string `json:"rule"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Container string `json:"container,omitempty"`
+	Message   string `json:"message"`
+}
+
+// PolicyViolationError is returned when the objects of a release do not
+// comply with the policy.
+type PolicyViolationError struct {
+	Violations []PolicyViolation
+}
+
+func (e *PolicyViolationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = fmt.Sprintf("%s %q: %s", v.Kind, v.Name, v.Message)
+	}
+	return fmt.Sprintf("the release violates the policy: %s", strings.Join(messages, "; "))
+}
+
+// policyViolationError returns the violations of the policy which made a
+// Helm action fail, if any.
+func policyViolationError(err error) *PolicyViolationError {
+	var violationErr *PolicyViolationError
+	if errors.As(err, &violationErr) {
+		return violationErr
+	}
+	return nil
+}
+
+// ParsePolicy parses the YAML rules of a policy.
+func ParsePolicy(data []byte) (*Policy, error) {
+	policy := &Policy{}
+	if err := sigsyaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("unable to parse the policy: %v", err)
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		switch rule.Type {
+		case RulePrivileged:
+		case RuleResourceLimits:
+			if len(rule.Resources) == 0 {
+				rule.Resources = defaultLimitedResources
+			}
+		case RuleAllowedRegistries:
+			if len(rule.Registries) == 0 {
+				return nil, fmt.Errorf("the rule %d of the policy has no registries", i)
+			}
+		default:
+			return nil, fmt.Errorf("the rule %d of the policy has an unknown type %q", i, rule.Type)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Type
+		}
+	}
+	return policy, nil
+}
+
+// PolicyLoader reads the policy from a ConfigMap each time it is loaded, so
+// that changes to the rules apply to the next releases without a restart.
+type PolicyLoader struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewPolicyLoader returns a loader of the policy of the given ConfigMap.
+func NewPolicyLoader(clientset kubernetes.Interface, namespace, name string) *PolicyLoader {
+	return &PolicyLoader{clientset: clientset, namespace: namespace, name: name}
+}
+
+// Load returns the policy, or nil without a loader.
+func (l *PolicyLoader) Load() (*Policy, error) {
+	if l == nil {
+		return nil, nil
+	}
+	configMap, err := l.clientset.CoreV1().ConfigMaps(l.namespace).Get(context.TODO(), l.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the policy ConfigMap %s/%s: %v", l.namespace, l.name, err)
+	}
+	return ParsePolicy([]byte(configMap.Data[PolicyConfigMapKey]))
+}
+
+// PolicyPostRenderer is a helm post-renderer which evaluates the rendered
+// objects of a release against a policy. It leaves the manifests unchanged
+// and fails with a PolicyViolationError if any object breaks a rule, so that
+// nothing is applied to the cluster.
+type PolicyPostRenderer struct {
+	policy *Policy
+}
+
+// NewPolicyPostRenderer returns a post renderer enforcing the given policy.
+func NewPolicyPostRenderer(policy *Policy) *PolicyPostRenderer {
+	return &PolicyPostRenderer{policy: policy}
+}
+
+// Run returns the rendered yaml unchanged if it complies with the policy.
+func (r *PolicyPostRenderer) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	if r.policy == nil || len(r.policy.Rules) == 0 {
+		return renderedManifests, nil
+	}
+
+	// The manifests are read from a copy as they are returned unchanged.
+	resourceList, err := decodeManifests(bytes.NewBuffer(renderedManifests.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	violations := []PolicyViolation{}
+	for _, resource := range resourceList {
+		violations = append(violations, r.evaluate(resource)...)
+	}
+	if len(violations) > 0 {
+		return nil, &PolicyViolationError{Violations: violations}
+	}
+	return renderedManifests, nil
+}
+
+// evaluate returns the violations of the policy by a resource and, for
+// lists, by its items. As with the image pull secrets, invalid resources are
+// left for the API server to reject.
+func (r *PolicyPostRenderer) evaluate(resourceItem interface{}) []PolicyViolation {
+	resource, ok := resourceItem.(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+	if items, ok := resource["items"].([]interface{}); ok {
+		violations := []PolicyViolation{}
+		for _, item := range items {
+			violations = append(violations, r.evaluate(item)...)
+		}
+		return violations
+	}
+	kind, ok := resource["kind"].(string)
+	if !ok {
+		return nil
+	}
+	podSpec := getResourcePodSpec(kind, resource)
+	if podSpec == nil {
+		return nil
+	}
+	var name string
+	if metadata, ok := resource["metadata"].(map[interface{}]interface{}); ok {
+		name, _ = metadata["name"].(string)
+	}
+
+	violations := []PolicyViolation{}
+	for _, rule := range r.policy.Rules {
+		if !ruleAppliesToKind(rule, kind) {
+			continue
+		}
+		for _, container := range podContainers(podSpec) {
+			containerName, _ := container["name"].(string)
+			if message := checkContainer(rule, container); message != "" {
+				violations = append(violations, PolicyViolation{
+					Rule:      rule.Name,
+					Kind:      kind,
+					Name:      name,
+					Container: containerName,
+					Message:   message,
+				})
+			}
+		}
+	}
+	return violations
+}
+
+func ruleAppliesToKind(rule PolicyRule, kind string) bool {
+	if len(rule.Kinds) == 0 {
+		return true
+	}
+	for _, k := range rule.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// podContainers returns the containers and init containers of a pod spec.
+func podContainers(podSpec map[interface{}]interface{}) []map[interface{}]interface{} {
+	containers := []map[interface{}]interface{}{}
+	for _, key := range []string{"initContainers", "containers"} {
+		list, ok := podSpec[key].([]interface{})
+		if !ok {
+			continue
+		}
+		for _, c := range list {
+			if container, ok := c.(map[interface{}]interface{}); ok {
+				containers = append(containers, container)
+			}
+		}
+	}
+	return containers
+}
+
+// checkContainer returns why a container breaks a rule, or an empty string
+// if it complies with it.
+func checkContainer(rule PolicyRule, container map[interface{}]interface{}) string {
+	switch rule.Type {
+	case RulePrivileged:
+		if privileged, _ := getMapForPath([]string{"securityContext"}, container)["privileged"].(bool); privileged {
+			return "the container is privileged"
+		}
+	case RuleResourceLimits:
+		limits := getMapForPath([]string{"resources", "limits"}, container)
+		missing := []string{}
+		for _, resource := range rule.Resources {
+			if _, ok := limits[resource]; !ok {
+				missing = append(missing, resource)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Sprintf("the container has no %s limits", strings.Join(missing, ", "))
+		}
+	case RuleAllowedRegistries:
+		image, _ := container["image"].(string)
+		if !imageFromRegistries(image, rule.Registries) {
+			return fmt.Sprintf("the image %q is not from an allowed registry", image)
+		}
+	}
+	return ""
+}
+
+// imageFromRegistries returns whether an image reference, normalized as
+// docker does, is within one of the registries.
+func imageFromRegistries(image string, registries []string) bool {
+	ref, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return false
+	}
+	for _, registry := range registries {
+		registry = strings.TrimSuffix(registry, "/")
+		if ref.Name() == registry || strings.HasPrefix(ref.Name(), registry+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// getMapForPath returns the map at the given keys, or nil if there is none.
+// Unlike getMapForKeys, missing keys are expected and not logged.
+func getMapForPath(keys []string, m map[interface{}]interface{}) map[interface{}]interface{} {
+	current := m
+	for _, k := range keys {
+		next, ok := current[k].(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
@@ -0,0 +1,285 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/chart"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testPolicy = `rules:
+- type: privileged
+- name: limits
+  type: resourceLimits
+  kinds: [Deployment]
+- name: registries
+  type: allowedRegistries
+  registries: [docker.io/bitnami, registry.example.com/]
+`
+
+func TestParsePolicy(t *testing.T) {
+	testCases := []struct {
+		name           string
+		data           string
+		expectedPolicy *Policy
+		expectErr      bool
+	}{
+		{
+			name: "it parses the rules with their defaults",
+			data: testPolicy,
+			expectedPolicy: &Policy{Rules: []PolicyRule{
+				{Name: "privileged", Type: RulePrivileged},
+				{Name: "limits", Type: RuleResourceLimits, Kinds: []string{"Deployment"}, Resources: []string{"cpu", "memory"}},
+				{Name: "registries", Type: RuleAllowedRegistries, Registries: []string{"docker.io/bitnami", "registry.example.com/"}},
+			}},
+		},
+		{
+			name:           "it parses an empty policy",
+			data:           "",
+			expectedPolicy: &Policy{},
+		},
+		{
+			name:      "it returns an error for an unknown rule type",
+			data:      "rules:\n- type: foo\n",
+			expectErr: true,
+		},
+		{
+			name:      "it returns an error for a registries rule without registries",
+			data:      "rules:\n- type: allowedRegistries\n",
+			expectErr: true,
+		},
+		{
+			name:      "it returns an error for an unknown field",
+			data:      "rules:\n- type: privileged\n  foo: bar\n",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := ParsePolicy([]byte(tc.data))
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if got, want := policy, tc.expectedPolicy; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestPolicyPostRenderer(t *testing.T) {
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	testCases := []struct {
+		name               string
+		policy             *Policy
+		input              string
+		expectedViolations []PolicyViolation
+		expectErr          bool
+	}{
+		{
+			name:   "it returns the input without parsing without policy",
+			policy: nil,
+			input:  `anything at : all`,
+		},
+		{
+			name:      "it returns an error if the input cannot be parsed as yaml",
+			policy:    policy,
+			input:     "v: [A,",
+			expectErr: true,
+		},
+		{
+			name:   "it returns the input if the objects comply with the policy",
+			policy: policy,
+			input: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    spec:
+      containers:
+      - name: nginx
+        image: bitnami/nginx:1.19
+        resources:
+          limits:
+            cpu: 100m
+            memory: 128Mi
+        securityContext:
+          privileged: false
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`,
+		},
+		{
+			name:   "it returns the violations of the containers",
+			policy: policy,
+			input: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: registry.example.com/tools/busybox
+        resources:
+          limits:
+            cpu: 100m
+        securityContext:
+          privileged: true
+      containers:
+      - name: nginx
+        image: nginx:1.19
+        resources:
+          limits:
+            cpu: 100m
+            memory: 128Mi
+`,
+			expectedViolations: []PolicyViolation{
+				{Rule: "privileged", Kind: "Deployment", Name: "foo", Container: "init", Message: "the container is privileged"},
+				{Rule: "limits", Kind: "Deployment", Name: "foo", Container: "init", Message: "the container has no memory limits"},
+				{Rule: "registries", Kind: "Deployment", Name: "foo", Container: "nginx", Message: `the image "nginx:1.19" is not from an allowed registry`},
+			},
+		},
+		{
+			name:   "it only applies the rules to their kinds",
+			policy: policy,
+			input: `apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+spec:
+  containers:
+  - name: nginx
+    image: docker.io/bitnami/nginx:1.19
+`,
+		},
+		{
+			name:   "it evaluates the items of lists",
+			policy: policy,
+			input: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: foo
+  spec:
+    containers:
+    - name: nginx
+      image: docker.io/bitnamilabs/nginx:1.19
+`,
+			expectedViolations: []PolicyViolation{
+				{Rule: "registries", Kind: "Pod", Name: "foo", Container: "nginx", Message: `the image "docker.io/bitnamilabs/nginx:1.19" is not from an allowed registry`},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input := bytes.NewBufferString(tc.input)
+			output, err := NewPolicyPostRenderer(tc.policy).Run(input)
+
+			var violationErr *PolicyViolationError
+			if errors.As(err, &violationErr) {
+				if got, want := violationErr.Violations, tc.expectedViolations; !cmp.Equal(want, got) {
+					t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+				}
+				return
+			}
+			if got, want := err != nil || tc.expectedViolations != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if err == nil && output.String() != tc.input {
+				t.Errorf("got: %q, want: %q", output.String(), tc.input)
+			}
+		})
+	}
+}
+
+func TestPolicyLoader(t *testing.T) {
+	var loader *PolicyLoader
+	policy, err := loader.Load()
+	if err != nil || policy != nil {
+		t.Errorf("got: %+v, %v, want: no policy", policy, err)
+	}
+
+	clientset := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy", Namespace: "kubeapps"},
+		Data:       map[string]string{PolicyConfigMapKey: "rules:\n- type: privileged\n"},
+	})
+	policy, err = NewPolicyLoader(clientset, "kubeapps", "policy").Load()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := policy, (&Policy{Rules: []PolicyRule{{Name: "privileged", Type: RulePrivileged}}}); !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+
+	if _, err := NewPolicyLoader(clientset, "kubeapps", "other").Load(); err == nil {
+		t.Errorf("got: nil, want: an error for a missing ConfigMap")
+	}
+}
+
+func TestCreateReleaseViolatingPolicy(t *testing.T) {
+	actionConfig := newActionConfigFixture(t)
+	policy, err := ParsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "mychart", Version: "1.0.0"},
+		Templates: []*chart.File{
+			{
+				Name: "templates/pod.yaml",
+				Data: []byte("apiVersion: v1\nkind: Pod\nmetadata:\n  name: {{ .Release.Name }}\nspec:\n  containers:\n  - name: nginx\n    image: bitnami/nginx\n    securityContext:\n      privileged: true\n"),
+			},
+		},
+	}
+
+	_, err = CreateRelease(actionConfig, "myrls", "default", "", ch, NewPolicyPostRenderer(policy))
+
+	var violationErr *PolicyViolationError
+	if !errors.As(err, &violationErr) {
+		t.Fatalf("got: %v, want: a policy violation", err)
+	}
+	expectedViolations := []PolicyViolation{
+		{Rule: "privileged", Kind: "Pod", Name: "myrls", Container: "nginx", Message: "the container is privileged"},
+	}
+	if got, want := violationErr.Violations, expectedViolations; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if _, err := GetRelease(actionConfig, "myrls"); err == nil {
+		t.Errorf("got: the release installed, want: not installed")
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"io"
+
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/postrender"
+)
+
+// PostRendererChain is a helm post-renderer running post-renderers in
+// order, each on the manifests rendered by the previous one.
+type PostRendererChain []postrender.PostRenderer
+
+// Run returns the manifests rendered by the last post-renderer, stopping at
+// the first error.
+func (c PostRendererChain) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	var err error
+	for _, r := range c {
+		renderedManifests, err = r.Run(renderedManifests)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return renderedManifests, nil
+}
+
+// PostRenderOptions configures the post-renderers of a release.
+type PostRenderOptions struct {
+	// ImageRewriteRules rewrite the images of the containers of the release.
+	ImageRewriteRules []ImageRewriteRule
+	// DigestResolver pins the rewritten images to their digest. They are
+	// not pinned if nil.
+	DigestResolver DigestResolver
+	// RegistrySecrets maps registry domains to the image pull secrets
+	// appended to the pods using their images.
+	RegistrySecrets map[string]string
+	// Metadata is injected in every object of the release.
+	Metadata ReleaseMetadata
+	// Policy is evaluated on the objects as they would be applied. Releases
+	// are not evaluated if nil.
+	Policy *Policy
+}
+
+// NewReleasePostRenderer returns the chain of the post-renderers of a
+// release: the image rewrite, the image pull secrets, the metadata and
+// finally the policy, so that the pull secrets and the policy see the
+// rewritten images and the policy sees the objects as they would be applied.
+func NewReleasePostRenderer(options PostRenderOptions) (PostRendererChain, error) {
+	imageRewritePostRenderer, err := NewImageRewritePostRenderer(options.ImageRewriteRules, options.DigestResolver)
+	if err != nil {
+		return nil, err
+	}
+	dockerSecretsPostRenderer, err := NewDockerSecretsPostRenderer(options.RegistrySecrets)
+	if err != nil {
+		return nil, err
+	}
+	return PostRendererChain{
+		imageRewritePostRenderer,
+		dockerSecretsPostRenderer,
+		NewMetadataPostRenderer(options.Metadata),
+		NewPolicyPostRenderer(options.Policy),
+	}, nil
+}
+
+// decodeManifests returns the objects of rendered manifests.
+func decodeManifests(renderedManifests *bytes.Buffer) ([]interface{}, error) {
+	decoder := yaml.NewDecoder(renderedManifests)
+	var resourceList []interface{}
+	for {
+		var resource interface{}
+		err := decoder.Decode(&resource)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		resourceList = append(resourceList, resource)
+	}
+	return resourceList, nil
+}
+
+// encodeManifests renders objects back to manifests.
+func encodeManifests(resourceList []interface{}) (*bytes.Buffer, error) {
+	modifiedManifests := bytes.NewBuffer([]byte{})
+	encoder := yaml.NewEncoder(modifiedManifests)
+	defer encoder.Close()
+
+	for _, resource := range resourceList {
+		err := encoder.Encode(resource)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return modifiedManifests, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// appendPostRenderer appends a suffix to the manifests.
+type appendPostRenderer struct {
+	suffix string
+	err    error
+}
+
+func (r *appendPostRenderer) Run(renderedManifests *bytes.Buffer) (*bytes.Buffer, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return bytes.NewBufferString(renderedManifests.String() + r.suffix), nil
+}
+
+func TestPostRendererChain(t *testing.T) {
+	testCases := []struct {
+		name      string
+		chain     PostRendererChain
+		output    string
+		expectErr bool
+	}{
+		{
+			name:   "it returns the input without post-renderers",
+			chain:  PostRendererChain{},
+			output: "input",
+		},
+		{
+			name:   "it runs the post-renderers in order",
+			chain:  PostRendererChain{&appendPostRenderer{suffix: "-a"}, &appendPostRenderer{suffix: "-b"}},
+			output: "input-a-b",
+		},
+		{
+			name:      "it stops at the first error",
+			chain:     PostRendererChain{&appendPostRenderer{err: errors.New("boom")}, &appendPostRenderer{suffix: "-b"}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := tc.chain.Run(bytes.NewBufferString("input"))
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if err == nil && output.String() != tc.output {
+				t.Errorf("got: %q, want: %q", output.String(), tc.output)
+			}
+		})
+	}
+}
+
+func TestNewReleasePostRenderer(t *testing.T) {
+	// The rewritten objects comply with the policy, which leaves them
+	// unchanged.
+	policy := &Policy{Rules: []PolicyRule{{Name: "registries", Type: RuleAllowedRegistries, Registries: []string{"example.com"}}}}
+	r, err := NewReleasePostRenderer(PostRenderOptions{
+		ImageRewriteRules: []ImageRewriteRule{{From: "docker.io/*", To: "example.com/dockerhub/*"}},
+		RegistrySecrets:   map[string]string{"example.com": "secret-name"},
+		Metadata:          ReleaseMetadata{Labels: map[string]string{"team": "a"}},
+		Policy:            policy,
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	output, err := r.Run(bytes.NewBufferString(`apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+spec:
+  containers:
+  - image: nginx
+    name: nginx
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := `apiVersion: v1
+kind: Pod
+metadata:
+  labels:
+    team: a
+  name: foo
+spec:
+  containers:
+  - image: example.com/dockerhub/library/nginx:latest
+    name: nginx
+  imagePullSecrets:
+  - name: secret-name
+`
+	if got, want := output.String(), expected; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
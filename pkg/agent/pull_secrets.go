@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// PullSecretLabel marks the image pull secrets copied by Kubeapps into
+	// the namespace of releases.
+	PullSecretLabel = "kubeapps.com/pull-secret"
+	// PullSecretOwnerLabelPrefix prefixes the labels naming the releases
+	// using a copied image pull secret, which is deleted with the last one.
+	PullSecretOwnerLabelPrefix = "owner.kubeapps.com/"
+	// PullSecretSourceAnnotation records the secret an image pull secret is
+	// copied from, as namespace/name.
+	PullSecretSourceAnnotation = "kubeapps.com/pull-secret-source"
+)
+
+// ProvisionPullSecrets copies the image pull secrets of the namespace of an
+// app repository into the namespace of a release, so that the pods of the
+// release can use them, and makes the release one of their owners. Existing
+// copies are updated with the data of their source, and the release stops
+// owning the copies of the secrets which are no longer given. Secrets of
+// the same name not copied by Kubeapps are left untouched.
+func ProvisionPullSecrets(clientset kubernetes.Interface, sourceNamespace string, secretNames []string, namespace, releaseName string) error {
+	if sourceNamespace == namespace {
+		return nil
+	}
+	ownerLabel := PullSecretOwnerLabelPrefix + releaseName
+	secrets := clientset.CoreV1().Secrets(namespace)
+	provisioned := map[string]bool{}
+	for _, name := range secretNames {
+		source, err := clientset.CoreV1().Secrets(sourceNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get the image pull secret %s/%s: %v", sourceNamespace, name, err)
+		}
+		provisioned[name] = true
+
+		existing, err := secrets.Get(context.TODO(), name, metav1.GetOptions{})
+		switch {
+		case k8serrors.IsNotFound(err):
+			_, err = secrets.Create(context.TODO(), &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Namespace:   namespace,
+					Labels:      map[string]string{PullSecretLabel: "true", ownerLabel: "true"},
+					Annotations: map[string]string{PullSecretSourceAnnotation: sourceNamespace + "/" + name},
+				},
+				Type: source.Type,
+				Data: source.Data,
+			}, metav1.CreateOptions{})
+		case err != nil:
+			// The error is returned below.
+		case existing.Labels[PullSecretLabel] != "true":
+			log.Infof("Leaving the secret %s/%s, which was not copied by Kubeapps", namespace, name)
+			continue
+		default:
+			existing.Labels[ownerLabel] = "true"
+			if existing.Annotations == nil {
+				existing.Annotations = map[string]string{}
+			}
+			existing.Annotations[PullSecretSourceAnnotation] = sourceNamespace + "/" + name
+			existing.Data = source.Data
+			_, err = secrets.Update(context.TODO(), existing, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("unable to copy the image pull secret %s/%s to the namespace %s: %v", sourceNamespace, name, namespace, err)
+		}
+	}
+	return releasePullSecrets(clientset, namespace, releaseName, provisioned)
+}
+
+// ReleasePullSecrets removes a release from the owners of the image pull
+// secrets copied into its namespace, deleting the copies it was the last
+// owner of.
+func ReleasePullSecrets(clientset kubernetes.Interface, namespace, releaseName string) error {
+	return releasePullSecrets(clientset, namespace, releaseName, nil)
+}
+
+// releasePullSecrets removes a release from the owners of the copied image
+// pull secrets of its namespace, except those to keep.
+func releasePullSecrets(clientset kubernetes.Interface, namespace, releaseName string, keep map[string]bool) error {
+	ownerLabel := PullSecretOwnerLabelPrefix + releaseName
+	secrets := clientset.CoreV1().Secrets(namespace)
+	list, err := secrets.List(context.TODO(), metav1.ListOptions{LabelSelector: PullSecretLabel + "=true," + ownerLabel})
+	if err != nil {
+		return fmt.Errorf("unable to list the image pull secrets of the namespace %s: %v", namespace, err)
+	}
+	for i := range list.Items {
+		secret := &list.Items[i]
+		if keep[secret.Name] {
+			continue
+		}
+		delete(secret.Labels, ownerLabel)
+		if hasPullSecretOwners(secret) {
+			_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+		} else {
+			err = secrets.Delete(context.TODO(), secret.Name, metav1.DeleteOptions{})
+		}
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("unable to release the image pull secret %s/%s: %v", namespace, secret.Name, err)
+		}
+	}
+	return nil
+}
+
+func hasPullSecretOwners(secret *corev1.Secret) bool {
+	for label := range secret.Labels {
+		if strings.HasPrefix(label, PullSecretOwnerLabelPrefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func pullSecret(namespace, name, data string, labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(data)},
+	}
+}
+
+// namespaceSecrets returns the secrets of a namespace by name.
+func namespaceSecrets(t *testing.T, clientset *fake.Clientset, namespace string) map[string]*corev1.Secret {
+	list, err := clientset.CoreV1().Secrets(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	secrets := map[string]*corev1.Secret{}
+	for i := range list.Items {
+		secrets[list.Items[i].Name] = &list.Items[i]
+	}
+	return secrets
+}
+
+func TestProvisionPullSecrets(t *testing.T) {
+	testCases := []struct {
+		name           string
+		existing       []runtime.Object
+		secretNames    []string
+		namespace      string
+		expectedData   map[string]string
+		expectedOwners map[string][]string
+		expectErr      bool
+	}{
+		{
+			name:           "it copies the secrets into the namespace of the release",
+			secretNames:    []string{"registry-a", "registry-b"},
+			namespace:      "target",
+			expectedData:   map[string]string{"registry-a": "a", "registry-b": "b"},
+			expectedOwners: map[string][]string{"registry-a": {"foo"}, "registry-b": {"foo"}},
+		},
+		{
+			name: "it updates the copies and adds the release to their owners",
+			existing: []runtime.Object{
+				pullSecret("target", "registry-a", "outdated", map[string]string{PullSecretLabel: "true", PullSecretOwnerLabelPrefix + "bar": "true"}),
+			},
+			secretNames:    []string{"registry-a"},
+			namespace:      "target",
+			expectedData:   map[string]string{"registry-a": "a"},
+			expectedOwners: map[string][]string{"registry-a": {"bar", "foo"}},
+		},
+		{
+			name: "it leaves the secrets not copied by Kubeapps",
+			existing: []runtime.Object{
+				pullSecret("target", "registry-a", "own", nil),
+			},
+			secretNames:    []string{"registry-a"},
+			namespace:      "target",
+			expectedData:   map[string]string{"registry-a": "own"},
+			expectedOwners: map[string][]string{"registry-a": {}},
+		},
+		{
+			name: "it releases the copies of the secrets no longer used",
+			existing: []runtime.Object{
+				pullSecret("target", "registry-a", "a", map[string]string{PullSecretLabel: "true", PullSecretOwnerLabelPrefix + "foo": "true"}),
+				pullSecret("target", "registry-b", "b", map[string]string{PullSecretLabel: "true", PullSecretOwnerLabelPrefix + "foo": "true"}),
+			},
+			secretNames:    []string{"registry-a"},
+			namespace:      "target",
+			expectedData:   map[string]string{"registry-a": "a"},
+			expectedOwners: map[string][]string{"registry-a": {"foo"}},
+		},
+		{
+			name:         "it does nothing in the namespace of the app repository",
+			secretNames:  []string{"registry-a"},
+			namespace:    "repo-namespace",
+			expectedData: map[string]string{"registry-a": "a", "registry-b": "b", "unrelated": "c"},
+			expectedOwners: map[string][]string{
+				"registry-a": {}, "registry-b": {}, "unrelated": {},
+			},
+		},
+		{
+			name:        "it returns an error for a missing secret",
+			secretNames: []string{"missing"},
+			namespace:   "target",
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			objects := append([]runtime.Object{
+				pullSecret("repo-namespace", "registry-a", "a", nil),
+				pullSecret("repo-namespace", "registry-b", "b", nil),
+				pullSecret("repo-namespace", "unrelated", "c", nil),
+			}, tc.existing...)
+			clientset := fake.NewSimpleClientset(objects...)
+
+			err := ProvisionPullSecrets(clientset, "repo-namespace", tc.secretNames, tc.namespace, "foo")
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if tc.expectErr {
+				return
+			}
+
+			data := map[string]string{}
+			owners := map[string][]string{}
+			for name, secret := range namespaceSecrets(t, clientset, tc.namespace) {
+				data[name] = string(secret.Data[corev1.DockerConfigJsonKey])
+				owners[name] = pullSecretOwners(secret)
+				if secret.Labels[PullSecretLabel] == "true" {
+					if got, want := secret.Annotations[PullSecretSourceAnnotation], "repo-namespace/"+name; got != want {
+						t.Errorf("got: %q, want: %q", got, want)
+					}
+				}
+			}
+			if got, want := data, tc.expectedData; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+			if got, want := owners, tc.expectedOwners; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestReleasePullSecrets(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		pullSecret("target", "owned", "a", map[string]string{PullSecretLabel: "true", PullSecretOwnerLabelPrefix + "foo": "true"}),
+		pullSecret("target", "shared", "b", map[string]string{PullSecretLabel: "true", PullSecretOwnerLabelPrefix + "foo": "true", PullSecretOwnerLabelPrefix + "bar": "true"}),
+		pullSecret("target", "other", "c", map[string]string{PullSecretLabel: "true", PullSecretOwnerLabelPrefix + "bar": "true"}),
+		pullSecret("target", "unmanaged", "d", map[string]string{PullSecretOwnerLabelPrefix + "foo": "true"}),
+	)
+
+	if err := ReleasePullSecrets(clientset, "target", "foo"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	owners := map[string][]string{}
+	for name, secret := range namespaceSecrets(t, clientset, "target") {
+		owners[name] = pullSecretOwners(secret)
+	}
+	expected := map[string][]string{"shared": {"bar"}, "other": {"bar"}, "unmanaged": {"foo"}}
+	if got, want := owners, expected; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+// pullSecretOwners returns the sorted names of the releases owning a secret.
+func pullSecretOwners(secret *corev1.Secret) []string {
+	owners := []string{}
+	for label := range secret.Labels {
+		if strings.HasPrefix(label, PullSecretOwnerLabelPrefix) {
+			owners = append(owners, strings.TrimPrefix(label, PullSecretOwnerLabelPrefix))
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// rootField is the field gojsonschema reports for errors of the whole values.
+const rootField = "(root)"
+
+// FieldError is an error of the value at a path of the values of a release.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValuesValidationError is returned when the values of a release do not meet
+// the JSON schemas of its chart.
+type ValuesValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValuesValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Message)
+	}
+	// Helm reports schema errors with the same sentence.
+	return fmt.Sprintf("values don't meet the specifications of the schema: %s", strings.Join(messages, "; "))
+}
+
+// ValidateValues validates the values of a release, merged with the default
+// values of the chart, against the JSON schemas of the chart and its enabled
+// subcharts as Helm does when rendering the release, so that invalid values
+// are reported before installing or upgrading it. The values of the release
+// being upgraded, nil for an install, are validated instead of empty values
+// since Helm upgrades the release with them.
+func ValidateValues(ch *chart.Chart, valuesYaml string, releaseValues map[string]interface{}) error {
+	values, err := chartutil.ReadValues([]byte(valuesYaml))
+	if err != nil {
+		return fmt.Errorf("Unable to validate the values because they could not be parsed: %v", err)
+	}
+	values = copyValues(upgradeValues(values, releaseValues))
+	// Subcharts disabled by their condition or tags are removed from the
+	// chart, as Helm does before rendering it.
+	if err := chartutil.ProcessDependencies(ch, values); err != nil {
+		return err
+	}
+	values, err = chartutil.CoalesceValues(ch, values)
+	if err != nil {
+		return err
+	}
+	fieldErrors, err := validateAgainstSchema(ch, values, "")
+	if err != nil {
+		return err
+	}
+	if len(fieldErrors) > 0 {
+		sort.SliceStable(fieldErrors, func(i, j int) bool {
+			return fieldErrors[i].Field < fieldErrors[j].Field
+		})
+		return &ValuesValidationError{Errors: fieldErrors}
+	}
+	return nil
+}
+
+// upgradeValues returns the values Helm upgrades a release with. Since the
+// values of the release are not reused, Helm only keeps them when no values
+// are given.
+func upgradeValues(values, releaseValues map[string]interface{}) map[string]interface{} {
+	if len(values) == 0 && len(releaseValues) > 0 {
+		return releaseValues
+	}
+	return values
+}
+
+// validateAgainstSchema returns the errors of the values of a chart, whose
+// fields are prefixed with the path of the chart within the parent chart.
+func validateAgainstSchema(ch *chart.Chart, values map[string]interface{}, prefix string) ([]FieldError, error) {
+	fieldErrors := []FieldError{}
+	if len(ch.Schema) > 0 {
+		valuesJSON, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(ch.Schema), gojsonschema.NewBytesLoader(valuesJSON))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to validate the values against the schema of chart %s: %v", ch.Name(), err)
+		}
+		for _, resultErr := range result.Errors() {
+			field := fieldPath(prefix, resultErr.Field())
+			// Missing properties are reported on their parent.
+			if property, ok := resultErr.Details()["property"].(string); ok && resultErr.Type() == "required" {
+				field = fieldPath(field, property)
+			}
+			if field == "" {
+				field = rootField
+			}
+			fieldErrors = append(fieldErrors, FieldError{Field: field, Message: resultErr.Description()})
+		}
+	}
+	for _, subchart := range ch.Dependencies() {
+		subchartValues, ok := values[subchart.Name()].(map[string]interface{})
+		if !ok {
+			subchartValues = map[string]interface{}{}
+		}
+		subchartErrors, err := validateAgainstSchema(subchart, subchartValues, fieldPath(prefix, subchart.Name()))
+		if err != nil {
+			return nil, err
+		}
+		fieldErrors = append(fieldErrors, subchartErrors...)
+	}
+	return fieldErrors, nil
+}
+
+func fieldPath(prefix, field string) string {
+	switch {
+	case field == rootField || field == "":
+		return prefix
+	case prefix == "":
+		return field
+	default:
+		return prefix + "." + field
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+const testSchema = `{
+  "type": "object",
+  "required": ["image"],
+  "properties": {
+    "image": {
+      "type": "object",
+      "required": ["repository"],
+      "properties": {
+        "repository": {"type": "string"},
+        "tag": {"type": "string"}
+      }
+    },
+    "replicaCount": {"type": "integer"}
+  }
+}`
+
+func TestValidateValues(t *testing.T) {
+	newChart := func() *chart.Chart {
+		ch := &chart.Chart{
+			Metadata: &chart.Metadata{Name: "apache"},
+			Values:   map[string]interface{}{"image": map[string]interface{}{"repository": "bitnami/apache"}},
+			Schema:   []byte(testSchema),
+		}
+		ch.Metadata.Dependencies = []*chart.Dependency{{Name: "redis", Condition: "redis.enabled"}}
+		ch.AddDependency(&chart.Chart{
+			Metadata: &chart.Metadata{Name: "redis"},
+			Values:   map[string]interface{}{"port": 6379},
+			Schema:   []byte(`{"type": "object", "properties": {"port": {"type": "integer"}}}`),
+		})
+		return ch
+	}
+
+	testCases := []struct {
+		name           string
+		chart          *chart.Chart
+		values         string
+		releaseValues  map[string]interface{}
+		expectedErrors []FieldError
+	}{
+		{
+			name:   "accepts values meeting the schema",
+			chart:  newChart(),
+			values: "replicaCount: 2\nimage:\n  tag: 2.4.43",
+		},
+		{
+			name:   "accepts any values of a chart without schema",
+			chart:  &chart.Chart{Metadata: &chart.Metadata{Name: "apache"}},
+			values: "replicaCount: many",
+		},
+		{
+			name:   "returns the paths of the invalid fields",
+			chart:  newChart(),
+			values: "replicaCount: many\nimage:\n  tag: 2\nredis:\n  port: default",
+			expectedErrors: []FieldError{
+				{Field: "image.tag", Message: "Invalid type. Expected: string, given: integer"},
+				{Field: "redis.port", Message: "Invalid type. Expected: integer, given: string"},
+				{Field: "replicaCount", Message: "Invalid type. Expected: integer, given: string"},
+			},
+		},
+		{
+			name: "returns the missing required fields",
+			chart: func() *chart.Chart {
+				ch := newChart()
+				ch.Values = map[string]interface{}{}
+				return ch
+			}(),
+			values: "",
+			expectedErrors: []FieldError{
+				{Field: "image", Message: "image is required"},
+			},
+		},
+		{
+			name:   "ignores the schemas of disabled subcharts",
+			chart:  newChart(),
+			values: "redis:\n  enabled: false\n  port: default",
+		},
+		{
+			name:          "validates the release values reused for empty values",
+			chart:         newChart(),
+			values:        "",
+			releaseValues: map[string]interface{}{"replicaCount": "many"},
+			expectedErrors: []FieldError{
+				{Field: "replicaCount", Message: "Invalid type. Expected: integer, given: string"},
+			},
+		},
+		{
+			name:          "ignores the release values replaced by the values",
+			chart:         newChart(),
+			values:        "replicaCount: 2",
+			releaseValues: map[string]interface{}{"replicaCount": "many"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateValues(tc.chart, tc.values, tc.releaseValues)
+			if tc.expectedErrors == nil {
+				if err != nil {
+					t.Fatalf("%+v", err)
+				}
+				return
+			}
+			validationErr, ok := err.(*ValuesValidationError)
+			if !ok {
+				t.Fatalf("got: %v, want: a ValuesValidationError", err)
+			}
+			if got, want := validationErr.Errors, tc.expectedErrors; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
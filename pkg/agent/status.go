@@ -0,0 +1,262 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubeapps/kubeapps/pkg/yaml"
+	"helm.sh/helm/v3/pkg/release"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+)
+
+// Readiness of a resource, or of a release as a whole.
+const (
+	StatusReady       = "ready"
+	StatusProgressing = "progressing"
+	StatusFailed      = "failed"
+	// StatusUnknown is the status of a resource which could not be fetched,
+	// for example because the user is not allowed to read it.
+	StatusUnknown = "unknown"
+)
+
+// ReleaseStatus is the aggregated readiness of the resources of a release.
+type ReleaseStatus struct {
+	Status    string           `json:"status"`
+	Resources []ResourceStatus `json:"resources"`
+}
+
+// ResourceStatus is the readiness of a single resource of a release.
+type ResourceStatus struct {
+	APIVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Namespace  string              `json:"namespace,omitempty"`
+	Name       string              `json:"name"`
+	Status     string              `json:"status"`
+	Message    string              `json:"message,omitempty"`
+	Replicas   *ReplicaCounts      `json:"replicas,omitempty"`
+	Conditions []ResourceCondition `json:"conditions,omitempty"`
+}
+
+// ReplicaCounts are the desired and observed replicas of a workload.
+type ReplicaCounts struct {
+	Desired   int64 `json:"desired"`
+	Ready     int64 `json:"ready"`
+	Updated   int64 `json:"updated"`
+	Available int64 `json:"available"`
+}
+
+// ResourceCondition is a condition reported in the status of a resource.
+type ResourceCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// GetReleaseStatus fetches every object of the release manifest and returns
+// their readiness. The objects are fetched with the given client, so only
+// resources the user has access to are inspected: the status of the others
+// is unknown.
+func GetReleaseStatus(rel *release.Release, client dynamic.Interface, mapper meta.RESTMapper) (*ReleaseStatus, error) {
+	objects, err := yaml.ParseObjects(rel.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	status := &ReleaseStatus{Status: StatusReady, Resources: []ResourceStatus{}}
+	for _, obj := range objects {
+		resourceStatus := ResourceStatus{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Name:       obj.GetName(),
+		}
+		current, namespace, err := getObject(obj, rel.Namespace, client, mapper)
+		resourceStatus.Namespace = namespace
+		if k8serrors.IsNotFound(err) {
+			resourceStatus.Status = StatusFailed
+			resourceStatus.Message = "resource not found"
+		} else if err != nil {
+			resourceStatus.Status = StatusUnknown
+			resourceStatus.Message = err.Error()
+		} else {
+			setResourceStatus(&resourceStatus, current)
+		}
+		status.Resources = append(status.Resources, resourceStatus)
+		status.Status = worstStatus(status.Status, resourceStatus.Status)
+	}
+	return status, nil
+}
+
+// getObject fetches the current state of a manifest object.
+func getObject(obj *unstructured.Unstructured, releaseNamespace string, client dynamic.Interface, mapper meta.RESTMapper) (*unstructured.Unstructured, string, error) {
+	resource, namespace, err := resourceFor(obj, releaseNamespace, client, mapper)
+	if err != nil {
+		return nil, "", err
+	}
+	current, err := resource.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+	return current, namespace, err
+}
+
+// resourceFor returns the client for the resource of a manifest object,
+// defaulting its namespace to the release namespace for namespaced resources.
+func resourceFor(obj *unstructured.Unstructured, releaseNamespace string, client dynamic.Interface, mapper meta.RESTMapper) (dynamic.ResourceInterface, string, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, "", err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return client.Resource(mapping.Resource), "", nil
+	}
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = releaseNamespace
+	}
+	return client.Resource(mapping.Resource).Namespace(namespace), namespace, nil
+}
+
+// worstStatus returns the least ready of two statuses.
+func worstStatus(a, b string) string {
+	rank := map[string]int{StatusReady: 0, StatusUnknown: 1, StatusProgressing: 2, StatusFailed: 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func setResourceStatus(s *ResourceStatus, obj *unstructured.Unstructured) {
+	s.Conditions = getConditions(obj)
+	s.Status = StatusReady
+	switch obj.GetKind() {
+	case "Deployment":
+		desired := getInt(obj, 1, "spec", "replicas")
+		s.Replicas = &ReplicaCounts{
+			Desired:   desired,
+			Ready:     getInt(obj, 0, "status", "readyReplicas"),
+			Updated:   getInt(obj, 0, "status", "updatedReplicas"),
+			Available: getInt(obj, 0, "status", "availableReplicas"),
+		}
+		for _, c := range s.Conditions {
+			if c.Type == "Progressing" && c.Reason == "ProgressDeadlineExceeded" {
+				s.Status = StatusFailed
+				s.Message = c.Message
+				return
+			}
+		}
+		if !observedGenerationIsCurrent(obj) || s.Replicas.Updated < desired || s.Replicas.Available < desired {
+			s.Status = StatusProgressing
+		}
+	case "StatefulSet":
+		desired := getInt(obj, 1, "spec", "replicas")
+		s.Replicas = &ReplicaCounts{
+			Desired: desired,
+			Ready:   getInt(obj, 0, "status", "readyReplicas"),
+			Updated: getInt(obj, 0, "status", "updatedReplicas"),
+		}
+		s.Replicas.Available = s.Replicas.Ready
+		currentRevision, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+		updateRevision, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+		if !observedGenerationIsCurrent(obj) || s.Replicas.Ready < desired || currentRevision != updateRevision {
+			s.Status = StatusProgressing
+		}
+	case "DaemonSet":
+		desired := getInt(obj, 0, "status", "desiredNumberScheduled")
+		s.Replicas = &ReplicaCounts{
+			Desired:   desired,
+			Ready:     getInt(obj, 0, "status", "numberReady"),
+			Updated:   getInt(obj, 0, "status", "updatedNumberScheduled"),
+			Available: getInt(obj, 0, "status", "numberAvailable"),
+		}
+		if !observedGenerationIsCurrent(obj) || s.Replicas.Updated < desired || s.Replicas.Available < desired {
+			s.Status = StatusProgressing
+		}
+	case "Job":
+		for _, c := range s.Conditions {
+			if c.Status != "True" {
+				continue
+			}
+			if c.Type == "Failed" {
+				s.Status = StatusFailed
+				s.Message = c.Message
+				return
+			}
+			if c.Type == "Complete" {
+				return
+			}
+		}
+		s.Status = StatusProgressing
+	case "Pod":
+		phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+		switch phase {
+		case "Succeeded":
+		case "Failed":
+			s.Status = StatusFailed
+		case "Running":
+			for _, c := range s.Conditions {
+				if c.Type == "Ready" && c.Status != "True" {
+					s.Status = StatusProgressing
+				}
+			}
+		default:
+			s.Status = StatusProgressing
+		}
+		if s.Status != StatusReady {
+			s.Message = fmt.Sprintf("pod phase is %s", phase)
+		}
+	}
+}
+
+func observedGenerationIsCurrent(obj *unstructured.Unstructured) bool {
+	observed, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	return !found || observed >= obj.GetGeneration()
+}
+
+// getInt returns an integer field of an object or the given default if the
+// field is not set.
+func getInt(obj *unstructured.Unstructured, defaultValue int64, fields ...string) int64 {
+	value, found, err := unstructured.NestedInt64(obj.Object, fields...)
+	if !found || err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getConditions(obj *unstructured.Unstructured) []ResourceCondition {
+	items, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	conditions := []ResourceCondition{}
+	for _, item := range items {
+		c, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		condition := ResourceCondition{}
+		condition.Type, _, _ = unstructured.NestedString(c, "type")
+		condition.Status, _, _ = unstructured.NestedString(c, "status")
+		condition.Reason, _, _ = unstructured.NestedString(c, "reason")
+		condition.Message, _, _ = unstructured.NestedString(c, "message")
+		conditions = append(conditions, condition)
+	}
+	if len(conditions) == 0 {
+		return nil
+	}
+	return conditions
+}
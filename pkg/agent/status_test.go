@@ -0,0 +1,312 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/release"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const deploymentManifest = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+`
+
+// newRESTMapperFixture returns a RESTMapper knowing the kinds used in tests.
+func newRESTMapperFixture() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "StatefulSet"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	return mapper
+}
+
+func deployment(status map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":       "foo",
+			"namespace":  "default",
+			"generation": int64(2),
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+		},
+		"status": status,
+	}}
+}
+
+func TestGetReleaseStatus(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		manifest string
+		objects  []runtime.Object
+		expected *ReleaseStatus
+	}{
+		{
+			desc:     "returns a ready deployment",
+			manifest: deploymentManifest,
+			objects: []runtime.Object{
+				deployment(map[string]interface{}{
+					"observedGeneration": int64(2),
+					"readyReplicas":      int64(2),
+					"updatedReplicas":    int64(2),
+					"availableReplicas":  int64(2),
+				}),
+			},
+			expected: &ReleaseStatus{
+				Status: StatusReady,
+				Resources: []ResourceStatus{
+					{
+						APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "foo",
+						Status:   StatusReady,
+						Replicas: &ReplicaCounts{Desired: 2, Ready: 2, Updated: 2, Available: 2},
+					},
+				},
+			},
+		},
+		{
+			desc:     "returns a progressing deployment",
+			manifest: deploymentManifest,
+			objects: []runtime.Object{
+				deployment(map[string]interface{}{
+					"observedGeneration": int64(2),
+					"readyReplicas":      int64(1),
+					"updatedReplicas":    int64(2),
+					"availableReplicas":  int64(1),
+				}),
+			},
+			expected: &ReleaseStatus{
+				Status: StatusProgressing,
+				Resources: []ResourceStatus{
+					{
+						APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "foo",
+						Status:   StatusProgressing,
+						Replicas: &ReplicaCounts{Desired: 2, Ready: 1, Updated: 2, Available: 1},
+					},
+				},
+			},
+		},
+		{
+			desc:     "returns a failed deployment",
+			manifest: deploymentManifest,
+			objects: []runtime.Object{
+				deployment(map[string]interface{}{
+					"observedGeneration": int64(2),
+					"conditions": []interface{}{
+						map[string]interface{}{
+							"type":    "Progressing",
+							"status":  "False",
+							"reason":  "ProgressDeadlineExceeded",
+							"message": `ReplicaSet "foo-123" has timed out progressing.`,
+						},
+					},
+				}),
+			},
+			expected: &ReleaseStatus{
+				Status: StatusFailed,
+				Resources: []ResourceStatus{
+					{
+						APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "foo",
+						Status:   StatusFailed,
+						Message:  `ReplicaSet "foo-123" has timed out progressing.`,
+						Replicas: &ReplicaCounts{Desired: 2},
+						Conditions: []ResourceCondition{
+							{Type: "Progressing", Status: "False", Reason: "ProgressDeadlineExceeded", Message: `ReplicaSet "foo-123" has timed out progressing.`},
+						},
+					},
+				},
+			},
+		},
+		{
+			desc: "returns missing resources as failed and cluster-wide resources without namespace",
+			manifest: `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: foo
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+  namespace: other
+`,
+			objects: []runtime.Object{
+				&unstructured.Unstructured{Object: map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "Namespace",
+					"metadata":   map[string]interface{}{"name": "foo"},
+				}},
+			},
+			expected: &ReleaseStatus{
+				Status: StatusFailed,
+				Resources: []ResourceStatus{
+					{APIVersion: "v1", Kind: "Namespace", Name: "foo", Status: StatusReady},
+					{APIVersion: "v1", Kind: "ConfigMap", Namespace: "other", Name: "foo", Status: StatusFailed, Message: "resource not found"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			client := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme(), tc.objects...)
+			rel := &release.Release{Name: "foo", Namespace: "default", Manifest: tc.manifest}
+
+			status, err := GetReleaseStatus(rel, client, newRESTMapperFixture())
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := status, tc.expected; !cmp.Equal(got, want) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestGetReleaseStatusWithoutAccess(t *testing.T) {
+	manifest := `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: foo
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`
+	client := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme(), &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Namespace",
+		"metadata":   map[string]interface{}{"name": "foo"},
+	}})
+	forbidden := k8serrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, "foo", errors.New("access denied"))
+	client.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, forbidden
+	})
+	rel := &release.Release{Name: "foo", Namespace: "default", Manifest: manifest}
+
+	status, err := GetReleaseStatus(rel, client, newRESTMapperFixture())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := &ReleaseStatus{
+		Status: StatusUnknown,
+		Resources: []ResourceStatus{
+			{APIVersion: "v1", Kind: "Namespace", Name: "foo", Status: StatusReady},
+			{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "foo", Status: StatusUnknown, Message: forbidden.Error()},
+		},
+	}
+	if got, want := status, expected; !cmp.Equal(got, want) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestSetResourceStatus(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		object   map[string]interface{}
+		expected string
+	}{
+		{
+			desc: "statefulset with a pending update is progressing",
+			object: map[string]interface{}{
+				"kind": "StatefulSet",
+				"spec": map[string]interface{}{"replicas": int64(1)},
+				"status": map[string]interface{}{
+					"readyReplicas":   int64(1),
+					"currentRevision": "foo-1",
+					"updateRevision":  "foo-2",
+				},
+			},
+			expected: StatusProgressing,
+		},
+		{
+			desc: "daemonset scheduled everywhere is ready",
+			object: map[string]interface{}{
+				"kind": "DaemonSet",
+				"status": map[string]interface{}{
+					"desiredNumberScheduled": int64(3),
+					"numberReady":            int64(3),
+					"updatedNumberScheduled": int64(3),
+					"numberAvailable":        int64(3),
+				},
+			},
+			expected: StatusReady,
+		},
+		{
+			desc: "complete job is ready",
+			object: map[string]interface{}{
+				"kind": "Job",
+				"status": map[string]interface{}{
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Complete", "status": "True"},
+					},
+				},
+			},
+			expected: StatusReady,
+		},
+		{
+			desc: "failed job is failed",
+			object: map[string]interface{}{
+				"kind": "Job",
+				"status": map[string]interface{}{
+					"conditions": []interface{}{
+						map[string]interface{}{"type": "Failed", "status": "True", "message": "BackoffLimitExceeded"},
+					},
+				},
+			},
+			expected: StatusFailed,
+		},
+		{
+			desc: "pending pod is progressing",
+			object: map[string]interface{}{
+				"kind":   "Pod",
+				"status": map[string]interface{}{"phase": "Pending"},
+			},
+			expected: StatusProgressing,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := &ResourceStatus{}
+			setResourceStatus(s, &unstructured.Unstructured{Object: tc.object})
+			if got, want := s.Status, tc.expected; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+// globalKey is the key of the values shared with the subcharts, which is
+// accepted even if the chart does not define it.
+const globalKey = "global"
+
+// ValuesPreview is the configuration a release would be rendered with.
+type ValuesPreview struct {
+	// Values are the chart default values coalesced with the values of the
+	// request, or the values of the existing release if the request has
+	// none, as YAML.
+	Values string `json:"values"`
+	// Overrides are the paths of the default values which are changed.
+	Overrides []string `json:"overrides"`
+	// Unknown are the paths of the values of the request which the default
+	// values of the chart do not define, likely typos.
+	Unknown []string `json:"unknown"`
+}
+
+// PreviewValues coalesces the default values of a chart with the given
+// values. As when upgrading a release, the values of the existing release,
+// which may be nil, are only used when no values are given.
+func PreviewValues(ch *chart.Chart, releaseValues map[string]interface{}, valuesYaml string) (*ValuesPreview, error) {
+	values, err := chartutil.ReadValues([]byte(valuesYaml))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to preview the values because they could not be parsed: %v", err)
+	}
+	// The coalescing functions modify the tables they are given.
+	defaults, err := chartutil.CoalesceValues(ch, map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	unknown := unknownValues(values, defaults, "")
+
+	overriding := copyValues(upgradeValues(values, releaseValues))
+	overrides := overriddenValues(overriding, defaults, "")
+	coalesced, err := chartutil.CoalesceValues(ch, copyValues(overriding))
+	if err != nil {
+		return nil, err
+	}
+	coalescedYaml, err := yaml.Marshal(coalesced)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(overrides)
+	sort.Strings(unknown)
+	return &ValuesPreview{
+		Values:    string(coalescedYaml),
+		Overrides: overrides,
+		Unknown:   unknown,
+	}, nil
+}
+
+// overriddenValues returns the paths of the default values which the values
+// change, descending into the tables of both.
+func overriddenValues(values, defaults map[string]interface{}, prefix string) []string {
+	paths := []string{}
+	for key, value := range values {
+		path := valuePath(prefix, key)
+		defaultValue, ok := defaults[key]
+		valueTable, isTable := value.(map[string]interface{})
+		defaultTable, isDefaultTable := defaultValue.(map[string]interface{})
+		if ok && isTable && isDefaultTable {
+			paths = append(paths, overriddenValues(valueTable, defaultTable, path)...)
+		} else if ok && !reflect.DeepEqual(value, defaultValue) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// unknownValues returns the paths of the values which are not defined in the
+// defaults. Tables which are empty in the defaults, like annotations, accept
+// any key.
+func unknownValues(values, defaults map[string]interface{}, prefix string) []string {
+	paths := []string{}
+	for key, value := range values {
+		path := valuePath(prefix, key)
+		defaultValue, ok := defaults[key]
+		if !ok {
+			if key != globalKey && (prefix == "" || len(defaults) > 0) {
+				paths = append(paths, path)
+			}
+			continue
+		}
+		valueTable, isTable := value.(map[string]interface{})
+		defaultTable, isDefaultTable := defaultValue.(map[string]interface{})
+		if isTable && isDefaultTable {
+			paths = append(paths, unknownValues(valueTable, defaultTable, path)...)
+		}
+	}
+	return paths
+}
+
+func valuePath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// copyValues returns a deep copy of the tables of some values.
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if table, ok := value.(map[string]interface{}); ok {
+			value = copyValues(table)
+		}
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+func newValuesChart() *chart.Chart {
+	return &chart.Chart{
+		Metadata: &chart.Metadata{Name: "apache"},
+		Values: map[string]interface{}{
+			"replicaCount":   1.0,
+			"image":          map[string]interface{}{"repository": "bitnami/apache", "tag": "2.4.43"},
+			"podAnnotations": map[string]interface{}{},
+		},
+	}
+}
+
+func TestPreviewValues(t *testing.T) {
+	testCases := []struct {
+		name          string
+		releaseValues map[string]interface{}
+		values        string
+		expected      *ValuesPreview
+	}{
+		{
+			name:   "returns the defaults without values",
+			values: "",
+			expected: &ValuesPreview{
+				Values:    "image:\n  repository: bitnami/apache\n  tag: 2.4.43\npodAnnotations: {}\nreplicaCount: 1\n",
+				Overrides: []string{},
+				Unknown:   []string{},
+			},
+		},
+		{
+			name:   "returns the overridden and unknown values",
+			values: "replicaCount: 1\nimage:\n  tag: 2.4.46\n  pullPolicy: Always\npodAnnotations:\n  foo: bar\nreplicas: 3\n",
+			expected: &ValuesPreview{
+				Values:    "image:\n  pullPolicy: Always\n  repository: bitnami/apache\n  tag: 2.4.46\npodAnnotations:\n  foo: bar\nreplicaCount: 1\nreplicas: 3\n",
+				Overrides: []string{"image.tag"},
+				Unknown:   []string{"image.pullPolicy", "replicas"},
+			},
+		},
+		{
+			name:          "keeps the values of the existing release without values",
+			releaseValues: map[string]interface{}{"replicaCount": 2.0, "image": map[string]interface{}{"tag": "2.4.41"}},
+			values:        "",
+			expected: &ValuesPreview{
+				Values:    "image:\n  repository: bitnami/apache\n  tag: 2.4.41\npodAnnotations: {}\nreplicaCount: 2\n",
+				Overrides: []string{"image.tag", "replicaCount"},
+				Unknown:   []string{},
+			},
+		},
+		{
+			name:          "replaces the values of the existing release with the values",
+			releaseValues: map[string]interface{}{"replicaCount": 2.0, "image": map[string]interface{}{"tag": "2.4.41"}},
+			values:        "image:\n  tag: 2.4.46\n",
+			expected: &ValuesPreview{
+				Values:    "image:\n  repository: bitnami/apache\n  tag: 2.4.46\npodAnnotations: {}\nreplicaCount: 1\n",
+				Overrides: []string{"image.tag"},
+				Unknown:   []string{},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			preview, err := PreviewValues(newValuesChart(), tc.releaseValues, tc.values)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := preview, tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+
+	t.Run("checks the values of the subcharts", func(t *testing.T) {
+		ch := newValuesChart()
+		ch.AddDependency(&chart.Chart{
+			Metadata: &chart.Metadata{Name: "redis"},
+			Values:   map[string]interface{}{"port": 6379.0},
+		})
+
+		preview, err := PreviewValues(ch, nil, "redis:\n  port: 6380\n  password: foo\nglobal:\n  imageRegistry: quay.io\n")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		if got, want := preview.Overrides, []string{"redis.port"}; !cmp.Equal(want, got) {
+			t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+		}
+		if got, want := preview.Unknown, []string{"redis.password"}; !cmp.Equal(want, got) {
+			t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+		}
+	})
+}
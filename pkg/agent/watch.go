@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kubeapps/kubeapps/pkg/yaml"
+	"helm.sh/helm/v3/pkg/release"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// EventUnknown is the type of the event sent for a resource which can't be
+// watched, for example because its kind is not known by the cluster.
+const EventUnknown = "UNKNOWN"
+
+// ResourceEvent is a change of one of the resources of a release.
+type ResourceEvent struct {
+	Type     string         `json:"type"`
+	Resource ResourceStatus `json:"resource"`
+}
+
+// WatchRelease watches every object of the release manifest and sends their
+// changes on the returned channel. The channel is closed once the context is
+// cancelled or a watch fails, for example because the user lost access to a
+// resource. In the latter case the error is then available on the returned
+// error channel.
+func WatchRelease(ctx context.Context, rel *release.Release, client dynamic.Interface, mapper meta.RESTMapper) (<-chan ResourceEvent, <-chan error, error) {
+	objects, err := yaml.ParseObjects(rel.Manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	type watchedObject struct {
+		resource dynamic.ResourceInterface
+		status   ResourceStatus
+	}
+	watched := []watchedObject{}
+	unknown := []ResourceStatus{}
+	for _, obj := range objects {
+		resource, namespace, err := resourceFor(obj, rel.Namespace, client, mapper)
+		if err != nil {
+			// The status of the object is unknown, as reported by
+			// GetReleaseStatus, and the other objects are still watched.
+			unknown = append(unknown, ResourceStatus{
+				APIVersion: obj.GetAPIVersion(),
+				Kind:       obj.GetKind(),
+				Name:       obj.GetName(),
+				Status:     StatusUnknown,
+				Message:    err.Error(),
+			})
+			continue
+		}
+		watched = append(watched, watchedObject{
+			resource: resource,
+			status: ResourceStatus{
+				APIVersion: obj.GetAPIVersion(),
+				Kind:       obj.GetKind(),
+				Namespace:  namespace,
+				Name:       obj.GetName(),
+			},
+		})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	events := make(chan ResourceEvent)
+	errs := make(chan error, 1)
+	var wg sync.WaitGroup
+	for _, status := range unknown {
+		wg.Add(1)
+		go func(status ResourceStatus) {
+			defer wg.Done()
+			select {
+			case events <- ResourceEvent{Type: EventUnknown, Resource: status}:
+			case <-ctx.Done():
+			}
+		}(status)
+	}
+	for _, w := range watched {
+		wg.Add(1)
+		go func(w watchedObject) {
+			defer wg.Done()
+			if err := watchObject(ctx, w.resource, w.status, events); err != nil {
+				// Only the first error is reported, it stops every watch.
+				select {
+				case errs <- err:
+				default:
+				}
+				cancel()
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(events)
+	}()
+	return events, errs, nil
+}
+
+// Delays before a watch closed by the API server is established again. The
+// delay doubles every time the watch closes without sending any event.
+var (
+	watchInitialBackoff = time.Second
+	watchMaxBackoff     = 30 * time.Second
+)
+
+// watchObject sends the changes of a single object until the context is
+// cancelled. Watches closed by the API server are established again from the
+// last version seen, or from a new list of the object if that version is too
+// old to resume from.
+func watchObject(ctx context.Context, resource dynamic.ResourceInterface, base ResourceStatus, events chan<- ResourceEvent) error {
+	opts := metav1.ListOptions{FieldSelector: fields.OneTermEqualSelector("metadata.name", base.Name).String()}
+	backoff := watchInitialBackoff
+	for {
+		w, err := resource.Watch(ctx, opts)
+		if err == nil {
+			var received bool
+			opts.ResourceVersion, received, err = forwardEvents(ctx, w, opts.ResourceVersion, base, events)
+			w.Stop()
+			if received {
+				backoff = watchInitialBackoff
+			}
+		}
+		if k8serrors.IsResourceExpired(err) || k8serrors.IsGone(err) {
+			opts.ResourceVersion, err = relistObject(ctx, resource, opts, base, events)
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > watchMaxBackoff {
+			backoff = watchMaxBackoff
+		}
+	}
+}
+
+// relistObject sends the current state of an object and returns the version
+// from which its changes can be watched.
+func relistObject(ctx context.Context, resource dynamic.ResourceInterface, opts metav1.ListOptions, base ResourceStatus, events chan<- ResourceEvent) (string, error) {
+	opts.ResourceVersion = ""
+	list, err := resource.List(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	event := ResourceEvent{Type: string(watch.Deleted), Resource: base}
+	event.Resource.Status = StatusFailed
+	event.Resource.Message = "resource deleted"
+	for i := range list.Items {
+		if list.Items[i].GetName() == base.Name {
+			event = ResourceEvent{Type: string(watch.Modified), Resource: base}
+			setResourceStatus(&event.Resource, &list.Items[i])
+		}
+	}
+	select {
+	case events <- event:
+	case <-ctx.Done():
+	}
+	return list.GetResourceVersion(), nil
+}
+
+// forwardEvents sends the changes of a watch until it is closed, returning
+// the last version seen and whether any change was sent.
+func forwardEvents(ctx context.Context, w watch.Interface, resourceVersion string, base ResourceStatus, events chan<- ResourceEvent) (string, bool, error) {
+	received := false
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion, received, nil
+		case e, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion, received, nil
+			}
+			if e.Type == watch.Error {
+				return resourceVersion, received, k8serrors.FromObject(e.Object)
+			}
+			obj, ok := e.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			resourceVersion = obj.GetResourceVersion()
+			received = true
+			status := base
+			if e.Type == watch.Deleted {
+				status.Status = StatusFailed
+				status.Message = "resource deleted"
+			} else {
+				setResourceStatus(&status, obj)
+			}
+			select {
+			case events <- ResourceEvent{Type: string(e.Type), Resource: status}:
+			case <-ctx.Done():
+				return resourceVersion, received, nil
+			}
+		}
+	}
+}
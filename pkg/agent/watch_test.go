@@ -0,0 +1,210 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agent
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/release"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newWatchFixture() (*fakedynamic.FakeDynamicClient, *watch.FakeWatcher) {
+	client := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("deployments", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		return true, watcher, nil
+	})
+	return client, watcher
+}
+
+func TestWatchRelease(t *testing.T) {
+	client, watcher := newWatchFixture()
+	rel := &release.Release{Name: "foo", Namespace: "default", Manifest: deploymentManifest}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events, errs, err := WatchRelease(ctx, rel, client, newRESTMapperFixture())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	go watcher.Modify(deployment(map[string]interface{}{
+		"observedGeneration": int64(2),
+		"readyReplicas":      int64(2),
+		"updatedReplicas":    int64(2),
+		"availableReplicas":  int64(2),
+	}))
+	expected := ResourceEvent{
+		Type: "MODIFIED",
+		Resource: ResourceStatus{
+			APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "foo",
+			Status:   StatusReady,
+			Replicas: &ReplicaCounts{Desired: 2, Ready: 2, Updated: 2, Available: 2},
+		},
+	}
+	if got, want := <-events, expected; !cmp.Equal(got, want) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+
+	go watcher.Delete(deployment(nil))
+	if got, want := (<-events).Resource.Status, StatusFailed; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	// Cancelling the context, as when the client disconnects, ends the stream.
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("expected the events channel to be closed")
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("got: %v, want: no error", err)
+	default:
+	}
+}
+
+func TestWatchReleaseError(t *testing.T) {
+	client, watcher := newWatchFixture()
+	rel := &release.Release{Name: "foo", Namespace: "default", Manifest: deploymentManifest}
+
+	events, errs, err := WatchRelease(context.Background(), rel, client, newRESTMapperFixture())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	go watcher.Error(&metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusForbidden,
+		Reason:  metav1.StatusReasonForbidden,
+		Message: "deployments.apps \"foo\" is forbidden",
+	})
+	if _, ok := <-events; ok {
+		t.Errorf("expected the events channel to be closed")
+	}
+	if err := <-errs; !k8serrors.IsForbidden(err) {
+		t.Errorf("got: %v, want: forbidden error", err)
+	}
+}
+
+func TestWatchReleaseUnknownKind(t *testing.T) {
+	client, watcher := newWatchFixture()
+	manifest := deploymentManifest + `---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: foo
+`
+	rel := &release.Release{Name: "foo", Namespace: "default", Manifest: manifest}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, _, err := WatchRelease(ctx, rel, client, newRESTMapperFixture())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// The object which can't be mapped is reported as unknown.
+	event := <-events
+	if got, want := event.Type, EventUnknown; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := event.Resource.Kind, "Widget"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := event.Resource.Status, StatusUnknown; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	// The other objects are still watched.
+	go watcher.Delete(deployment(nil))
+	event = <-events
+	if got, want := event.Resource.Kind, "Deployment"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := event.Resource.Status, StatusFailed; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestWatchReleaseResumes(t *testing.T) {
+	defer func(initial time.Duration) { watchInitialBackoff = initial }(watchInitialBackoff)
+	watchInitialBackoff = time.Millisecond
+
+	client := fakedynamic.NewSimpleDynamicClient(runtime.NewScheme())
+	watchers := make(chan *watch.FakeWatcher, 3)
+	resourceVersions := make(chan string, 3)
+	client.PrependWatchReactor("deployments", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		resourceVersions <- action.(k8stesting.WatchAction).GetWatchRestrictions().ResourceVersion
+		watcher := watch.NewFake()
+		watchers <- watcher
+		return true, watcher, nil
+	})
+	client.PrependReactor("list", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		list := &unstructured.UnstructuredList{Object: map[string]interface{}{"apiVersion": "apps/v1", "kind": "DeploymentList"}}
+		list.SetResourceVersion("20")
+		list.Items = append(list.Items, *deployment(nil))
+		return true, list, nil
+	})
+	rel := &release.Release{Name: "foo", Namespace: "default", Manifest: deploymentManifest}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, _, err := WatchRelease(ctx, rel, client, newRESTMapperFixture())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if got, want := <-resourceVersions, ""; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	watcher := <-watchers
+	updated := deployment(nil)
+	updated.SetResourceVersion("10")
+	go watcher.Modify(updated)
+	<-events
+
+	// A watch closed by the API server is resumed from the last version seen.
+	watcher.Stop()
+	if got, want := <-resourceVersions, "10"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	// A watch from an expired version lists the object again.
+	watcher = <-watchers
+	go watcher.Error(&metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusGone,
+		Reason:  metav1.StatusReasonExpired,
+		Message: "too old resource version: 10 (15)",
+	})
+	if got, want := (<-events).Resource.Status, StatusProgressing; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := <-resourceVersions, "20"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
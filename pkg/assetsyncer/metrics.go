@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package assetsyncer
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const metricsNamespace = "asset_syncer"
+
+// Registry holds the metrics of the syncs, labelled with the namespace and
+// name of the repository.
+var Registry = prometheus.NewRegistry()
+
+var repoLabels = []string{"namespace", "repo"}
+
+var (
+	syncDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "sync_duration_seconds",
+		Help:      "Duration of the last sync of the repository.",
+	}, repoLabels)
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Time of the last successful sync of the repository.",
+	}, repoLabels)
+	chartsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "charts_processed_total",
+		Help:      "Number of added or updated charts stored in the database.",
+	}, repoLabels)
+	chartVersionsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "chart_versions_processed_total",
+		Help:      "Number of versions of the added or updated charts stored in the database.",
+	}, repoLabels)
+	tarballsDownloaded = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tarballs_downloaded_total",
+		Help:      "Number of chart tarballs downloaded to extract their files.",
+	}, repoLabels)
+	bytesFetched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fetched_bytes_total",
+		Help:      "Bytes of the repository indexes, chart tarballs and icons fetched.",
+	}, repoLabels)
+	iconFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "icon_failures_total",
+		Help:      "Number of chart icons which could not be imported.",
+	}, repoLabels)
+	fileFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "file_failures_total",
+		Help:      "Number of chart versions whose files (README, values and schema) could not be imported.",
+	}, repoLabels)
+	indexFetchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "index_fetch_errors_total",
+		Help:      "Number of failures fetching the index of the repository.",
+	}, repoLabels)
+)
+
+func init() {
+	Registry.MustRegister(
+		syncDuration,
+		lastSuccess,
+		chartsProcessed,
+		chartVersionsProcessed,
+		tarballsDownloaded,
+		bytesFetched,
+		iconFailures,
+		fileFailures,
+		indexFetchErrors,
+	)
+}
+
+func metricLabels(namespace, name string) prometheus.Labels {
+	return prometheus.Labels{"namespace": namespace, "repo": name}
+}
+
+// PushMetrics pushes the metrics of a repository to a Pushgateway, grouped by
+// "<namespace>/<name>" of the repository so that the syncs of different
+// repositories do not replace each other. The grouping label can't be one of
+// the labels of the metrics.
+func PushMetrics(url, namespace, name string) error {
+	return push.New(url, metricsNamespace).
+		Gatherer(Registry).
+		Grouping("repository", namespace+"/"+name).
+		Push()
+}
+
+// WriteMetricsTextfile writes the metrics to a file in the text format, as
+// read by the textfile collector of the node exporter.
+func WriteMetricsTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, Registry)
+}
+
+// observeSync records the duration and outcome of a sync.
+func observeSync(namespace, name string, start time.Time, err error) {
+	labels := metricLabels(namespace, name)
+	syncDuration.With(labels).Set(time.Since(start).Seconds())
+	if err == nil {
+		lastSuccess.With(labels).SetToCurrentTime()
+	}
+}
+
+// countingReader counts the bytes read from a response body.
+type countingReader struct {
+	io.Reader
+	counter prometheus.Counter
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
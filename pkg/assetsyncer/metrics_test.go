@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package assetsyncer
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func Test_observeSync(t *testing.T) {
+	observeSync("metrics-ns", "failed-repo", time.Now(), errors.New("boom"))
+	if got, want := testutil.ToFloat64(lastSuccess.With(metricLabels("metrics-ns", "failed-repo"))), 0.0; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	observeSync("metrics-ns", "synced-repo", time.Now().Add(-2*time.Second), nil)
+	if got := testutil.ToFloat64(syncDuration.With(metricLabels("metrics-ns", "synced-repo"))); got < 2 {
+		t.Errorf("got: %v, want: at least 2", got)
+	}
+	if got := testutil.ToFloat64(lastSuccess.With(metricLabels("metrics-ns", "synced-repo"))); got == 0 {
+		t.Errorf("got: %v, want: the time of the sync", got)
+	}
+}
+
+func Test_countingReader(t *testing.T) {
+	counter := bytesFetched.With(metricLabels("metrics-ns", "counted-repo"))
+	b, err := ioutil.ReadAll(countingReader{strings.NewReader("some chart"), counter})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := testutil.ToFloat64(counter), float64(len(b)); got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
+
+func Test_PushMetrics(t *testing.T) {
+	var pushedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		pushedPath = req.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	indexFetchErrors.With(metricLabels("metrics-ns", "pushed-repo")).Inc()
+
+	if err := PushMetrics(server.URL, "metrics-ns", "pushed-repo"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expected := "/metrics/job/asset_syncer/repository@base64/" + base64.RawURLEncoding.EncodeToString([]byte("metrics-ns/pushed-repo"))
+	if got, want := pushedPath, expected; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func Test_WriteMetricsTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileFailures.With(metricLabels("metrics-ns", "written-repo")).Inc()
+
+	file := path.Join(dir, "asset-syncer.prom")
+	if err := WriteMetricsTextfile(file); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `asset_syncer_file_failures_total{namespace="metrics-ns",repo="written-repo"} 1`; !strings.Contains(string(content), want) {
+		t.Errorf("got: %s, want it to contain: %s", content, want)
+	}
+}
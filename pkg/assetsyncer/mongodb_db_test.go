@@ -19,7 +19,7 @@ limitations under the License.
 // Run the local mongodb with
 // docker run --publish 27017:27017 -e MONGODB_ROOT_PASSWORD=testpassword -e ALLOW_EMPTY_PASSWORD=yes bitnami/mongodb:4.2.3-debian-10-r31
 // in another terminal.
-package main
+package assetsyncer
 
 import (
 	"errors"
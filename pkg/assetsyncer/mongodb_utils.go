@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package assetsyncer
 
 import (
 	"fmt"
@@ -32,7 +32,7 @@ type mongodbAssetManager struct {
 	*dbutils.MongodbAssetManager
 }
 
-func newMongoDBManager(config datastore.Config, kubeappsNamespace string) assetManager {
+func newMongoDBManager(config datastore.Config, kubeappsNamespace string) AssetManager {
 	m := dbutils.NewMongoDBManager(config, kubeappsNamespace)
 	return &mongodbAssetManager{m}
 }
@@ -55,6 +55,67 @@ func (m *mongodbAssetManager) Sync(repo models.Repo, charts []models.Chart) erro
 	return m.importCharts(charts, repo)
 }
 
+// SyncChanges only writes the charts which have been added or updated since
+// the last sync and removes the charts no longer existing in the index.
+func (m *mongodbAssetManager) SyncChanges(repo models.Repo, changes chartChanges) error {
+	err := m.InitCollections()
+	if err != nil {
+		return err
+	}
+
+	return m.importChartChanges(changes, repo)
+}
+
+func (m *mongodbAssetManager) importChartChanges(changes chartChanges, repo models.Repo) error {
+	pairs, _, err := chartUpserts(changes.upserts(), repo)
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 && len(changes.removed) == 0 {
+		return nil
+	}
+
+	db, closer := m.DBSession.DB()
+	defer closer()
+	bulk := db.C(dbutils.ChartCollection).Bulk()
+	if len(pairs) > 0 {
+		bulk.Upsert(pairs...)
+	}
+	if len(changes.removed) > 0 {
+		bulk.RemoveAll(bson.M{
+			"chart_id": bson.M{
+				"$in": changes.removed,
+			},
+			"repo.name":      repo.Name,
+			"repo.namespace": repo.Namespace,
+		})
+	}
+
+	_, err = bulk.Run()
+	return err
+}
+
+// StoredChartVersions returns the chart versions currently stored for each
+// chart of the repository.
+func (m *mongodbAssetManager) StoredChartVersions(repo models.Repo) (map[string][]models.ChartVersion, error) {
+	db, closer := m.DBSession.DB()
+	defer closer()
+	var charts []models.Chart
+	err := db.C(dbutils.ChartCollection).Find(bson.M{
+		"repo.name":      repo.Name,
+		"repo.namespace": repo.Namespace,
+	}).Select(bson.M{"chart_id": 1, "chartversions": 1}).All(&charts)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := map[string][]models.ChartVersion{}
+	for _, c := range charts {
+		stored[c.ID] = c.ChartVersions
+	}
+	return stored, nil
+}
+
 func (m *mongodbAssetManager) RepoAlreadyProcessed(repo models.Repo, checksum string) bool {
 	db, closer := m.DBSession.DB()
 	defer closer()
@@ -97,16 +158,9 @@ func (m *mongodbAssetManager) Delete(repo models.Repo) error {
 }
 
 func (m *mongodbAssetManager) importCharts(charts []models.Chart, repo models.Repo) error {
-	var pairs []interface{}
-	var chartIDs []string
-	for _, c := range charts {
-		if c.Repo == nil || c.Repo.Namespace != repo.Namespace || c.Repo.Name != repo.Name {
-			return fmt.Errorf("%w: chart repo: %+v, import repo: %+v", ErrRepoMismatch, c.Repo, repo)
-		}
-		chartIDs = append(chartIDs, c.ID)
-		// charts to upsert - pair of selector, chart
-		// Mongodb generates the unique _id, we rely on the compound unique index on chart_id and repo.
-		pairs = append(pairs, bson.M{"chart_id": c.ID, "repo.name": repo.Name, "repo.namespace": repo.Namespace}, bson.M{"$set": c})
+	pairs, chartIDs, err := chartUpserts(charts, repo)
+	if err != nil {
+		return err
 	}
 
 	db, closer := m.DBSession.DB()
@@ -125,10 +179,27 @@ func (m *mongodbAssetManager) importCharts(charts []models.Chart, repo models.Re
 		"repo.namespace": repo.Namespace,
 	})
 
-	_, err := bulk.Run()
+	_, err = bulk.Run()
 	return err
 }
 
+// chartUpserts returns the pairs of selector and chart to be upserted, along
+// with the IDs of the charts.
+func chartUpserts(charts []models.Chart, repo models.Repo) ([]interface{}, []string, error) {
+	var pairs []interface{}
+	var chartIDs []string
+	for _, c := range charts {
+		if c.Repo == nil || c.Repo.Namespace != repo.Namespace || c.Repo.Name != repo.Name {
+			return nil, nil, fmt.Errorf("%w: chart repo: %+v, import repo: %+v", ErrRepoMismatch, c.Repo, repo)
+		}
+		chartIDs = append(chartIDs, c.ID)
+		// charts to upsert - pair of selector, chart
+		// Mongodb generates the unique _id, we rely on the compound unique index on chart_id and repo.
+		pairs = append(pairs, bson.M{"chart_id": c.ID, "repo.name": repo.Name, "repo.namespace": repo.Namespace}, bson.M{"$set": c})
+	}
+	return pairs, chartIDs, nil
+}
+
 func (m *mongodbAssetManager) updateIcon(repo models.Repo, data []byte, contentType, ID string) error {
 	db, closer := m.DBSession.DB()
 	defer closer()
@@ -143,6 +214,14 @@ func (m *mongodbAssetManager) filesExist(repo models.Repo, chartFilesID, digest
 	return err == nil
 }
 
+func (m *mongodbAssetManager) iconExists(repo models.Repo, chartID string) bool {
+	db, closer := m.DBSession.DB()
+	defer closer()
+	var chart models.Chart
+	err := db.C(dbutils.ChartCollection).Find(bson.M{"chart_id": chartID, "repo.name": repo.Name, "repo.namespace": repo.Namespace}).One(&chart)
+	return err == nil && len(chart.RawIcon) > 0
+}
+
 func (m *mongodbAssetManager) insertFiles(chartId string, files models.ChartFiles) error {
 	db, closer := m.DBSession.DB()
 	defer closer()
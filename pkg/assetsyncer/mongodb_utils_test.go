@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package assetsyncer
 
 import (
 	"testing"
@@ -65,6 +65,52 @@ func Test_importCharts(t *testing.T) {
 	}
 }
 
+func Test_importChartChanges(t *testing.T) {
+	repo := models.Repo{Namespace: "repo-namespace", Name: "repo-name"}
+	changes := chartChanges{
+		added:   []models.Chart{{ID: "repo-name/added", Repo: &repo}},
+		updated: []models.Chart{{ID: "repo-name/updated", Repo: &repo}},
+		removed: []string{"repo-name/removed"},
+	}
+	m := &mock.Mock{}
+	m.On("Upsert", mock.Anything)
+	m.On("RemoveAll", []interface{}{bson.M{
+		"chart_id":       bson.M{"$in": []string{"repo-name/removed"}},
+		"repo.name":      "repo-name",
+		"repo.namespace": "repo-namespace",
+	}})
+
+	manager := getMockManager(m)
+	err := manager.importChartChanges(changes, repo)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	m.AssertExpectations(t)
+
+	// Only the added and updated charts are upserted.
+	args := m.Calls[0].Arguments.Get(0).([]interface{})
+	assert.Equal(t, len(args), 4, "number of selector, chart pairs to upsert")
+	assert.Equal(t, args[0], bson.M{"chart_id": "repo-name/added", "repo.name": "repo-name", "repo.namespace": "repo-namespace"}, "selector")
+	assert.Equal(t, args[2], bson.M{"chart_id": "repo-name/updated", "repo.name": "repo-name", "repo.namespace": "repo-namespace"}, "selector")
+}
+
+func Test_StoredChartVersions(t *testing.T) {
+	var charts []models.Chart
+	m := &mock.Mock{}
+	m.On("All", &charts).Run(func(args mock.Arguments) {
+		*args.Get(0).(*[]models.Chart) = []models.Chart{
+			{ID: "repo-name/wordpress", ChartVersions: []models.ChartVersion{{Version: "2.1.3", Digest: "abc"}}},
+		}
+	})
+
+	manager := getMockManager(m)
+	stored, err := manager.StoredChartVersions(models.Repo{Namespace: "repo-namespace", Name: "repo-name"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	assert.Equal(t, stored, map[string][]models.ChartVersion{"repo-name/wordpress": {{Version: "2.1.3", Digest: "abc"}}}, "stored chart versions")
+}
+
 func Test_DeleteRepo(t *testing.T) {
 	m := &mock.Mock{}
 	repo := models.Repo{Name: "repo-name", Namespace: "repo-namespace"}
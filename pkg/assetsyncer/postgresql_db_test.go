@@ -19,7 +19,7 @@ limitations under the License.
 // Run the local postgres with
 // docker run --publish 5432:5432 -e ALLOW_EMPTY_PASSWORD=yes bitnami/postgresql:11.6.0-debian-9-r0
 // in another terminal.
-package main
+package assetsyncer
 
 import (
 	"database/sql"
@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package assetsyncer
 
 import (
 	"database/sql"
@@ -27,7 +27,7 @@ import (
 	"github.com/kubeapps/common/datastore"
 	"github.com/kubeapps/kubeapps/pkg/chart/models"
 	"github.com/kubeapps/kubeapps/pkg/dbutils"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var ErrMultipleRows = fmt.Errorf("more than one row returned in query result")
@@ -36,7 +36,7 @@ type postgresAssetManager struct {
 	*dbutils.PostgresAssetManager
 }
 
-func newPGManager(config datastore.Config, kubeappsNamespace string) (assetManager, error) {
+func newPGManager(config datastore.Config, kubeappsNamespace string) (AssetManager, error) {
 	m, err := dbutils.NewPGManager(config, kubeappsNamespace)
 	if err != nil {
 		return nil, err
@@ -71,6 +71,52 @@ func (m *postgresAssetManager) Sync(repo models.Repo, charts []models.Chart) err
 	return m.removeMissingCharts(repo, charts)
 }
 
+// SyncChanges only writes the charts which have been added or updated since
+// the last sync and removes the charts no longer existing in the index.
+func (m *postgresAssetManager) SyncChanges(repo models.Repo, changes chartChanges) error {
+	m.InitTables()
+
+	// Ensure the repo exists so FK constraints will be met.
+	_, err := m.EnsureRepoExists(repo.Namespace, repo.Name)
+	if err != nil {
+		return err
+	}
+
+	err = m.importCharts(changes.upserts(), repo)
+	if err != nil {
+		return err
+	}
+
+	return m.removeCharts(repo, changes.removed)
+}
+
+// StoredChartVersions returns the chart versions currently stored for each
+// chart of the repository.
+func (m *postgresAssetManager) StoredChartVersions(repo models.Repo) (map[string][]models.ChartVersion, error) {
+	rows, err := m.DB.Query(fmt.Sprintf("SELECT chart_id, info -> 'chartVersions' FROM %s WHERE repo_name = $1 AND repo_namespace = $2", dbutils.ChartTable), repo.Name, repo.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := map[string][]models.ChartVersion{}
+	for rows.Next() {
+		var chartID string
+		var versions []byte
+		if err := rows.Scan(&chartID, &versions); err != nil {
+			return nil, err
+		}
+		var chartVersions []models.ChartVersion
+		if len(versions) > 0 {
+			if err := json.Unmarshal(versions, &chartVersions); err != nil {
+				return nil, err
+			}
+		}
+		stored[chartID] = chartVersions
+	}
+	return stored, rows.Err()
+}
+
 func (m *postgresAssetManager) RepoAlreadyProcessed(repo models.Repo, repoChecksum string) bool {
 	var lastChecksum string
 	row := m.DB.QueryRow(fmt.Sprintf("SELECT checksum FROM %s WHERE name = $1 AND namespace = $2", dbutils.RepositoryTable), repo.Name, repo.Namespace)
@@ -126,6 +172,17 @@ func (m *postgresAssetManager) removeMissingCharts(repo models.Repo, charts []mo
 	return err
 }
 
+func (m *postgresAssetManager) removeCharts(repo models.Repo, chartIDs []string) error {
+	if len(chartIDs) == 0 {
+		return nil
+	}
+	rows, err := m.DB.Query(fmt.Sprintf("DELETE FROM %s WHERE chart_id = ANY($1) AND repo_name = $2 AND repo_namespace = $3", dbutils.ChartTable), pq.Array(chartIDs), repo.Name, repo.Namespace)
+	if rows != nil {
+		defer rows.Close()
+	}
+	return err
+}
+
 func (m *postgresAssetManager) Delete(repo models.Repo) error {
 	rows, err := m.DB.Query(fmt.Sprintf("DELETE FROM %s WHERE name = $1 AND namespace = $2", dbutils.RepositoryTable), repo.Name, repo.Namespace)
 	if rows != nil {
@@ -171,6 +228,21 @@ SELECT EXISTS(
 	return err == nil && exists
 }
 
+func (m *postgresAssetManager) iconExists(repo models.Repo, chartID string) bool {
+	var exists bool
+	err := m.DB.QueryRow(
+		fmt.Sprintf(`
+SELECT EXISTS(
+	SELECT 1 FROM %s
+	WHERE chart_id = $1 AND
+		repo_name = $2 AND
+		repo_namespace = $3 AND
+		COALESCE(info ->> 'raw_icon', '') <> ''
+	)`, dbutils.ChartTable),
+		chartID, repo.Name, repo.Namespace).Scan(&exists)
+	return err == nil && exists
+}
+
 func (m *postgresAssetManager) insertFiles(chartId string, files models.ChartFiles) error {
 	if files.Repo == nil {
 		return fmt.Errorf("unable to insert file without repo: %q", files.ID)
@@ -1,4 +1,4 @@
-package main
+package assetsyncer
 
 import (
 	"database/sql"
@@ -6,10 +6,12 @@ import (
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/go-cmp/cmp"
 	"github.com/kubeapps/common/datastore"
 	"github.com/kubeapps/kubeapps/pkg/chart/models"
 	"github.com/kubeapps/kubeapps/pkg/dbutils"
 	"github.com/kubeapps/kubeapps/pkg/dbutils/dbutilstest"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -98,6 +100,58 @@ func Test_PGremoveMissingCharts(t *testing.T) {
 	m.AssertExpectations(t)
 }
 
+func Test_PGremoveCharts(t *testing.T) {
+	repo := models.Repo{Namespace: "repo-namespace", Name: "repo"}
+	m := &mockDB{&mock.Mock{}}
+	man, _ := dbutils.NewPGManager(datastore.Config{URL: "localhost:4123"}, dbutilstest.KubeappsTestNamespace)
+	man.DB = m
+	pgManager := &postgresAssetManager{man}
+	m.On("Query", "DELETE FROM charts WHERE chart_id = ANY($1) AND repo_name = $2 AND repo_namespace = $3", []interface{}{pq.Array([]string{"repo/foo", "repo/bar"}), repo.Name, repo.Namespace})
+	err := pgManager.removeCharts(repo, []string{"repo/foo", "repo/bar"})
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	m.AssertExpectations(t)
+
+	// No query is run when there is nothing to remove.
+	err = pgManager.removeCharts(repo, nil)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	m.AssertNumberOfCalls(t, "Query", 1)
+}
+
+func Test_PGStoredChartVersions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	rows := sqlmock.NewRows([]string{"chart_id", "versions"}).
+		AddRow("repo-name/wordpress", `[{"version": "2.1.3", "digest": "abc"}]`).
+		AddRow("repo-name/empty", nil)
+	mock.ExpectQuery(`^SELECT chart_id, info -> 'chartVersions' FROM charts WHERE repo_name = \$1 AND repo_namespace = \$2$`).
+		WithArgs("repo-name", "namespace").
+		WillReturnRows(rows)
+	man := &dbutils.PostgresAssetManager{DB: db}
+	pgManager := &postgresAssetManager{man}
+
+	stored, err := pgManager.StoredChartVersions(models.Repo{Namespace: "namespace", Name: "repo-name"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	expected := map[string][]models.ChartVersion{
+		"repo-name/wordpress": {{Version: "2.1.3", Digest: "abc"}},
+		"repo-name/empty":     nil,
+	}
+	if got, want := stored, expected; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Errorf("err %v", err)
+	}
+}
+
 func Test_PGupdateIcon(t *testing.T) {
 	data := []byte("foo")
 	contentType := "image/png"
@@ -145,6 +199,31 @@ func Test_PGfilesExist(t *testing.T) {
 	}
 }
 
+func Test_PGiconExists(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	rows := sqlmock.NewRows([]string{"exists"}).AddRow(`false`)
+	mock.ExpectQuery(`^SELECT EXISTS\(
+	SELECT 1 FROM charts
+	WHERE chart_id = \$1 AND
+		repo_name = \$2 AND
+		repo_namespace = \$3 AND
+		COALESCE\(info ->> 'raw_icon', ''\) <> ''
+	\)$`).WithArgs("repo-name/wordpress", "repo-name", "namespace").WillReturnRows(rows)
+	man := &dbutils.PostgresAssetManager{DB: db}
+	pgManager := &postgresAssetManager{man}
+	exists := pgManager.iconExists(models.Repo{Namespace: "namespace", Name: "repo-name"}, "repo-name/wordpress")
+	if exists != false {
+		t.Errorf("Expected the icon to be missing")
+	}
+	err = mock.ExpectationsWereMet()
+	if err != nil {
+		t.Errorf("err %v", err)
+	}
+}
+
 func Test_PGinsertFiles(t *testing.T) {
 	const (
 		namespace = "my-namespace"
@@ -0,0 +1,115 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package assetsyncer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	"github.com/kubeapps/kubeapps/pkg/provenance"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
+)
+
+// verifyWorkers is the number of provenance files fetched at a time.
+const verifyWorkers = 10
+
+// verifyCharts sets the status of the verification of the provenance file of
+// each chart version with the trusted keys of the repository. The chart
+// versions are verified against the digest of their tarball in the index,
+// so only the provenance files are fetched.
+func verifyCharts(netClient HTTPClient, r *models.RepoInternal, charts []models.Chart, keyring openpgp.EntityList) {
+	chartVersions := make(chan *models.ChartVersion, verifyWorkers)
+	var wg sync.WaitGroup
+	for i := 0; i < verifyWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cv := range chartVersions {
+				cv.Verification, cv.SignedBy = verifyChartVersion(netClient, r, *cv, keyring)
+			}
+		}()
+	}
+	for i := range charts {
+		for j := range charts[i].ChartVersions {
+			chartVersions <- &charts[i].ChartVersions[j]
+		}
+	}
+	close(chartVersions)
+	wg.Wait()
+}
+
+// verifyChartVersion returns the status of the verification of a chart
+// version and the identity of its signer.
+func verifyChartVersion(netClient HTTPClient, r *models.RepoInternal, cv models.ChartVersion, keyring openpgp.EntityList) (string, string) {
+	if len(cv.URLs) == 0 {
+		return provenance.StatusFailed, ""
+	}
+	tarballURL := chartTarballURL(r, cv)
+	prov, err := fetchProvenance(netClient, tarballURL+provenance.Extension, r.AuthorizationHeader)
+	var signedBy string
+	if err == nil {
+		signedBy, err = provenance.Verify(keyring, prov, chartFileName(tarballURL), cv.Digest)
+	}
+	status := provenance.Status(err)
+	if err != nil {
+		log.WithFields(log.Fields{"url": tarballURL, "status": status}).WithError(err).Info("chart version not verified")
+	}
+	return status, signedBy
+}
+
+// fetchProvenance returns the provenance file at the given URL. A missing
+// provenance file means the chart version is unsigned.
+func fetchProvenance(netClient HTTPClient, provURL, authHeader string) ([]byte, error) {
+	req, err := http.NewRequest("GET", provURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent())
+	if len(authHeader) > 0 {
+		req.Header.Set("Authorization", authHeader)
+	}
+
+	res, err := netClient.Do(req)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s not found: %w", provURL, provenance.ErrUnsigned)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%d %s", res.StatusCode, provURL)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// chartFileName returns the file name of a chart tarball, which is the one
+// signed in its provenance file.
+func chartFileName(tarballURL string) string {
+	if u, err := url.Parse(tarballURL); err == nil {
+		return path.Base(u.Path)
+	}
+	return path.Base(tarballURL)
+}
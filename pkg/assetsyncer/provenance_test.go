@@ -0,0 +1,97 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package assetsyncer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arschles/assert"
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	"github.com/kubeapps/kubeapps/pkg/provenance"
+	"golang.org/x/crypto/openpgp"
+)
+
+// provenanceHTTPClient serves the provenance files of some chart tarball
+// URLs, and a 404 for the others.
+type provenanceHTTPClient struct {
+	provs map[string][]byte
+}
+
+func (h *provenanceHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	prov, ok := h.provs[req.URL.String()]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return w.Result(), nil
+	}
+	w.Write(prov)
+	return w.Result(), nil
+}
+
+func Test_verifyCharts(t *testing.T) {
+	trusted, err := openpgp.NewEntity("trusted", "", "trusted@example.com", nil)
+	assert.NoErr(t, err)
+	untrusted, err := openpgp.NewEntity("untrusted", "", "untrusted@example.com", nil)
+	assert.NoErr(t, err)
+
+	index, err := parseRepoIndex([]byte(validRepoIndexYAML))
+	assert.NoErr(t, err)
+	repo := &models.RepoInternal{Name: "test", Namespace: "repo-namespace", URL: "http://testrepo.com"}
+	charts := chartsFromIndex(index, &models.Repo{Name: repo.Name, Namespace: repo.Namespace, URL: repo.URL})
+
+	sign := func(signer *openpgp.Entity, cv models.ChartVersion) []byte {
+		tarballURL := chartTarballURL(repo, cv)
+		prov, err := provenance.Sign(signer, []byte("name: chart\n"), chartFileName(tarballURL), cv.Digest)
+		assert.NoErr(t, err)
+		return prov
+	}
+	provs := map[string][]byte{}
+	expected := map[string]string{}
+	// The latest version of wordpress is signed by the trusted key and its
+	// other versions by another key.
+	for _, c := range charts {
+		for i, cv := range c.ChartVersions {
+			tarballURL := chartTarballURL(repo, cv)
+			switch {
+			case c.Name == "wordpress" && i == 0:
+				provs[tarballURL+provenance.Extension] = sign(trusted, cv)
+				expected[tarballURL] = provenance.StatusVerified
+			case c.Name == "wordpress":
+				provs[tarballURL+provenance.Extension] = sign(untrusted, cv)
+				expected[tarballURL] = provenance.StatusFailed
+			default:
+				expected[tarballURL] = provenance.StatusUnsigned
+			}
+		}
+	}
+
+	verifyCharts(&provenanceHTTPClient{provs: provs}, repo, charts, openpgp.EntityList{trusted})
+
+	for _, c := range charts {
+		for _, cv := range c.ChartVersions {
+			tarballURL := chartTarballURL(repo, cv)
+			assert.Equal(t, cv.Verification, expected[tarballURL], "verification of "+tarballURL)
+			expectedSigner := ""
+			if cv.Verification == provenance.StatusVerified {
+				expectedSigner = "trusted <trusted@example.com>"
+			}
+			assert.Equal(t, cv.SignedBy, expectedSigner, "signer of "+tarballURL)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package assetsyncer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	"github.com/kubeapps/kubeapps/pkg/oci"
+	"github.com/kubeapps/kubeapps/pkg/provenance"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/openpgp"
+	helmrepo "k8s.io/helm/pkg/repo"
+)
+
+// Repository is a chart repository or OCI registry to sync.
+type Repository struct {
+	Namespace string
+	Name      string
+	URL       string
+	// Type is either HelmRepoType (default) or OCIRepoType.
+	Type string
+	// OCIRepositories are the repositories of an OCI registry to sync.
+	OCIRepositories     []string
+	AuthorizationHeader string
+	// Keyring holds the trusted PGP public keys the provenance files of the
+	// charts are verified with. The charts are not verified if empty.
+	Keyring []byte
+}
+
+// Sync stores the charts of a repository in the database, only processing the
+// charts which changed since the last sync.
+func Sync(manager AssetManager, netClient HTTPClient, r Repository) (*models.RepoSyncResult, error) {
+	start := time.Now()
+	result, err := syncRepo(manager, netClient, r)
+	observeSync(r.Namespace, r.Name, start, err)
+	return result, err
+}
+
+func syncRepo(manager AssetManager, netClient HTTPClient, r Repository) (*models.RepoSyncResult, error) {
+	var repo *models.RepoInternal
+	var repoContent []byte
+	var ociTags map[string][]string
+	var err error
+	switch r.Type {
+	case HelmRepoType, "":
+		repo, repoContent, err = getRepo(netClient, r.Namespace, r.Name, r.URL, r.AuthorizationHeader)
+	case OCIRepoType:
+		// The chart blobs are downloaded with the token of the registry, if
+		// it requires one.
+		netClient = oci.NewTokenClient(netClient)
+		repo, ociTags, err = getOCIRepo(netClient, r.Namespace, r.Name, r.URL, r.AuthorizationHeader, r.OCIRepositories)
+	default:
+		return nil, fmt.Errorf("unsupported repository type %q", r.Type)
+	}
+	if err != nil {
+		indexFetchErrors.With(metricLabels(r.Namespace, r.Name)).Inc()
+		return nil, err
+	}
+
+	var keyring openpgp.EntityList
+	if len(r.Keyring) > 0 {
+		keyring, err = provenance.ReadKeyring(r.Keyring)
+		if err != nil {
+			return nil, err
+		}
+		// The charts are verified again when the trusted keys change.
+		repo.Checksum, err = getSha256(append([]byte(repo.Checksum), r.Keyring...))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Check if the repo has been already processed
+	if manager.RepoAlreadyProcessed(models.Repo{Namespace: repo.Namespace, Name: repo.Name}, repo.Checksum) {
+		log.WithFields(log.Fields{"url": repo.URL}).Info("Skipping repository since there are no updates")
+		return &models.RepoSyncResult{Checksum: repo.Checksum, Unchanged: true}, nil
+	}
+
+	var index *helmrepo.IndexFile
+	if r.Type == OCIRepoType {
+		index, err = ociRegistryIndex(netClient, repo, ociTags)
+	} else {
+		index, err = parseRepoIndex(repoContent)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	charts := chartsFromIndex(index, &models.Repo{Namespace: repo.Namespace, Name: repo.Name, URL: repo.URL})
+	if len(charts) == 0 {
+		return nil, errors.New("no charts in repository index")
+	}
+	if keyring != nil {
+		// Helm does not support provenance files for OCI registries.
+		if r.Type == OCIRepoType {
+			log.WithFields(log.Fields{"url": repo.URL}).Info("Skipping the verification of the charts of an OCI registry")
+		} else {
+			verifyCharts(netClient, repo, charts, keyring)
+		}
+	}
+
+	// Only the charts which changed since the last sync are written to the
+	// database.
+	repoModel := models.Repo{Name: repo.Name, Namespace: repo.Namespace}
+	stored, err := manager.StoredChartVersions(repoModel)
+	if err != nil {
+		return nil, fmt.Errorf("can't get the stored charts of the repository: %v", err)
+	}
+	changes := diffCharts(charts, stored)
+	if err = manager.SyncChanges(repoModel, changes); err != nil {
+		return nil, fmt.Errorf("can't add chart repository to database: %v", err)
+	}
+	labels := metricLabels(r.Namespace, r.Name)
+	for _, c := range changes.upserts() {
+		chartsProcessed.With(labels).Inc()
+		chartVersionsProcessed.With(labels).Add(float64(len(c.ChartVersions)))
+	}
+
+	// Fetch and store chart icons and the files missing for any chart
+	// version, also when a previous sync stopped before fetching them.
+	fImporter := fileImporter{manager, netClient}
+	fImporter.fetchFiles(charts, iconsToFetch(manager, repoModel, charts, changes), repo)
+
+	// Update cache in the database
+	if err = manager.UpdateLastCheck(repo.Namespace, repo.Name, repo.Checksum, time.Now()); err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{"url": repo.URL}).Info("Stored repository update in cache")
+
+	log.WithFields(log.Fields{
+		"added":     len(changes.added),
+		"updated":   len(changes.updated),
+		"removed":   len(changes.removed),
+		"unchanged": changes.unchanged,
+	}).Infof("Successfully added the chart repository %s to database", r.Name)
+
+	return &models.RepoSyncResult{Checksum: repo.Checksum, ChartCount: len(charts)}, nil
+}
+
+// ResultForError returns the result of a sync which failed with the given
+// error.
+func ResultForError(err error) *models.RepoSyncResult {
+	return &models.RepoSyncResult{
+		Error:      err.Error(),
+		AuthFailed: errors.Is(err, errUnauthorized) || errors.Is(err, oci.ErrUnauthorized),
+	}
+}
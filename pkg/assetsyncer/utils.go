@@ -14,7 +14,9 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+// Package assetsyncer syncs the charts of chart repositories and OCI
+// registries to the assets database.
+package assetsyncer
 
 import (
 	"archive/tar"
@@ -23,6 +25,7 @@ import (
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -31,6 +34,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -40,43 +44,47 @@ import (
 	"github.com/jinzhu/copier"
 	"github.com/kubeapps/common/datastore"
 	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	"github.com/kubeapps/kubeapps/pkg/oci"
 	log "github.com/sirupsen/logrus"
+	"k8s.io/helm/pkg/proto/hapi/chart"
 	helmrepo "k8s.io/helm/pkg/repo"
 )
 
 const (
 	defaultTimeoutSeconds = 10
-	additionalCAFile      = "/usr/local/share/ca-certificates/ca.crt"
+
+	// HelmRepoType is the type of the chart repositories serving an index.
+	HelmRepoType = "helm"
+	// OCIRepoType is the type of the OCI registries.
+	OCIRepoType = "oci"
 )
 
+// errUnauthorized is wrapped by the errors returned when a repository rejects
+// the credentials used to fetch its index.
+var errUnauthorized = errors.New("unauthorized")
+
 type importChartFilesJob struct {
 	Name         string
 	Repo         *models.Repo
 	ChartVersion models.ChartVersion
 }
 
-type httpClient interface {
+// HTTPClient performs the requests to the chart repositories.
+type HTTPClient interface {
 	Do(req *http.Request) (*http.Response, error)
 }
 
-var netClient httpClient = &http.Client{}
-
 func parseRepoURL(repoURL string) (*url.URL, error) {
 	repoURL = strings.TrimSpace(repoURL)
 	return url.ParseRequestURI(repoURL)
 }
 
-func init() {
-	var err error
-	netClient, err = initNetClient(additionalCAFile)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-type assetManager interface {
+// AssetManager stores the charts of the synced repositories.
+type AssetManager interface {
 	Delete(repo models.Repo) error
 	Sync(repo models.Repo, charts []models.Chart) error
+	SyncChanges(repo models.Repo, changes chartChanges) error
+	StoredChartVersions(repo models.Repo) (map[string][]models.ChartVersion, error)
 	RepoAlreadyProcessed(repo models.Repo, checksum string) bool
 	UpdateLastCheck(repoNamespace, repoName, checksum string, now time.Time) error
 	Init() error
@@ -84,10 +92,12 @@ type assetManager interface {
 	InvalidateCache() error
 	updateIcon(repo models.Repo, data []byte, contentType, ID string) error
 	filesExist(repo models.Repo, chartFilesID, digest string) bool
+	iconExists(repo models.Repo, chartID string) bool
 	insertFiles(chartId string, files models.ChartFiles) error
 }
 
-func newManager(databaseType string, config datastore.Config, kubeappsNamespace string) (assetManager, error) {
+// NewManager returns the AssetManager for the given database type.
+func NewManager(databaseType string, config datastore.Config, kubeappsNamespace string) (AssetManager, error) {
 	if databaseType == "mongodb" {
 		return newMongoDBManager(config, kubeappsNamespace), nil
 	} else if databaseType == "postgresql" {
@@ -106,17 +116,18 @@ func getSha256(src []byte) (string, error) {
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
-func getRepo(namespace, name, repoURL, authorizationHeader string) (*models.RepoInternal, []byte, error) {
+func getRepo(netClient HTTPClient, namespace, name, repoURL, authorizationHeader string) (*models.RepoInternal, []byte, error) {
 	url, err := parseRepoURL(repoURL)
 	if err != nil {
 		log.WithFields(log.Fields{"url": repoURL}).WithError(err).Error("failed to parse URL")
 		return nil, []byte{}, err
 	}
 
-	repoBytes, err := fetchRepoIndex(url.String(), authorizationHeader)
+	repoBytes, err := fetchRepoIndex(netClient, url.String(), authorizationHeader)
 	if err != nil {
 		return nil, []byte{}, err
 	}
+	bytesFetched.With(metricLabels(namespace, name)).Add(float64(len(repoBytes)))
 
 	repoChecksum, err := getSha256(repoBytes)
 	if err != nil {
@@ -126,7 +137,7 @@ func getRepo(namespace, name, repoURL, authorizationHeader string) (*models.Repo
 	return &models.RepoInternal{Namespace: namespace, Name: name, URL: url.String(), Checksum: repoChecksum, AuthorizationHeader: authorizationHeader}, repoBytes, nil
 }
 
-func fetchRepoIndex(url, authHeader string) ([]byte, error) {
+func fetchRepoIndex(netClient HTTPClient, url, authHeader string) ([]byte, error) {
 	indexURL, err := parseRepoURL(url)
 	if err != nil {
 		log.WithFields(log.Fields{"url": url}).WithError(err).Error("failed to parse URL")
@@ -153,6 +164,10 @@ func fetchRepoIndex(url, authHeader string) ([]byte, error) {
 		return nil, err
 	}
 
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		log.WithFields(log.Fields{"url": req.URL.String(), "status": res.StatusCode}).Error("error requesting repo index, the credentials were rejected")
+		return nil, fmt.Errorf("repo index request failed: %w", errUnauthorized)
+	}
 	if res.StatusCode != http.StatusOK {
 		log.WithFields(log.Fields{"url": req.URL.String(), "status": res.StatusCode}).Error("error requesting repo index, are you sure this is a chart repository?")
 		return nil, errors.New("repo index request failed")
@@ -165,6 +180,116 @@ func fetchRepoIndex(url, authHeader string) ([]byte, error) {
 	return body, nil
 }
 
+func newOCIRegistry(netClient HTTPClient, registryURL, authHeader string) (*oci.Registry, error) {
+	headers := http.Header{}
+	headers.Set("User-Agent", userAgent())
+	if len(authHeader) > 0 {
+		headers.Set("Authorization", authHeader)
+	}
+	return oci.NewRegistry(registryURL, netClient, headers)
+}
+
+// getOCIRepo lists the tags of each of the given repositories of an OCI
+// registry. The checksum of the repo is calculated from these tags, so
+// re-pushing an existing tag won't be detected until a tag is added or removed.
+func getOCIRepo(netClient HTTPClient, namespace, name, repoURL, authorizationHeader string, ociRepos []string) (*models.RepoInternal, map[string][]string, error) {
+	url, err := parseRepoURL(repoURL)
+	if err != nil {
+		log.WithFields(log.Fields{"url": repoURL}).WithError(err).Error("failed to parse URL")
+		return nil, nil, err
+	}
+	if len(ociRepos) == 0 {
+		return nil, nil, fmt.Errorf("at least one OCI repository is required for registry %s", url.String())
+	}
+
+	registry, err := newOCIRegistry(netClient, url.String(), authorizationHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tags := map[string][]string{}
+	for _, ociRepo := range ociRepos {
+		repoTags, err := registry.ListTags(ociRepo)
+		if err != nil {
+			log.WithFields(log.Fields{"url": url.String(), "repository": ociRepo}).WithError(err).Error("error requesting repository tags, are you sure this is an OCI registry?")
+			return nil, nil, err
+		}
+		tags[ociRepo] = repoTags
+	}
+
+	// Map keys are sorted when marshalling so the checksum is stable.
+	tagsBytes, err := json.Marshal(tags)
+	if err != nil {
+		return nil, nil, err
+	}
+	repoChecksum, err := getSha256(tagsBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &models.RepoInternal{Namespace: namespace, Name: name, URL: url.String(), Checksum: repoChecksum, AuthorizationHeader: authorizationHeader}, tags, nil
+}
+
+// ociRegistryIndex builds a Helm repository index for the charts found in the
+// given tags of an OCI registry, so that they can then be processed as the
+// charts of any other repository. The URL of each chart version points to
+// the blob of its chart layer.
+func ociRegistryIndex(netClient HTTPClient, r *models.RepoInternal, tags map[string][]string) (*helmrepo.IndexFile, error) {
+	registry, err := newOCIRegistry(netClient, r.URL, r.AuthorizationHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	index := helmrepo.NewIndexFile()
+	for ociRepo, repoTags := range tags {
+		for _, tag := range repoTags {
+			cv, err := ociChartVersion(registry, ociRepo, tag)
+			if err != nil {
+				log.WithFields(log.Fields{"repository": ociRepo, "tag": tag}).WithError(err).Error("failed to import chart from OCI registry")
+				continue
+			}
+			index.Entries[cv.GetName()] = append(index.Entries[cv.GetName()], cv)
+		}
+	}
+	index.SortEntries()
+	return index, nil
+}
+
+func ociChartVersion(registry *oci.Registry, ociRepo, tag string) (*helmrepo.ChartVersion, error) {
+	manifest, err := registry.GetManifest(ociRepo, tag)
+	if err != nil {
+		return nil, err
+	}
+	if !oci.IsChart(manifest) {
+		return nil, fmt.Errorf("unexpected config media type %q", manifest.Config.MediaType)
+	}
+	layer, err := oci.ChartLayer(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// The config blob of a chart contains the Chart.yaml metadata as JSON.
+	config, err := registry.GetBlob(ociRepo, manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var metadata chart.Metadata
+	if err := json.Unmarshal(config, &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.GetName() == "" {
+		return nil, fmt.Errorf("chart metadata does not include a name")
+	}
+
+	// The creation annotation is optional so a zero time is used if missing.
+	created, _ := time.Parse(time.RFC3339, manifest.Annotations[oci.AnnotationCreated])
+	return &helmrepo.ChartVersion{
+		Metadata: &metadata,
+		URLs:     []string{registry.BlobURL(ociRepo, layer.Digest)},
+		Created:  created,
+		Digest:   oci.DigestHex(layer.Digest),
+	}, nil
+}
+
 func parseRepoIndex(body []byte) (*helmrepo.IndexFile, error) {
 	var index helmrepo.IndexFile
 	err := yaml.Unmarshal(body, &index)
@@ -187,6 +312,82 @@ func chartsFromIndex(index *helmrepo.IndexFile, r *models.Repo) []models.Chart {
 	return charts
 }
 
+// chartChanges contains the charts of a repository index which differ from
+// the charts stored in the database for that repository.
+type chartChanges struct {
+	added     []models.Chart
+	updated   []models.Chart
+	removed   []string
+	unchanged int
+}
+
+// iconsToFetch returns the charts whose icon needs to be fetched: the charts
+// written to the database, which lost their icon, and the unchanged charts
+// without a stored icon, e.g. because a previous sync stopped before
+// fetching it.
+func iconsToFetch(manager AssetManager, repo models.Repo, charts []models.Chart, changes chartChanges) []models.Chart {
+	icons := changes.upserts()
+	upserted := map[string]bool{}
+	for _, c := range icons {
+		upserted[c.ID] = true
+	}
+	for _, c := range charts {
+		if !upserted[c.ID] && c.Icon != "" && !manager.iconExists(repo, c.ID) {
+			icons = append(icons, c)
+		}
+	}
+	return icons
+}
+
+// upserts returns the charts which need to be written to the database.
+func (c chartChanges) upserts() []models.Chart {
+	return append(append([]models.Chart{}, c.added...), c.updated...)
+}
+
+// diffCharts compares the charts of an index with the chart versions stored
+// for each chart ID. A chart is considered updated when any of its versions
+// has been added, removed or has a different digest or verification.
+func diffCharts(charts []models.Chart, stored map[string][]models.ChartVersion) chartChanges {
+	var changes chartChanges
+	inIndex := map[string]bool{}
+	for _, c := range charts {
+		inIndex[c.ID] = true
+		storedVersions, ok := stored[c.ID]
+		switch {
+		case !ok:
+			changes.added = append(changes.added, c)
+		case !sameChartVersions(c.ChartVersions, storedVersions):
+			changes.updated = append(changes.updated, c)
+		default:
+			changes.unchanged++
+		}
+	}
+	for id := range stored {
+		if !inIndex[id] {
+			changes.removed = append(changes.removed, id)
+		}
+	}
+	sort.Strings(changes.removed)
+	return changes
+}
+
+func sameChartVersions(a, b []models.ChartVersion) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	versions := map[string]models.ChartVersion{}
+	for _, cv := range b {
+		versions[cv.Version] = cv
+	}
+	for _, cv := range a {
+		stored, ok := versions[cv.Version]
+		if !ok || stored.Digest != cv.Digest || stored.Verification != cv.Verification || stored.SignedBy != cv.SignedBy {
+			return false
+		}
+	}
+	return true
+}
+
 // Takes an entry from the index and constructs a database representation of the
 // object.
 func newChart(entry helmrepo.ChartVersions, r *models.Repo) models.Chart {
@@ -234,23 +435,36 @@ func chartTarballURL(r *models.RepoInternal, cv models.ChartVersion) string {
 	return source
 }
 
-func initNetClient(additionalCA string) (*http.Client, error) {
+// InitNetClient returns the HTTP client used to fetch the repositories,
+// trusting the additional CA file as well if it exists.
+func InitNetClient(additionalCA string) (*http.Client, error) {
+	var certs []byte
+	if _, err := os.Stat(additionalCA); !os.IsNotExist(err) {
+		certs, err = ioutil.ReadFile(additionalCA)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to append %s to RootCAs: %v", additionalCA, err)
+		}
+	}
+	client, err := NewNetClient(certs)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to append %s to RootCAs", additionalCA)
+	}
+	return client, nil
+}
+
+// NewNetClient returns the HTTP client used to fetch the repositories,
+// trusting the given PEM encoded CA certificates as well if any.
+func NewNetClient(additionalCA []byte) (*http.Client, error) {
 	// Get the SystemCertPool, continue with an empty pool on error
 	caCertPool, _ := x509.SystemCertPool()
 	if caCertPool == nil {
 		caCertPool = x509.NewCertPool()
 	}
 
-	// If additionalCA exists, load it
-	if _, err := os.Stat(additionalCA); !os.IsNotExist(err) {
-		certs, err := ioutil.ReadFile(additionalCA)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to append %s to RootCAs: %v", additionalCA, err)
-		}
-
-		// Append our cert to the system pool
-		if ok := caCertPool.AppendCertsFromPEM(certs); !ok {
-			return nil, fmt.Errorf("Failed to append %s to RootCAs", additionalCA)
+	// Append our cert to the system pool
+	if len(additionalCA) > 0 {
+		if ok := caCertPool.AppendCertsFromPEM(additionalCA); !ok {
+			return nil, fmt.Errorf("Failed to append the additional CA to RootCAs")
 		}
 	}
 
@@ -267,10 +481,13 @@ func initNetClient(additionalCA string) (*http.Client, error) {
 }
 
 type fileImporter struct {
-	manager assetManager
+	manager   AssetManager
+	netClient HTTPClient
 }
 
-func (f *fileImporter) fetchFiles(charts []models.Chart, r *models.RepoInternal) {
+// fetchFiles fetches the icons of the given icon charts and the files of
+// the versions of the charts which are not stored yet.
+func (f *fileImporter) fetchFiles(charts, icons []models.Chart, r *models.RepoInternal) {
 	// Process 10 charts at a time
 	numWorkers := 10
 	iconJobs := make(chan models.Chart, numWorkers)
@@ -284,7 +501,7 @@ func (f *fileImporter) fetchFiles(charts []models.Chart, r *models.RepoInternal)
 	}
 
 	// Enqueue jobs to process chart icons
-	for _, c := range charts {
+	for _, c := range icons {
 		iconJobs <- c
 	}
 	// Close the iconJobs channel to signal the worker pools to move on to the
@@ -316,16 +533,19 @@ func (f *fileImporter) fetchFiles(charts []models.Chart, r *models.RepoInternal)
 
 func (f *fileImporter) importWorker(wg *sync.WaitGroup, icons <-chan models.Chart, chartFiles <-chan importChartFilesJob, r *models.RepoInternal) {
 	defer wg.Done()
+	labels := metricLabels(r.Namespace, r.Name)
 	for c := range icons {
 		log.WithFields(log.Fields{"name": c.Name}).Debug("importing icon")
 		if err := f.fetchAndImportIcon(c, r); err != nil {
 			log.WithFields(log.Fields{"name": c.Name}).WithError(err).Error("failed to import icon")
+			iconFailures.With(labels).Inc()
 		}
 	}
 	for j := range chartFiles {
 		log.WithFields(log.Fields{"name": j.Name, "version": j.ChartVersion.Version}).Debug("importing readme and values")
 		if err := f.fetchAndImportFiles(j.Name, r, j.ChartVersion); err != nil {
 			log.WithFields(log.Fields{"name": j.Name, "version": j.ChartVersion.Version}).WithError(err).Error("failed to import files")
+			fileFailures.With(labels).Inc()
 		}
 	}
 }
@@ -345,7 +565,7 @@ func (f *fileImporter) fetchAndImportIcon(c models.Chart, r *models.RepoInternal
 		req.Header.Set("Authorization", r.AuthorizationHeader)
 	}
 
-	res, err := netClient.Do(req)
+	res, err := f.netClient.Do(req)
 	if res != nil {
 		defer res.Body.Close()
 	}
@@ -356,19 +576,20 @@ func (f *fileImporter) fetchAndImportIcon(c models.Chart, r *models.RepoInternal
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("%d %s", res.StatusCode, c.Icon)
 	}
+	body := countingReader{res.Body, bytesFetched.With(metricLabels(r.Namespace, r.Name))}
 
 	b := []byte{}
 	contentType := ""
 	if strings.Contains(res.Header.Get("Content-Type"), "image/svg") {
 		// if the icon is a SVG file simply read it
-		b, err = ioutil.ReadAll(res.Body)
+		b, err = ioutil.ReadAll(body)
 		if err != nil {
 			return err
 		}
 		contentType = res.Header.Get("Content-Type")
 	} else {
 		// if the icon is in any other format try to convert it to PNG
-		orig, err := imaging.Decode(res.Body)
+		orig, err := imaging.Decode(body)
 		if err != nil {
 			log.WithFields(log.Fields{"name": c.Name}).WithError(err).Error("failed to decode icon")
 			return err
@@ -407,16 +628,18 @@ func (f *fileImporter) fetchAndImportFiles(name string, r *models.RepoInternal,
 		req.Header.Set("Authorization", r.AuthorizationHeader)
 	}
 
-	res, err := netClient.Do(req)
+	res, err := f.netClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
+	labels := metricLabels(r.Namespace, r.Name)
+	tarballsDownloaded.With(labels).Inc()
 
 	// We read the whole chart into memory, this should be okay since the chart
 	// tarball needs to be small enough to fit into a GRPC call (Tiller
 	// requirement)
-	gzf, err := gzip.NewReader(res.Body)
+	gzf, err := gzip.NewReader(countingReader{res.Body, bytesFetched.With(labels)})
 	if err != nil {
 		return err
 	}
@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package assetsyncer
 
 import (
 	"archive/tar"
@@ -48,6 +48,9 @@ var validRepoIndexYAML = string(validRepoIndexYAMLBytes)
 
 var invalidRepoIndexYAML = "invalid"
 
+// netClient is the HTTP client the tests fetch the repositories with.
+var netClient HTTPClient
+
 type badHTTPClient struct{}
 
 func (h *badHTTPClient) Do(req *http.Request) (*http.Response, error) {
@@ -181,7 +184,7 @@ func Test_syncURLInvalidity(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, _, err := getRepo("namespace", "test", tt.repoURL, "")
+			_, _, err := getRepo(netClient, "namespace", "test", tt.repoURL, "")
 			assert.ExistsErr(t, err, tt.name)
 		})
 	}
@@ -202,20 +205,20 @@ func Test_fetchRepoIndex(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			netClient = &goodHTTPClient{}
-			_, err := fetchRepoIndex(tt.url, "")
+			_, err := fetchRepoIndex(netClient, tt.url, "")
 			assert.NoErr(t, err)
 		})
 	}
 
 	t.Run("authenticated request", func(t *testing.T) {
 		netClient = &authenticatedHTTPClient{}
-		_, err := fetchRepoIndex("https://my.examplerepo.com", "Bearer ThisSecretAccessTokenAuthenticatesTheClient")
+		_, err := fetchRepoIndex(netClient, "https://my.examplerepo.com", "Bearer ThisSecretAccessTokenAuthenticatesTheClient")
 		assert.NoErr(t, err)
 	})
 
 	t.Run("failed request", func(t *testing.T) {
 		netClient = &badHTTPClient{}
-		_, err := fetchRepoIndex("https://my.examplerepo.com", "")
+		_, err := fetchRepoIndex(netClient, "https://my.examplerepo.com", "")
 		assert.ExistsErr(t, err, "failed request")
 	})
 }
@@ -236,11 +239,11 @@ func Test_fetchRepoIndexUserAgent(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Override global variables used to generate the userAgent
 			if tt.version != "" {
-				version = tt.version
+				Version = tt.version
 			}
 
 			if tt.userAgentComment != "" {
-				userAgentComment = tt.userAgentComment
+				UserAgentComment = tt.userAgentComment
 			}
 
 			server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
@@ -252,7 +255,7 @@ func Test_fetchRepoIndexUserAgent(t *testing.T) {
 
 			netClient = server.Client()
 
-			_, err := fetchRepoIndex(server.URL, "")
+			_, err := fetchRepoIndex(netClient, server.URL, "")
 			assert.NoErr(t, err)
 		})
 	}
@@ -296,6 +299,71 @@ func Test_chartsFromIndex(t *testing.T) {
 	assert.Equal(t, len(charts), 2, "number of charts")
 }
 
+func Test_iconsToFetch(t *testing.T) {
+	repo := &models.Repo{Namespace: "namespace", Name: "test"}
+	charts := []models.Chart{
+		{ID: "test/unchanged", Icon: "https://example.com/unchanged.png", Repo: repo},
+		{ID: "test/added", Icon: "https://example.com/added.png", Repo: repo},
+		{ID: "test/no-icon", Repo: repo},
+	}
+	changes := chartChanges{added: []models.Chart{charts[1]}, unchanged: 2}
+
+	t.Run("icons of unchanged charts are stored", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("One", &models.Chart{}).Run(func(args mock.Arguments) {
+			args.Get(0).(*models.Chart).RawIcon = []byte("icon")
+		}).Return(nil)
+		icons := iconsToFetch(getMockManager(m), *repo, charts, changes)
+		assert.Equal(t, icons, []models.Chart{charts[1]}, "charts to fetch icons of")
+	})
+
+	t.Run("icons of unchanged charts are missing", func(t *testing.T) {
+		m := &mock.Mock{}
+		m.On("One", &models.Chart{}).Return(errors.New("not found"))
+		icons := iconsToFetch(getMockManager(m), *repo, charts, changes)
+		assert.Equal(t, icons, []models.Chart{charts[1], charts[0]}, "charts to fetch icons of")
+	})
+}
+
+func Test_diffCharts(t *testing.T) {
+	repo := &models.Repo{Namespace: "namespace", Name: "test"}
+	charts := []models.Chart{
+		{ID: "test/unchanged", Repo: repo, ChartVersions: []models.ChartVersion{{Version: "1.0.0", Digest: "a"}, {Version: "0.9.0", Digest: "b"}}},
+		{ID: "test/new-version", Repo: repo, ChartVersions: []models.ChartVersion{{Version: "2.0.0", Digest: "c"}, {Version: "1.0.0", Digest: "d"}}},
+		{ID: "test/new-digest", Repo: repo, ChartVersions: []models.ChartVersion{{Version: "1.0.0", Digest: "new"}}},
+		{ID: "test/added", Repo: repo, ChartVersions: []models.ChartVersion{{Version: "1.0.0", Digest: "e"}}},
+	}
+	stored := map[string][]models.ChartVersion{
+		"test/unchanged":   {{Version: "0.9.0", Digest: "b"}, {Version: "1.0.0", Digest: "a"}},
+		"test/new-version": {{Version: "1.0.0", Digest: "d"}},
+		"test/new-digest":  {{Version: "1.0.0", Digest: "old"}},
+		"test/removed-b":   {{Version: "1.0.0", Digest: "f"}},
+		"test/removed-a":   {{Version: "1.0.0", Digest: "g"}},
+	}
+
+	changes := diffCharts(charts, stored)
+	assert.Equal(t, changes.added, []models.Chart{charts[3]}, "added charts")
+	assert.Equal(t, changes.updated, []models.Chart{charts[1], charts[2]}, "updated charts")
+	assert.Equal(t, changes.removed, []string{"test/removed-a", "test/removed-b"}, "removed charts")
+	assert.Equal(t, changes.unchanged, 1, "unchanged charts")
+	assert.Equal(t, changes.upserts(), []models.Chart{charts[3], charts[1], charts[2]}, "upserted charts")
+
+	t.Run("charts with another verification are updated", func(t *testing.T) {
+		verified := []models.Chart{
+			{ID: "test/verified", Repo: repo, ChartVersions: []models.ChartVersion{{Version: "1.0.0", Digest: "a", Verification: "verified", SignedBy: "signer"}}},
+		}
+		changes := diffCharts(verified, map[string][]models.ChartVersion{"test/verified": {{Version: "1.0.0", Digest: "a"}}})
+		assert.Equal(t, changes.updated, verified, "updated charts")
+	})
+
+	t.Run("all charts are added without stored charts", func(t *testing.T) {
+		changes := diffCharts(charts, map[string][]models.ChartVersion{})
+		assert.Equal(t, len(changes.added), len(charts), "added charts")
+		assert.Equal(t, len(changes.updated), 0, "updated charts")
+		assert.Equal(t, len(changes.removed), 0, "removed charts")
+	})
+}
+
 func Test_newChart(t *testing.T) {
 	r := &models.Repo{Name: "test", URL: "http://testrepo.com"}
 	index, _ := parseRepoIndex([]byte(validRepoIndexYAML))
@@ -414,7 +482,7 @@ func createTestTarball(w io.Writer, files []tarballFile) {
 	}
 }
 
-func Test_initNetClient(t *testing.T) {
+func Test_InitNetClient(t *testing.T) {
 	// Test env
 	otherDir, err := ioutil.TempDir("", "ca-registry")
 	if err != nil {
@@ -447,7 +515,7 @@ h251U/Daz6NiQBM9AxyAw6EHm8XAZBvCuebfzyrT
 		t.Error(err)
 	}
 
-	_, err = initNetClient(otherCA)
+	_, err = InitNetClient(otherCA)
 	if err != nil {
 		t.Error(err)
 	}
@@ -470,7 +538,7 @@ func Test_getSha256(t *testing.T) {
 	assert.Equal(t, sha, "2e99758548972a8e8822ad47fa1017ff72f06f3ff6a016851f45c398732bc50c", "Unable to get sha")
 }
 
-func Test_newManager(t *testing.T) {
+func Test_NewManager(t *testing.T) {
 	tests := []struct {
 		name            string
 		database        string
@@ -486,7 +554,7 @@ func Test_newManager(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			config := datastore.Config{URL: tt.dbURL, Database: tt.dbName, Username: tt.dbUser, Password: tt.dbPass}
-			_, err := newManager(tt.database, config, "kubeapps")
+			_, err := NewManager(tt.database, config, "kubeapps")
 			assert.NoErr(t, err)
 		})
 	}
@@ -499,7 +567,7 @@ func Test_fetchAndImportIcon(t *testing.T) {
 		m := &mock.Mock{}
 		c := models.Chart{ID: "test/acs-engine-autoscaler"}
 		manager := getMockManager(m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		assert.NoErr(t, fImporter.fetchAndImportIcon(c, r))
 	})
 
@@ -511,7 +579,7 @@ func Test_fetchAndImportIcon(t *testing.T) {
 		c := charts[0]
 		m := &mock.Mock{}
 		manager := getMockManager(m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		assert.Err(t, fmt.Errorf("500 %s", c.Icon), fImporter.fetchAndImportIcon(c, r))
 	})
 
@@ -520,7 +588,7 @@ func Test_fetchAndImportIcon(t *testing.T) {
 		c := charts[0]
 		m := &mock.Mock{}
 		manager := getMockManager(m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		assert.Err(t, image.ErrFormat, fImporter.fetchAndImportIcon(c, r))
 	})
 
@@ -530,7 +598,7 @@ func Test_fetchAndImportIcon(t *testing.T) {
 		m := &mock.Mock{}
 		m.On("Upsert", bson.M{"chart_id": c.ID, "repo.name": c.Repo.Name, "repo.namespace": c.Repo.Namespace}, bson.M{"$set": bson.M{"raw_icon": iconBytes(), "icon_content_type": "image/png"}}).Return(nil)
 		manager := getMockManager(m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		assert.NoErr(t, fImporter.fetchAndImportIcon(c, r))
 		m.AssertExpectations(t)
 	})
@@ -546,7 +614,7 @@ func Test_fetchAndImportIcon(t *testing.T) {
 		m.On("Upsert", bson.M{"chart_id": c.ID, "repo.name": c.Repo.Name, "repo.namespace": c.Repo.Namespace}, bson.M{"$set": bson.M{"raw_icon": []byte("foo"), "icon_content_type": "image/svg"}}).Return(nil)
 
 		manager := getMockManager(m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		assert.NoErr(t, fImporter.fetchAndImportIcon(c, r))
 		m.AssertExpectations(t)
 	})
@@ -563,7 +631,7 @@ func Test_fetchAndImportFiles(t *testing.T) {
 		m.On("One", mock.Anything).Return(errors.New("return an error when checking if readme already exists to force fetching"))
 		netClient = &badHTTPClient{}
 		manager := getMockManager(&m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		assert.Err(t, io.EOF, fImporter.fetchAndImportFiles(charts[0].Name, repo, cv))
 	})
 
@@ -582,7 +650,7 @@ func Test_fetchAndImportFiles(t *testing.T) {
 		})
 
 		manager := getMockManager(&m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		err := fImporter.fetchAndImportFiles(charts[0].Name, repo, cv)
 		assert.NoErr(t, err)
 		m.AssertExpectations(t)
@@ -602,7 +670,7 @@ func Test_fetchAndImportFiles(t *testing.T) {
 			Digest: cv.Digest,
 		})
 		manager := getMockManager(&m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		r := &models.RepoInternal{Name: repo.Name, Namespace: repo.Namespace, URL: repo.URL, AuthorizationHeader: "Bearer ThisSecretAccessTokenAuthenticatesTheClient"}
 		err := fImporter.fetchAndImportFiles(charts[0].Name, r, cv)
 		assert.NoErr(t, err)
@@ -623,7 +691,7 @@ func Test_fetchAndImportFiles(t *testing.T) {
 			Digest: cv.Digest,
 		})
 		manager := getMockManager(&m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		err := fImporter.fetchAndImportFiles(charts[0].Name, repo, cv)
 		assert.NoErr(t, err)
 		m.AssertExpectations(t)
@@ -634,9 +702,82 @@ func Test_fetchAndImportFiles(t *testing.T) {
 		// don't return an error when checking if files already exists
 		m.On("One", mock.Anything).Return(nil)
 		manager := getMockManager(&m)
-		fImporter := fileImporter{manager}
+		fImporter := fileImporter{manager, netClient}
 		err := fImporter.fetchAndImportFiles(charts[0].Name, repo, cv)
 		assert.NoErr(t, err)
 		m.AssertNotCalled(t, "UpsertId", mock.Anything, mock.Anything)
 	})
 }
+
+type ociRegistryClient struct{}
+
+func (h *ociRegistryClient) Do(req *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	if req.Header.Get("Authorization") != "Basic Zm9vOmJhcg==" {
+		w.WriteHeader(401)
+		return w.Result(), nil
+	}
+	switch req.URL.Path {
+	case "/v2/charts/acs-engine-autoscaler/tags/list":
+		w.Write([]byte(`{"name": "charts/acs-engine-autoscaler", "tags": ["2.1.1", "not-a-chart"]}`))
+	case "/v2/charts/acs-engine-autoscaler/manifests/2.1.1":
+		w.Write([]byte(`{
+  "schemaVersion": 2,
+  "config": {"mediaType": "application/vnd.cncf.helm.config.v1+json", "digest": "sha256:123"},
+  "layers": [{"mediaType": "application/tar+gzip", "digest": "sha256:456"}],
+  "annotations": {"org.opencontainers.image.created": "2017-12-06T18:48:59Z"}
+}`))
+	case "/v2/charts/acs-engine-autoscaler/manifests/not-a-chart":
+		w.Write([]byte(`{
+  "schemaVersion": 2,
+  "config": {"mediaType": "application/vnd.docker.container.image.v1+json", "digest": "sha256:789"},
+  "layers": []
+}`))
+	case "/v2/charts/acs-engine-autoscaler/blobs/sha256:123":
+		w.Write([]byte(`{"name": "acs-engine-autoscaler", "version": "2.1.1", "appVersion": "2.1.1", "description": "Scales worker nodes within agent pools"}`))
+	default:
+		w.WriteHeader(404)
+	}
+	return w.Result(), nil
+}
+
+func Test_getOCIRepo(t *testing.T) {
+	netClient = &ociRegistryClient{}
+
+	t.Run("lists the tags of each repository", func(t *testing.T) {
+		repo, tags, err := getOCIRepo(netClient, "namespace", "test", "oci://registry.example.com", "Basic Zm9vOmJhcg==", []string{"charts/acs-engine-autoscaler"})
+		assert.NoErr(t, err)
+		assert.Equal(t, repo.URL, "oci://registry.example.com", "repo URL")
+		assert.Equal(t, tags["charts/acs-engine-autoscaler"], []string{"2.1.1", "not-a-chart"}, "tags")
+		assert.True(t, repo.Checksum != "", "checksum should be set")
+	})
+
+	t.Run("fails without repositories", func(t *testing.T) {
+		_, _, err := getOCIRepo(netClient, "namespace", "test", "oci://registry.example.com", "Basic Zm9vOmJhcg==", []string{})
+		assert.ExistsErr(t, err, "no repositories")
+	})
+
+	t.Run("fails with an unknown repository", func(t *testing.T) {
+		_, _, err := getOCIRepo(netClient, "namespace", "test", "oci://registry.example.com", "Basic Zm9vOmJhcg==", []string{"charts/unknown"})
+		assert.ExistsErr(t, err, "unknown repository")
+	})
+}
+
+func Test_ociRegistryIndex(t *testing.T) {
+	netClient = &ociRegistryClient{}
+	repo := &models.RepoInternal{Namespace: "namespace", Name: "test", URL: "oci://registry.example.com", AuthorizationHeader: "Basic Zm9vOmJhcg=="}
+	index, err := ociRegistryIndex(netClient, repo, map[string][]string{"charts/acs-engine-autoscaler": {"2.1.1", "not-a-chart"}})
+	assert.NoErr(t, err)
+	assert.Equal(t, len(index.Entries), 1, "number of charts")
+	versions := index.Entries["acs-engine-autoscaler"]
+	assert.Equal(t, len(versions), 1, "number of chart versions")
+	assert.Equal(t, versions[0].GetVersion(), "2.1.1", "chart version")
+	assert.Equal(t, versions[0].GetAppVersion(), "2.1.1", "app version")
+	assert.Equal(t, versions[0].Digest, "456", "chart digest")
+	assert.Equal(t, versions[0].URLs, []string{"https://registry.example.com/v2/charts/acs-engine-autoscaler/blobs/sha256:456"}, "chart URLs")
+	assert.Equal(t, versions[0].Created.Year(), 2017, "created")
+
+	charts := chartsFromIndex(index, &models.Repo{Namespace: repo.Namespace, Name: repo.Name, URL: repo.URL})
+	assert.Equal(t, len(charts), 1, "number of charts")
+	assert.Equal(t, charts[0].ID, "test/acs-engine-autoscaler", "chart ID")
+}
@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records who created, changed or deleted releases and app
+// repositories through Kubeapps.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ResourceRelease is the resource of the events of releases.
+	ResourceRelease = "release"
+	// ResourceAppRepository is the resource of the events of app repositories.
+	ResourceAppRepository = "apprepository"
+
+	// OutcomeSuccess is the outcome of an action which succeeded.
+	OutcomeSuccess = "success"
+	// OutcomeFailure is the outcome of an action which failed.
+	OutcomeFailure = "failure"
+)
+
+// Event is an entry of the audit log.
+type Event struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Cluster    string    `json:"cluster"`
+	Namespace  string    `json:"namespace"`
+	Resource   string    `json:"resource"`
+	Name       string    `json:"name"`
+	Action     string    `json:"action"`
+	Chart      string    `json:"chart,omitempty"`
+	Version    string    `json:"version,omitempty"`
+	Revision   int       `json:"revision,omitempty"`
+	ValuesHash string    `json:"valuesHash,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Sink stores the events of the audit log.
+type Sink interface {
+	Write(event Event) error
+}
+
+// UserResolver returns the name of the user authenticated by a bearer token
+// on a cluster.
+type UserResolver interface {
+	Resolve(token, cluster string) (string, error)
+}
+
+// Logger completes the events with the user who requested them and writes
+// them to its sinks. A nil Logger records nothing, so that the audit log can
+// be disabled.
+type Logger struct {
+	resolver UserResolver
+	sinks    []Sink
+	now      func() time.Time
+}
+
+// NewLogger returns a Logger writing to the given sinks.
+func NewLogger(resolver UserResolver, sinks ...Sink) *Logger {
+	return &Logger{resolver: resolver, sinks: sinks, now: time.Now}
+}
+
+// Record writes an event for an action requested with the given token, with
+// the outcome of the error of the action. Failures to resolve the user or to
+// write to a sink are logged but do not fail the action.
+func (l *Logger) Record(token string, event Event, err error) {
+	if l == nil {
+		return
+	}
+	event.Time = l.now().UTC()
+	event.Outcome = OutcomeSuccess
+	if err != nil {
+		event.Outcome = OutcomeFailure
+		event.Error = err.Error()
+	}
+	if token != "" && l.resolver != nil {
+		user, err := l.resolver.Resolve(token, event.Cluster)
+		if err != nil {
+			log.Errorf("Unable to resolve the user of the audit event: %v", err)
+		}
+		event.User = user
+	}
+	for _, sink := range l.sinks {
+		if err := sink.Write(event); err != nil {
+			log.Errorf("Unable to write the audit event: %v", err)
+		}
+	}
+}
+
+// ValuesHash returns a hash of the values of a release so that changes of
+// values can be tracked without storing values which may include secrets.
+func ValuesHash(values string) string {
+	if values == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(values))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
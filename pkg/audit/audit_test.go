@@ -0,0 +1,215 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type fakeResolver struct {
+	users map[string]string
+}
+
+func (r fakeResolver) Resolve(token, cluster string) (string, error) {
+	user, ok := r.users[cluster+"/"+token]
+	if !ok {
+		return "", errors.New("unknown token")
+	}
+	return user, nil
+}
+
+func TestLoggerRecord(t *testing.T) {
+	now := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+	resolver := fakeResolver{users: map[string]string{"default/abc": "alice"}}
+
+	testCases := []struct {
+		name     string
+		token    string
+		err      error
+		expected Event
+	}{
+		{
+			name:  "records a successful action of a user",
+			token: "abc",
+			expected: Event{
+				Time:       now,
+				User:       "alice",
+				Cluster:    "default",
+				Namespace:  "kubeapps",
+				Resource:   ResourceRelease,
+				Name:       "my-release",
+				Action:     "create",
+				Chart:      "apache",
+				Version:    "1.0.0",
+				ValuesHash: ValuesHash("foo: bar"),
+				Outcome:    OutcomeSuccess,
+			},
+		},
+		{
+			name:  "records a failed action",
+			token: "abc",
+			err:   errors.New("boom"),
+			expected: Event{
+				Time:       now,
+				User:       "alice",
+				Cluster:    "default",
+				Namespace:  "kubeapps",
+				Resource:   ResourceRelease,
+				Name:       "my-release",
+				Action:     "create",
+				Chart:      "apache",
+				Version:    "1.0.0",
+				ValuesHash: ValuesHash("foo: bar"),
+				Outcome:    OutcomeFailure,
+				Error:      "boom",
+			},
+		},
+		{
+			name:  "records the action of an unknown user",
+			token: "def",
+			expected: Event{
+				Time:       now,
+				Cluster:    "default",
+				Namespace:  "kubeapps",
+				Resource:   ResourceRelease,
+				Name:       "my-release",
+				Action:     "create",
+				Chart:      "apache",
+				Version:    "1.0.0",
+				ValuesHash: ValuesHash("foo: bar"),
+				Outcome:    OutcomeSuccess,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger := NewLogger(resolver, NewJSONSink(&buf))
+			logger.now = func() time.Time { return now }
+
+			logger.Record(tc.token, Event{
+				Cluster:    "default",
+				Namespace:  "kubeapps",
+				Resource:   ResourceRelease,
+				Name:       "my-release",
+				Action:     "create",
+				Chart:      "apache",
+				Version:    "1.0.0",
+				ValuesHash: ValuesHash("foo: bar"),
+			}, tc.err)
+
+			var got Event
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if !cmp.Equal(tc.expected, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(tc.expected, got))
+			}
+		})
+	}
+
+	t.Run("a nil logger records nothing", func(t *testing.T) {
+		var logger *Logger
+		logger.Record("abc", Event{}, nil)
+	})
+}
+
+func TestWebhookSink(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if received.Name == "rejected" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	sink := NewWebhookSink(server.URL)
+
+	if err := sink.Write(Event{Resource: ResourceAppRepository, Name: "bitnami", Action: "delete"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := received.Name, "bitnami"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	if err := sink.Write(Event{Name: "rejected"}); err == nil {
+		t.Errorf("got: nil, want: error")
+	}
+}
+
+func TestTokenReviewResolver(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if review.Spec.Token == "abc" {
+			review.Status = authenticationv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authenticationv1.UserInfo{Username: "alice"},
+			}
+		}
+		return true, review, nil
+	})
+	resolver := &tokenReviewResolver{
+		clientsetForCluster: func(cluster string) (kubernetes.Interface, error) {
+			if cluster != "default" {
+				return nil, fmt.Errorf("cluster %q has no service token configured", cluster)
+			}
+			return clientset, nil
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		token        string
+		cluster      string
+		expectedUser string
+		expectedErr  bool
+	}{
+		{name: "resolves an authenticated token", token: "abc", cluster: "default", expectedUser: "alice"},
+		{name: "fails for an unauthenticated token", token: "def", cluster: "default", expectedErr: true},
+		{name: "fails for a cluster without service token", token: "abc", cluster: "other", expectedErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user, err := resolver.Resolve(tc.token, tc.cluster)
+			if got, want := err != nil, tc.expectedErr; got != want {
+				t.Fatalf("got error: %v, want error: %t", err, want)
+			}
+			if got, want := user, tc.expectedUser; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubeapps/kubeapps/pkg/kube"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// tokenReviewResolver resolves the users of tokens with TokenReviews, created
+// with the service account of Kubeapps on the cluster of the token.
+type tokenReviewResolver struct {
+	// clientsetForCluster is a field on the struct only so it can be
+	// switched for a fake clientset when testing.
+	clientsetForCluster func(cluster string) (kubernetes.Interface, error)
+}
+
+// NewTokenReviewResolver returns a UserResolver using TokenReviews. Users of
+// additional clusters are only resolved on the clusters with a service token.
+func NewTokenReviewResolver(additionalClusters kube.AdditionalClustersConfig) (UserResolver, error) {
+	inClusterConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	svcClientset, err := kubernetes.NewForConfig(inClusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenReviewResolver{
+		clientsetForCluster: func(cluster string) (kubernetes.Interface, error) {
+			if cluster == kube.DefaultClusterName {
+				return svcClientset, nil
+			}
+			additionalCluster, ok := additionalClusters[cluster]
+			if !ok || additionalCluster.ServiceToken == "" {
+				return nil, fmt.Errorf("cluster %q has no service token configured", cluster)
+			}
+			config, err := kube.NewClusterConfig(inClusterConfig, additionalCluster.ServiceToken, cluster, additionalClusters)
+			if err != nil {
+				return nil, err
+			}
+			return kubernetes.NewForConfig(config)
+		},
+	}, nil
+}
+
+func (r *tokenReviewResolver) Resolve(token, cluster string) (string, error) {
+	clientset, err := r.clientsetForCluster(cluster)
+	if err != nil {
+		return "", err
+	}
+	review, err := clientset.AuthenticationV1().TokenReviews().Create(context.TODO(), &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	if !review.Status.Authenticated {
+		return "", fmt.Errorf("token not authenticated on cluster %q: %s", cluster, review.Status.Error)
+	}
+	return review.Status.User.Username, nil
+}
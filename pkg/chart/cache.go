@@ -0,0 +1,283 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chart
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	indexCacheName   = "index"
+	tarballCacheName = "tarball"
+
+	// tarballExtension is the extension of the tarballs persisted in the
+	// directory of the tarball cache, named by their digest.
+	tarballExtension = ".tgz"
+)
+
+// CacheOptions are the limits of the caches of repository indexes and chart
+// tarballs.
+type CacheOptions struct {
+	// IndexCacheSize is the maximum size in bytes of the repository indexes
+	// kept parsed in memory, measured by the size of their YAML.
+	IndexCacheSize int64
+	// TarballCacheSize is the maximum size in bytes of the chart tarballs
+	// kept in memory and, if any, in the cache directory.
+	TarballCacheSize int64
+	// Dir is a directory where the chart tarballs are persisted so that they
+	// are not downloaded again after a restart. They are only kept in memory
+	// if empty.
+	Dir string
+}
+
+// DefaultCacheOptions are the cache limits used unless ConfigureCache is
+// called.
+var DefaultCacheOptions = CacheOptions{
+	IndexCacheSize:   128 << 20,
+	TarballCacheSize: 256 << 20,
+}
+
+var (
+	indexCache   = newLRUCache(indexCacheName, DefaultCacheOptions.IndexCacheSize)
+	tarballCache = &tarballStore{lru: newLRUCache(tarballCacheName, DefaultCacheOptions.TarballCacheSize)}
+)
+
+// ConfigureCache replaces the caches of repository indexes and chart tarballs
+// with empty ones with the given limits, loading the tarballs persisted in
+// the cache directory if any.
+func ConfigureCache(options CacheOptions) error {
+	tarballs := &tarballStore{lru: newLRUCache(tarballCacheName, options.TarballCacheSize), dir: options.Dir}
+	if options.Dir != "" {
+		if err := tarballs.load(); err != nil {
+			return fmt.Errorf("unable to load the chart cache from %q: %v", options.Dir, err)
+		}
+	}
+	indexCache = newLRUCache(indexCacheName, options.IndexCacheSize)
+	tarballCache = tarballs
+	return nil
+}
+
+type cacheEntry struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+// lruCache is a concurrency-safe cache which evicts its least recently used
+// entries when the total size of its entries exceeds its maximum size. A
+// maximum size of zero or less means the cache is unbounded.
+type lruCache struct {
+	name    string
+	maxSize int64
+	// onEvict is called, while holding the lock, for the evicted entries.
+	onEvict func(entry *cacheEntry)
+
+	mutex   sync.Mutex
+	size    int64
+	entries *list.List
+	items   map[string]*list.Element
+}
+
+func newLRUCache(name string, maxSize int64) *lruCache {
+	return &lruCache{
+		name:    name,
+		maxSize: maxSize,
+		entries: list.New(),
+		items:   map[string]*list.Element{},
+	}
+}
+
+// get returns the value of a key, marking it as the most recently used.
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.items[key]
+	if !ok {
+		recordCacheLookup(c.name, false)
+		return nil, false
+	}
+	c.entries.MoveToFront(element)
+	recordCacheLookup(c.name, true)
+	return element.Value.(*cacheEntry).value, true
+}
+
+// add stores the value of a key, evicting the least recently used entries if
+// needed. It returns false if the value is larger than the cache itself.
+func (c *lruCache) add(key string, value interface{}, size int64) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.maxSize > 0 && size > c.maxSize {
+		return false
+	}
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element, false)
+	}
+	c.items[key] = c.entries.PushFront(&cacheEntry{key: key, value: value, size: size})
+	c.size += size
+	for c.maxSize > 0 && c.size > c.maxSize {
+		c.removeElement(c.entries.Back(), true)
+	}
+	cacheSize.WithLabelValues(c.name).Set(float64(c.size))
+	return true
+}
+
+// remove deletes a key from the cache.
+func (c *lruCache) remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element, true)
+		cacheSize.WithLabelValues(c.name).Set(float64(c.size))
+	}
+}
+
+func (c *lruCache) removeElement(element *list.Element, evicted bool) {
+	entry := c.entries.Remove(element).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+	if evicted && c.onEvict != nil {
+		c.onEvict(entry)
+	}
+}
+
+func (c *lruCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.entries.Len()
+}
+
+// tarballStore caches the chart tarballs by their SHA-256 digest, so that
+// the same tarball is reused whatever its URL and a tarball is never
+// returned for a digest it does not match.
+type tarballStore struct {
+	lru *lruCache
+	dir string
+}
+
+// get returns the tarball of a digest.
+func (s *tarballStore) get(digest string) ([]byte, bool) {
+	value, ok := s.lru.get(digest)
+	if !ok {
+		return nil, false
+	}
+	return value.([]byte), true
+}
+
+// add stores a tarball, which must match the digest, in memory and in the
+// cache directory if any. Failures to persist a tarball are only logged
+// since it is still cached in memory.
+func (s *tarballStore) add(digest string, data []byte) {
+	if !s.lru.add(digest, data, int64(len(data))) || s.dir == "" {
+		return
+	}
+	err := ioutil.WriteFile(s.path(digest), data, 0644)
+	if err != nil {
+		log.Printf("Unable to persist the chart tarball %s: %v", digest, err)
+	}
+}
+
+func (s *tarballStore) path(digest string) string {
+	return filepath.Join(s.dir, digest+tarballExtension)
+}
+
+// load fills the cache with the tarballs of the cache directory, most
+// recently modified first, deleting those which do not match their digest or
+// do not fit in the cache.
+func (s *tarballStore) load() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	var size int64
+	tarballs := []*cacheEntry{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), tarballExtension) {
+			continue
+		}
+		digest := strings.TrimSuffix(file.Name(), tarballExtension)
+		filePath := s.path(digest)
+		if s.lru.maxSize > 0 && size+file.Size() > s.lru.maxSize {
+			os.Remove(filePath)
+			continue
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		if tarballDigest(data) != digest {
+			log.Printf("Removing the chart tarball %s from the cache since it does not match its digest", digest)
+			os.Remove(filePath)
+			continue
+		}
+		size += int64(len(data))
+		tarballs = append(tarballs, &cacheEntry{key: digest, value: data, size: int64(len(data))})
+	}
+	// The least recently modified tarballs are added first so that they are
+	// the first evicted.
+	for i := len(tarballs) - 1; i >= 0; i-- {
+		s.lru.add(tarballs[i].key, tarballs[i].value, tarballs[i].size)
+	}
+	s.lru.onEvict = func(entry *cacheEntry) {
+		if err := os.Remove(s.path(entry.key)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Unable to remove the chart tarball %s from the cache: %v", entry.key, err)
+		}
+	}
+	return nil
+}
+
+// tarballDigest returns the hex encoded SHA-256 digest of a tarball, as used
+// in the repository indexes.
+func tarballDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeDigest returns the hex encoded SHA-256 digest of a digest of a
+// repository index or an OCI registry, which prefixes it with its algorithm,
+// or an empty string for other algorithms.
+func normalizeDigest(digest string) string {
+	digest = strings.ToLower(strings.TrimSpace(digest))
+	if strings.Contains(digest, ":") {
+		if !strings.HasPrefix(digest, "sha256:") {
+			return ""
+		}
+		digest = strings.TrimPrefix(digest, "sha256:")
+	}
+	if len(digest) != sha256.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(digest); err != nil {
+		return ""
+	}
+	return digest
+}
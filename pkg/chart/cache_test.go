@@ -0,0 +1,234 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chart
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kubeapps/kubeapps/pkg/kube"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache("test", 10)
+	evicted := []string{}
+	cache.onEvict = func(entry *cacheEntry) {
+		evicted = append(evicted, entry.key)
+	}
+	hits := cacheLookups.WithLabelValues("test", "hit")
+	misses := cacheLookups.WithLabelValues("test", "miss")
+
+	cache.add("a", "A", 4)
+	cache.add("b", "B", 4)
+	// Looking up "a" makes "b" the least recently used entry.
+	if value, ok := cache.get("a"); !ok || value != "A" {
+		t.Errorf("got: %v, want: %q", value, "A")
+	}
+	cache.add("c", "C", 4)
+	if _, ok := cache.get("b"); ok {
+		t.Errorf("got: %q cached, want: evicted", "b")
+	}
+	if got, want := evicted, []string{"b"}; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if cache.add("d", "D", 11) {
+		t.Errorf("got: %q cached, want: larger than the cache", "d")
+	}
+	if got, want := cache.len(), 2; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+
+	if got, want := testutil.ToFloat64(hits), 1.0; got != want {
+		t.Errorf("got: %v hits, want: %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(misses), 1.0; got != want {
+		t.Errorf("got: %v misses, want: %v", got, want)
+	}
+	if got, want := testutil.ToFloat64(cacheSize.WithLabelValues("test")), 8.0; got != want {
+		t.Errorf("got: %v bytes, want: %v", got, want)
+	}
+}
+
+func TestTarballStorePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chart-cache")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	first, second, third := []byte("first"), []byte("second"), []byte("third")
+	store := &tarballStore{lru: newLRUCache(tarballCacheName, 12), dir: dir}
+	if err := store.load(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	store.add(tarballDigest(first), first)
+	store.add(tarballDigest(second), second)
+	// Adding the third tarball evicts the first one, also from the directory.
+	store.add(tarballDigest(third), third)
+	// A tarball which does not match its digest is removed when loading.
+	corrupted := filepath.Join(dir, tarballDigest([]byte("other"))+tarballExtension)
+	if err := ioutil.WriteFile(corrupted, []byte("corrupted"), 0644); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	restarted := &tarballStore{lru: newLRUCache(tarballCacheName, 12), dir: dir}
+	if err := restarted.load(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, tarball := range [][]byte{second, third} {
+		if data, ok := restarted.get(tarballDigest(tarball)); !ok || string(data) != string(tarball) {
+			t.Errorf("got: %q, want: %q", data, tarball)
+		}
+	}
+	if _, ok := restarted.get(tarballDigest(first)); ok {
+		t.Errorf("got: %q cached, want: evicted", first)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	fileNames := []string{}
+	for _, file := range files {
+		fileNames = append(fileNames, file.Name())
+	}
+	expectedFileNames := []string{tarballDigest(second) + tarballExtension, tarballDigest(third) + tarballExtension}
+	sort.Strings(expectedFileNames)
+	if got, want := fileNames, expectedFileNames; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestTarballStoreLoadKeepsMostRecent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chart-cache")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	older, newer := []byte("older"), []byte("newer")
+	for i, tarball := range [][]byte{older, newer} {
+		filePath := filepath.Join(dir, tarballDigest(tarball)+tarballExtension)
+		if err := ioutil.WriteFile(filePath, tarball, 0644); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		modTime := time.Now().Add(time.Duration(i-2) * time.Hour)
+		if err := os.Chtimes(filePath, modTime, modTime); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	store := &tarballStore{lru: newLRUCache(tarballCacheName, 8), dir: dir}
+	if err := store.load(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := store.get(tarballDigest(newer)); !ok {
+		t.Errorf("got: %q evicted, want: cached", newer)
+	}
+	if _, ok := store.get(tarballDigest(older)); ok {
+		t.Errorf("got: %q cached, want: evicted", older)
+	}
+}
+
+func TestNormalizeDigest(t *testing.T) {
+	digest := tarballDigest([]byte("chart"))
+	testCases := []struct {
+		name     string
+		digest   string
+		expected string
+	}{
+		{"digest of a repository index", digest, digest},
+		{"digest of an OCI registry", "sha256:" + strings.ToUpper(digest), digest},
+		{"digest of another algorithm", "sha512:" + digest, ""},
+		{"invalid digest", "sha256:123", ""},
+		{"no digest", "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := normalizeDigest(tc.digest), tc.expected; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFetchChartTarball(t *testing.T) {
+	tarball := []byte("tarball")
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		downloads++
+		w.Write(tarball)
+	}))
+	defer server.Close()
+	var netClient kube.HTTPClient = server.Client()
+
+	testCases := []struct {
+		name              string
+		digest            string
+		fetches           int
+		expectedDownloads int
+		errorExpected     bool
+	}{
+		{
+			name:              "downloads a tarball once",
+			digest:            tarballDigest(tarball),
+			fetches:           2,
+			expectedDownloads: 1,
+		},
+		{
+			name:              "does not cache a tarball without digest",
+			fetches:           2,
+			expectedDownloads: 2,
+		},
+		{
+			name:              "returns an error for a tarball which does not match its digest",
+			digest:            tarballDigest([]byte("other")),
+			fetches:           1,
+			expectedDownloads: 1,
+			errorExpected:     true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tarballCache = &tarballStore{lru: newLRUCache(tarballCacheName, 0)}
+			downloads = 0
+			for i := 0; i < tc.fetches; i++ {
+				data, err := fetchChartTarball(&netClient, server.URL+"/chart-1.0.0.tgz", tc.digest)
+				if got, want := err != nil, tc.errorExpected; got != want {
+					t.Fatalf("got error: %v, want error: %t", err, want)
+				}
+				if err == nil && string(data) != string(tarball) {
+					t.Errorf("got: %q, want: %q", data, tarball)
+				}
+			}
+			if got, want := downloads, tc.expectedDownloads; got != want {
+				t.Errorf("got: %d downloads, want: %d", got, want)
+			}
+			if tc.errorExpected && tarballCache.lru.len() != 0 {
+				t.Errorf("got: the tarball cached, want: not cached")
+			}
+		})
+	}
+}
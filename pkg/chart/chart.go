@@ -20,17 +20,20 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
 
 	"github.com/ghodss/yaml"
 	appRepov1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
 	"github.com/kubeapps/kubeapps/pkg/kube"
+	"github.com/kubeapps/kubeapps/pkg/oci"
 	helm3chart "helm.sh/helm/v3/pkg/chart"
 	helm3loader "helm.sh/helm/v3/pkg/chart/loader"
 	corev1 "k8s.io/api/core/v1"
@@ -50,12 +53,6 @@ type repoIndex struct {
 	index    *repo.IndexFile
 }
 
-var repoIndexes map[string]*repoIndex
-
-func init() {
-	repoIndexes = map[string]*repoIndex{}
-}
-
 // Details contains the information to retrieve a Chart
 type Details struct {
 	// AppRepositoryResourceName specifies an app repository resource to use
@@ -92,6 +89,7 @@ type Resolver interface {
 	GetChart(details *Details, netClient kube.HTTPClient, requireV1Support bool) (*ChartMultiVersion, error)
 	InitNetClient(details *Details, userAuthToken string) (kube.HTTPClient, error)
 	RegistrySecretsPerDomain() map[string]string
+	AppRepository() *appRepov1.AppRepository
 }
 
 // ChartClient struct contains the clients required to retrieve charts info
@@ -101,6 +99,15 @@ type ChartClient struct {
 	kubeappsNamespace        string
 	appRepo                  *appRepov1.AppRepository
 	registrySecretsPerDomain map[string]string
+	userAuthToken            string
+}
+
+// appRepoClient gets the app repositories and their secrets on behalf of a
+// user or the service account.
+type appRepoClient interface {
+	GetAppRepository(repoName, repoNamespace string) (*appRepov1.AppRepository, error)
+	ListAppRepositories(repoNamespace string) ([]appRepov1.AppRepository, error)
+	GetSecret(name, namespace string) (*corev1.Secret, error)
 }
 
 // NewChartClient returns a new ChartClient
@@ -152,15 +159,18 @@ func checksum(data []byte) string {
 // is an expensive operation. See https://github.com/kubeapps/kubeapps/issues/1052
 func getIndexFromCache(repoURL string, data []byte) (*repo.IndexFile, string) {
 	sha := checksum(data)
-	if repoIndexes[repoURL] == nil || repoIndexes[repoURL].checksum != sha {
+	cached, ok := indexCache.get(repoURL)
+	if !ok || cached.(*repoIndex).checksum != sha {
 		// The repository is not in the cache or the content changed
 		return nil, sha
 	}
-	return repoIndexes[repoURL].index, sha
+	return cached.(*repoIndex).index, sha
 }
 
-func storeIndexInCache(repoURL string, index *repo.IndexFile, sha string) {
-	repoIndexes[repoURL] = &repoIndex{sha, index}
+// storeIndexInCache caches a parsed index, whose size is the size of its
+// YAML.
+func storeIndexInCache(repoURL string, index *repo.IndexFile, sha string, size int) {
+	indexCache.add(repoURL, &repoIndex{sha, index}, int64(size))
 }
 
 func parseIndex(data []byte) (*repo.IndexFile, error) {
@@ -196,7 +206,7 @@ func fetchRepoIndex(netClient *kube.HTTPClient, repoURL string) (*repo.IndexFile
 		if err != nil {
 			return nil, err
 		}
-		storeIndexInCache(repoURL, index, sha)
+		storeIndexInCache(repoURL, index, sha, len(data))
 	}
 	return index, nil
 }
@@ -213,24 +223,100 @@ func resolveChartURL(index, chart string) (string, error) {
 	return chartURL.String(), nil
 }
 
-// findChartInRepoIndex returns the URL of a chart given a Helm repository and its name and version
-func findChartInRepoIndex(repoIndex *repo.IndexFile, repoURL, chartName, chartVersion string) (string, error) {
+// findChartInRepoIndex returns the URL and the digest of a chart given a Helm repository and its name and version
+func findChartInRepoIndex(repoIndex *repo.IndexFile, repoURL, chartName, chartVersion string) (string, string, error) {
 	errMsg := fmt.Sprintf("chart %q", chartName)
 	if chartVersion != "" {
 		errMsg = fmt.Sprintf("%s version %q", errMsg, chartVersion)
 	}
 	cv, err := repoIndex.Get(chartName, chartVersion)
 	if err != nil {
-		return "", fmt.Errorf("%s not found in repository", errMsg)
+		return "", "", fmt.Errorf("%s not found in repository", errMsg)
 	}
 	if len(cv.URLs) == 0 {
-		return "", fmt.Errorf("%s has no downloadable URLs", errMsg)
+		return "", "", fmt.Errorf("%s has no downloadable URLs", errMsg)
 	}
-	return resolveChartURL(repoURL, cv.URLs[0])
+	chartURL, err := resolveChartURL(repoURL, cv.URLs[0])
+	if err != nil {
+		return "", "", err
+	}
+	return chartURL, cv.Digest, nil
 }
 
-// fetchChart returns the Chart content given an URL
-func fetchChart(netClient *kube.HTTPClient, chartURL string, requireV1Support bool) (*ChartMultiVersion, error) {
+// findChartInOCIRegistry returns the URL and the digest of the chart layer for the given
+// chart version in one of the repositories of an OCI registry. The charts are
+// identified by the name of their metadata, as they are indexed when synced.
+// Since registries cannot be searched, the repositories whose last path
+// element matches the chart name are looked up first.
+func findChartInOCIRegistry(netClient kube.HTTPClient, appRepo *appRepov1.AppRepository, chartName, chartVersion string) (string, string, error) {
+	errMsg := fmt.Sprintf("chart %q version %q", chartName, chartVersion)
+	if chartVersion == "" {
+		return "", "", fmt.Errorf("a version is required to fetch chart %q from an OCI registry", chartName)
+	}
+	registry, err := oci.NewRegistry(appRepo.Spec.URL, netClient, nil)
+	if err != nil {
+		return "", "", err
+	}
+	// OCI tags cannot contain "+" so Helm replaces it with "_" when pushing.
+	tag := strings.Replace(chartVersion, "+", "_", -1)
+	for _, ociRepo := range ociReposByName(appRepo.Spec.OCIRepositories, chartName) {
+		manifest, err := registry.GetManifest(ociRepo, tag)
+		if errors.Is(err, oci.ErrUnauthorized) {
+			return "", "", fmt.Errorf("%s not found in registry: %v", errMsg, err)
+		}
+		if err != nil || !oci.IsChart(manifest) {
+			// The version does not exist in this repository.
+			continue
+		}
+		// The config blob of a chart contains the Chart.yaml metadata as JSON.
+		config, err := registry.GetBlob(ociRepo, manifest.Config.Digest)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to get the metadata of %s: %v", errMsg, err)
+		}
+		var metadata helm3chart.Metadata
+		if err := json.Unmarshal(config, &metadata); err != nil || metadata.Name != chartName {
+			continue
+		}
+		layer, err := oci.ChartLayer(manifest)
+		if err != nil {
+			return "", "", fmt.Errorf("%s has no downloadable layer: %v", errMsg, err)
+		}
+		return registry.BlobURL(ociRepo, layer.Digest), layer.Digest, nil
+	}
+	return "", "", fmt.Errorf("%s not found in registry", errMsg)
+}
+
+// ociReposByName returns the repositories of an OCI registry, those whose
+// last path element is the chart name first.
+func ociReposByName(ociRepos []string, chartName string) []string {
+	sorted := make([]string, 0, len(ociRepos))
+	var others []string
+	for _, ociRepo := range ociRepos {
+		if path.Base(ociRepo) == chartName {
+			sorted = append(sorted, ociRepo)
+		} else {
+			others = append(others, ociRepo)
+		}
+	}
+	return append(sorted, others...)
+}
+
+// findChartURL returns the URL and the digest of a chart in the Helm
+// repository or OCI registry of an app repository.
+func findChartURL(netClient kube.HTTPClient, appRepo *appRepov1.AppRepository, chartName, chartVersion string) (string, string, error) {
+	if appRepo.Spec.Type == "oci" {
+		return findChartInOCIRegistry(netClient, appRepo, chartName, chartVersion)
+	}
+	indexURL := strings.TrimSuffix(strings.TrimSpace(appRepo.Spec.URL), "/") + "/index.yaml"
+	repoIndex, err := fetchRepoIndex(&netClient, indexURL)
+	if err != nil {
+		return "", "", err
+	}
+	return findChartInRepoIndex(repoIndex, indexURL, chartName, chartVersion)
+}
+
+// downloadChart returns the tarball of a chart given an URL
+func downloadChart(netClient *kube.HTTPClient, chartURL string) ([]byte, error) {
 	req, err := getReq(chartURL)
 	if err != nil {
 		return nil, err
@@ -240,10 +326,45 @@ func fetchChart(netClient *kube.HTTPClient, chartURL string, requireV1Support bo
 	if err != nil {
 		return nil, err
 	}
-	data, err := readResponseBody(res)
+	return readResponseBody(res)
+}
+
+// fetchChartTarball returns the tarball of a chart given an URL and its
+// digest, from the cache if it was already downloaded. The downloaded tarball
+// must match the digest. Tarballs without a SHA-256 digest are not cached.
+func fetchChartTarball(netClient *kube.HTTPClient, chartURL, digest string) ([]byte, error) {
+	digest = normalizeDigest(digest)
+	if digest != "" {
+		if data, ok := tarballCache.get(digest); ok {
+			return data, nil
+		}
+	}
+	log.Printf("Downloading %s ...", chartURL)
+	data, err := downloadChart(netClient, chartURL)
+	if err != nil {
+		return nil, err
+	}
+	if digest == "" {
+		return data, nil
+	}
+	if tarballDigest(data) != digest {
+		return nil, fmt.Errorf("the chart downloaded from %s does not match its digest %q", chartURL, digest)
+	}
+	tarballCache.add(digest, data)
+	return data, nil
+}
+
+// fetchChart returns the Chart content given an URL and its digest
+func fetchChart(netClient *kube.HTTPClient, chartURL, digest string, requireV1Support bool) (*ChartMultiVersion, error) {
+	data, err := fetchChartTarball(netClient, chartURL, digest)
 	if err != nil {
 		return nil, err
 	}
+	return loadChart(data, requireV1Support)
+}
+
+// loadChart returns the Chart content of a tarball
+func loadChart(data []byte, requireV1Support bool) (*ChartMultiVersion, error) {
 	// We only return an error when loading using the helm2loader (ie. chart v1)
 	// if we require v1 support, otherwise we continue to load using the
 	// helm3 v2 loader.
@@ -277,43 +398,66 @@ func (c *ChartClient) ParseDetails(data []byte) (*Details, error) {
 	return details, nil
 }
 
+// clientForNamespace returns a client for the app repositories of a
+// namespace.
+func (c *ChartClient) clientForNamespace(namespace string) (appRepoClient, error) {
+	if namespace == c.kubeappsNamespace {
+		// If we're parsing a global repository (from the kubeappsNamespace), use a service client.
+		return c.appRepoHandler.AsSVC(), nil
+	}
+	client, err := c.appRepoHandler.AsUser(c.userAuthToken, kube.DefaultClusterName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create clientset: %v", err)
+	}
+	return client, nil
+}
+
 func (c *ChartClient) parseDetailsForHTTPClient(details *Details, userAuthToken string) (*appRepov1.AppRepository, *corev1.Secret, *corev1.Secret, error) {
+	c.userAuthToken = userAuthToken
 	// We grab the specified app repository (for later access to the repo URL, as well as any specified
 	// auth).
-	client, err := c.appRepoHandler.AsUser(userAuthToken, kube.DefaultClusterName)
+	client, err := c.clientForNamespace(details.AppRepositoryResourceNamespace)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("unable to create clientset: %v", err)
-	}
-	if details.AppRepositoryResourceNamespace == c.kubeappsNamespace {
-		// If we're parsing a global repository (from the kubeappsNamespace), use a service client.
-		client = c.appRepoHandler.AsSVC()
+		return nil, nil, nil, err
 	}
 	appRepo, err := client.GetAppRepository(details.AppRepositoryResourceName, details.AppRepositoryResourceNamespace)
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("unable to get app repository %q: %v", details.AppRepositoryResourceName, err)
 	}
 	c.appRepo = appRepo
+
+	caCertSecret, authSecret, err := getAppRepoSecrets(client, appRepo)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return appRepo, caCertSecret, authSecret, nil
+}
+
+// getAppRepoSecrets returns the secrets of the custom CA and the
+// authorization header of an app repository, if any.
+func getAppRepoSecrets(client appRepoClient, appRepo *appRepov1.AppRepository) (*corev1.Secret, *corev1.Secret, error) {
 	auth := appRepo.Spec.Auth
 
 	var caCertSecret *corev1.Secret
+	var err error
 	if auth.CustomCA != nil {
 		secretName := auth.CustomCA.SecretKeyRef.Name
-		caCertSecret, err = client.GetSecret(secretName, details.AppRepositoryResourceNamespace)
+		caCertSecret, err = client.GetSecret(secretName, appRepo.Namespace)
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("unable to read secret %q: %v", auth.CustomCA.SecretKeyRef.Name, err)
+			return nil, nil, fmt.Errorf("unable to read secret %q: %v", auth.CustomCA.SecretKeyRef.Name, err)
 		}
 	}
 
 	var authSecret *corev1.Secret
 	if auth.Header != nil {
 		secretName := auth.Header.SecretKeyRef.Name
-		authSecret, err = client.GetSecret(secretName, details.AppRepositoryResourceNamespace)
+		authSecret, err = client.GetSecret(secretName, appRepo.Namespace)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, nil, err
 		}
 	}
 
-	return appRepo, caCertSecret, authSecret, nil
+	return caCertSecret, authSecret, nil
 }
 
 // InitNetClient returns an HTTP client based on the chart details loading a
@@ -329,30 +473,52 @@ func (c *ChartClient) InitNetClient(details *Details, userAuthToken string) (kub
 		return nil, err
 	}
 
-	return kube.InitNetClient(appRepo, caCertSecret, authSecret, http.Header{"User-Agent": []string{c.userAgent}})
+	return c.newNetClient(appRepo, caCertSecret, authSecret)
+}
+
+// newNetClient returns an HTTP client for an app repository. The requests
+// to OCI registries are authenticated with a Bearer token when they are
+// challenged, which includes the download of the chart blobs.
+func (c *ChartClient) newNetClient(appRepo *appRepov1.AppRepository, caCertSecret, authSecret *corev1.Secret) (kube.HTTPClient, error) {
+	netClient, err := kube.InitNetClient(appRepo, caCertSecret, authSecret, http.Header{"User-Agent": []string{c.userAgent}})
+	if err != nil {
+		return nil, err
+	}
+	if appRepo.Spec.Type == "oci" {
+		return oci.NewTokenClient(netClient), nil
+	}
+	return netClient, nil
 }
 
 // GetChart retrieves and loads a Chart from a registry in both
-// v2 and v3 formats.
+// v2 and v3 formats. Charts of app repositories requiring signatures must be
+// signed by one of their trusted keys. The dependencies of the v3 chart which are not vendored
+// in it are resolved from the known app repositories.
 func (c *ChartClient) GetChart(details *Details, netClient kube.HTTPClient, requireV1Support bool) (*ChartMultiVersion, error) {
-	indexURL := strings.TrimSuffix(strings.TrimSpace(c.appRepo.Spec.URL), "/") + "/index.yaml"
+	chartURL, digest, err := findChartURL(netClient, c.appRepo, details.ChartName, details.Version)
+	if err != nil {
+		return nil, err
+	}
 
-	repoIndex, err := fetchRepoIndex(&netClient, indexURL)
+	data, err := fetchChartTarball(&netClient, chartURL, digest)
 	if err != nil {
 		return nil, err
 	}
 
-	chartURL, err := findChartInRepoIndex(repoIndex, indexURL, details.ChartName, details.Version)
+	err = c.verifyChart(netClient, c.appRepo, chartURL, data)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Downloading %s ...", chartURL)
-	chart, err := fetchChart(&netClient, chartURL, requireV1Support)
+	chart, err := loadChart(data, requireV1Support)
 	if err != nil {
 		return nil, err
 	}
 
+	err = c.resolveDependencies(chart.Helm3Chart, netClient, 0)
+	if err != nil {
+		return nil, err
+	}
 	return chart, nil
 }
 
@@ -365,6 +531,12 @@ func (c *ChartClient) RegistrySecretsPerDomain() map[string]string {
 	return c.registrySecretsPerDomain
 }
 
+// AppRepository returns the app repository of the chart, once known with
+// InitNetClient.
+func (c *ChartClient) AppRepository() *appRepov1.AppRepository {
+	return c.appRepo
+}
+
 func getRegistrySecretsPerDomain(appRepoSecrets []string, namespace, token string, authHandler kube.AuthHandler) (map[string]string, error) {
 	secretsPerDomain := map[string]string{}
 	client, err := authHandler.AsUser(token, kube.DefaultClusterName)
@@ -23,6 +23,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"testing"
@@ -97,7 +98,7 @@ func TestFindChartInRepoIndex(t *testing.T) {
 	entries[name] = chartVersions
 	index := &repo.IndexFile{APIVersion: "v1", Generated: time.Now(), Entries: entries}
 
-	res, err := findChartInRepoIndex(index, repoURL, name, version)
+	res, _, err := findChartInRepoIndex(index, repoURL, name, version)
 	if err != nil {
 		t.Errorf("Unexpected error %v", err)
 	}
@@ -546,6 +547,105 @@ func TestGetChart(t *testing.T) {
 	}
 }
 
+func TestGetChartFromOCIRegistry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/v2/charts/nginx/manifests/5.1.1-apiVersionV1", "/v2/charts/web-server/manifests/5.1.1-apiVersionV1":
+			w.Write([]byte(`{
+  "schemaVersion": 2,
+  "config": {"mediaType": "application/vnd.cncf.helm.config.v1+json", "digest": "sha256:123"},
+  "layers": [{"mediaType": "application/tar+gzip", "digest": "sha256:456"}]
+}`))
+		case "/v2/charts/nginx/blobs/sha256:123", "/v2/charts/web-server/blobs/sha256:123":
+			w.Write([]byte(`{"name": "nginx", "version": "5.1.1-apiVersionV1", "apiVersion": "v1"}`))
+		case "/v2/charts/nginx/blobs/sha256:456", "/v2/charts/web-server/blobs/sha256:456":
+			http.ServeFile(w, req, "./testdata/nginx-5.1.1-apiVersionV1.tgz")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name            string
+		chartName       string
+		chartVersion    string
+		ociRepositories []string
+		errorExpected   bool
+	}{
+		{
+			name:            "gets the chart from the repository matching its name",
+			chartName:       "nginx",
+			chartVersion:    "5.1.1-apiVersionV1",
+			ociRepositories: []string{"charts/wordpress", "charts/nginx"},
+		},
+		{
+			name:            "gets the chart from a repository named differently",
+			chartName:       "nginx",
+			chartVersion:    "5.1.1-apiVersionV1",
+			ociRepositories: []string{"charts/web-server"},
+		},
+		{
+			name:            "returns an error if no repository contains the chart",
+			chartName:       "nginx",
+			chartVersion:    "5.1.1-apiVersionV1",
+			ociRepositories: []string{"charts/wordpress"},
+			errorExpected:   true,
+		},
+		{
+			name:            "returns an error if the chart metadata has another name",
+			chartName:       "web-server",
+			chartVersion:    "5.1.1-apiVersionV1",
+			ociRepositories: []string{"charts/web-server"},
+			errorExpected:   true,
+		},
+		{
+			name:            "returns an error if the tag does not exist",
+			chartName:       "nginx",
+			chartVersion:    "1.0.0",
+			ociRepositories: []string{"charts/nginx"},
+			errorExpected:   true,
+		},
+		{
+			name:            "returns an error without a version",
+			chartName:       "nginx",
+			ociRepositories: []string{"charts/nginx"},
+			errorExpected:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			chUtils := ChartClient{
+				appRepo: &appRepov1.AppRepository{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "foo-repo",
+						Namespace: metav1.NamespaceSystem,
+					},
+					Spec: appRepov1.AppRepositorySpec{
+						URL:             server.URL,
+						Type:            "oci",
+						OCIRepositories: tc.ociRepositories,
+					},
+				},
+			}
+			ch, err := chUtils.GetChart(&Details{ChartName: tc.chartName, Version: tc.chartVersion}, server.Client(), false)
+			if tc.errorExpected {
+				if err == nil {
+					t.Fatalf("got: nil, want: error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got, want := ch.Helm3Chart.Name(), "nginx"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
 func TestGetIndexFromCache(t *testing.T) {
 	repoURL := "https://test.com"
 	data := []byte("foo")
@@ -554,7 +654,7 @@ func TestGetIndexFromCache(t *testing.T) {
 		t.Error("Index should be empty since it's not in the cache yet")
 	}
 	fakeIndex := &repo.IndexFile{}
-	storeIndexInCache(repoURL, fakeIndex, sha)
+	storeIndexInCache(repoURL, fakeIndex, sha, len(data))
 	index, _ = getIndexFromCache(repoURL, data)
 	if index != fakeIndex {
 		t.Error("It should return the stored index")
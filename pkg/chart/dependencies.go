@@ -0,0 +1,177 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	appRepov1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/kube"
+	helm3chart "helm.sh/helm/v3/pkg/chart"
+	helm3loader "helm.sh/helm/v3/pkg/chart/loader"
+)
+
+// maxDependencyDepth limits the nesting of the dependencies resolved, which
+// would otherwise never end for charts depending on each other.
+const maxDependencyDepth = 5
+
+// resolveDependencies adds to a chart the dependencies declared in its
+// Chart.yaml which are not vendored in its charts directory. They are
+// downloaded from the app repositories matching their repository, either by
+// URL or by name with "@name" or "alias:name", at the version locked in
+// Chart.lock or else the latest version meeting their version constraint.
+func (c *ChartClient) resolveDependencies(ch *helm3chart.Chart, netClient kube.HTTPClient, depth int) error {
+	if ch == nil || ch.Metadata == nil {
+		return nil
+	}
+	vendored := map[string]bool{}
+	for _, subchart := range ch.Dependencies() {
+		vendored[subchart.Name()] = true
+	}
+	for _, dep := range ch.Metadata.Dependencies {
+		// Dependencies with an alias share the subchart of their name.
+		if vendored[dep.Name] {
+			continue
+		}
+		if depth >= maxDependencyDepth {
+			return fmt.Errorf("unable to resolve dependency %q of chart %q: too many nested dependencies", dep.Name, ch.Name())
+		}
+		subchart, subchartNetClient, err := c.fetchDependency(dep, lockedVersion(ch.Lock, dep), netClient)
+		if err != nil {
+			return fmt.Errorf("unable to resolve dependency %q of chart %q: %v", dep.Name, ch.Name(), err)
+		}
+		err = c.resolveDependencies(subchart, subchartNetClient, depth+1)
+		if err != nil {
+			return err
+		}
+		ch.AddDependency(subchart)
+		vendored[dep.Name] = true
+	}
+	return nil
+}
+
+// lockedVersion returns the version of a dependency in a Chart.lock, or its
+// version constraint if it is not locked.
+func lockedVersion(lock *helm3chart.Lock, dep *helm3chart.Dependency) string {
+	if lock != nil {
+		for _, locked := range lock.Dependencies {
+			if locked.Name == dep.Name && locked.Repository == dep.Repository {
+				return locked.Version
+			}
+		}
+	}
+	return dep.Version
+}
+
+// fetchDependency returns a dependency loaded from its app repository along
+// with the client for the app repository.
+func (c *ChartClient) fetchDependency(dep *helm3chart.Dependency, version string, netClient kube.HTTPClient) (*helm3chart.Chart, kube.HTTPClient, error) {
+	appRepo, err := c.findDependencyAppRepo(dep.Repository)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The client of the chart can be reused for dependencies of the same
+	// app repository.
+	if appRepo.Name != c.appRepo.Name || appRepo.Namespace != c.appRepo.Namespace {
+		netClient, err = c.netClientForAppRepo(appRepo)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	chartURL, digest, err := findChartURL(netClient, appRepo, dep.Name, version)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Many charts share the same dependencies, which are downloaded once.
+	data, err := fetchChartTarball(&netClient, chartURL, digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = c.verifyChart(netClient, appRepo, chartURL, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	subchart, err := helm3loader.LoadArchive(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	return subchart, netClient, nil
+}
+
+// findDependencyAppRepo returns the app repository of the repository of a
+// dependency, looking first at the app repository of the chart, then at
+// those of its namespace and finally at the global ones.
+func (c *ChartClient) findDependencyAppRepo(repository string) (*appRepov1.AppRepository, error) {
+	var matches func(appRepo *appRepov1.AppRepository) bool
+	switch {
+	case strings.HasPrefix(repository, "@"), strings.HasPrefix(repository, "alias:"):
+		name := strings.TrimPrefix(strings.TrimPrefix(repository, "@"), "alias:")
+		matches = func(appRepo *appRepov1.AppRepository) bool {
+			return appRepo.Name == name
+		}
+	case strings.HasPrefix(repository, "http://"), strings.HasPrefix(repository, "https://"):
+		matches = func(appRepo *appRepov1.AppRepository) bool {
+			return normalizeRepoURL(appRepo.Spec.URL) == normalizeRepoURL(repository)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported repository %q, the dependency must be vendored", repository)
+	}
+
+	if matches(c.appRepo) {
+		return c.appRepo, nil
+	}
+	namespaces := []string{c.appRepo.Namespace}
+	if c.appRepo.Namespace != c.kubeappsNamespace {
+		namespaces = append(namespaces, c.kubeappsNamespace)
+	}
+	for _, namespace := range namespaces {
+		client, err := c.clientForNamespace(namespace)
+		if err != nil {
+			return nil, err
+		}
+		appRepos, err := client.ListAppRepositories(namespace)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list app repositories of namespace %q: %v", namespace, err)
+		}
+		for i := range appRepos {
+			if matches(&appRepos[i]) {
+				return &appRepos[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no app repository found for repository %q", repository)
+}
+
+// netClientForAppRepo returns a client for an app repository other than the
+// one of the chart.
+func (c *ChartClient) netClientForAppRepo(appRepo *appRepov1.AppRepository) (kube.HTTPClient, error) {
+	client, err := c.clientForNamespace(appRepo.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	caCertSecret, authSecret, err := getAppRepoSecrets(client, appRepo)
+	if err != nil {
+		return nil, err
+	}
+	return c.newNetClient(appRepo, caCertSecret, authSecret)
+}
+
+func normalizeRepoURL(repoURL string) string {
+	return strings.TrimSuffix(strings.TrimSpace(repoURL), "/")
+}
@@ -0,0 +1,232 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chart
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	appRepov1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/kube"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	chartv2 "k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/repo"
+)
+
+// chartTarball returns a chart archive with the given files, named by their
+// path within the chart directory.
+func chartTarball(t *testing.T, name string, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for filePath, content := range files {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name + "/" + filePath, Mode: 0644, Size: int64(len(content))})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return buf.Bytes()
+}
+
+func chartYaml(name, version, dependencies string) string {
+	return fmt.Sprintf("apiVersion: v2\nname: %s\nversion: %s\n%s", name, version, dependencies)
+}
+
+// chartRepoServer serves an index and the tarballs of some chart versions.
+type chartRepoServer struct {
+	*httptest.Server
+	tarballs map[string][]byte
+	// downloads counts the downloads of each tarball.
+	downloads map[string]int
+}
+
+func newChartRepoServer(t *testing.T, versions map[string][]string) *chartRepoServer {
+	s := &chartRepoServer{tarballs: map[string][]byte{}, downloads: map[string]int{}}
+	entries := map[string]repo.ChartVersions{}
+	for name, chartVersions := range versions {
+		for _, version := range chartVersions {
+			tarball := fmt.Sprintf("%s-%s.tgz", name, version)
+			data := chartTarball(t, name, map[string]string{"Chart.yaml": chartYaml(name, version, "")})
+			s.tarballs["/"+tarball] = data
+			entries[name] = append(entries[name], &repo.ChartVersion{
+				Metadata: &chartv2.Metadata{Name: name, Version: version},
+				URLs:     []string{tarball},
+				Digest:   tarballDigest(data),
+			})
+		}
+	}
+	index := &repo.IndexFile{APIVersion: "v1", Entries: entries}
+	index.SortEntries()
+	indexJSON, err := json.Marshal(index)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/index.yaml" {
+			w.Write(indexJSON)
+			return
+		}
+		tarball, ok := s.tarballs[req.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		s.downloads[req.URL.Path]++
+		w.Write(tarball)
+	}))
+	return s
+}
+
+func TestResolveDependencies(t *testing.T) {
+	mainRepo := newChartRepoServer(t, map[string][]string{"redis": {"1.0.0", "1.1.0"}})
+	defer mainRepo.Close()
+	otherRepo := newChartRepoServer(t, map[string][]string{"postgresql": {"8.1.0", "9.0.0"}})
+	defer otherRepo.Close()
+
+	appRepo := &appRepov1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "main", Namespace: "my-namespace"},
+		Spec:       appRepov1.AppRepositorySpec{URL: mainRepo.URL},
+	}
+	otherAppRepo := &appRepov1.AppRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "kubeapps"},
+		Spec:       appRepov1.AppRepositorySpec{URL: otherRepo.URL + "/"},
+	}
+
+	testCases := []struct {
+		name                 string
+		files                map[string]string
+		expectedDependencies map[string]string
+		expectedDownloads    map[string]int
+		errorExpected        bool
+	}{
+		{
+			name: "resolves a dependency of the same app repository at its locked version",
+			files: map[string]string{
+				"Chart.yaml": chartYaml("app", "1.0.0", "dependencies:\n- name: redis\n  version: ^1.0.0\n  repository: \"@main\"\n"),
+				"Chart.lock": "dependencies:\n- name: redis\n  version: 1.0.0\n  repository: \"@main\"\ndigest: sha256:abc\n",
+			},
+			expectedDependencies: map[string]string{"redis": "1.0.0"},
+			expectedDownloads:    map[string]int{"/redis-1.0.0.tgz": 1},
+		},
+		{
+			name: "resolves a dependency of a global app repository by URL",
+			files: map[string]string{
+				"Chart.yaml": chartYaml("app", "1.0.0", fmt.Sprintf("dependencies:\n- name: postgresql\n  version: 8.x.x\n  repository: %s\n", otherRepo.URL)),
+			},
+			expectedDependencies: map[string]string{"postgresql": "8.1.0"},
+			expectedDownloads:    map[string]int{"/postgresql-8.1.0.tgz": 1},
+		},
+		{
+			name: "caches the tarballs of the dependencies",
+			files: map[string]string{
+				"Chart.yaml": chartYaml("app", "1.0.0", "dependencies:\n- name: redis\n  version: 1.1.0\n  repository: alias:main\n- name: redis\n  alias: cache\n  version: 1.1.0\n  repository: alias:main\n"),
+			},
+			expectedDependencies: map[string]string{"redis": "1.1.0"},
+			expectedDownloads:    map[string]int{"/redis-1.1.0.tgz": 1},
+		},
+		{
+			name: "does not resolve vendored dependencies",
+			files: map[string]string{
+				"Chart.yaml":              chartYaml("app", "1.0.0", "dependencies:\n- name: redis\n  version: ^1.0.0\n  repository: \"@main\"\n"),
+				"charts/redis/Chart.yaml": chartYaml("redis", "0.1.0", ""),
+			},
+			expectedDependencies: map[string]string{"redis": "0.1.0"},
+			expectedDownloads:    map[string]int{},
+		},
+		{
+			name: "returns an error for an unknown repository",
+			files: map[string]string{
+				"Chart.yaml": chartYaml("app", "1.0.0", "dependencies:\n- name: mysql\n  version: ^1.0.0\n  repository: https://charts.example.com\n"),
+			},
+			errorExpected: true,
+		},
+		{
+			name: "returns an error for a local repository",
+			files: map[string]string{
+				"Chart.yaml": chartYaml("app", "1.0.0", "dependencies:\n- name: mysql\n  version: ^1.0.0\n  repository: file://../mysql\n"),
+			},
+			errorExpected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tarballCache = &tarballStore{lru: newLRUCache(tarballCacheName, 0)}
+			mainRepo.downloads = map[string]int{}
+			otherRepo.downloads = map[string]int{}
+			mainRepo.tarballs["/app-1.0.0.tgz"] = chartTarball(t, "app", tc.files)
+			chUtils := ChartClient{
+				appRepoHandler:    &kube.FakeHandler{AppRepos: []*appRepov1.AppRepository{appRepo, otherAppRepo}},
+				kubeappsNamespace: "kubeapps",
+				appRepo:           appRepo,
+			}
+			netClient, err := kube.InitNetClient(appRepo, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			chartURL := mainRepo.URL + "/app-1.0.0.tgz"
+			ch, err := fetchChart(&netClient, chartURL, "", false)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			err = chUtils.resolveDependencies(ch.Helm3Chart, netClient, 0)
+			if got, want := err != nil, tc.errorExpected; got != want {
+				t.Fatalf("got error: %v, want error: %t", err, want)
+			}
+			if tc.errorExpected {
+				return
+			}
+
+			dependencies := map[string]string{}
+			for _, subchart := range ch.Helm3Chart.Dependencies() {
+				dependencies[subchart.Name()] = subchart.Metadata.Version
+			}
+			if got, want := dependencies, tc.expectedDependencies; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+			downloads := map[string]int{}
+			for _, server := range []*chartRepoServer{mainRepo, otherRepo} {
+				for tarball, count := range server.downloads {
+					if !strings.HasPrefix(tarball, "/app-") {
+						downloads[tarball] += count
+					}
+				}
+			}
+			if got, want := downloads, tc.expectedDownloads; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
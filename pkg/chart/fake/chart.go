@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	appRepov1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
 	chartUtils "github.com/kubeapps/kubeapps/pkg/chart"
 	"github.com/kubeapps/kubeapps/pkg/kube"
 	chart3 "helm.sh/helm/v3/pkg/chart"
@@ -27,7 +28,9 @@ import (
 	"sigs.k8s.io/yaml"
 )
 
-type FakeChart struct{}
+type FakeChart struct {
+	AppRepo *appRepov1.AppRepository
+}
 
 func (f *FakeChart) ParseDetails(data []byte) (*chartUtils.Details, error) {
 	details := &chartUtils.Details{}
@@ -74,3 +77,7 @@ func (f *FakeChart) InitNetClient(details *chartUtils.Details, userAuthToken str
 func (f *FakeChart) RegistrySecretsPerDomain() map[string]string {
 	return nil
 }
+
+func (f *FakeChart) AppRepository() *appRepov1.AppRepository {
+	return f.AppRepo
+}
@@ -0,0 +1,45 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chart
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubeapps",
+		Name:      "chart_cache_lookups_total",
+		Help:      "Number of lookups in the caches of repository indexes and chart tarballs, by cache and result.",
+	}, []string{"cache", "result"})
+	cacheSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "kubeapps",
+		Name:      "chart_cache_size_bytes",
+		Help:      "Size of the entries of the caches of repository indexes and chart tarballs, by cache.",
+	}, []string{"cache"})
+)
+
+func init() {
+	prometheus.MustRegister(cacheLookups, cacheSize)
+}
+
+// recordCacheLookup counts a lookup in a cache as a hit or a miss.
+func recordCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookups.WithLabelValues(cache, result).Inc()
+}
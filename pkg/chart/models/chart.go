@@ -71,6 +71,11 @@ type ChartVersion struct {
 	Created    time.Time `json:"created"`
 	Digest     string    `json:"digest"`
 	URLs       []string  `json:"urls"`
+	// Verification is the status of the verification of the provenance file
+	// of the chart version, empty if its repository has no trusted keys.
+	Verification string `json:"verification,omitempty"`
+	// SignedBy is the identity of the key which signed the chart version.
+	SignedBy string `json:"signed_by,omitempty"`
 	// The following three fields get set with the URL paths to the respective
 	// chart files (as opposed to the similar fields on ChartFiles which
 	// contain the actual content).
@@ -99,3 +104,15 @@ type RepoCheck struct {
 	LastUpdate time.Time `bson:"last_update"`
 	Checksum   string    `bson:"checksum"`
 }
+
+// RepoSyncResult is the outcome of a repository sync, reported by the sync
+// job as its termination message.
+type RepoSyncResult struct {
+	Checksum   string `json:"checksum,omitempty"`
+	ChartCount int    `json:"chartCount"`
+	// Unchanged is true if the repository content did not change since the
+	// last sync, in which case ChartCount is not computed.
+	Unchanged  bool   `json:"unchanged,omitempty"`
+	Error      string `json:"error,omitempty"`
+	AuthFailed bool   `json:"authFailed,omitempty"`
+}
@@ -0,0 +1,77 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chart
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+
+	appRepov1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/kube"
+	"github.com/kubeapps/kubeapps/pkg/provenance"
+	"golang.org/x/crypto/openpgp"
+)
+
+// verifyChart checks that a chart tarball of an app repository requiring
+// signatures is signed by one of its trusted keys. The tarball is verified
+// against its provenance file, published next to it.
+func (c *ChartClient) verifyChart(netClient kube.HTTPClient, appRepo *appRepov1.AppRepository, chartURL string, data []byte) error {
+	verification := appRepo.Spec.Verification
+	if verification == nil || !verification.Required {
+		return nil
+	}
+	if appRepo.Spec.Type == "oci" {
+		return fmt.Errorf("the chart %s is not signed by a trusted key: provenance files are not supported for OCI registries", chartURL)
+	}
+
+	keyring, err := c.appRepoKeyring(appRepo)
+	if err != nil {
+		return err
+	}
+	prov, err := downloadChart(&netClient, chartURL+provenance.Extension)
+	if err != nil {
+		return fmt.Errorf("the chart %s is not signed by a trusted key: unable to fetch its provenance file: %v", chartURL, err)
+	}
+	chartFileName := path.Base(chartURL)
+	if u, err := url.Parse(chartURL); err == nil {
+		chartFileName = path.Base(u.Path)
+	}
+	_, err = provenance.Verify(keyring, prov, chartFileName, tarballDigest(data))
+	if err != nil {
+		return fmt.Errorf("the chart %s is not signed by a trusted key: %v", chartURL, err)
+	}
+	return nil
+}
+
+// appRepoKeyring returns the trusted keys of an app repository.
+func (c *ChartClient) appRepoKeyring(appRepo *appRepov1.AppRepository) (openpgp.EntityList, error) {
+	client, err := c.clientForNamespace(appRepo.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	ref := appRepo.Spec.Verification.KeyringSecretRef
+	secret, err := client.GetSecret(ref.Name, appRepo.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read secret %q: %v", ref.Name, err)
+	}
+	keyring, err := provenance.ReadKeyring(secret.Data[ref.Key])
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the keyring of app repository %q: %v", appRepo.Name, err)
+	}
+	return keyring, nil
+}
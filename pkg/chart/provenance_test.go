@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chart
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	appRepov1 "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/kube"
+	"github.com/kubeapps/kubeapps/pkg/provenance"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// provenanceHTTPClient serves the provenance files of some charts along with
+// the repository of a fakeHTTPClient.
+type provenanceHTTPClient struct {
+	kube.HTTPClient
+	provs map[string][]byte
+}
+
+func (p *provenanceHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if prov, ok := p.provs[req.URL.String()]; ok {
+		return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(bytes.NewReader(prov))}, nil
+	}
+	return p.HTTPClient.Do(req)
+}
+
+func TestGetChartVerification(t *testing.T) {
+	const repoName = "foo-repo"
+	const repoURL = "http://example.com/"
+	target := Details{
+		AppRepositoryResourceName: repoName,
+		ChartName:                 "nginx",
+		ReleaseName:               "foo",
+		Version:                   "5.1.1-apiVersionV1",
+	}
+	chartURL := repoURL + "nginx-5.1.1-apiVersionV1.tgz"
+	data, err := ioutil.ReadFile("./testdata/nginx-5.1.1-apiVersionV1.tgz")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	trusted, err := openpgp.NewEntity("trusted", "", "trusted@example.com", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	untrusted, err := openpgp.NewEntity("untrusted", "", "untrusted@example.com", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var keyring bytes.Buffer
+	w, err := armor.Encode(&keyring, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := trusted.Serialize(w); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sign := func(signer *openpgp.Entity) []byte {
+		prov, err := provenance.Sign(signer, []byte("name: nginx\n"), "nginx-5.1.1-apiVersionV1.tgz", tarballDigest(data))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		return prov
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "keyring", Namespace: metav1.NamespaceSystem},
+		Data:       map[string][]byte{"pubring.gpg": keyring.Bytes()},
+	}
+
+	testCases := []struct {
+		name          string
+		verification  *appRepov1.AppRepositoryVerification
+		prov          []byte
+		errorExpected bool
+	}{
+		{
+			name: "gets a chart signed by a trusted key",
+			verification: &appRepov1.AppRepositoryVerification{
+				KeyringSecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "keyring"}, Key: "pubring.gpg"},
+				Required:         true,
+			},
+			prov: sign(trusted),
+		},
+		{
+			name: "returns an error for a chart signed by an untrusted key",
+			verification: &appRepov1.AppRepositoryVerification{
+				KeyringSecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "keyring"}, Key: "pubring.gpg"},
+				Required:         true,
+			},
+			prov:          sign(untrusted),
+			errorExpected: true,
+		},
+		{
+			name: "returns an error for an unsigned chart",
+			verification: &appRepov1.AppRepositoryVerification{
+				KeyringSecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "keyring"}, Key: "pubring.gpg"},
+				Required:         true,
+			},
+			errorExpected: true,
+		},
+		{
+			name: "gets an unsigned chart if signatures are not required",
+			verification: &appRepov1.AppRepositoryVerification{
+				KeyringSecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "keyring"}, Key: "pubring.gpg"},
+			},
+		},
+		{
+			name: "gets an unsigned chart without verification",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			provs := map[string][]byte{}
+			if tc.prov != nil {
+				provs[chartURL+provenance.Extension] = tc.prov
+			}
+			httpClient := &provenanceHTTPClient{
+				HTTPClient: newHTTPClient(repoURL, []Details{target}, ""),
+				provs:      provs,
+			}
+			chUtils := ChartClient{
+				appRepoHandler:    &kube.FakeHandler{Secrets: []*corev1.Secret{secret}},
+				kubeappsNamespace: metav1.NamespaceSystem,
+				appRepo: &appRepov1.AppRepository{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      repoName,
+						Namespace: metav1.NamespaceSystem,
+					},
+					Spec: appRepov1.AppRepositorySpec{
+						URL:          repoURL,
+						Verification: tc.verification,
+					},
+				},
+			}
+
+			ch, err := chUtils.GetChart(&target, httpClient, false)
+			if got, want := err != nil, tc.errorExpected; got != want {
+				t.Fatalf("got error: %v, want error: %t", err, want)
+			}
+			if err != nil {
+				if !strings.Contains(err.Error(), "is not signed by a trusted key") {
+					t.Errorf("got: %q, want: an error about the signature", err)
+				}
+				return
+			}
+			if got, want := ch.Helm3Chart.Name(), "nginx"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
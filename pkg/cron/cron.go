@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cron validates and evaluates schedules in the cron format accepted
+// by Kubernetes CronJobs.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// descriptors are the predefined schedules which can be used instead of the
+// five fields.
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	{name: "day of week", min: 0, max: 6, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// Schedule returns the activation times of a cron schedule.
+type Schedule interface {
+	// Next returns the first activation time strictly after the given time.
+	Next(time.Time) time.Time
+}
+
+// Validate returns an error if the schedule is not a valid cron schedule:
+// either five space-separated fields (minute, hour, day of month, month and
+// day of week), a descriptor such as "@daily" or "@every <duration>".
+func Validate(schedule string) error {
+	_, err := Parse(schedule)
+	return err
+}
+
+// Parse returns the Schedule of a cron schedule in one of the formats accepted
+// by Validate.
+func Parse(schedule string) (Schedule, error) {
+	schedule = strings.TrimSpace(schedule)
+	if strings.HasPrefix(schedule, "@") {
+		if strings.HasPrefix(schedule, "@every ") {
+			d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(schedule, "@every ")))
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration in %q: %v", schedule, err)
+			}
+			if d <= 0 {
+				return nil, fmt.Errorf("invalid duration in %q: must be positive", schedule)
+			}
+			return constantDelay(d), nil
+		}
+		expanded, ok := descriptors[strings.ToLower(schedule)]
+		if !ok {
+			return nil, fmt.Errorf("unrecognized descriptor %q", schedule)
+		}
+		schedule = expanded
+	}
+
+	values := strings.Fields(schedule)
+	if len(values) != len(fields) {
+		return nil, fmt.Errorf("expected exactly %d fields, found %d: %q", len(fields), len(values), schedule)
+	}
+	var s specSchedule
+	for i, f := range fields {
+		bits, err := f.bits(values[i])
+		if err != nil {
+			return nil, err
+		}
+		s.fields[i] = bits
+	}
+	// As in cron, when both the day of month and the day of week are
+	// restricted a day matches if either of them matches.
+	s.anyDom = isStar(values[2])
+	s.anyDow = isStar(values[4])
+	return &s, nil
+}
+
+func isStar(value string) bool {
+	return strings.HasPrefix(value, "*") || strings.HasPrefix(value, "?")
+}
+
+// bits parses a comma-separated list of ranges, each being "*", a value or a
+// "min-max" range with an optional "/step", into the set of matching values.
+func (f field) bits(value string) (uint64, error) {
+	var bits uint64
+	for _, expr := range strings.Split(value, ",") {
+		rangeExpr, step := expr, 1
+		if i := strings.Index(expr, "/"); i >= 0 {
+			rangeExpr = expr[:i]
+			s, err := strconv.Atoi(expr[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field %q", expr[i+1:], f.name, value)
+			}
+			step = s
+		}
+		start, end := f.min, f.max
+		if rangeExpr != "*" && rangeExpr != "?" {
+			bounds := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			start, err = f.parse(bounds[0])
+			if err != nil {
+				return 0, fmt.Errorf("%v in %s field %q", err, f.name, value)
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = f.parse(bounds[1])
+				if err != nil {
+					return 0, fmt.Errorf("%v in %s field %q", err, f.name, value)
+				}
+				if end < start {
+					return 0, fmt.Errorf("range %q is reversed in %s field %q", rangeExpr, f.name, value)
+				}
+			} else if step > 1 {
+				// As in cron, "N/step" means from N to the maximum value.
+				end = f.max
+			}
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f field) parse(value string) (int, error) {
+	if n, ok := f.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", n, f.min, f.max)
+	}
+	return n, nil
+}
+
+// specSchedule is the Schedule of the five cron fields, each being the set of
+// matching values.
+type specSchedule struct {
+	fields         [5]uint64
+	anyDom, anyDow bool
+}
+
+func (s *specSchedule) matches(i, v int) bool {
+	return s.fields[i]&(1<<uint(v)) != 0
+}
+
+func (s *specSchedule) dayMatches(t time.Time) bool {
+	dom, dow := s.matches(2, t.Day()), s.matches(4, int(t.Weekday()))
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first matching minute after t, or the zero time if there
+// is none in the next five years (e.g. "0 0 30 2 *").
+func (s *specSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.matches(3, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matches(1, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matches(0, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// constantDelay is the Schedule of "@every <duration>".
+type constantDelay time.Duration
+
+// Next returns the given time plus the delay.
+func (d constantDelay) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(d))
+}
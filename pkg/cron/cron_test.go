@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		schedule string
+		valid    bool
+	}{
+		{"*/10 * * * *", true},
+		{"*/2 * * * *", true},
+		{"0 3 * * *", true},
+		{"0 0 1,15 * mon-fri", true},
+		{"30 8-18/2 * JAN-jun SUN", true},
+		{"0 0 ? * *", true},
+		{"@daily", true},
+		{"@Hourly", true},
+		{"@every 2m", true},
+		{"", false},
+		{"* * * *", false},
+		{"* * * * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 7", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"foo * * * *", false},
+		{"@fortnightly", false},
+		{"@every soon", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.schedule, func(t *testing.T) {
+			err := Validate(tc.schedule)
+			if got, want := err == nil, tc.valid; got != want {
+				t.Errorf("got valid: %t, want: %t (error: %v)", got, want, err)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2020, 6, 1, 10, 7, 30, 0, time.UTC) // Monday
+	testCases := []struct {
+		schedule string
+		expected time.Time
+	}{
+		{"*/10 * * * *", time.Date(2020, 6, 1, 10, 10, 0, 0, time.UTC)},
+		{"* * * * *", time.Date(2020, 6, 1, 10, 8, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2020, 6, 2, 3, 0, 0, 0, time.UTC)},
+		{"30 8-18/2 * * *", time.Date(2020, 6, 1, 10, 30, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2020, 6, 1, 10, 25, 0, 0, time.UTC)},
+		{"0 0 * * sat", time.Date(2020, 6, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * fri", time.Date(2020, 6, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 6, 1, 11, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2020, 6, 1, 10, 9, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.schedule, func(t *testing.T) {
+			s, err := Parse(tc.schedule)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := s.Next(from), tc.expected; !got.Equal(want) {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+}
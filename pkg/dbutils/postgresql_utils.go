@@ -35,6 +35,16 @@ const (
 	ChartFilesTable = "files"
 	// EnvvarPostgresTests enables tests that run against a local postgres
 	EnvvarPostgresTests = "ENABLE_PG_INTEGRATION_TESTS"
+	// ChartSearchDocument is the full-text search document of a chart, weighting
+	// the name above keywords, description, maintainers and sources. The same
+	// expression is used for the index and the search queries so that the index
+	// is used. The separators of the source URLs are replaced with spaces so
+	// that their hosts and path segments are words of the document.
+	ChartSearchDocument = `(setweight(to_tsvector('simple', coalesce(info ->> 'name', '')), 'A') || ` +
+		`setweight(to_tsvector('simple', coalesce(info -> 'keywords', '[]')), 'B') || ` +
+		`setweight(to_tsvector('simple', coalesce(info ->> 'description', '')), 'C') || ` +
+		`setweight(to_tsvector('simple', coalesce(info -> 'maintainers', '[]')), 'D') || ` +
+		`setweight(to_tsvector('simple', translate(coalesce(info ->> 'sources', ''), '/:.', '   ')), 'D'))`
 )
 
 type PostgresDB interface {
@@ -160,6 +170,11 @@ CREATE TABLE IF NOT EXISTS %s (
 		return err
 	}
 
+	_, err = m.DB.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS charts_search_idx ON %s USING GIN (%s)", ChartTable, ChartSearchDocument))
+	if err != nil {
+		return err
+	}
+
 	_, err = m.DB.Exec(fmt.Sprintf(`
 CREATE TABLE IF NOT EXISTS %s (
 	ID serial NOT NULL PRIMARY KEY,
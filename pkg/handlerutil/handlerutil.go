@@ -40,12 +40,12 @@ func isAlreadyExists(err error) bool {
 }
 
 func isForbidden(err error) bool {
-	return strings.Contains(err.Error(), "Unauthorized") || strings.Contains(err.Error(), "forbidden")
+	return strings.Contains(err.Error(), "Unauthorized") || strings.Contains(err.Error(), "forbidden") || strings.Contains(err.Error(), "is not signed by a trusted key")
 }
 
 func isUnprocessable(err error) bool {
 	re := regexp.MustCompile(`[rR]elease.*failed`)
-	return re.MatchString(err.Error())
+	return re.MatchString(err.Error()) || strings.Contains(err.Error(), "values don't meet the specifications of the schema")
 }
 
 // ErrorCode returns the int representing an error.
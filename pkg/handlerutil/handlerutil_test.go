@@ -16,8 +16,10 @@ func TestErrorCodeWithDefault(t *testing.T) {
 		{fmt.Errorf("a release named foo already exists"), http.StatusInternalServerError, http.StatusConflict},
 		{fmt.Errorf("release foo not found"), http.StatusInternalServerError, http.StatusNotFound},
 		{fmt.Errorf("Unauthorized to get release foo"), http.StatusInternalServerError, http.StatusForbidden},
+		{fmt.Errorf("the chart http://example.com/foo-1.0.0.tgz is not signed by a trusted key: no signature found in the provenance file"), http.StatusInternalServerError, http.StatusForbidden},
 		{fmt.Errorf("release \"Foo \" failed"), http.StatusInternalServerError, http.StatusUnprocessableEntity},
 		{fmt.Errorf("Release \"Foo \" failed"), http.StatusInternalServerError, http.StatusUnprocessableEntity},
+		{fmt.Errorf("values don't meet the specifications of the schema: replicas: Invalid type"), http.StatusInternalServerError, http.StatusUnprocessableEntity},
 		{fmt.Errorf("This is an unexpected error"), http.StatusInternalServerError, http.StatusInternalServerError},
 		{fmt.Errorf("This is an unexpected error"), http.StatusUnprocessableEntity, http.StatusUnprocessableEntity},
 	}
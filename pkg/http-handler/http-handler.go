@@ -17,13 +17,16 @@ limitations under the License.
 package httphandler
 
 import (
+	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/audit"
 	"github.com/kubeapps/kubeapps/pkg/auth"
 	"github.com/kubeapps/kubeapps/pkg/kube"
 	log "github.com/sirupsen/logrus"
@@ -69,8 +72,37 @@ func getNamespaceAndCluster(req *http.Request) (string, string) {
 	return requestNamespace, requestCluster
 }
 
+// recordAudit records an app repository action in the audit log.
+func recordAudit(auditLogger *audit.Logger, req *http.Request, token, name, action string, err error) {
+	requestNamespace, requestCluster := getNamespaceAndCluster(req)
+	auditLogger.Record(token, audit.Event{
+		Cluster:   requestCluster,
+		Namespace: requestNamespace,
+		Resource:  audit.ResourceAppRepository,
+		Name:      name,
+		Action:    action,
+	}, err)
+}
+
+// appRepositoryName returns the name of the app repository in the body of a
+// request, leaving the body to be read again.
+func appRepositoryName(req *http.Request) string {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return ""
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var appRepoRequest struct {
+		AppRepository struct {
+			Name string `json:"name"`
+		} `json:"appRepository"`
+	}
+	json.Unmarshal(body, &appRepoRequest)
+	return appRepoRequest.AppRepository.Name
+}
+
 // CreateAppRepository creates App Repository
-func CreateAppRepository(handler kube.AuthHandler) func(w http.ResponseWriter, req *http.Request) {
+func CreateAppRepository(handler kube.AuthHandler, auditLogger *audit.Logger) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		requestNamespace, requestCluster := getNamespaceAndCluster(req)
 		token := auth.ExtractToken(req.Header.Get("Authorization"))
@@ -81,7 +113,9 @@ func CreateAppRepository(handler kube.AuthHandler) func(w http.ResponseWriter, r
 			return
 		}
 
+		repoName := appRepositoryName(req)
 		appRepo, err := clientset.CreateAppRepository(req.Body, requestNamespace)
+		recordAudit(auditLogger, req, token, repoName, "create", err)
 		if err != nil {
 			returnK8sError(err, w)
 			return
@@ -100,7 +134,7 @@ func CreateAppRepository(handler kube.AuthHandler) func(w http.ResponseWriter, r
 }
 
 // UpdateAppRepository updates an App Repository
-func UpdateAppRepository(handler kube.AuthHandler) func(w http.ResponseWriter, req *http.Request) {
+func UpdateAppRepository(handler kube.AuthHandler, auditLogger *audit.Logger) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		requestNamespace, requestCluster := getNamespaceAndCluster(req)
 		token := auth.ExtractToken(req.Header.Get("Authorization"))
@@ -112,6 +146,7 @@ func UpdateAppRepository(handler kube.AuthHandler) func(w http.ResponseWriter, r
 		}
 
 		appRepo, err := clientset.UpdateAppRepository(req.Body, requestNamespace)
+		recordAudit(auditLogger, req, token, mux.Vars(req)["name"], "update", err)
 		if err != nil {
 			returnK8sError(err, w)
 			return
@@ -156,7 +191,7 @@ func ValidateAppRepository(handler kube.AuthHandler) func(w http.ResponseWriter,
 }
 
 // DeleteAppRepository deletes an App Repository
-func DeleteAppRepository(kubeHandler kube.AuthHandler) func(w http.ResponseWriter, req *http.Request) {
+func DeleteAppRepository(kubeHandler kube.AuthHandler, auditLogger *audit.Logger) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		requestNamespace, requestCluster := getNamespaceAndCluster(req)
 		repoName := mux.Vars(req)["name"]
@@ -169,6 +204,7 @@ func DeleteAppRepository(kubeHandler kube.AuthHandler) func(w http.ResponseWrite
 		}
 
 		err = clientset.DeleteAppRepository(repoName, requestNamespace)
+		recordAudit(auditLogger, req, token, repoName, "delete", err)
 		if err != nil {
 			returnK8sError(err, w)
 		}
@@ -224,23 +260,24 @@ func GetOperatorLogo(kubeHandler kube.AuthHandler) func(w http.ResponseWriter, r
 }
 
 // SetupDefaultRoutes enables call-sites to use the backend api's default routes with minimal setup.
-func SetupDefaultRoutes(r *mux.Router, additionalClusters kube.AdditionalClustersConfig) error {
+// The app repository actions are recorded with the given audit logger, if any.
+func SetupDefaultRoutes(r *mux.Router, additionalClusters kube.AdditionalClustersConfig, auditLogger *audit.Logger) error {
 	backendHandler, err := kube.NewHandler(os.Getenv("POD_NAMESPACE"), additionalClusters)
 	if err != nil {
 		return err
 	}
 	// Deprecate non-cluster-aware URIs.
 	r.Methods("GET").Path("/namespaces").Handler(http.HandlerFunc(GetNamespaces(backendHandler)))
-	r.Methods("POST").Path("/namespaces/{namespace}/apprepositories").Handler(http.HandlerFunc(CreateAppRepository(backendHandler)))
+	r.Methods("POST").Path("/namespaces/{namespace}/apprepositories").Handler(http.HandlerFunc(CreateAppRepository(backendHandler, auditLogger)))
 	r.Methods("POST").Path("/namespaces/{namespace}/apprepositories/validate").Handler(http.HandlerFunc(ValidateAppRepository(backendHandler)))
-	r.Methods("PUT").Path("/namespaces/{namespace}/apprepositories/{name}").Handler(http.HandlerFunc(UpdateAppRepository(backendHandler)))
-	r.Methods("DELETE").Path("/namespaces/{namespace}/apprepositories/{name}").Handler(http.HandlerFunc(DeleteAppRepository(backendHandler)))
+	r.Methods("PUT").Path("/namespaces/{namespace}/apprepositories/{name}").Handler(http.HandlerFunc(UpdateAppRepository(backendHandler, auditLogger)))
+	r.Methods("DELETE").Path("/namespaces/{namespace}/apprepositories/{name}").Handler(http.HandlerFunc(DeleteAppRepository(backendHandler, auditLogger)))
 	r.Methods("GET").Path("/namespaces/{namespace}/operator/{name}/logo").Handler(http.HandlerFunc(GetOperatorLogo(backendHandler)))
 	r.Methods("GET").Path("/clusters/{cluster}/namespaces").Handler(http.HandlerFunc(GetNamespaces(backendHandler)))
-	r.Methods("POST").Path("/clusters/{cluster}/namespaces/{namespace}/apprepositories").Handler(http.HandlerFunc(CreateAppRepository(backendHandler)))
+	r.Methods("POST").Path("/clusters/{cluster}/namespaces/{namespace}/apprepositories").Handler(http.HandlerFunc(CreateAppRepository(backendHandler, auditLogger)))
 	r.Methods("POST").Path("/clusters/{cluster}/namespaces/{namespace}/apprepositories/validate").Handler(http.HandlerFunc(ValidateAppRepository(backendHandler)))
-	r.Methods("PUT").Path("/clusters/{cluster}/namespaces/{namespace}/apprepositories/{name}").Handler(http.HandlerFunc(UpdateAppRepository(backendHandler)))
-	r.Methods("DELETE").Path("/clusters/{cluster}/namespaces/{namespace}/apprepositories/{name}").Handler(http.HandlerFunc(DeleteAppRepository(backendHandler)))
+	r.Methods("PUT").Path("/clusters/{cluster}/namespaces/{namespace}/apprepositories/{name}").Handler(http.HandlerFunc(UpdateAppRepository(backendHandler, auditLogger)))
+	r.Methods("DELETE").Path("/clusters/{cluster}/namespaces/{namespace}/apprepositories/{name}").Handler(http.HandlerFunc(DeleteAppRepository(backendHandler, auditLogger)))
 	r.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/operator/{name}/logo").Handler(http.HandlerFunc(GetOperatorLogo(backendHandler)))
 	return nil
 }
@@ -24,9 +24,11 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/gorilla/mux"
+	"github.com/kubeapps/kubeapps/pkg/audit"
 	"github.com/kubeapps/kubeapps/pkg/kube"
 	corev1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
@@ -102,7 +104,7 @@ func TestCreateAppRepository(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			createAppFunc := CreateAppRepository(&kube.FakeHandler{CreatedRepo: tc.appRepo, Err: tc.err})
+			createAppFunc := CreateAppRepository(&kube.FakeHandler{CreatedRepo: tc.appRepo, Err: tc.err}, nil)
 			req := httptest.NewRequest("POST", "https://foo.bar/backend/v1/namespaces/kubeapps/apprepositories", strings.NewReader("data"))
 			req = mux.SetURLVars(req, map[string]string{"namespace": "kubeapps"})
 
@@ -147,7 +149,7 @@ func TestUpdateAppRepository(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			createAppFunc := UpdateAppRepository(&kube.FakeHandler{UpdatedRepo: tc.appRepo, Err: tc.err})
+			createAppFunc := UpdateAppRepository(&kube.FakeHandler{UpdatedRepo: tc.appRepo, Err: tc.err}, nil)
 			req := httptest.NewRequest("POST", "https://foo.bar/backend/v1/namespaces/kubeapps/apprepositories/foo", strings.NewReader("data"))
 			req = mux.SetURLVars(req, map[string]string{"namespace": "kubeapps"})
 
@@ -190,7 +192,7 @@ func TestDeleteAppRepository(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			deleteAppFunc := DeleteAppRepository(&kube.FakeHandler{Err: tc.err})
+			deleteAppFunc := DeleteAppRepository(&kube.FakeHandler{Err: tc.err}, nil)
 			req := httptest.NewRequest("POST", "https://foo.bar/backend/v1/namespaces/kubeapps/apprepositories", strings.NewReader("data"))
 			req = mux.SetURLVars(req, map[string]string{"namespace": "kubeapps"})
 
@@ -204,6 +206,54 @@ func TestDeleteAppRepository(t *testing.T) {
 	}
 }
 
+func TestAppRepositoryAudit(t *testing.T) {
+	var buf bytes.Buffer
+	auditLogger := audit.NewLogger(nil, audit.NewJSONSink(&buf))
+	fakeHandler := &kube.FakeHandler{CreatedRepo: &v1alpha1.AppRepository{ObjectMeta: metav1.ObjectMeta{Name: "bitnami"}}}
+
+	req := httptest.NewRequest("POST", "https://foo.bar/backend/v1/clusters/other/namespaces/kubeapps/apprepositories", strings.NewReader(`{"appRepository": {"name": "bitnami"}}`))
+	req = mux.SetURLVars(req, map[string]string{"cluster": "other", "namespace": "kubeapps"})
+	CreateAppRepository(fakeHandler, auditLogger)(httptest.NewRecorder(), req)
+
+	fakeHandler.Err = k8sErrors.NewForbidden(schema.GroupResource{}, "bitnami", fmt.Errorf("nope"))
+	req = httptest.NewRequest("DELETE", "https://foo.bar/backend/v1/namespaces/kubeapps/apprepositories/bitnami", nil)
+	req = mux.SetURLVars(req, map[string]string{"namespace": "kubeapps", "name": "bitnami"})
+	DeleteAppRepository(fakeHandler, auditLogger)(httptest.NewRecorder(), req)
+
+	expected := []audit.Event{
+		{
+			Cluster:   "other",
+			Namespace: "kubeapps",
+			Resource:  audit.ResourceAppRepository,
+			Name:      "bitnami",
+			Action:    "create",
+			Outcome:   audit.OutcomeSuccess,
+		},
+		{
+			Cluster:   "default",
+			Namespace: "kubeapps",
+			Resource:  audit.ResourceAppRepository,
+			Name:      "bitnami",
+			Action:    "delete",
+			Outcome:   audit.OutcomeFailure,
+			Error:     fakeHandler.Err.Error(),
+		},
+	}
+	events := []audit.Event{}
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var event audit.Event
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		event.Time = time.Time{}
+		events = append(events, event)
+	}
+	if got, want := events, expected; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
 func TestGetNamespaces(t *testing.T) {
 	testCases := []struct {
 		name         string
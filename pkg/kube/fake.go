@@ -71,6 +71,17 @@ func (c *FakeHandler) GetAppRepository(name, namespace string) (*v1alpha1.AppRep
 	return nil, fmt.Errorf("not found")
 }
 
+// ListAppRepositories fake
+func (c *FakeHandler) ListAppRepositories(namespace string) ([]v1alpha1.AppRepository, error) {
+	appRepos := []v1alpha1.AppRepository{}
+	for _, r := range c.AppRepos {
+		if r.Namespace == namespace {
+			appRepos = append(appRepos, *r)
+		}
+	}
+	return appRepos, c.Err
+}
+
 // GetNamespaces fake
 func (c *FakeHandler) GetNamespaces() ([]corev1.Namespace, error) {
 	return c.Namespaces, c.Err
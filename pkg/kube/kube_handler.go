@@ -28,6 +28,8 @@ import (
 	"github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
 	apprepoclientset "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/clientset/versioned"
 	v1alpha1typed "github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/client/clientset/versioned/typed/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/cron"
+	"github.com/kubeapps/kubeapps/pkg/oci"
 	log "github.com/sirupsen/logrus"
 	authorizationapi "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -171,6 +173,7 @@ type handler interface {
 	GetNamespaces() ([]corev1.Namespace, error)
 	GetSecret(name, namespace string) (*corev1.Secret, error)
 	GetAppRepository(repoName, repoNamespace string) (*v1alpha1.AppRepository, error)
+	ListAppRepositories(repoNamespace string) ([]v1alpha1.AppRepository, error)
 	ValidateAppRepository(appRepoBody io.ReadCloser, requestNamespace string) (*ValidationResponse, error)
 	GetOperatorLogo(namespace, name string) ([]byte, error)
 }
@@ -239,17 +242,43 @@ type appRepositoryRequest struct {
 type appRepositoryRequestDetails struct {
 	Name               string                 `json:"name"`
 	RepoURL            string                 `json:"repoURL"`
+	Type               string                 `json:"type"`
+	OCIRepositories    []string               `json:"ociRepositories"`
 	AuthHeader         string                 `json:"authHeader"`
 	CustomCA           string                 `json:"customCA"`
 	RegistrySecrets    []string               `json:"registrySecrets"`
 	SyncJobPodTemplate corev1.PodTemplateSpec `json:"syncJobPodTemplate"`
 	ResyncRequests     uint                   `json:"resyncRequests"`
+	SyncSchedule       string                 `json:"syncSchedule"`
+	Suspend            bool                   `json:"suspend"`
+	// Verification references a keyring secret of the namespace of the
+	// repository, which is copied to the kubeapps namespace.
+	Verification *v1alpha1.AppRepositoryVerification `json:"verification"`
 }
 
 // ErrGlobalRepositoryWithSecrets defines the error returned when an attempt is
 // made to create registry secrets for a global repo.
 var ErrGlobalRepositoryWithSecrets = fmt.Errorf("docker registry secrets cannot be set for app repositories available in all namespaces")
 
+// ErrOCIRepositoriesRequired defines the error returned when an OCI app
+// repository is requested without any repositories to sync.
+var ErrOCIRepositoriesRequired = fmt.Errorf("at least one OCI repository is required for app repositories of type oci")
+
+// ErrInvalidSyncSchedule defines the error returned when an app repository
+// is requested with a sync schedule which is not in cron format.
+var ErrInvalidSyncSchedule = fmt.Errorf("invalid sync schedule")
+
+// validateSyncSchedule checks the optional sync schedule of an app repository.
+func validateSyncSchedule(appRepo *v1alpha1.AppRepository) error {
+	if appRepo.Spec.SyncSchedule == "" {
+		return nil
+	}
+	if err := cron.Validate(appRepo.Spec.SyncSchedule); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSyncSchedule, err)
+	}
+	return nil
+}
+
 // NewHandler returns a handler configured with a service account client set and a config
 // with a blank token to be copied when creating user client sets with specific tokens.
 func NewHandler(kubeappsNamespace string, additionalClusters AdditionalClustersConfig) (AuthHandler, error) {
@@ -338,6 +367,36 @@ func (a *userHandler) applyAppRepositorySecret(repoSecret *corev1.Secret, reques
 	return nil
 }
 
+// applyKeyringSecret copies the trusted keys of an app repository outside the
+// kubeapps namespace to the kubeapps namespace, where its sync jobs run.
+func (a *userHandler) applyKeyringSecret(requestNamespace string, appRepo *v1alpha1.AppRepository) error {
+	if appRepo.Spec.Verification == nil || requestNamespace == a.kubeappsNamespace {
+		return nil
+	}
+	ref := appRepo.Spec.Verification.KeyringSecretRef
+	// The keyring is read with the user's client, so that only the secrets
+	// the user has access to are copied.
+	keyringSecret, err := a.clientset.CoreV1().Secrets(requestNamespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	keyring, ok := keyringSecret.Data[ref.Key]
+	if !ok {
+		return k8sErrors.NewBadRequest(fmt.Sprintf("key %q not found in secret %q", ref.Key, ref.Name))
+	}
+	secretCopy := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: KubeappsKeyringSecretNameForRepo(appRepo.ObjectMeta.Name, appRepo.ObjectMeta.Namespace),
+		},
+		Data: map[string][]byte{ref.Key: keyring},
+	}
+	_, err = a.svcClientset.CoreV1().Secrets(a.kubeappsNamespace).Create(context.TODO(), secretCopy, metav1.CreateOptions{})
+	if err != nil && k8sErrors.IsAlreadyExists(err) {
+		_, err = a.svcClientset.CoreV1().Secrets(a.kubeappsNamespace).Update(context.TODO(), secretCopy, metav1.UpdateOptions{})
+	}
+	return err
+}
+
 // CreateAppRepository creates an AppRepository resource based on the request data
 func (a *userHandler) CreateAppRepository(appRepoBody io.ReadCloser, requestNamespace string) (*v1alpha1.AppRepository, error) {
 	if a.kubeappsNamespace == "" {
@@ -359,6 +418,10 @@ func (a *userHandler) CreateAppRepository(appRepoBody io.ReadCloser, requestName
 		return nil, ErrGlobalRepositoryWithSecrets
 	}
 
+	if err = validateSyncSchedule(appRepo); err != nil {
+		return nil, err
+	}
+
 	appRepo, err = a.clientset.KubeappsV1alpha1().AppRepositories(requestNamespace).Create(context.TODO(), appRepo, metav1.CreateOptions{})
 
 	if err != nil {
@@ -372,6 +435,9 @@ func (a *userHandler) CreateAppRepository(appRepoBody io.ReadCloser, requestName
 			return nil, err
 		}
 	}
+	if err = a.applyKeyringSecret(requestNamespace, appRepo); err != nil {
+		return nil, err
+	}
 	return appRepo, nil
 }
 
@@ -396,6 +462,10 @@ func (a *userHandler) UpdateAppRepository(appRepoBody io.ReadCloser, requestName
 		return nil, ErrGlobalRepositoryWithSecrets
 	}
 
+	if err = validateSyncSchedule(appRepo); err != nil {
+		return nil, err
+	}
+
 	existingAppRepo, err := a.clientset.KubeappsV1alpha1().AppRepositories(requestNamespace).Get(context.TODO(), appRepo.Name, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
@@ -415,6 +485,9 @@ func (a *userHandler) UpdateAppRepository(appRepoBody io.ReadCloser, requestName
 			return nil, err
 		}
 	}
+	if err = a.applyKeyringSecret(requestNamespace, appRepo); err != nil {
+		return nil, err
+	}
 	return appRepo, nil
 }
 
@@ -435,6 +508,15 @@ func (a *userHandler) DeleteAppRepository(repoName, repoNamespace string) error
 	// namespace should be deleted when the owning app repo is deleted).
 	if hasCredentials && repoNamespace != a.kubeappsNamespace {
 		err = a.clientset.CoreV1().Secrets(a.kubeappsNamespace).Delete(context.TODO(), KubeappsSecretNameForRepo(repoName, repoNamespace), metav1.DeleteOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	if appRepo.Spec.Verification != nil && repoNamespace != a.kubeappsNamespace {
+		err = a.svcClientset.CoreV1().Secrets(a.kubeappsNamespace).Delete(context.TODO(), KubeappsKeyringSecretNameForRepo(repoName, repoNamespace), metav1.DeleteOptions{})
+		if k8sErrors.IsNotFound(err) {
+			err = nil
+		}
 	}
 	return err
 }
@@ -454,14 +536,29 @@ func getValidationCliAndReq(appRepoBody io.ReadCloser, requestNamespace, kubeapp
 		// already exist in the namespace.
 		return nil, nil, ErrGlobalRepositoryWithSecrets
 	}
+	if err = validateSyncSchedule(appRepo); err != nil {
+		return nil, nil, err
+	}
 
 	repoSecret := secretForRequest(appRepoRequest, appRepo)
 	cli, err := InitNetClient(appRepo, repoSecret, repoSecret, nil)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Unable to create HTTP client: %w", err)
 	}
-	indexURL := strings.TrimSuffix(strings.TrimSpace(appRepo.Spec.URL), "/") + "/index.yaml"
-	req, err := http.NewRequest("GET", indexURL, nil)
+	validationURL := strings.TrimSuffix(strings.TrimSpace(appRepo.Spec.URL), "/") + "/index.yaml"
+	if appRepo.Spec.Type == "oci" {
+		// OCI registries don't have an index so we check that the first
+		// repository can be listed instead.
+		if len(appRepo.Spec.OCIRepositories) == 0 {
+			return nil, nil, ErrOCIRepositoriesRequired
+		}
+		registry, err := oci.NewRegistry(appRepo.Spec.URL, cli, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		validationURL = registry.TagsListURL(appRepo.Spec.OCIRepositories[0])
+	}
+	req, err := http.NewRequest("GET", validationURL, nil)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -496,6 +593,15 @@ func (a *userHandler) GetAppRepository(repoName, repoNamespace string) (*v1alpha
 	return a.clientset.KubeappsV1alpha1().AppRepositories(repoNamespace).Get(context.TODO(), repoName, metav1.GetOptions{})
 }
 
+// ListAppRepositories returns the AppRepository resources of a namespace.
+func (a *userHandler) ListAppRepositories(repoNamespace string) ([]v1alpha1.AppRepository, error) {
+	appRepos, err := a.clientset.KubeappsV1alpha1().AppRepositories(repoNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return appRepos.Items, nil
+}
+
 // appRepositoryForRequest takes care of parsing the request data into an AppRepository.
 func appRepositoryForRequest(appRepoRequest *appRepositoryRequest) *v1alpha1.AppRepository {
 	appRepo := appRepoRequest.AppRepository
@@ -525,17 +631,26 @@ func appRepositoryForRequest(appRepoRequest *appRepositoryRequest) *v1alpha1.App
 		}
 	}
 
+	repoType := appRepo.Type
+	if repoType == "" {
+		repoType = "helm"
+	}
+
 	return &v1alpha1.AppRepository{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: appRepo.Name,
 		},
 		Spec: v1alpha1.AppRepositorySpec{
 			URL:                   appRepo.RepoURL,
-			Type:                  "helm",
+			Type:                  repoType,
+			OCIRepositories:       appRepo.OCIRepositories,
 			Auth:                  auth,
 			DockerRegistrySecrets: appRepo.RegistrySecrets,
 			SyncJobPodTemplate:    appRepo.SyncJobPodTemplate,
 			ResyncRequests:        appRepo.ResyncRequests,
+			SyncSchedule:          appRepo.SyncSchedule,
+			Suspend:               appRepo.Suspend,
+			Verification:          appRepo.Verification,
 		},
 	}
 }
@@ -582,6 +697,12 @@ func KubeappsSecretNameForRepo(repoName, namespace string) string {
 	return fmt.Sprintf("%s-%s", namespace, secretNameForRepo(repoName))
 }
 
+// KubeappsKeyringSecretNameForRepo returns the name of the copy of the
+// keyring secret of a per-namespace repository in the kubeapps namespace.
+func KubeappsKeyringSecretNameForRepo(repoName, namespace string) string {
+	return KubeappsSecretNameForRepo(repoName, namespace) + "-keyring"
+}
+
 func filterAllowedNamespaces(userClientset combinedClientsetInterface, namespaces *corev1.NamespaceList) (*corev1.NamespaceList, error) {
 	allowedNamespaces := []corev1.Namespace{}
 	for _, namespace := range namespaces.Items {
@@ -271,6 +271,17 @@ func TestAppRepositoryCreate(t *testing.T) {
 			requestData:      `{"appRepository": {"name": "test-repo", "url": "http://example.com/test-repo", "registrySecrets": ["secret-one", "secret-two"]}}`,
 			expectedError:    ErrGlobalRepositoryWithSecrets,
 		},
+		{
+			name:             "it creates an app repository with a sync schedule",
+			requestNamespace: kubeappsNamespace,
+			requestData:      `{"appRepository": {"name": "test-repo", "url": "http://example.com/test-repo", "syncSchedule": "*/2 * * * *"}}`,
+		},
+		{
+			name:             "it errors if the sync schedule is not valid",
+			requestNamespace: kubeappsNamespace,
+			requestData:      `{"appRepository": {"name": "test-repo", "url": "http://example.com/test-repo", "syncSchedule": "every two minutes"}}`,
+			expectedError:    fmt.Errorf(`invalid sync schedule: expected exactly 5 fields, found 3: "every two minutes"`),
+		},
 		{
 			name:             "it errors if the repo exists in the kubeapps ns already",
 			requestNamespace: kubeappsNamespace,
@@ -504,6 +515,86 @@ func TestDeleteAppRepository(t *testing.T) {
 	}
 }
 
+func TestAppRepositoryKeyringSecret(t *testing.T) {
+	const requestData = `{"appRepository": {"name": "test-repo", "url": "http://example.com/test-repo", "verification": {"keyringSecretRef": {"name": "my-keyring", "key": "pubring.gpg"}}}}`
+	keyringSecret := func(namespace string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "my-keyring", Namespace: namespace},
+			Data:       map[string][]byte{"pubring.gpg": []byte("keys")},
+		}
+	}
+	testCases := []struct {
+		name             string
+		requestNamespace string
+		existingSecrets  []runtime.Object
+		expectCopy       bool
+		expectedError    error
+	}{
+		{
+			name:             "it copies the keyring secret of a namespaced repo to the kubeapps namespace",
+			requestNamespace: "my-namespace",
+			existingSecrets:  []runtime.Object{keyringSecret("my-namespace")},
+			expectCopy:       true,
+		},
+		{
+			name:             "it does not copy the keyring secret of a global repo",
+			requestNamespace: kubeappsNamespace,
+			existingSecrets:  []runtime.Object{keyringSecret(kubeappsNamespace)},
+		},
+		{
+			name:             "it errors if the keyring secret does not exist",
+			requestNamespace: "my-namespace",
+			expectedError:    fmt.Errorf(`secrets "my-keyring" not found`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := fakeCombinedClientset{
+				fakeapprepoclientset.NewSimpleClientset(),
+				fakecoreclientset.NewSimpleClientset(tc.existingSecrets...),
+				&fakeRest.RESTClient{},
+			}
+			handler := userHandler{
+				kubeappsNamespace: kubeappsNamespace,
+				svcClientset:      cs,
+				clientset:         cs,
+			}
+
+			_, err := handler.CreateAppRepository(ioutil.NopCloser(strings.NewReader(requestData)), tc.requestNamespace)
+			checkErr(t, err, tc.expectedError)
+			if err != nil {
+				return
+			}
+
+			copyName := KubeappsKeyringSecretNameForRepo("test-repo", tc.requestNamespace)
+			secretCopy, err := cs.CoreV1().Secrets(kubeappsNamespace).Get(context.TODO(), copyName, metav1.GetOptions{})
+			if !tc.expectCopy {
+				if got, want := errorCodeForK8sError(t, err), 404; got != want {
+					t.Errorf("got: %d, want: %d", got, want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := string(secretCopy.Data["pubring.gpg"]), "keys"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+
+			// The copy is deleted along with the repo.
+			err = handler.DeleteAppRepository("test-repo", tc.requestNamespace)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			_, err = cs.CoreV1().Secrets(kubeappsNamespace).Get(context.TODO(), copyName, metav1.GetOptions{})
+			if got, want := errorCodeForK8sError(t, err), 404; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
+
 func errorCodeForK8sError(t *testing.T, err error) int {
 	if err == nil {
 		return 0
@@ -635,6 +726,45 @@ func TestAppRepositoryForRequest(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "it creates an app repo for an OCI registry",
+			request: appRepositoryRequestDetails{
+				Name:            "test-repo",
+				RepoURL:         "oci://example.com",
+				Type:            "oci",
+				OCIRepositories: []string{"charts/nginx"},
+			},
+			appRepo: v1alpha1.AppRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-repo",
+				},
+				Spec: v1alpha1.AppRepositorySpec{
+					URL:             "oci://example.com",
+					Type:            "oci",
+					OCIRepositories: []string{"charts/nginx"},
+				},
+			},
+		},
+		{
+			name: "it creates a suspended app repo with a sync schedule",
+			request: appRepositoryRequestDetails{
+				Name:         "test-repo",
+				RepoURL:      "http://example.com/test-repo",
+				SyncSchedule: "@daily",
+				Suspend:      true,
+			},
+			appRepo: v1alpha1.AppRepository{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "test-repo",
+				},
+				Spec: v1alpha1.AppRepositorySpec{
+					URL:          "http://example.com/test-repo",
+					Type:         "helm",
+					SyncSchedule: "@daily",
+					Suspend:      true,
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -882,6 +1012,24 @@ func TestValidateAppRepository(t *testing.T) {
 			requestData:      `{"appRepository": {"name": "test-repo", "repoURL": "http://example.com/test-repo", "registrySecrets": ["secret-1"]}}`,
 			expectedError:    ErrGlobalRepositoryWithSecrets,
 		},
+		{
+			name:             "it lists the tags of the first repository of an OCI registry",
+			requestNamespace: kubeappsNamespace,
+			requestData:      `{"appRepository": {"name": "test-repo", "repoURL": "oci://example.com", "type": "oci", "ociRepositories": ["charts/nginx", "charts/wordpress"]}}`,
+			expectedURL:      "https://example.com/v2/charts/nginx/tags/list",
+		},
+		{
+			name:             "validation fails if an OCI registry has no repositories",
+			requestNamespace: kubeappsNamespace,
+			requestData:      `{"appRepository": {"name": "test-repo", "repoURL": "oci://example.com", "type": "oci"}}`,
+			expectedError:    ErrOCIRepositoriesRequired,
+		},
+		{
+			name:             "validation fails if the sync schedule is not valid",
+			requestNamespace: kubeappsNamespace,
+			requestData:      `{"appRepository": {"name": "test-repo", "repoURL": "http://example.com/test-repo", "syncSchedule": "* * *"}}`,
+			expectedError:    ErrInvalidSyncSchedule,
+		},
 	}
 
 	for _, tc := range getValidationCliAndReqTests {
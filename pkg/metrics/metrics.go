@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics records the Prometheus metrics of the HTTP requests served
+// by the Kubeapps services.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/negroni"
+)
+
+const (
+	// Path is the path the metrics are served on.
+	Path = "/metrics"
+
+	// defaultCluster is the cluster of the routes without cluster.
+	defaultCluster = "default"
+	// unknownCluster is the cluster of the routes with a cluster which is not
+	// configured, so that arbitrary values are not used as labels.
+	unknownCluster = "unknown"
+	// unmatchedRoute is the route of the requests not matching any route, so
+	// that their raw paths are not used as labels.
+	unmatchedRoute = "unmatched"
+)
+
+var labels = []string{"method", "route", "cluster", "code"}
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kubeapps",
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests served.",
+	}, labels)
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "kubeapps",
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests served.",
+		Buckets:   prometheus.DefBuckets,
+	}, labels)
+)
+
+func init() {
+	prometheus.MustRegister(requests, requestDuration)
+}
+
+// Middleware is a negroni middleware recording the number and latency of the
+// requests, labelled by the template of the route of a router they match.
+type Middleware struct {
+	router   *mux.Router
+	clusters map[string]bool
+}
+
+// NewMiddleware returns a Middleware for the routes of the given router. The
+// requests for clusters other than the default cluster and the given ones
+// are labelled with an unknown cluster.
+func NewMiddleware(router *mux.Router, clusters ...string) *Middleware {
+	m := &Middleware{router: router, clusters: map[string]bool{defaultCluster: true}}
+	for _, c := range clusters {
+		m.clusters[c] = true
+	}
+	return m
+}
+
+func (m *Middleware) ServeHTTP(rw http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	start := time.Now()
+	// The route is matched before calling the router since the route of a
+	// request is only available within the router.
+	route, cluster := unmatchedRoute, defaultCluster
+	var match mux.RouteMatch
+	if m.router.Match(req, &match) && match.Route != nil {
+		if template, err := match.Route.GetPathTemplate(); err == nil {
+			route = template
+		}
+		if c, ok := match.Vars["cluster"]; ok {
+			cluster = unknownCluster
+			if m.clusters[c] {
+				cluster = c
+			}
+		}
+	}
+
+	nrw, ok := rw.(negroni.ResponseWriter)
+	if !ok {
+		nrw = negroni.NewResponseWriter(rw)
+	}
+	next(nrw, req)
+
+	status := nrw.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	values := []string{req.Method, route, cluster, strconv.Itoa(status)}
+	requests.WithLabelValues(values...).Inc()
+	requestDuration.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+}
+
+// Handler serves the metrics registered in the default Prometheus registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Serve serves the metrics on the given address, separately from the API of
+// the service so that they are not exposed through the frontend.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle(Path, Handler())
+	log.WithFields(log.Fields{"addr": addr}).Info("Serving metrics")
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Errorf("Unable to serve metrics: %v", err)
+	}
+}
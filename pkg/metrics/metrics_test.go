@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/urfave/negroni"
+)
+
+func TestMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Handle(Path, Handler())
+	v1 := r.PathPrefix("/v1").Subrouter()
+	v1.Methods("GET").Path("/namespaces/{namespace}/releases/{releaseName}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("foo"))
+	})
+	v1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}").HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	n := negroni.New(NewMiddleware(r, "other"))
+	n.UseHandler(r)
+
+	tests := []struct {
+		name    string
+		path    string
+		route   string
+		cluster string
+		code    string
+	}{
+		{"route without cluster", "/v1/namespaces/default/releases/foo", "/v1/namespaces/{namespace}/releases/{releaseName}", "default", "200"},
+		{"route with cluster", "/v1/clusters/other/namespaces/default/releases/foo", "/v1/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}", "other", "404"},
+		{"route with unknown cluster", "/v1/clusters/foo/namespaces/default/releases/foo", "/v1/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}", "unknown", "404"},
+		{"unknown route", "/v1/foo/bar", "unmatched", "default", "404"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := requests.WithLabelValues("GET", tt.route, tt.cluster, tt.code)
+			before := testutil.ToFloat64(counter)
+
+			n.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+
+			if got, want := testutil.ToFloat64(counter), before+1; got != want {
+				t.Errorf("got: %v, want: %v", got, want)
+			}
+		})
+	}
+
+	t.Run("metrics endpoint", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		n.ServeHTTP(rec, httptest.NewRequest("GET", Path, nil))
+
+		if want := `kubeapps_http_requests_total{cluster="other",code="404",method="GET",route="/v1/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}"} 1`; !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("got: %s, want it to contain: %s", rec.Body.String(), want)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package oci implements the subset of the OCI distribution API required to
+// list and fetch Helm charts stored in an OCI registry.
+// See https://github.com/opencontainers/distribution-spec/blob/master/spec.md
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	// ManifestMediaType is the media type of an OCI image manifest.
+	ManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// HelmChartConfigMediaType is the media type of the config blob of a Helm
+	// chart, containing the Chart.yaml metadata as JSON.
+	HelmChartConfigMediaType = "application/vnd.cncf.helm.config.v1+json"
+	// HelmChartContentLayerMediaType is the media type of the chart tarball
+	// layer as pushed by Helm 3.0 - 3.2.
+	HelmChartContentLayerMediaType = "application/tar+gzip"
+	// HelmChartContentLayerMediaTypeV1 is the media type of the chart tarball
+	// layer as pushed by newer Helm releases.
+	HelmChartContentLayerMediaTypeV1 = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	// AnnotationCreated is the annotation used to record the creation date
+	// of an artifact.
+	AnnotationCreated = "org.opencontainers.image.created"
+	// DigestHeader is the header in which the registry returns the digest of
+	// a manifest.
+	DigestHeader = "Docker-Content-Digest"
+)
+
+// imageManifestMediaTypes are the media types of the manifests of container
+// images, accepted when resolving their digest.
+var imageManifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	ManifestMediaType,
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ErrUnauthorized is wrapped by the errors returned when the registry rejects
+// the credentials of a request.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// HTTPClient Interface to perform HTTP requests
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Descriptor describes the disposition of targeted content.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Manifest is an OCI image manifest.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// TagList is the response of the tags list endpoint.
+type TagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// Registry is a client for a single OCI registry.
+type Registry struct {
+	url     *url.URL
+	client  HTTPClient
+	headers http.Header
+}
+
+// NewRegistry returns a Registry for the given registry URL. Requests are
+// sent with the given headers (for example Authorization or User-Agent), and
+// are authenticated with a Bearer token when the registry challenges them.
+// The oci:// scheme is accepted as an alias of https://.
+func NewRegistry(registryURL string, client HTTPClient, headers http.Header) (*Registry, error) {
+	registryURL = strings.TrimSpace(registryURL)
+	if strings.HasPrefix(registryURL, "oci://") {
+		registryURL = "https://" + strings.TrimPrefix(registryURL, "oci://")
+	}
+	u, err := url.ParseRequestURI(registryURL)
+	if err != nil {
+		return nil, err
+	}
+	if headers == nil {
+		headers = http.Header{}
+	}
+	return &Registry{url: u, client: NewTokenClient(client), headers: headers}, nil
+}
+
+// endpoint returns the absolute URL for a path under the /v2/ API of the
+// given repository.
+func (r *Registry) endpoint(repository string, elem ...string) string {
+	u := *r.url
+	u.Path = path.Join(append([]string{"/v2", strings.Trim(repository, "/")}, elem...)...)
+	return u.String()
+}
+
+// do sends a request to the registry and returns its response, or an error
+// if it was not successful. The caller must close the body of the response.
+func (r *Registry) do(method, rawURL, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.headers {
+		req.Header[k] = v
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		if res != nil {
+			res.Body.Close()
+		}
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		res.Body.Close()
+		return nil, fmt.Errorf("request to %s failed: %d: %w", rawURL, res.StatusCode, ErrUnauthorized)
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("request to %s failed: %d", rawURL, res.StatusCode)
+	}
+	return res, nil
+}
+
+func (r *Registry) get(rawURL, accept string) ([]byte, error) {
+	res, err := r.do("GET", rawURL, accept)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+// TagsListURL returns the URL listing the tags of a repository.
+func (r *Registry) TagsListURL(repository string) string {
+	return r.endpoint(repository, "tags", "list")
+}
+
+// ListTags returns the tags of a repository.
+func (r *Registry) ListTags(repository string) ([]string, error) {
+	body, err := r.get(r.TagsListURL(repository), "")
+	if err != nil {
+		return nil, err
+	}
+	var tagList TagList
+	if err := json.Unmarshal(body, &tagList); err != nil {
+		return nil, err
+	}
+	return tagList.Tags, nil
+}
+
+// GetManifest returns the manifest of a repository for a tag or digest.
+func (r *Registry) GetManifest(repository, reference string) (*Manifest, error) {
+	body, err := r.get(r.endpoint(repository, "manifests", reference), ManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// ResolveDigest returns the digest of the manifest of a repository for a
+// tag, as the container runtime would pull it. The digest of an image built
+// for several platforms is the one of its index.
+func (r *Registry) ResolveDigest(repository, tag string) (string, error) {
+	res, err := r.do("HEAD", r.endpoint(repository, "manifests", tag), strings.Join(imageManifestMediaTypes, ", "))
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	digest := res.Header.Get(DigestHeader)
+	if digest == "" {
+		return "", fmt.Errorf("the registry did not return the digest of %s:%s", repository, tag)
+	}
+	return digest, nil
+}
+
+// GetBlob returns the content of a blob.
+func (r *Registry) GetBlob(repository, digest string) ([]byte, error) {
+	return r.get(r.BlobURL(repository, digest), "")
+}
+
+// BlobURL returns the URL from which a blob can be downloaded.
+func (r *Registry) BlobURL(repository, digest string) string {
+	return r.endpoint(repository, "blobs", digest)
+}
+
+// ChartLayer returns the descriptor of the layer containing the chart tarball.
+func ChartLayer(manifest *Manifest) (*Descriptor, error) {
+	for i, l := range manifest.Layers {
+		if l.MediaType == HelmChartContentLayerMediaType || l.MediaType == HelmChartContentLayerMediaTypeV1 {
+			return &manifest.Layers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("manifest does not contain a chart layer")
+}
+
+// IsChart returns whether the manifest describes a Helm chart.
+func IsChart(manifest *Manifest) bool {
+	return manifest.Config.MediaType == HelmChartConfigMediaType
+}
+
+// DigestHex returns the hex encoded part of a digest such as sha256:abc...,
+// which is the format used for chart digests in a Helm repository index.
+func DigestHex(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 {
+		return digest[i+1:]
+	}
+	return digest
+}
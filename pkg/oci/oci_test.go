@@ -0,0 +1,207 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func newTestRegistry(t *testing.T) (*Registry, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if got, want := req.Header.Get("Authorization"), "Basic Zm9vOmJhcg=="; got != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/v2/project/nginx/tags/list":
+			w.Write([]byte(`{"name": "project/nginx", "tags": ["1.0.0", "1.1.0"]}`))
+		case "/v2/project/nginx/manifests/1.1.0":
+			if got, want := req.Header.Get("Accept"), ManifestMediaType; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			w.Write([]byte(`{
+  "schemaVersion": 2,
+  "config": {"mediaType": "application/vnd.cncf.helm.config.v1+json", "digest": "sha256:123", "size": 10},
+  "layers": [{"mediaType": "application/tar+gzip", "digest": "sha256:456", "size": 20}]
+}`))
+		case "/v2/project/nginx/manifests/1.0.0":
+			if got, want := req.Method, "HEAD"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			w.Header().Set(DigestHeader, "sha256:789")
+		case "/v2/project/nginx/manifests/untracked":
+		case "/v2/project/nginx/blobs/sha256:123":
+			w.Write([]byte(`{"name": "nginx", "version": "1.1.0"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	r, err := NewRegistry(server.URL, server.Client(), http.Header{"Authorization": []string{"Basic Zm9vOmJhcg=="}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return r, server
+}
+
+func TestListTags(t *testing.T) {
+	r, server := newTestRegistry(t)
+	defer server.Close()
+
+	tags, err := r.ListTags("project/nginx")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := tags, []string{"1.0.0", "1.1.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+
+	_, err = r.ListTags("project/unknown")
+	if err == nil {
+		t.Errorf("got: nil, want: error")
+	}
+
+	unauthorized, err := NewRegistry(server.URL, server.Client(), nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	_, err = unauthorized.ListTags("project/nginx")
+	if got, want := err, ErrUnauthorized; !errors.Is(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestGetManifestAndBlob(t *testing.T) {
+	r, server := newTestRegistry(t)
+	defer server.Close()
+
+	manifest, err := r.GetManifest("project/nginx", "1.1.0")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !IsChart(manifest) {
+		t.Errorf("expected manifest to be a chart")
+	}
+	layer, err := ChartLayer(manifest)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := layer.Digest, "sha256:456"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := r.BlobURL("project/nginx", layer.Digest), server.URL+"/v2/project/nginx/blobs/sha256:456"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	config, err := r.GetBlob("project/nginx", manifest.Config.Digest)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := string(config), `{"name": "nginx", "version": "1.1.0"}`; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestResolveDigest(t *testing.T) {
+	r, server := newTestRegistry(t)
+	defer server.Close()
+
+	digest, err := r.ResolveDigest("project/nginx", "1.0.0")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := digest, "sha256:789"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	for _, tag := range []string{"untracked", "unknown"} {
+		if _, err := r.ResolveDigest("project/nginx", tag); err == nil {
+			t.Errorf("got: nil, want: error for %q", tag)
+		}
+	}
+}
+
+func TestChartLayer(t *testing.T) {
+	testCases := []struct {
+		name     string
+		manifest Manifest
+		digest   string
+	}{
+		{
+			name:     "legacy media type",
+			manifest: Manifest{Layers: []Descriptor{{MediaType: HelmChartContentLayerMediaType, Digest: "sha256:1"}}},
+			digest:   "sha256:1",
+		},
+		{
+			name: "v1 media type",
+			manifest: Manifest{Layers: []Descriptor{
+				{MediaType: "application/vnd.cncf.helm.chart.provenance.v1.prov", Digest: "sha256:1"},
+				{MediaType: HelmChartContentLayerMediaTypeV1, Digest: "sha256:2"},
+			}},
+			digest: "sha256:2",
+		},
+		{
+			name:     "no chart layer",
+			manifest: Manifest{Layers: []Descriptor{{MediaType: "application/octet-stream", Digest: "sha256:1"}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			layer, err := ChartLayer(&tc.manifest)
+			if tc.digest == "" {
+				if err == nil {
+					t.Errorf("got: nil, want: error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := layer.Digest, tc.digest; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNewRegistry(t *testing.T) {
+	r, err := NewRegistry("oci://harbor.example.com", &http.Client{}, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := r.BlobURL("library/nginx", "sha256:1"), "https://harbor.example.com/v2/library/nginx/blobs/sha256:1"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	_, err = NewRegistry("not-a-url", &http.Client{}, nil)
+	if err == nil {
+		t.Errorf("got: nil, want: error")
+	}
+}
+
+func TestDigestHex(t *testing.T) {
+	if got, want := DigestHex("sha256:abc"), "abc"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+	if got, want := DigestHex("abc"), "abc"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// tokenClient is an HTTPClient implementing the Bearer token flow of the
+// Docker registry API. When a request is challenged with a Bearer
+// WWW-Authenticate header, a token is requested from the authorization
+// service of the registry with the Authorization header of the request, if
+// any, and the request is retried with it.
+// See https://docs.docker.com/registry/spec/auth/token/
+type tokenClient struct {
+	client HTTPClient
+
+	mu sync.Mutex
+	// tokens are the last tokens obtained, by host and repository.
+	tokens map[string]string
+}
+
+// NewTokenClient returns an HTTPClient which authenticates the requests
+// challenged by a registry with a Bearer token.
+func NewTokenClient(client HTTPClient) HTTPClient {
+	if _, ok := client.(*tokenClient); ok {
+		return client
+	}
+	return &tokenClient{client: client, tokens: map[string]string{}}
+}
+
+// Do sends a request, authenticating it with a token if the registry
+// requires it. Only requests without a body can be retried.
+func (c *tokenClient) Do(req *http.Request) (*http.Response, error) {
+	key := tokenKey(req.URL)
+	credentials := req.Header.Get("Authorization")
+
+	if token := c.token(key); token != "" {
+		res, err := c.client.Do(withToken(req, token))
+		if err != nil || res.StatusCode != http.StatusUnauthorized {
+			return res, err
+		}
+		// The token expired so a new one is requested.
+		res.Body.Close()
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+	challenge, ok := parseBearerChallenge(res.Header.Get("WWW-Authenticate"))
+	if !ok {
+		return res, nil
+	}
+	res.Body.Close()
+
+	token, err := c.fetchToken(challenge, credentials, req.URL)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.tokens[key] = token
+	c.mu.Unlock()
+	return c.client.Do(withToken(req, token))
+}
+
+func (c *tokenClient) token(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens[key]
+}
+
+// fetchToken requests a token from the authorization service of a challenge.
+// The credentials of the registry are only sent to services over https or
+// on the same host as the registry.
+func (c *tokenClient) fetchToken(challenge map[string]string, credentials string, registry *url.URL) (string, error) {
+	realm, err := url.Parse(challenge["realm"])
+	if err != nil || realm.Scheme == "" {
+		return "", fmt.Errorf("invalid token realm %q", challenge["realm"])
+	}
+	query := realm.Query()
+	for _, param := range []string{"service", "scope"} {
+		if challenge[param] != "" {
+			query.Set(param, challenge[param])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if credentials != "" && (realm.Scheme == "https" || realm.Host == registry.Host) {
+		req.Header.Set("Authorization", credentials)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return "", fmt.Errorf("token request to %s failed: %d: %w", realm.Host, res.StatusCode, ErrUnauthorized)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request to %s failed: %d", realm.Host, res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	var tokenRes struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &tokenRes); err != nil {
+		return "", err
+	}
+	// Both fields are equivalent, access_token is used for OAuth 2.0
+	// compatibility.
+	if tokenRes.Token != "" {
+		return tokenRes.Token, nil
+	}
+	if tokenRes.AccessToken != "" {
+		return tokenRes.AccessToken, nil
+	}
+	return "", fmt.Errorf("token request to %s did not return a token", realm.Host)
+}
+
+// withToken returns a copy of a request authenticated with a token.
+func withToken(req *http.Request, token string) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// tokenKey returns the key under which the token of a request is stored. The
+// scope of the tokens issued by registries is a repository, which is the
+// path of the request between /v2/ and its manifests, blobs or tags.
+func tokenKey(u *url.URL) string {
+	repository := strings.TrimPrefix(u.Path, "/v2/")
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.LastIndex(repository, sep); i >= 0 {
+			repository = repository[:i]
+			break
+		}
+	}
+	return u.Host + "/" + repository
+}
+
+// parseBearerChallenge returns the parameters of a Bearer WWW-Authenticate
+// header such as:
+//
+//	Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"
+func parseBearerChallenge(header string) (map[string]string, bool) {
+	const prefix = "bearer "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return nil, false
+	}
+	params := map[string]string{}
+	rest := header[len(prefix):]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, false
+			}
+			value, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
+		}
+		params[name] = value
+	}
+	if params["realm"] == "" {
+		return nil, false
+	}
+	return params, true
+}
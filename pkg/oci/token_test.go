@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTokenClient(t *testing.T) {
+	tokenRequests := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/token":
+			tokenRequests++
+			if got, want := req.Header.Get("Authorization"), "Basic Zm9vOmJhcg=="; got != want {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if got, want := req.URL.Query().Get("scope"), "repository:project/nginx:pull"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := req.URL.Query().Get("service"), "registry.example.com"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			w.Write([]byte(`{"token": "abc"}`))
+		case "/v2/project/nginx/tags/list":
+			if req.Header.Get("Authorization") != "Bearer abc" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.example.com",scope="repository:project/nginx:pull"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"name": "project/nginx", "tags": ["1.0.0"]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	r, err := NewRegistry(server.URL, server.Client(), http.Header{"Authorization": []string{"Basic Zm9vOmJhcg=="}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for i := 0; i < 2; i++ {
+		tags, err := r.ListTags("project/nginx")
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if got, want := tags, []string{"1.0.0"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %v, want: %v", got, want)
+		}
+	}
+	// The token is reused for the requests to the same repository.
+	if got, want := tokenRequests, 1; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+
+	unauthorized, err := NewRegistry(server.URL, server.Client(), nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	_, err = unauthorized.ListTags("project/nginx")
+	if got, want := err, ErrUnauthorized; !errors.Is(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestTokenClientForeignRealm(t *testing.T) {
+	var credentials string
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		credentials = req.Header.Get("Authorization")
+		w.Write([]byte(`{"token": "abc"}`))
+	}))
+	defer authServer.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer abc" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token"`, authServer.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"name": "project/nginx", "tags": ["1.0.0"]}`))
+	}))
+	defer server.Close()
+
+	r, err := NewRegistry(server.URL, server.Client(), http.Header{"Authorization": []string{"Basic Zm9vOmJhcg=="}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, err := r.ListTags("project/nginx"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// The credentials are not sent over http to another host.
+	if got, want := credentials, ""; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestParseBearerChallenge(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header string
+		params map[string]string
+	}{
+		{
+			name:   "docker hub",
+			header: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`,
+			params: map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/nginx:pull"},
+		},
+		{
+			name:   "unquoted values",
+			header: `bearer realm=https://ghcr.io/token, service=ghcr.io`,
+			params: map[string]string{"realm": "https://ghcr.io/token", "service": "ghcr.io"},
+		},
+		{
+			name:   "basic challenge",
+			header: `Basic realm="Registry"`,
+		},
+		{
+			name:   "no realm",
+			header: `Bearer service="registry.docker.io"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params, ok := parseBearerChallenge(tc.header)
+			if got, want := ok, tc.params != nil; got != want {
+				t.Fatalf("got: %t, want: %t", got, want)
+			}
+			if ok && !reflect.DeepEqual(params, tc.params) {
+				t.Errorf("got: %v, want: %v", params, tc.params)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package provenance verifies the provenance files which Helm publishes
+// along with signed charts, as "helm verify" does.
+package provenance
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+const (
+	// StatusVerified is the status of a chart version signed by a trusted key.
+	StatusVerified = "verified"
+	// StatusUnsigned is the status of a chart version without a provenance
+	// file.
+	StatusUnsigned = "unsigned"
+	// StatusFailed is the status of a chart version whose provenance file is
+	// invalid, not signed by a trusted key or does not match the chart.
+	StatusFailed = "failed"
+
+	// Extension is the extension of the provenance file of a chart tarball,
+	// which is published next to it.
+	Extension = ".prov"
+)
+
+// ErrUnsigned is returned for a provenance file without signature.
+var ErrUnsigned = errors.New("no signature found in the provenance file")
+
+// sumCollection is the list of the digests of the files signed in a
+// provenance file.
+type sumCollection struct {
+	Files map[string]string `json:"files"`
+}
+
+// ReadKeyring returns the public keys of a keyring, either ASCII armored or
+// binary as exported by "gpg --export".
+func ReadKeyring(data []byte) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the keyring: %v", err)
+	}
+	if len(keyring) == 0 {
+		return nil, errors.New("the keyring has no keys")
+	}
+	return keyring, nil
+}
+
+// Verify checks that a provenance file is signed by one of the keys of a
+// keyring and that it signs a chart tarball with the given file name and
+// hex encoded SHA-256 digest. It returns the identity of the signer.
+func Verify(keyring openpgp.EntityList, prov []byte, chartFileName, digest string) (string, error) {
+	block, _ := clearsign.Decode(prov)
+	if block == nil {
+		return "", ErrUnsigned
+	}
+	signer, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body)
+	if err != nil {
+		return "", fmt.Errorf("the provenance file is not signed by a trusted key: %v", err)
+	}
+
+	// The signed message is the Chart.yaml of the chart followed by the
+	// digests of the signed files.
+	parts := bytes.Split(block.Plaintext, []byte("\n...\n"))
+	if len(parts) < 2 {
+		return "", errors.New("the provenance file has no digests")
+	}
+	sums := sumCollection{}
+	if err := yaml.Unmarshal(parts[1], &sums); err != nil {
+		return "", fmt.Errorf("unable to parse the digests of the provenance file: %v", err)
+	}
+	sum, ok := sums.Files[chartFileName]
+	if !ok {
+		return "", fmt.Errorf("the provenance file does not sign %s", chartFileName)
+	}
+	if !strings.EqualFold(sum, "sha256:"+digest) {
+		return "", fmt.Errorf("the digest of %s does not match the provenance file", chartFileName)
+	}
+	return identity(signer), nil
+}
+
+// Sign returns a provenance file signing a chart tarball with the given
+// Chart.yaml, file name and hex encoded SHA-256 digest, in the format of
+// "helm package --sign".
+func Sign(signer *openpgp.Entity, chartYaml []byte, chartFileName, digest string) ([]byte, error) {
+	sums, err := yaml.Marshal(sumCollection{Files: map[string]string{chartFileName: "sha256:" + digest}})
+	if err != nil {
+		return nil, err
+	}
+	var message bytes.Buffer
+	message.Write(bytes.TrimRight(chartYaml, "\n"))
+	message.WriteString("\n...\n")
+	message.Write(sums)
+
+	var prov bytes.Buffer
+	w, err := clearsign.Encode(&prov, signer.PrivateKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(message.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return prov.Bytes(), nil
+}
+
+// identity returns the first identity of a key, or its ID if it has none.
+func identity(entity *openpgp.Entity) string {
+	names := make([]string, 0, len(entity.Identities))
+	for name := range entity.Identities {
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return entity.PrimaryKey.KeyIdString()
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// Status returns the status of a verification which returned the given
+// error.
+func Status(err error) string {
+	switch {
+	case err == nil:
+		return StatusVerified
+	case errors.Is(err, ErrUnsigned):
+		return StatusUnsigned
+	default:
+		return StatusFailed
+	}
+}
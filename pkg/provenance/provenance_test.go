@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2020 Bitnami
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package provenance
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+const (
+	chartYaml     = "apiVersion: v1\nname: mychart\nversion: 0.1.0\n"
+	chartFileName = "mychart-0.1.0.tgz"
+	digest        = "4d9f1c3eb0c1e5a6b2bb2ef2c8e2b2b7c0b6b3e8a8b5b2f8d4e0e7c8d6f1a2b3"
+)
+
+func newEntity(t *testing.T, name string) *openpgp.Entity {
+	entity, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return entity
+}
+
+func armoredKeyring(t *testing.T, entities ...*openpgp.Entity) []byte {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, entity := range entities {
+		if err := entity.Serialize(w); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadKeyring(t *testing.T) {
+	entity := newEntity(t, "signer")
+	var binary bytes.Buffer
+	if err := entity.Serialize(&binary); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		data          []byte
+		errorExpected bool
+	}{
+		{name: "reads an armored keyring", data: armoredKeyring(t, entity)},
+		{name: "reads a binary keyring", data: binary.Bytes()},
+		{name: "returns an error for an invalid keyring", data: []byte("not a keyring"), errorExpected: true},
+		{name: "returns an error for an empty keyring", data: []byte{}, errorExpected: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			keyring, err := ReadKeyring(tc.data)
+			if got, want := err != nil, tc.errorExpected; got != want {
+				t.Fatalf("got error: %v, want error: %t", err, want)
+			}
+			if !tc.errorExpected && len(keyring) != 1 {
+				t.Errorf("got: %d keys, want: 1", len(keyring))
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	trusted := newEntity(t, "trusted")
+	untrusted := newEntity(t, "untrusted")
+	keyring, err := ReadKeyring(armoredKeyring(t, trusted))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sign := func(signer *openpgp.Entity, chartFileName, digest string) []byte {
+		prov, err := Sign(signer, []byte(chartYaml), chartFileName, digest)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		return prov
+	}
+
+	testCases := []struct {
+		name           string
+		prov           []byte
+		expectedSigner string
+		expectedStatus string
+	}{
+		{
+			name:           "verifies a chart signed by a trusted key",
+			prov:           sign(trusted, chartFileName, digest),
+			expectedSigner: "trusted <trusted@example.com>",
+			expectedStatus: StatusVerified,
+		},
+		{
+			name:           "fails for a chart signed by an untrusted key",
+			prov:           sign(untrusted, chartFileName, digest),
+			expectedStatus: StatusFailed,
+		},
+		{
+			name:           "fails for a chart with another digest",
+			prov:           sign(trusted, chartFileName, "0000000000000000000000000000000000000000000000000000000000000000"),
+			expectedStatus: StatusFailed,
+		},
+		{
+			name:           "fails for another chart",
+			prov:           sign(trusted, "otherchart-0.1.0.tgz", digest),
+			expectedStatus: StatusFailed,
+		},
+		{
+			name:           "fails for a tampered provenance file",
+			prov:           bytes.Replace(sign(trusted, chartFileName, digest), []byte("mychart\n"), []byte("evilchart\n"), 1),
+			expectedStatus: StatusFailed,
+		},
+		{
+			name:           "reports a provenance file without signature as unsigned",
+			prov:           []byte(chartYaml),
+			expectedStatus: StatusUnsigned,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := Verify(keyring, tc.prov, chartFileName, digest)
+			if got, want := Status(err), tc.expectedStatus; got != want {
+				t.Fatalf("got: %q (%v), want: %q", got, err, want)
+			}
+			if got, want := signer, tc.expectedSigner; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	missing := fmt.Errorf("unable to fetch the provenance file: %w", ErrUnsigned)
+	if got, want := Status(missing), StatusUnsigned; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}